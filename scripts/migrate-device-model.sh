@@ -0,0 +1,25 @@
+#!/usr/bin/env bash
+# Copying the model of the devices written before it was stored as "device_model" from their "deviceModel" attribute,
+# so the StoredModel indexes and the model filters find them. "deviceModel" is kept for the old indexes, a device which
+# has been written since keeps its own "device_model". Usage: ./scripts/migrate-device-model.sh <stage>
+
+stage=${1:-dev}
+table="simple-Go-RESTful-AWS-$stage-devices"
+
+echo "Copying deviceModel to device_model in $table ..."
+
+aws dynamodb scan --table-name "$table" --projection-expression "id" \
+  --filter-expression "attribute_exists(deviceModel) AND attribute_not_exists(device_model)" --output json |
+  jq -r '.Items[].id.S' |
+  while read -r id;
+  do
+    if aws dynamodb update-item --table-name "$table" --key "{\"id\":{\"S\":\"$id\"}}" \
+      --update-expression "SET device_model = deviceModel" \
+      --condition-expression "attribute_exists(deviceModel) AND attribute_not_exists(device_model)" 2> /dev/null; then
+      echo "✓ Migrated $id"
+    else
+      echo "— Skipped $id"
+    fi
+  done
+
+echo "Done."
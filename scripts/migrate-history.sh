@@ -0,0 +1,26 @@
+#!/usr/bin/env bash
+# Copying the history of a former deployment, keyed by id and version, to the history table keyed by id and revision
+# (createdAt#version, the version zero padded to 10 digits). Usage: ./scripts/migrate-history.sh <stage>
+
+stage=${1:-dev}
+source="simple-Go-RESTful-AWS-$stage-history"
+target="simple-Go-RESTful-AWS-$stage-history-revisions"
+
+echo "Copying $source to $target ..."
+
+aws dynamodb scan --table-name "$source" --output json |
+  jq -c '.Items[]
+    | .version = (.version // {"N": "0"})
+    | .revision = {"S": ((.createdAt.S // "") + "#" + ("0000000000" + .version.N)[-10:])}' |
+  while read -r item;
+  do
+    # Rows already copied are kept as they are.
+    if aws dynamodb put-item --table-name "$target" --item "$item" \
+      --condition-expression "attribute_not_exists(revision)" 2> /dev/null; then
+      echo "✓ Copied $(echo "$item" | jq -r '.id.S + " " + .revision.S')"
+    else
+      echo "— Skipped $(echo "$item" | jq -r '.id.S + " " + .revision.S')"
+    fi
+  done
+
+echo "Done."
@@ -1,143 +1,1108 @@
 package main
 
 import (
+	"attributes"
+	"audit"
+	"breaker"
+	"context"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"gateway"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/eventbridge/eventbridgeiface"
+	"github.com/aws/aws-xray-sdk-go/strategy/ctxmissing"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"history"
+	"log/slog"
+	"math"
+	"math/rand"
+	"negotiation"
+	"net/url"
 	"os"
+	"owner"
+	"placeholder"
+	"projection"
+	"recovery"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 	"types"
+	"validation"
 )
 
 type AmazonWebServices struct {
-	Config   *aws.Config
-	Session  *session.Session
-	DynamoDB dynamodbiface.DynamoDBAPI
+	Config      *aws.Config
+	Session     *session.Session
+	DynamoDB    dynamodbiface.DynamoDBAPI
+	EventBridge eventbridgeiface.EventBridgeAPI
+	// Names of the devices table and of the idempotency, serials, audit, rate limit and history tables, which are optional.
+	TableName            string
+	IdempotencyTableName string
+	SerialsTableName     string
+	AuditTableName       string
+	RateLimitTableName   string
+	HistoryTableName     string
+	// Set when a required setting is missing, every request which needs the database then fails with it.
+	ConfigError error
+	// Skips the duplicate serial check, i.e: while migrating data which is already known to be unique.
+	SkipSerialCheck bool
+	// Overwrites an existing device with the same id instead of rejecting it, i.e: for idempotent syncs.
+	AllowOverwrite bool
+	// Answers a create of an existing device with the same data by the existing device, so retries are transparent.
+	ReturnExisting bool
+	// Event bus which the events of created devices are published to, no event is published without it.
+	EventBusName string
 }
 
+// Source of the events published by this API on Amazon EventBridge.
+const eventSource = "simple-go-restful-aws.devices"
+
+// Name of the global secondary index of the devices table which is keyed by serial.
+const serialIndex = "Serial-index"
+
+// Constraints of a transactional create, returned by TransactPut when the transaction is cancelled by their condition.
+// Overwrite returns errModified when the device has changed since it was read.
+var (
+	errDeviceExists = errors.New("device with this ID already exists")
+	errSerialExists = errors.New("serial already registered")
+	errModified     = errors.New("the device has been modified meanwhile")
+)
+
+// Position of the device in the transaction of Overwrite, its markers and history follow when they're written.
+const deviceWrite = 0
+
 // Prepare a new AWS & DynamoDB session, then configure it.
 var TestAws *AmazonWebServices
 
+// An X-Request-ID of the caller which is taken as is, printable and short enough for the logs, and a W3C traceparent
+// header, "version-traceid-parentid-flags", whose trace ID is taken otherwise.
+var (
+	requestIDPattern   = regexp.MustCompile(`^[\x21-\x7e]{1,128}$`)
+	traceparentPattern = regexp.MustCompile(`^[0-9a-f]{2}-([0-9a-f]{32})-[0-9a-f]{16}-[0-9a-f]{2}$`)
+)
+
+// Structured JSON logs on Amazon CloudWatch, so they can be queried with CloudWatch Logs Insights.
+var logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+// Whether the container is yet to handle its first request, whose latency includes the start of the container by
+// Lambda. Set in init and cleared by the first request, which is logged with "coldStart": true.
+var coldStart bool
+
+// Circuit breaker in front of the writes of the devices, shared by the requests of the container. After
+// BREAKER_THRESHOLD (5 by default) consecutive failures, writes fail fast with HTTP 503 for BREAKER_COOLDOWN_SECONDS
+// (30 by default) instead of waiting on a failing DynamoDB.
+var writeBreaker = breaker.New(envInt("BREAKER_THRESHOLD", 5), time.Duration(envInt("BREAKER_COOLDOWN_SECONDS", 30))*time.Second)
+
 func init() {
+	coldStart = true
 	region := os.Getenv("AWS_REGION")
 	var Aws *AmazonWebServices = new(AmazonWebServices)
 	Aws.Config = &aws.Config{Region: aws.String(region)}
+	// Pointing the client to a local DynamoDB, i.e: DynamoDB Local for the integration tests. It's unset in production.
+	if endpoint := os.Getenv("DYNAMODB_ENDPOINT"); endpoint != "" {
+		Aws.Config.Endpoint = aws.String(endpoint)
+	}
+	// Get table names from OS's environment once, instead of on every call.
+	Aws.TableName = os.Getenv("DEVICES_TABLE_NAME")
+	Aws.IdempotencyTableName = os.Getenv("IDEMPOTENCY_TABLE_NAME")
+	Aws.SerialsTableName = os.Getenv("SERIALS_TABLE_NAME")
+	Aws.AuditTableName = os.Getenv("AUDIT_TABLE_NAME")
+	Aws.RateLimitTableName = os.Getenv("RATE_LIMIT_TABLE_NAME")
+	Aws.HistoryTableName = os.Getenv("HISTORY_TABLE_NAME")
+	Aws.SkipSerialCheck = os.Getenv("SKIP_SERIAL_CHECK") == "true"
+	Aws.AllowOverwrite = os.Getenv("ALLOW_OVERWRITE") == "true"
+	Aws.ReturnExisting = os.Getenv("RETURN_EXISTING") == "true"
+	Aws.EventBusName = os.Getenv("EVENT_BUS_NAME")
+	// Not exiting here, so the process (and the tests) keep running while requests report the problem.
+	Aws.ConfigError = validateConfig()
+	if Aws.ConfigError != nil {
+		logger.Error("Service misconfigured", "error", Aws.ConfigError.Error())
+	}
 	var err error
 	Aws.Session, err = session.NewSession(Aws.Config)
 	if err != nil {
 		// Logs error on Amazon CloudWatch. It's sysadmin's duty to handle it.
-		fmt.Println(fmt.Sprintf("Failed to connect to AWS: %s", err.Error()))
+		logger.Error("Failed to connect to AWS", "error", err.Error())
 	} else {
 		var svc *dynamodb.DynamoDB = dynamodb.New(Aws.Session)
+		// Tracing every DynamoDB call on AWS X-Ray, calls outside of a traced request are left alone.
+		xray.Configure(xray.Config{ContextMissingStrategy: ctxmissing.NewDefaultIgnoreErrorStrategy()})
+		xray.AWS(svc.Client)
 		Aws.DynamoDB = dynamodbiface.DynamoDBAPI(svc)
+		// The endpoint override is meant for DynamoDB only, an empty one resolves the regular EventBridge endpoint.
+		var bus *eventbridge.EventBridge = eventbridge.New(Aws.Session, &aws.Config{Endpoint: aws.String("")})
+		xray.AWS(bus.Client)
+		Aws.EventBridge = eventbridgeiface.EventBridgeAPI(bus)
 	}
 	// Instantiate a global session in TestAws
 	TestAws = Aws
 }
 
+// Checking the required settings in OS's environment are provided.
+func validateConfig() error {
+	for _, name := range []string{"AWS_REGION", "DEVICES_TABLE_NAME"} {
+		if os.Getenv(name) == "" {
+			return fmt.Errorf("Service misconfigured: %s not set", name)
+		}
+	}
+	return nil
+}
+
 // Preparing DynamoDB Session and Calling DB's PutItem function inside.
-func (self *AmazonWebServices) Put(item map[string]*dynamodb.AttributeValue) (*dynamodb.PutItemOutput, error) {
-	// Get table name from OS's environment
-	tableName := aws.String(os.Getenv("DEVICES_TABLE_NAME"))
+// The condition makes sure an existing device with the same id is never overwritten, see Overwrite for AllowOverwrite.
+func (self *AmazonWebServices) Put(ctx context.Context, item map[string]*dynamodb.AttributeValue) (*dynamodb.PutItemOutput, error) {
+	names := placeholder.Names{}
 	var input = &dynamodb.PutItemInput{
-		Item:      item,
-		TableName: tableName,
+		Item:                     item,
+		TableName:                aws.String(self.TableName),
+		ConditionExpression:      aws.String(fmt.Sprintf("attribute_not_exists(%s)", names.Of("id"))),
+		ExpressionAttributeNames: names,
+		ReturnConsumedCapacity:   returnConsumedCapacity(),
 	}
 	// Calling either PutItem function of interface, defined in addDevice_test.go file, or api with the input we've provided.
 	// In mock case, the PutItem function of getDeviceById_test.go will be called(interface.go)
 	// In real deployment environment, the PutItem function of aws (api.go) will be called.
-	result, err := self.DynamoDB.PutItem(input)
+	var result *dynamodb.PutItemOutput
+	err := writeBreaker.Do(func() error {
+		return traced(ctx, "DynamoDB.PutItem", func(ctx context.Context) error {
+			return withRetries(ctx, func() error {
+				var err error
+				result, err = self.DynamoDB.PutItemWithContext(ctx, input)
+				return err
+			})
+		})
+	}, breakerFailure(ctx))
+	if err == nil {
+		logConsumedCapacity("PutItem", result.ConsumedCapacity)
+	}
 	return result, err
 }
 
+// Preparing DynamoDB Session and Calling DB's TransactWriteItems function inside, writing in one transaction
+// the device and a marker item of its serial on SerialsTableName, whose key is "serial".
+// Unlike SerialExists and Put, two concurrent creates with the same serial can never both succeed.
+// Returns errDeviceExists or errSerialExists when the transaction is cancelled by the condition of the device or the marker.
+// The marker is deleted along with its device by DeleteDevice and DeleteDevices, freeing the serial again.
+func (self *AmazonWebServices) TransactPut(ctx context.Context, item map[string]*dynamodb.AttributeValue) error {
+	deviceNames, markerNames := placeholder.Names{}, placeholder.Names{}
+	device := &dynamodb.Put{
+		Item:                     item,
+		TableName:                aws.String(self.TableName),
+		ConditionExpression:      aws.String(fmt.Sprintf("attribute_not_exists(%s)", deviceNames.Of("id"))),
+		ExpressionAttributeNames: deviceNames,
+	}
+	marker := &dynamodb.Put{
+		Item:                     map[string]*dynamodb.AttributeValue{"serial": item["serial"], "id": item["id"]},
+		TableName:                aws.String(self.SerialsTableName),
+		ConditionExpression:      aws.String(fmt.Sprintf("attribute_not_exists(%s)", markerNames.Of("serial"))),
+		ExpressionAttributeNames: markerNames,
+	}
+	var input = &dynamodb.TransactWriteItemsInput{
+		TransactItems:          []*dynamodb.TransactWriteItem{{Put: device}, {Put: marker}},
+		ReturnConsumedCapacity: returnConsumedCapacity(),
+	}
+	var result *dynamodb.TransactWriteItemsOutput
+	err := writeBreaker.Do(func() error {
+		return traced(ctx, "DynamoDB.TransactWriteItems", func(ctx context.Context) error {
+			return withRetries(ctx, func() error {
+				var err error
+				result, err = self.DynamoDB.TransactWriteItemsWithContext(ctx, input)
+				return err
+			})
+		})
+	}, breakerFailure(ctx))
+	if err == nil {
+		logConsumedCapacity("TransactWriteItems", result.ConsumedCapacity...)
+	}
+	// The reasons are in the order of the items, "None" for an item which has not caused the cancellation.
+	if canceled, ok := err.(*dynamodb.TransactionCanceledException); ok {
+		for i, reason := range canceled.CancellationReasons {
+			if aws.StringValue(reason.Code) != "ConditionalCheckFailed" {
+				continue
+			}
+			if i == 0 {
+				return errDeviceExists
+			}
+			return errSerialExists
+		}
+	}
+	return err
+}
+
+// Preparing DynamoDB Session and Calling DB's TransactWriteItems function inside, overwriting the stored device, as read
+// by GetStored, with item when AllowOverwrite is set. The device is only written while it's still the stored one and a
+// non empty ownerID is its owner, otherwise errModified is returned. In the same transaction, when the serials are
+// marked, the marker of its serial is moved like RotateSerial does, errSerialExists being returned for the serial of
+// another device, and the stored device is appended to HistoryTableName when it's set, as UpdateDevice keeps it.
+func (self *AmazonWebServices) Overwrite(ctx context.Context, item map[string]*dynamodb.AttributeValue, stored map[string]*dynamodb.AttributeValue, ownerID string) error {
+	names := placeholder.Names{}
+	values := map[string]*dynamodb.AttributeValue{}
+	condition := fmt.Sprintf("attribute_exists(%s) AND attribute_not_exists(%s)", names.Of("id"), names.Of("deleted"))
+	// The devices stored before versioning have no version yet.
+	if version := stored["version"]; version != nil {
+		condition += fmt.Sprintf(" AND %s = :storedVersion", names.Of("version"))
+		values[":storedVersion"] = version
+	} else {
+		condition += fmt.Sprintf(" AND attribute_not_exists(%s)", names.Of("version"))
+	}
+	if ownerID != "" {
+		condition += " AND " + owner.Condition(ownerID, nil, names, values)
+	}
+	var input = &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			deviceWrite: {Put: &dynamodb.Put{
+				Item:                      item,
+				TableName:                 aws.String(self.TableName),
+				ConditionExpression:       aws.String(condition),
+				ExpressionAttributeNames:  names,
+				ExpressionAttributeValues: values,
+			}},
+		},
+		ReturnConsumedCapacity: returnConsumedCapacity(),
+	}
+	// Position of the marker of the new serial in the transaction, -1 when it isn't written.
+	markerWrite := -1
+	if self.SerialsTableName != "" && !self.SkipSerialCheck {
+		id := map[string]*dynamodb.AttributeValue{":id": item["id"]}
+		// A device without a serial has no marker to write.
+		if item["serial"] != nil {
+			markerNames := placeholder.Names{}
+			markerWrite = len(input.TransactItems)
+			input.TransactItems = append(input.TransactItems, &dynamodb.TransactWriteItem{Put: &dynamodb.Put{
+				Item:                      map[string]*dynamodb.AttributeValue{"serial": item["serial"], "id": item["id"]},
+				TableName:                 aws.String(self.SerialsTableName),
+				ConditionExpression:       aws.String(fmt.Sprintf("attribute_not_exists(%s) OR %s = :id", markerNames.Of("serial"), markerNames.Of("id"))),
+				ExpressionAttributeNames:  markerNames,
+				ExpressionAttributeValues: id,
+			}})
+		}
+		// The marker of a serial which the device no longer has is freed, unless it's another device's one.
+		if oldSerial := stored["serial"]; oldSerial != nil && (item["serial"] == nil || aws.StringValue(oldSerial.S) != aws.StringValue(item["serial"].S)) {
+			oldMarkerNames := placeholder.Names{}
+			input.TransactItems = append(input.TransactItems, &dynamodb.TransactWriteItem{Delete: &dynamodb.Delete{
+				Key:                       map[string]*dynamodb.AttributeValue{"serial": oldSerial},
+				TableName:                 aws.String(self.SerialsTableName),
+				ConditionExpression:       aws.String(fmt.Sprintf("attribute_not_exists(%s) OR %s = :id", oldMarkerNames.Of("serial"), oldMarkerNames.Of("id"))),
+				ExpressionAttributeNames:  oldMarkerNames,
+				ExpressionAttributeValues: id,
+			}})
+		}
+	}
+	// Position of the history in the transaction, -1 when it isn't written.
+	historyWrite := -1
+	if self.HistoryTableName != "" {
+		historyWrite = len(input.TransactItems)
+		input.TransactItems = append(input.TransactItems, &dynamodb.TransactWriteItem{Put: history.Put(self.HistoryTableName, stored)})
+	}
+	var result *dynamodb.TransactWriteItemsOutput
+	err := writeBreaker.Do(func() error {
+		return traced(ctx, "DynamoDB.TransactWriteItems", func(ctx context.Context) error {
+			return withRetries(ctx, func() error {
+				var err error
+				result, err = self.DynamoDB.TransactWriteItemsWithContext(ctx, input)
+				return err
+			})
+		})
+	}, breakerFailure(ctx))
+	if err == nil {
+		logConsumedCapacity("TransactWriteItems", result.ConsumedCapacity...)
+	}
+	if canceled, ok := err.(*dynamodb.TransactionCanceledException); ok {
+		// The version read is already in the history, so the device has been replaced meanwhile as well.
+		if reasonFailed(canceled, deviceWrite) || reasonFailed(canceled, historyWrite) {
+			return errModified
+		}
+		if reasonFailed(canceled, markerWrite) {
+			return errSerialExists
+		}
+	}
+	return err
+}
+
+// Checking whether a write of a cancelled transaction has failed its condition.
+// A write which isn't in the transaction, at -1, never has.
+func reasonFailed(canceled *dynamodb.TransactionCanceledException, write int) bool {
+	return write >= 0 && write < len(canceled.CancellationReasons) && aws.StringValue(canceled.CancellationReasons[write].Code) == "ConditionalCheckFailed"
+}
+
+// Asking DynamoDB for the capacity consumed by a call, with RETURN_CAPACITY=true in OS's environment, to tune the
+// provisioned capacity of the tables. It's off by default, so the logs are not flooded with it.
+func returnConsumedCapacity() *string {
+	if os.Getenv("RETURN_CAPACITY") != "true" {
+		return nil
+	}
+	return aws.String(dynamodb.ReturnConsumedCapacityTotal)
+}
+
+// Logging the capacity consumed by a DynamoDB call, one line for every table, which DynamoDB only returns when asked for.
+func logConsumedCapacity(operation string, capacities ...*dynamodb.ConsumedCapacity) {
+	for _, capacity := range capacities {
+		if capacity == nil {
+			continue
+		}
+		logger.Info("Consumed capacity", "operation", operation, "table", aws.StringValue(capacity.TableName), "capacityUnits", aws.Float64Value(capacity.CapacityUnits))
+	}
+}
+
+// Running an operation inside an AWS X-Ray subsegment with the given name.
+// Lambda sets _X_AMZN_TRACE_ID for traced invocations only, without it the operation is simply run.
+func traced(ctx context.Context, name string, operation func(context.Context) error) error {
+	if os.Getenv("_X_AMZN_TRACE_ID") == "" {
+		return operation(ctx)
+	}
+	return xray.Capture(ctx, name, operation)
+}
+
+// Preparing DynamoDB Session and Calling DB's Query function inside, to find whether any device other than the one
+// with the given id has the given serial, an empty id excludes none.
+// It requires a global secondary index named "Serial-index" on the devices table, with "serial" (S) as its HASH key.
+// Note that the index is eventually consistent and checked before the insert, so two concurrent creates may still slip through.
+func (self *AmazonWebServices) SerialExists(ctx context.Context, serial string, id string) (bool, error) {
+	// Only the keys of the devices are needed to tell whether they exist. The placeholders of the projection and of
+	// the key condition are alike, so they share the names.
+	keys := projection.Keys()
+	names := placeholder.Names{}
+	for attribute, name := range keys.Names {
+		names[attribute] = name
+	}
+	var input = &dynamodb.QueryInput{
+		TableName:                aws.String(self.TableName),
+		IndexName:                aws.String(serialIndex),
+		KeyConditionExpression:   aws.String(fmt.Sprintf("%s = :serial", names.Of("serial"))),
+		ProjectionExpression:     keys.Expression,
+		ExpressionAttributeNames: names,
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":serial": {S: aws.String(serial)},
+		},
+		// The device with the id may be one of them, the serials are unique otherwise.
+		Limit:                  aws.Int64(2),
+		ReturnConsumedCapacity: returnConsumedCapacity(),
+	}
+	var result *dynamodb.QueryOutput
+	err := traced(ctx, "DynamoDB.Query", func(ctx context.Context) error {
+		return withRetries(ctx, func() error {
+			var err error
+			result, err = self.DynamoDB.QueryWithContext(ctx, input)
+			return err
+		})
+	})
+	if err != nil {
+		return false, err
+	}
+	logConsumedCapacity("Query", result.ConsumedCapacity)
+	for _, item := range result.Items {
+		if id == "" || aws.StringValue(item["id"].S) != id {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Preparing EventBridge Session and Calling its PutEvents function inside, publishing a single event to EventBusName.
+// PutEvents may accept the call but fail the entry itself, which is reported as an error too.
+func (self *AmazonWebServices) PublishEvent(ctx context.Context, detailType string, detail interface{}) error {
+	if self.EventBusName == "" || self.EventBridge == nil {
+		return nil
+	}
+	detailJson, err := json.Marshal(detail)
+	if err != nil {
+		return err
+	}
+	var input = &eventbridge.PutEventsInput{
+		Entries: []*eventbridge.PutEventsRequestEntry{{
+			EventBusName: aws.String(self.EventBusName),
+			Source:       aws.String(eventSource),
+			DetailType:   aws.String(detailType),
+			Detail:       aws.String(string(detailJson)),
+		}},
+	}
+	result, err := self.EventBridge.PutEventsWithContext(ctx, input)
+	if err != nil {
+		return err
+	}
+	if aws.Int64Value(result.FailedEntryCount) > 0 && len(result.Entries) > 0 {
+		return fmt.Errorf("%s: %s", aws.StringValue(result.Entries[0].ErrorCode), aws.StringValue(result.Entries[0].ErrorMessage))
+	}
+	return nil
+}
+
+// Telling the failures of DynamoDB which count on the writeBreaker from the errors it returns on purpose: a failed
+// condition, of a put or of a transaction, is a healthy answer. So is any error once the request itself is done.
+func breakerFailure(ctx context.Context) func(error) bool {
+	return func(err error) bool {
+		if ctx.Err() != nil {
+			return false
+		}
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return false
+		}
+		if canceled, ok := err.(*dynamodb.TransactionCanceledException); ok {
+			for _, reason := range canceled.CancellationReasons {
+				if aws.StringValue(reason.Code) == "ConditionalCheckFailed" {
+					return false
+				}
+			}
+		}
+		return true
+	}
+}
+
+// An integer taken from OS's environment, falling back to the given one when it's missing or malformed.
+func envInt(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// Delay before the first retry of a throttled DynamoDB call, doubling on each next one.
+var retryBaseDelay = 50 * time.Millisecond
+
+// Calling a DynamoDB operation again while DynamoDB throttles it, with an exponential backoff and full jitter.
+// Max attempts are taken from OS's environment (DDB_MAX_RETRIES), defaulting to 3. Other errors are returned at once.
+// No more attempts are made once the context is done.
+func withRetries(ctx context.Context, operation func() error) error {
+	maxAttempts, err := strconv.Atoi(os.Getenv("DDB_MAX_RETRIES"))
+	if err != nil || maxAttempts <= 0 {
+		maxAttempts = 3
+	}
+	delay := retryBaseDelay
+	for attempt := 1; ; attempt++ {
+		err = operation()
+		aerr, ok := err.(awserr.Error)
+		throttled := ok && (aerr.Code() == dynamodb.ErrCodeProvisionedThroughputExceededException || aerr.Code() == dynamodb.ErrCodeRequestLimitExceeded)
+		if !throttled || attempt >= maxAttempts {
+			return err
+		}
+		if delay > 0 {
+			select {
+			case <-time.After(time.Duration(rand.Int63n(int64(delay)))):
+			case <-ctx.Done():
+				return err
+			}
+		}
+		delay *= 2
+	}
+}
+
+// Preparing DynamoDB Session and Calling DB's GetItem function inside, on the devices table.
+// Returns whether the device has been found.
+func (self *AmazonWebServices) GetDevice(ctx context.Context, id string) (types.Device, bool, error) {
+	Device := types.Device{}
+	item, err := self.GetStored(ctx, id)
+	if err != nil || len(item) == 0 {
+		return Device, false, err
+	}
+	err = dynamodbattribute.UnmarshalMap(item, &Device)
+	return Device, err == nil, err
+}
+
+// Preparing DynamoDB Session and Calling DB's GetItem function inside, on the devices table.
+// Returns the stored item, empty when the device isn't found.
+func (self *AmazonWebServices) GetStored(ctx context.Context, id string) (map[string]*dynamodb.AttributeValue, error) {
+	var input = &dynamodb.GetItemInput{
+		TableName: aws.String(self.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(id),
+			},
+		},
+		// The device which a create has just conflicted with has to be seen, even if it's been written a moment ago.
+		ConsistentRead: aws.Bool(true),
+	}
+	var result *dynamodb.GetItemOutput
+	err := withRetries(ctx, func() error {
+		var err error
+		result, err = self.DynamoDB.GetItemWithContext(ctx, input)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.Item, nil
+}
+
+// Preparing DynamoDB Session and Calling DB's GetItem function inside, on the idempotency table.
+// A record which has expired but has not been purged by DynamoDB's TTL yet is treated as not found.
+func (self *AmazonWebServices) GetIdempotencyRecord(ctx context.Context, key string) (types.IdempotencyRecord, bool, error) {
+	record := types.IdempotencyRecord{}
+	var input = &dynamodb.GetItemInput{
+		TableName: aws.String(self.IdempotencyTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"key": {
+				S: aws.String(key),
+			},
+		},
+		// The record of a create which has just happened has to be seen by its retry.
+		ConsistentRead: aws.Bool(true),
+	}
+	var result *dynamodb.GetItemOutput
+	err := withRetries(ctx, func() error {
+		var err error
+		result, err = self.DynamoDB.GetItemWithContext(ctx, input)
+		return err
+	})
+	if err != nil || len(result.Item) == 0 {
+		return record, false, err
+	}
+	dynamodbattribute.UnmarshalMap(result.Item, &record)
+	if record.ExpiresAt <= time.Now().Unix() {
+		return types.IdempotencyRecord{}, false, nil
+	}
+	return record, true, nil
+}
+
+// Preparing DynamoDB Session and Calling DB's PutItem function inside, on the idempotency table.
+func (self *AmazonWebServices) PutIdempotencyRecord(ctx context.Context, record types.IdempotencyRecord) error {
+	item, _ := dynamodbattribute.MarshalMap(record)
+	var input = &dynamodb.PutItemInput{
+		Item:      item,
+		TableName: aws.String(self.IdempotencyTableName),
+	}
+	return withRetries(ctx, func() error {
+		_, err := self.DynamoDB.PutItemWithContext(ctx, input)
+		return err
+	})
+}
+
+// Preparing DynamoDB Session and Calling DB's UpdateItem function inside, taking a token of the caller's bucket on the
+// rate limit table. The bucket holds RATE_LIMIT_REQUESTS tokens (100 by default) and is refilled every
+// RATE_LIMIT_WINDOW_SECONDS (60 by default): it's an atomic counter of the requests of the caller in the current
+// window, which DynamoDB's TTL removes once the window is over. Returns whether the request may go through, and
+// the time left before the bucket is refilled otherwise.
+func (self *AmazonWebServices) TakeToken(ctx context.Context, caller string) (bool, time.Duration, error) {
+	limit := envInt("RATE_LIMIT_REQUESTS", 100)
+	window := envInt("RATE_LIMIT_WINDOW_SECONDS", 60)
+	if limit <= 0 || window <= 0 {
+		return true, 0, nil
+	}
+	now := time.Now().Unix()
+	windowStart := now - now%int64(window)
+	windowEnd := windowStart + int64(window)
+
+	names := placeholder.Names{}
+	var input = &dynamodb.UpdateItemInput{
+		TableName: aws.String(self.RateLimitTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"key": {S: aws.String(fmt.Sprintf("%s#%d", caller, windowStart))},
+		},
+		UpdateExpression:         aws.String(fmt.Sprintf("ADD %s :one SET %[2]s = if_not_exists(%[2]s, :expiresAt)", names.Of("count"), names.Of("expiresAt"))),
+		ExpressionAttributeNames: names,
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one":       {N: aws.String("1")},
+			":expiresAt": {N: aws.String(strconv.FormatInt(windowEnd, 10))},
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueUpdatedNew),
+	}
+	var result *dynamodb.UpdateItemOutput
+	err := withRetries(ctx, func() error {
+		var err error
+		result, err = self.DynamoDB.UpdateItemWithContext(ctx, input)
+		return err
+	})
+	if err != nil {
+		return false, 0, err
+	}
+	count, _ := strconv.Atoi(aws.StringValue(result.Attributes["count"].N))
+	if count <= limit {
+		return true, 0, nil
+	}
+	return false, time.Duration(windowEnd-now) * time.Second, nil
+}
+
+// Finding who a request is rate limited as: the principal of its authorizer, or else its source IP.
+func rateLimitKey(request events.APIGatewayProxyRequest) string {
+	if caller := owner.Caller(request); caller != "" {
+		return "principal:" + caller
+	}
+	return "ip:" + request.RequestContext.Identity.SourceIP
+}
+
 // The handler function which will be first started from main function.
-func AddDevice(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	// First & foremost we have to validate user input.
-	NewDevice, err := ValidateInputs(request)
+// When an Idempotency-Key header is sent, the response of the first create is recorded and returned again for
+// every retry with the same key and body, instead of inserting again. Reusing the key with another body is rejected.
+// All DynamoDB calls share a timeout (DDB_TIMEOUT_MS), so a hung call is answered with HTTP 504.
+// A log line with the request ID, status code, latency and whether it's the cold start of the container is written
+// for every request. It carries the correlation ID of the request as well, which is echoed in the X-Request-ID header.
+// A dry run, "?dryRun=true" or an "X-Dry-Run: true" header, only validates the device and returns it with HTTP 200.
+// With RATE_LIMIT_TABLE_NAME set, a caller exceeding its rate limit is answered with HTTP 429, see TakeToken.
+// With RETURN_EXISTING=true, creating a device which already exists with the same fields returns it with HTTP 200.
+func AddDevice(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	start := time.Now()
+	correlation := correlationID(request)
+	requestLogger := logger.With("requestId", request.RequestContext.RequestID, "correlationId", correlation, "handler", "AddDevice", "coldStart", coldStart)
+	coldStart = false
+	response, err := addDevice(ctx, request, requestLogger)
+	if response.Headers == nil {
+		response.Headers = map[string]string{}
+	}
+	response.Headers["X-Request-ID"] = correlation
+	requestLogger.Info("Request handled", "statusCode", response.StatusCode, "latencyMs", time.Since(start).Milliseconds())
+	return response, err
+} // End of AddDevice function
+
+// Handling the request of AddDevice, errors are logged on requestLogger.
+func addDevice(ctx context.Context, request events.APIGatewayProxyRequest, requestLogger *slog.Logger) (events.APIGatewayProxyResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, dynamoDBTimeout())
+	defer cancel()
+
+	// Devices are only created by a POST, any other method is answered with HTTP 405, see gateway.AllowsMethod.
+	if !gateway.AllowsMethod(request, "POST") {
+		return withHeaders(gateway.MethodNotAllowed("POST")), nil
+	}
+
+	// Rate limiting is only available when its table has been configured. It's a soft limit: when the table can't be
+	// reached the request goes through, the devices table is what it protects.
+	if TestAws.RateLimitTableName != "" {
+		allowed, retryAfter, err := TestAws.TakeToken(ctx, rateLimitKey(request))
+		if err != nil {
+			requestLogger.Warn("Failed to check the rate limit", "error", err.Error())
+		} else if !allowed {
+			response := respondError(429, "TOO_MANY_REQUESTS", "Too many requests, please retry later.")
+			response.Headers["Retry-After"] = strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))
+			return response, nil
+		}
+	}
+
+	// The created device is encoded as the client accepts it, errors are always JSON.
+	mediaType, err := negotiation.MediaType(headerValue(request.Headers, "Accept"))
+	if err != nil {
+		return respondError(406, "NOT_ACCEPTABLE", err.Error()), nil
+	}
+
+	// Only a JSON body is understood, return HTTP error code 415 for any other.
+	if err := validation.CheckContentType(request); err != nil {
+		return respondError(415, "UNSUPPORTED_MEDIA_TYPE", err.Error()), nil
+	}
+
+	// A dry run stores nothing, so it has nothing to be idempotent about either.
+	dryRun := isDryRun(request)
+
+	// Idempotency is only available when its table has been configured.
+	idempotencyKey := ""
+	if TestAws.IdempotencyTableName != "" && !dryRun {
+		idempotencyKey = headerValue(request.Headers, "Idempotency-Key")
+	}
+	bodyHash := ""
+	if idempotencyKey != "" {
+		bodyHash = hashBody(request.Body)
+		record, found, err := TestAws.GetIdempotencyRecord(ctx, idempotencyKey)
+		if err != nil {
+			requestLogger.Error("Failed to read Idempotency-Key", "error", err.Error())
+			return respondDatabaseError(ctx, err), nil
+		}
+		if found {
+			// Same key with another body is a client bug, return HTTP error code 422.
+			if record.BodyHash != bodyHash {
+				return respondError(422, "IDEMPOTENCY_KEY_REUSED", "Idempotency-Key has already been used with a different body."), nil
+			}
+			// It's a retry, return the originally created response, encoded as this retry accepts it.
+			CreatedDevice := types.Device{}
+			if (record.StatusCode == 201 || record.StatusCode == 200) && json.Unmarshal([]byte(record.Body), &types.SuccessResponse{Data: &CreatedDevice}) == nil {
+				replay := respond(mediaType, record.StatusCode, CreatedDevice)
+				if record.StatusCode == 201 {
+					replay.Headers["Location"] = deviceLocation(CreatedDevice.ID)
+				}
+				return replay, nil
+			}
+			return withHeaders(events.APIGatewayProxyResponse{
+				Body:       record.Body,
+				StatusCode: record.StatusCode,
+			}), nil
+		}
+	}
+
+	// First & foremost we have to validate user input. The id may be omitted when the server generates it.
+	NewDevice, err := validation.ValidateNewInputs(request)
 	// if inputs are not suitable, return HTTP error code 400.
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			Body:       "" + err.Error(),
-			StatusCode: 400,
-		}, nil
+		if err == validation.ErrBodyTooLarge {
+			return respondError(413, "PAYLOAD_TOO_LARGE", err.Error()), nil
+		}
+		// Field failures are collected, so return all of them as a list.
+		if fieldErrors, ok := err.(validation.FieldErrors); ok {
+			return respondValidationError(fieldErrors), nil
+		}
+		return respondError(400, "INVALID_INPUT", err.Error()), nil
+	}
+
+	// The input is fine, but without its settings the service can't store it, return HTTP error code 500.
+	if TestAws.ConfigError != nil {
+		return respondError(500, "SERVICE_MISCONFIGURED", TestAws.ConfigError.Error()), nil
+	}
+
+	// The server generates the id of a device created without one, when SERVER_GENERATED_IDS=true.
+	if NewDevice.ID == "" {
+		NewDevice.ID, err = newUUID()
+		if err != nil {
+			requestLogger.Error("Failed to generate the id", "error", err.Error())
+			return respondInternalError("INTERNAL_ERROR", err), nil
+		}
+	}
+
+	// Timestamps are set on the server side, whatever the user has sent for them is ignored.
+	NewDevice.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	NewDevice.UpdatedAt = ""
+	NewDevice.Deleted = false
+	NewDevice.DeletedAt = ""
+	// Every device starts from the first version, updates have to provide it back.
+	NewDevice.Version = 1
+	// The device belongs to the tenant of the caller, whatever the user has sent for it is ignored.
+	NewDevice.OwnerID = owner.Caller(request)
+	// A new device is in service unless the user has told otherwise.
+	if NewDevice.Status == "" {
+		NewDevice.Status = types.StatusActive
+	}
+
+	// The device is valid, return HTTP 200 with it as it would have been created, without storing it.
+	if dryRun {
+		return respond(mediaType, 200, NewDevice), nil
 	}
 
 	// Serialization/Encoding "NewDevice" in "item" for using in DynamoDB functions.
-	item, _ := dynamodbattribute.MarshalMap(NewDevice)
+	item, _ := attributes.Marshal(NewDevice)
+
+	// A device without serial, when REQUIRED_FIELDS leaves it out, has no serial to keep unique.
+	checkSerial := !TestAws.SkipSerialCheck && NewDevice.Serial != ""
+
+	// With a serials table, the device and its serial are written at once, so concurrent creates can't share a serial.
+	if TestAws.SerialsTableName != "" && checkSerial {
+		err = TestAws.TransactPut(ctx, item)
+	} else {
+		// Two physical devices never share a serial, so a registered one is rejected with HTTP error code 409.
+		// A device which may be overwritten doesn't conflict with its own serial.
+		if checkSerial {
+			overwritten := ""
+			if TestAws.AllowOverwrite || TestAws.ReturnExisting {
+				overwritten = NewDevice.ID
+			}
+			exists, err := TestAws.SerialExists(ctx, NewDevice.Serial, overwritten)
+			if err != nil {
+				requestLogger.Error("Failed to check the serial", "error", err.Error())
+				return respondDatabaseError(ctx, err), nil
+			}
+			if exists {
+				return respondError(409, "SERIAL_EXISTS", "Serial already registered"), nil
+			}
+		}
 
-	// Till now the user have provided a valid data input.
-	// Let's add it to the DynamoDB table.
-	_, err = TestAws.Put(item)
+		// Till now the user have provided a valid data input.
+		// Let's add it to the DynamoDB table.
+		_, err = TestAws.Put(ctx, item)
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			err = errDeviceExists
+		}
+	}
+
+	// Whether an existing device with the same id has been overwritten, which ALLOW_OVERWRITE=true allows,
+	// and its attributes as they were before.
+	replaced := false
+	var previous map[string]*dynamodb.AttributeValue
+	if err == errDeviceExists && TestAws.AllowOverwrite {
+		previous, err = overwrite(ctx, &NewDevice)
+		replaced = err == nil
+		if replaced {
+			item, _ = attributes.Marshal(NewDevice)
+		}
+	}
 
-	// If internal database errors occurred, return HTTP error code 500.
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			Body:       "Internal Server Error\nDatabase error.",
-			StatusCode: 500,
-		}, nil
-	}
-
-	// Serialization/Encoding "NewDevice" to JSON.
-	jsonResponse, _ := json.Marshal(NewDevice)
-	return events.APIGatewayProxyResponse{
-		Body: string(jsonResponse),
-		// Everything looks fine, return HTTP 201
-		StatusCode: 201,
-	}, nil
-} // End of AddDevice function
+		// The condition has failed, so a device with this id already exists, return HTTP error code 409.
+		if err == errDeviceExists {
+			// A retry of a create which has already succeeded gets the existing device, with RETURN_EXISTING=true.
+			if TestAws.ReturnExisting {
+				Existing, found, err := TestAws.GetDevice(ctx, NewDevice.ID)
+				if err != nil {
+					requestLogger.Error("Failed to get the existing device", "error", err.Error())
+					return respondDatabaseError(ctx, err), nil
+				}
+				if found && sameDevice(Existing, NewDevice) {
+					return respond(mediaType, 200, Existing), nil
+				}
+			}
+			return respondError(409, "DEVICE_EXISTS", "Device with this ID already exists."), nil
+		}
+		if err == errSerialExists {
+			return respondError(409, "SERIAL_EXISTS", "Serial already registered"), nil
+		}
+		// The overwritten device has changed since it was read, return HTTP error code 409 so the client retries.
+		if err == errModified {
+			return respondError(409, "DEVICE_MODIFIED", "The device has been modified meanwhile, please retry."), nil
+		}
+		// DynamoDB has kept failing, so the write hasn't been tried, return HTTP error code 503 till the breaker half-opens.
+		if err == breaker.ErrOpen {
+			requestLogger.Warn("Circuit breaker is open, the device is not put")
+			response := respondError(503, "SERVICE_UNAVAILABLE", "Service temporarily unavailable, please retry later.")
+			response.Headers["Retry-After"] = strconv.Itoa(int(math.Ceil(writeBreaker.RetryAfter().Seconds())))
+			return response, nil
+		}
+		requestLogger.Error("Failed to put the device", "error", err.Error())
+		return respondDatabaseError(ctx, err), nil
+	}
 
-func ValidateInputs(request events.APIGatewayProxyRequest) (types.Device, error) {
-	NewDevice := types.Device{}
-	ErrorMessage := ""
+	// An overwritten device is answered with HTTP 200 and audited as an update, it's no new device.
+	statusCode, action := 201, audit.ActionCreate
+	if replaced {
+		statusCode, action = 200, audit.ActionUpdate
+	} else {
+		// Letting downstream systems know about the new device. It has been created anyway, so a failure is only logged.
+		err = TestAws.PublishEvent(ctx, "device.created", types.DeviceCreatedEvent{ID: NewDevice.ID, DeviceModel: NewDevice.DeviceModel})
+		if err != nil {
+			requestLogger.Error("Failed to publish the device.created event", "error", err.Error())
+		}
+	}
+	// Recording who has created the device for the audit trail, a failure is only logged as well.
+	record := audit.NewRecord(NewDevice.ID, action, NewDevice.OwnerID, previous, item)
+	err = withRetries(ctx, func() error {
+		return audit.WriteWithContext(ctx, TestAws.DynamoDB, TestAws.AuditTableName, record)
+	})
+	if err != nil {
+		requestLogger.Error("Failed to write the audit record", "error", err.Error())
+	}
 
-	if len(request.Body) == 0 {
-		ErrorMessage = "No inputs provided, please provide inputs in JSON format."
-		return types.Device{}, errors.New(ErrorMessage)
+	// Everything looks fine, return HTTP 201 (or 200) with "NewDevice" in the envelope.
+	response := respond(mediaType, statusCode, NewDevice)
+	// Pointing the client to the created device.
+	if statusCode == 201 {
+		response.Headers["Location"] = deviceLocation(NewDevice.ID)
 	}
 
-	// De-serialize "request.Body" which is in JSON format into "NewDevice" in Go object.
-	var err = json.Unmarshal([]byte(request.Body), &NewDevice)
+	// Recording the response for the retries of this request.
+	if idempotencyKey != "" {
+		err = TestAws.PutIdempotencyRecord(ctx, types.IdempotencyRecord{
+			Key:        idempotencyKey,
+			BodyHash:   bodyHash,
+			StatusCode: statusCode,
+			// Always recorded as JSON, a retry may accept another media type.
+			Body:      respondJSON(statusCode, NewDevice).Body,
+			ExpiresAt: time.Now().Add(idempotencyTTL()).Unix(),
+		})
+		if err != nil {
+			// The device has been created anyway, so only logs error on Amazon CloudWatch.
+			requestLogger.Error("Failed to record Idempotency-Key", "idempotencyKey", idempotencyKey, "error", err.Error())
+		}
+	}
+	return response, nil
+} // End of addDevice function
 
+// Overwriting the stored device with the same id as device, with ALLOW_OVERWRITE=true, see Overwrite. The device is
+// replaced like an update: it keeps the createdAt of the stored one, continues from its version and keeps its
+// allowedGroups unless it has its own. A soft deleted device, or one of another tenant, is never overwritten, so
+// errDeviceExists is returned for it, as for any existing device. Returns the stored device as it was before.
+func overwrite(ctx context.Context, device *types.Device) (map[string]*dynamodb.AttributeValue, error) {
+	stored, err := TestAws.GetStored(ctx, device.ID)
 	if err != nil {
-		ErrorMessage = "Wrong format: Inputs must be a valid JSON."
-		return types.Device{}, errors.New(ErrorMessage)
+		return nil, err
+	}
+	// The device has been deleted since the create conflicted with it.
+	if len(stored) == 0 {
+		return nil, errModified
+	}
+	Existing := types.Device{}
+	if err = dynamodbattribute.UnmarshalMap(stored, &Existing); err != nil {
+		return nil, err
+	}
+	if Existing.Deleted || !owner.Matches(device.OwnerID, Existing.OwnerID) {
+		return nil, errDeviceExists
 	}
+	// The devices stored before the timestamps have no createdAt, the one of the create is kept for them.
+	if Existing.CreatedAt != "" {
+		device.CreatedAt = Existing.CreatedAt
+	}
+	device.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	device.Version = Existing.Version + 1
+	if len(device.AllowedGroups) == 0 {
+		device.AllowedGroups = Existing.AllowedGroups
+	}
+	item, _ := attributes.Marshal(*device)
+	return stored, TestAws.Overwrite(ctx, item, stored, device.OwnerID)
+}
 
-	if len(NewDevice.ID) == 0 {
-		ErrorMessage = "Missing field: ID"
-		return types.Device{}, errors.New(ErrorMessage)
+// Checking whether an existing device is the one which a create would have stored, comparing the fields which the
+// client sends. A soft deleted device, or one of another tenant, is never the same, so it's not disclosed.
+func sameDevice(existing types.Device, created types.Device) bool {
+	status := existing.Status
+	// The devices stored before the status have none, they are active.
+	if status == "" {
+		status = types.StatusActive
 	}
+	return !existing.Deleted && owner.Matches(created.OwnerID, existing.OwnerID) &&
+		existing.ID == created.ID && existing.DeviceModel == created.DeviceModel && existing.Name == created.Name &&
+		existing.Note == created.Note && existing.Serial == created.Serial && status == created.Status &&
+		existing.FirmwareVersion == created.FirmwareVersion && existing.ModelCode == created.ModelCode && existing.ExpiresAt == created.ExpiresAt &&
+		(len(existing.Tags) == 0 && len(created.Tags) == 0 || reflect.DeepEqual(existing.Tags, created.Tags)) &&
+		(len(existing.AllowedGroups) == 0 && len(created.AllowedGroups) == 0 || reflect.DeepEqual(existing.AllowedGroups, created.AllowedGroups))
+}
 
-	if len(NewDevice.DeviceModel) == 0 {
-		ErrorMessage = "Missing field: Device Model"
-		return types.Device{}, errors.New(ErrorMessage)
+// Generating a random (version 4) UUID, i.e: for a device created without id.
+func newUUID() (string, error) {
+	var id [16]byte
+	if _, err := crand.Read(id[:]); err != nil {
+		return "", err
 	}
+	id[6] = id[6]&0x0f | 0x40 // Version 4.
+	id[8] = id[8]&0x3f | 0x80 // RFC 4122 variant.
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16]), nil
+}
 
-	if len(NewDevice.Name) == 0 {
-		ErrorMessage = "Missing field: Name"
-		return types.Device{}, errors.New(ErrorMessage)
+// URI of a device, under the base path taken from OS's environment (DEVICES_BASE_PATH) and defaulting to "/devices".
+// Behind a custom domain with a base path mapping, i.e: "/api/devices", the base path has to be set accordingly.
+func deviceLocation(id string) string {
+	basePath := os.Getenv("DEVICES_BASE_PATH")
+	if basePath == "" {
+		basePath = "/devices"
 	}
+	return strings.TrimSuffix(basePath, "/") + "/" + url.PathEscape(id)
+}
 
-	if len(NewDevice.Note) == 0 {
-		ErrorMessage = "Missing field: Note"
-		return types.Device{}, errors.New(ErrorMessage)
+// Preparing the response of a failed DynamoDB call.
+// If the call has run out of time, return HTTP error code 504, otherwise it's an internal database error, return HTTP error code 500.
+func respondDatabaseError(ctx context.Context, err error) events.APIGatewayProxyResponse {
+	if ctx.Err() != nil {
+		return respondError(504, "DATABASE_TIMEOUT", "Gateway Timeout: database did not respond in time.")
 	}
+	return respondInternalError("DATABASE_ERROR", err)
+}
+
+// Preparing the HTTP 500 response of an internal error, which has already been logged in full.
+// The error itself is only listed in the body with ERROR_VERBOSITY=debug, for the development stages: by default, or
+// with any other verbosity, clients get a generic message so the internals of DynamoDB are never disclosed to them.
+func respondInternalError(code string, err error) events.APIGatewayProxyResponse {
+	if os.Getenv("ERROR_VERBOSITY") == "debug" && err != nil {
+		return respondError(500, code, "Internal Server Error.", err.Error())
+	}
+	return respondError(500, code, "Internal Server Error.")
+}
 
-	if len(NewDevice.Serial) == 0 {
-		ErrorMessage = "Missing field: Serial"
-		return types.Device{}, errors.New(ErrorMessage)
+// Time limit of the DynamoDB calls of a request, taken from OS's environment in milliseconds and defaulting to 2 seconds.
+func dynamoDBTimeout() time.Duration {
+	milliseconds, err := strconv.Atoi(os.Getenv("DDB_TIMEOUT_MS"))
+	if err != nil || milliseconds <= 0 {
+		milliseconds = 2000
 	}
+	return time.Duration(milliseconds) * time.Millisecond
+}
 
-	// Everything looks fine, return created NewDevice in Go struct.
-	return NewDevice, nil
-} // End of ValidateInputs function.
+// Checking whether the client only wants the device validated, through the dryRun query parameter or the X-Dry-Run header.
+func isDryRun(request events.APIGatewayProxyRequest) bool {
+	return strings.EqualFold(request.QueryStringParameters["dryRun"], "true") || strings.EqualFold(strings.TrimSpace(headerValue(request.Headers, "X-Dry-Run")), "true")
+}
+
+// Finding a header of the request regardless of its case, as clients and proxies may change it.
+func headerValue(headers map[string]string, name string) string {
+	for header, value := range headers {
+		if strings.EqualFold(header, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// Finding the ID which correlates a request across the services it goes through: the X-Request-ID of the caller,
+// else the trace ID of its traceparent, else a new one. One which can't be logged safely is ignored.
+func correlationID(request events.APIGatewayProxyRequest) string {
+	if requestID := headerValue(request.Headers, "X-Request-ID"); requestIDPattern.MatchString(requestID) {
+		return requestID
+	}
+	if match := traceparentPattern.FindStringSubmatch(headerValue(request.Headers, "traceparent")); match != nil && match[1] != strings.Repeat("0", 32) {
+		return match[1]
+	}
+	generated, err := newUUID()
+	if err != nil {
+		// Without a random source there's nothing better to correlate with than the API Gateway's own request ID.
+		return request.RequestContext.RequestID
+	}
+	return generated
+}
+
+// Hashing the body of a request, to tell a retry from another request using the same Idempotency-Key.
+func hashBody(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+// How long an Idempotency-Key is remembered, taken from OS's environment in seconds and defaulting to a day.
+func idempotencyTTL() time.Duration {
+	seconds, err := strconv.Atoi(os.Getenv("IDEMPOTENCY_TTL_SECONDS"))
+	if err != nil || seconds <= 0 {
+		seconds = 24 * 60 * 60
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// Preparing a successful response with the data wrapped in a JSON body of types.SuccessResponse.
+func respondJSON(status int, data interface{}) events.APIGatewayProxyResponse {
+	successJson, _ := negotiation.Marshal(negotiation.JSON, types.SuccessResponse{Data: data})
+	return withHeaders(events.APIGatewayProxyResponse{
+		Body:       string(successJson),
+		StatusCode: status,
+	})
+}
+
+// Preparing a successful response in the media type chosen by negotiation.MediaType, JSON unless XML is asked for.
+func respond(mediaType string, status int, data interface{}) events.APIGatewayProxyResponse {
+	if mediaType != negotiation.XML {
+		return respondJSON(status, data)
+	}
+	successXml, _ := negotiation.Marshal(mediaType, types.SuccessResponse{Data: data})
+	response := withHeaders(events.APIGatewayProxyResponse{
+		Body:       string(successXml),
+		StatusCode: status,
+	})
+	response.Headers["Content-Type"] = negotiation.XML
+	return response
+}
+
+// Preparing an error response with a JSON body of types.ErrorResponse.
+// The optional details are the list of failures which have caused the error, i.e: validation failures.
+func respondError(status int, code, message string, details ...string) events.APIGatewayProxyResponse {
+	errorJson, _ := json.Marshal(types.ErrorResponse{Message: message, Code: code, Errors: details})
+	return withHeaders(events.APIGatewayProxyResponse{
+		Body:       string(errorJson),
+		StatusCode: status,
+	})
+}
+
+// Preparing the HTTP 400 response of a rejected device, listing its failures both as messages and with their codes.
+func respondValidationError(fieldErrors validation.FieldErrors) events.APIGatewayProxyResponse {
+	errorJson, _ := json.Marshal(types.ErrorResponse{Message: "Validation failed.", Code: "VALIDATION_FAILED", Errors: fieldErrors.Messages(), Details: fieldErrors.Details()})
+	return withHeaders(events.APIGatewayProxyResponse{
+		Body:       string(errorJson),
+		StatusCode: 400,
+	})
+}
+
+// Setting the JSON content type and the CORS headers, which every response of AddDevice needs for browsers.
+// Allowed origin is taken from OS's environment, defaulting to any origin.
+func withHeaders(response events.APIGatewayProxyResponse) events.APIGatewayProxyResponse {
+	allowedOrigin := os.Getenv("ALLOWED_ORIGIN")
+	if allowedOrigin == "" {
+		allowedOrigin = "*"
+	}
+	if response.Headers == nil {
+		response.Headers = map[string]string{}
+	}
+	response.Headers["Content-Type"] = "application/json"
+	response.Headers["Access-Control-Allow-Origin"] = allowedOrigin
+	response.Headers["Access-Control-Allow-Methods"] = "POST, OPTIONS"
+	return response
+}
 
 func main() {
-	lambda.Start(AddDevice)
+	lambda.Start(gateway.AdaptContext(recovery.WithRecoverContext(AddDevice)))
 }
@@ -0,0 +1,92 @@
+//go:build integration
+// +build integration
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"os"
+	"testing"
+	"time"
+	"types"
+)
+
+// Adding a device against a real DynamoDB, i.e: DynamoDB Local, and reading it back.
+// Runs only with the "integration" build tag and DYNAMODB_ENDPOINT set, for example:
+//
+//	docker run -p 8000:8000 amazon/dynamodb-local
+//	AWS_REGION=us-east-2 AWS_ACCESS_KEY_ID=local AWS_SECRET_ACCESS_KEY=local DYNAMODB_ENDPOINT=http://localhost:8000 \
+//	    go test -tags integration ./addDevice/
+func TestAddDeviceIntegration(t *testing.T) {
+	if os.Getenv("DYNAMODB_ENDPOINT") == "" {
+		t.Skip("DYNAMODB_ENDPOINT not set, skipping the integration test")
+	}
+	if TestAws.DynamoDB == nil {
+		t.Fatalf("** Testing: Connecting to the local DynamoDB. ** \n \t<expected a DynamoDB session> <resulted none>")
+	}
+
+	// A table of its own, with the Serial-index which AddDevice queries.
+	tableName := fmt.Sprintf("devices_integration_%d", time.Now().UnixNano())
+	_, err := TestAws.DynamoDB.CreateTable(&dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{AttributeName: aws.String("id"), AttributeType: aws.String("S")},
+			{AttributeName: aws.String("serial"), AttributeType: aws.String("S")},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{{AttributeName: aws.String("id"), KeyType: aws.String("HASH")}},
+		GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{{
+			IndexName:             aws.String(serialIndex),
+			KeySchema:             []*dynamodb.KeySchemaElement{{AttributeName: aws.String("serial"), KeyType: aws.String("HASH")}},
+			Projection:            &dynamodb.Projection{ProjectionType: aws.String("KEYS_ONLY")},
+			ProvisionedThroughput: &dynamodb.ProvisionedThroughput{ReadCapacityUnits: aws.Int64(1), WriteCapacityUnits: aws.Int64(1)},
+		}},
+		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{ReadCapacityUnits: aws.Int64(1), WriteCapacityUnits: aws.Int64(1)},
+	})
+	if err != nil {
+		t.Fatalf("** Testing: Creating the table. ** \n \t<resulted error: %v>", err)
+	}
+	defer TestAws.DynamoDB.DeleteTable(&dynamodb.DeleteTableInput{TableName: aws.String(tableName)})
+	TestAws.DynamoDB.WaitUntilTableExists(&dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+
+	// Only the devices table is used, the optional tables and the event bus are left out.
+	realAws := TestAws
+	local := *TestAws
+	local.TableName = tableName
+	local.IdempotencyTableName = ""
+	local.SerialsTableName = ""
+	local.EventBusName = ""
+	local.ConfigError = nil
+	TestAws = &local
+	defer func() { TestAws = realAws }()
+
+	response, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"/devicemodels/id1\",\"name\":\"Sensor\",\"note\":\"Testing a sensor.\",\"serial\":\"A020000102\"}"})
+	if response.StatusCode != 201 {
+		t.Fatalf("** Testing: Adding a device. ** \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", 201, response.StatusCode, response.Body)
+	}
+
+	result, err := TestAws.DynamoDB.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(tableName),
+		Key:            map[string]*dynamodb.AttributeValue{"id": {S: aws.String("7c9e6679-7425-40de-944b-e07fc1f90ae7")}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		t.Fatalf("** Testing: Reading the device back. ** \n \t<resulted error: %v>", err)
+	}
+	StoredDevice := types.Device{}
+	dynamodbattribute.UnmarshalMap(result.Item, &StoredDevice)
+	if StoredDevice.DeviceModel != "/devicemodels/id1" || StoredDevice.Name != "Sensor" || StoredDevice.Note != "Testing a sensor." ||
+		StoredDevice.Serial != "A020000102" || StoredDevice.Version != 1 || StoredDevice.CreatedAt == "" {
+		t.Errorf("** Testing: Reading the device back. ** \n \t<expected the added device> <resulted item: %v>", result.Item)
+	}
+
+	// The same serial is now registered.
+	response, _ = AddDevice(context.Background(), events.APIGatewayProxyRequest{Body: "{\"id\":\"16fd2706-8baf-433b-82eb-8c7fada847da\",\"deviceModel\":\"/devicemodels/id1\",\"name\":\"Sensor\",\"note\":\"Testing a sensor.\",\"serial\":\"A020000102\"}"})
+	if response.StatusCode != 409 {
+		t.Errorf("** Testing: Adding a device with the same serial. ** \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", 409, response.StatusCode, response.Body)
+	}
+} // End of TestAddDeviceIntegration function
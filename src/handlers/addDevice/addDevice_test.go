@@ -1,11 +1,35 @@
 package main
 
 import (
+	"audit"
+	"breaker"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/eventbridge/eventbridgeiface"
+	"log/slog"
+	"metrics"
+	"os"
+	"recovery"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+	"types"
+	"validation"
 )
 
 type TestCase struct {
@@ -18,16 +42,179 @@ type TestCase struct {
 	ExpectedError          error
 }
 
+// Headers of a JSON body, as AddDevice only accepts JSON.
+func jsonContent() map[string]string {
+	return map[string]string{"Content-Type": "application/json"}
+}
+
 // Mocking DynamoDB through dynamodbiface.
 type MockDynamoDB struct {
 	dynamodbiface.DynamoDBAPI
-	// Other return values expected to store, i.e: "payload map[string]string" or "err error"
+	// Ids of the devices which are already stored in the mocked table.
+	ExistingIDs map[string]bool
+	// Records of the mocked idempotency table by key, and the number of devices which have been put.
+	IdempotencyRecords map[string]map[string]*dynamodb.AttributeValue
+	DevicePuts         int
+	// Number of PutItem calls which are throttled before a device is put, and the number of PutItem calls.
+	Throttles   int
+	PutAttempts int
+	// Table, item and ReturnConsumedCapacity of the last device which has been put.
+	DeviceTable          string
+	DeviceItem           map[string]*dynamodb.AttributeValue
+	DeviceReturnCapacity string
+	// Serials of the devices which are already stored in the mocked table, and the projection of the last serial query.
+	ExistingSerials  map[string]bool
+	SerialProjection string
+	// Records of the mocked audit table in their order, and whether writing them fails.
+	AuditRecords []map[string]*dynamodb.AttributeValue
+	AuditFails   bool
+	// Counters of the mocked rate limit table by key.
+	RateLimitCounts map[string]int
+	// Items of the devices which are already stored in the mocked table, by id.
+	ExistingDevices map[string]map[string]*dynamodb.AttributeValue
+	// Writes of the last transaction which has overwritten a device.
+	OverwriteItems []*dynamodb.TransactWriteItem
+}
+
+// Names of the mocked idempotency and audit tables.
+const (
+	MockIdempotencyTable = "idempotency_test"
+	MockAuditTable       = "audit_test"
+)
+
+// Custom QueryWithContext function for mocking the "#serial = :serial" query of the Serial-index.
+func (self *MockDynamoDB) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, options ...request.Option) (*dynamodb.QueryOutput, error) {
+	if ctx.Err() != nil {
+		return nil, awserr.New(request.CanceledErrorCode, "request context canceled", ctx.Err())
+	}
+	self.SerialProjection = aws.StringValue(input.ProjectionExpression)
+	MockOutput := new(dynamodb.QueryOutput)
+	serial := aws.StringValue(input.ExpressionAttributeValues[":serial"].S)
+	if aws.StringValue(input.IndexName) == serialIndex && self.ExistingSerials[serial] {
+		MockOutput.SetItems([]map[string]*dynamodb.AttributeValue{{"id": {S: aws.String("id_test")}, "serial": {S: aws.String(serial)}}})
+	}
+	return MockOutput, nil
+}
+
+// Custom TransactWriteItemsWithContext function for mocking the transactional create of a device and its serial marker,
+// and the overwrite of a stored device. Cancels the transaction like DynamoDB, with a reason for each item in their order.
+func (self *MockDynamoDB) TransactWriteItemsWithContext(ctx aws.Context, input *dynamodb.TransactWriteItemsInput, options ...request.Option) (*dynamodb.TransactWriteItemsOutput, error) {
+	if aws.StringValue(input.TransactItems[0].Put.ConditionExpression) != "attribute_not_exists(#id)" {
+		return self.overwrite(input)
+	}
+	device, marker := input.TransactItems[0].Put, input.TransactItems[1].Put
+	reasons := []*dynamodb.CancellationReason{{Code: aws.String("None")}, {Code: aws.String("None")}}
+	canceled := false
+	if device.ConditionExpression != nil && self.ExistingIDs[aws.StringValue(device.Item["id"].S)] {
+		reasons[0].Code, canceled = aws.String("ConditionalCheckFailed"), true
+	}
+	if aws.StringValue(marker.ConditionExpression) == "attribute_not_exists(#serial)" && self.ExistingSerials[aws.StringValue(marker.Item["serial"].S)] {
+		reasons[1].Code, canceled = aws.String("ConditionalCheckFailed"), true
+	}
+	if canceled {
+		return nil, &dynamodb.TransactionCanceledException{Message_: aws.String("Transaction cancelled"), CancellationReasons: reasons}
+	}
+	self.DevicePuts++
+	self.DeviceTable = aws.StringValue(device.TableName)
+	self.DeviceItem = device.Item
+	return new(dynamodb.TransactWriteItemsOutput), nil
+}
+
+// Mocking the transaction of Overwrite: the device is only written while it's stored with the version which has been
+// read, isn't soft deleted and belongs to the ":owner", if any, and the marker of its serial only when the serial is
+// either not registered or the stored device's own one.
+func (self *MockDynamoDB) overwrite(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+	device := input.TransactItems[0].Put
+	stored := self.ExistingDevices[aws.StringValue(device.Item["id"].S)]
+	reasons := make([]*dynamodb.CancellationReason, len(input.TransactItems))
+	canceled := false
+	for i, write := range input.TransactItems {
+		reasons[i] = &dynamodb.CancellationReason{Code: aws.String("None")}
+		failed := false
+		switch {
+		case i == 0:
+			storedVersion, owner := device.ExpressionAttributeValues[":storedVersion"], device.ExpressionAttributeValues[":owner"]
+			failed = len(stored) == 0 || stored["deleted"] != nil || !reflect.DeepEqual(stored["version"], storedVersion) ||
+				(owner != nil && !reflect.DeepEqual(stored["ownerId"], owner))
+		case write.Put != nil && aws.StringValue(write.Put.TableName) == "serials_test":
+			serial := aws.StringValue(write.Put.Item["serial"].S)
+			failed = self.ExistingSerials[serial] && (stored["serial"] == nil || serial != aws.StringValue(stored["serial"].S))
+		}
+		if failed {
+			reasons[i].Code, canceled = aws.String("ConditionalCheckFailed"), true
+		}
+	}
+	if canceled {
+		return nil, &dynamodb.TransactionCanceledException{Message_: aws.String("Transaction cancelled"), CancellationReasons: reasons}
+	}
+	self.DevicePuts++
+	self.DeviceTable = aws.StringValue(device.TableName)
+	self.DeviceItem = device.Item
+	self.OverwriteItems = input.TransactItems
+	return new(dynamodb.TransactWriteItemsOutput), nil
+}
+
+// Custom UpdateItemWithContext function for mocking the atomic counters of the rate limit table.
+func (self *MockDynamoDB) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, options ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	if self.RateLimitCounts == nil {
+		self.RateLimitCounts = map[string]int{}
+	}
+	key := aws.StringValue(input.Key["key"].S)
+	self.RateLimitCounts[key]++
+	return &dynamodb.UpdateItemOutput{Attributes: map[string]*dynamodb.AttributeValue{
+		"count": {N: aws.String(strconv.Itoa(self.RateLimitCounts[key]))},
+	}}, nil
 }
 
-// Custom PutItem function for overriding the PutItem of getDeviceById.go for using in test scenarios.
-// Mocking PutItem output to the a desire valid response.
-func (self *MockDynamoDB) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+// Custom GetItem function for mocking the idempotency table.
+func (self *MockDynamoDB) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, options ...request.Option) (*dynamodb.GetItemOutput, error) {
+	MockOutput := new(dynamodb.GetItemOutput)
+	if aws.StringValue(input.TableName) == MockIdempotencyTable {
+		MockOutput.SetItem(self.IdempotencyRecords[aws.StringValue(input.Key["key"].S)])
+	} else {
+		MockOutput.SetItem(self.ExistingDevices[aws.StringValue(input.Key["id"].S)])
+	}
+	return MockOutput, nil
+}
+
+// Custom PutItemWithContext function for overriding the PutItemWithContext of addDevice.go for using in test scenarios.
+// Mocking PutItem output to the a desire valid response, or to a conditional failure for an already existing id.
+// A done context makes the call fail, as the real client does.
+func (self *MockDynamoDB) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, options ...request.Option) (*dynamodb.PutItemOutput, error) {
+	if ctx.Err() != nil {
+		return nil, awserr.New(request.CanceledErrorCode, "request context canceled", ctx.Err())
+	}
+	if aws.StringValue(input.TableName) == MockIdempotencyTable {
+		if self.IdempotencyRecords == nil {
+			self.IdempotencyRecords = map[string]map[string]*dynamodb.AttributeValue{}
+		}
+		self.IdempotencyRecords[aws.StringValue(input.Item["key"].S)] = input.Item
+		return new(dynamodb.PutItemOutput), nil
+	}
+	if aws.StringValue(input.TableName) == MockAuditTable {
+		if self.AuditFails {
+			return nil, awserr.New(dynamodb.ErrCodeInternalServerError, "Internal server error", nil)
+		}
+		self.AuditRecords = append(self.AuditRecords, input.Item)
+		return new(dynamodb.PutItemOutput), nil
+	}
+	self.PutAttempts++
+	if self.Throttles > 0 {
+		self.Throttles--
+		return nil, awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "The level of configured provisioned throughput for the table was exceeded", nil)
+	}
+	if aws.StringValue(input.ConditionExpression) == "attribute_not_exists(#id)" && self.ExistingIDs[aws.StringValue(input.Item["id"].S)] {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
+	self.DevicePuts++
+	self.DeviceTable = aws.StringValue(input.TableName)
+	self.DeviceItem = input.Item
+	self.DeviceReturnCapacity = aws.StringValue(input.ReturnConsumedCapacity)
 	MockOutput := new(dynamodb.PutItemOutput)
+	// Like DynamoDB, the consumed capacity is only returned when it has been asked for.
+	if self.DeviceReturnCapacity == dynamodb.ReturnConsumedCapacityTotal {
+		MockOutput.ConsumedCapacity = &dynamodb.ConsumedCapacity{TableName: input.TableName, CapacityUnits: aws.Float64(1)}
+	}
 	return MockOutput, nil
 }
 
@@ -37,11 +224,11 @@ func TestPut(t *testing.T) {
 	MockInput := dynamodb.PutItemInput{}
 	MockInput.SetItem(
 		map[string]*dynamodb.AttributeValue{
-			"id":          &dynamodb.AttributeValue{S: aws.String("id1")},
-			"deviceModel": &dynamodb.AttributeValue{S: aws.String("testDeviceModel")},
-			"name":        &dynamodb.AttributeValue{S: aws.String("testName")},
-			"note":        &dynamodb.AttributeValue{S: aws.String("testNote")},
-			"serial":      &dynamodb.AttributeValue{S: aws.String("testSerial")},
+			"id":           &dynamodb.AttributeValue{S: aws.String("id1")},
+			"device_model": &dynamodb.AttributeValue{S: aws.String("testDeviceModel")},
+			"name":         &dynamodb.AttributeValue{S: aws.String("testName")},
+			"note":         &dynamodb.AttributeValue{S: aws.String("testNote")},
+			"serial":       &dynamodb.AttributeValue{S: aws.String("testSerial")},
 		},
 	)
 
@@ -54,14 +241,21 @@ func TestPut(t *testing.T) {
 	}
 
 	// Prepare AWS & DynamoDB session for mocking.
-	test_aws := new(AmazonWebServices)
-	test_aws.DynamoDB = &MockDynamoDB{}
+	mock := &MockDynamoDB{}
+	test_aws := &AmazonWebServices{DynamoDB: mock, TableName: "devices_test"}
 
-	_, err := test_aws.Put(testCase.inputedItems)
+	_, err := test_aws.Put(context.Background(), testCase.inputedItems)
 
 	// Function here is %100 proof, so no error will happen.
-	if err != testCase.ExpectedError {
-		t.Errorf("%s \n \t<expected error: %t> <resulted error: %t>", testCase.ExpectedError, err)
+	if err != testCase.ExpectedError || mock.DeviceTable != "devices_test" {
+		t.Errorf("%s \n \t<expected error: %v, table: devices_test> <resulted error: %v, table: %s>", testCase.Name, testCase.ExpectedError, err, mock.DeviceTable)
+	}
+
+	// Putting the same id again has to fail because of the "attribute_not_exists(id)" condition.
+	test_aws.DynamoDB = &MockDynamoDB{ExistingIDs: map[string]bool{"id1": true}}
+	_, err = test_aws.Put(context.Background(), testCase.inputedItems)
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != dynamodb.ErrCodeConditionalCheckFailedException {
+		t.Errorf("** Testing JSON with an already existing id ** \n \t<expected error: %s> <resulted error: %v>", dynamodb.ErrCodeConditionalCheckFailedException, err)
 	}
 } // End of TestPut function.
 
@@ -70,69 +264,2246 @@ func TestAddDevice(t *testing.T) {
 	testCases := []TestCase{
 		{
 			Name:               "** Testing: Empty body input. **",
-			Request:            events.APIGatewayProxyRequest{Body: ""},
-			ExpectedBody:       "No inputs provided, please provide inputs in JSON format.",
+			Request:            events.APIGatewayProxyRequest{Headers: jsonContent(), Body: ""},
+			ExpectedBody:       "{\"message\":\"No inputs provided, please provide inputs in JSON format.\",\"code\":\"INVALID_INPUT\"}",
 			ExpectedStatusCode: 400,
 		},
 
 		{
 			Name:               "** Testing: Wrong JSON format. **",
-			Request:            events.APIGatewayProxyRequest{Body: "{{{}"},
-			ExpectedBody:       "Wrong format: Inputs must be a valid JSON.",
+			Request:            events.APIGatewayProxyRequest{Headers: jsonContent(), Body: "{{{}"},
+			ExpectedBody:       "{\"message\":\"Wrong format: Inputs must be a valid JSON, invalid character '{' looking for beginning of object key string at offset 2.\",\"code\":\"INVALID_INPUT\"}",
 			ExpectedStatusCode: 400,
 		},
 
 		{
 			Name:               "** Testing: JSON with missing field - ID **",
-			Request:            events.APIGatewayProxyRequest{Body: "{\"id\":\"\" , \"deviceModel\":\"testDeviceModel\" , \"name\":\"testName\" , \"note\":\"testNote\" , \"serial\":\"testSerial\" }"},
-			ExpectedBody:       "Missing field: ID",
+			Request:            events.APIGatewayProxyRequest{Headers: jsonContent(), Body: "{\"id\":\"\" , \"deviceModel\":\"testDeviceModel\" , \"name\":\"testName\" , \"note\":\"testNote\" , \"serial\":\"testSerial\" }"},
+			ExpectedBody:       "{\"message\":\"Validation failed.\",\"code\":\"VALIDATION_FAILED\",\"errors\":[\"Missing field: ID\"],\"details\":[{\"field\":\"id\",\"code\":\"MISSING_FIELD\",\"message\":\"Missing field: ID\"}]}",
 			ExpectedStatusCode: 400,
 		},
 
 		{
 			Name:               "** Testing: JSON with missing field - Device Model **",
-			Request:            events.APIGatewayProxyRequest{Body: "{\"id\":\"1\" , \"deviceModel\":\"\" , \"name\":\"testName\" , \"note\":\"testNote\" , \"serial\":\"testSerial\" }"},
-			ExpectedBody:       "Missing field: Device Model",
+			Request:            events.APIGatewayProxyRequest{Headers: jsonContent(), Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\" , \"deviceModel\":\"\" , \"name\":\"testName\" , \"note\":\"testNote\" , \"serial\":\"testSerial\" }"},
+			ExpectedBody:       "{\"message\":\"Validation failed.\",\"code\":\"VALIDATION_FAILED\",\"errors\":[\"Missing field: Device Model\"],\"details\":[{\"field\":\"deviceModel\",\"code\":\"MISSING_FIELD\",\"message\":\"Missing field: Device Model\"}]}",
 			ExpectedStatusCode: 400,
 		},
 
 		{
 			Name:               "** Testing: JSON with missing field - Name **",
-			Request:            events.APIGatewayProxyRequest{Body: "{\"id\":\"1\" , \"deviceModel\":\"testDeviceModel\" , \"name\":\"\" , \"note\":\"testNote\" , \"serial\":\"testSerial\" }"},
-			ExpectedBody:       "Missing field: Name",
+			Request:            events.APIGatewayProxyRequest{Headers: jsonContent(), Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\" , \"deviceModel\":\"testDeviceModel\" , \"name\":\"\" , \"note\":\"testNote\" , \"serial\":\"testSerial\" }"},
+			ExpectedBody:       "{\"message\":\"Validation failed.\",\"code\":\"VALIDATION_FAILED\",\"errors\":[\"Missing field: Name\"],\"details\":[{\"field\":\"name\",\"code\":\"MISSING_FIELD\",\"message\":\"Missing field: Name\"}]}",
 			ExpectedStatusCode: 400,
 		},
 
 		{
 			Name:               "** Testing: JSON with missing field - Note **",
-			Request:            events.APIGatewayProxyRequest{Body: "{\"id\":\"1\" , \"deviceModel\":\"testDeviceModel\" , \"name\":\"testName\" , \"note\":\"\" , \"serial\":\"testSerial\" }"},
-			ExpectedBody:       "Missing field: Note",
+			Request:            events.APIGatewayProxyRequest{Headers: jsonContent(), Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\" , \"deviceModel\":\"testDeviceModel\" , \"name\":\"testName\" , \"note\":\"\" , \"serial\":\"testSerial\" }"},
+			ExpectedBody:       "{\"message\":\"Validation failed.\",\"code\":\"VALIDATION_FAILED\",\"errors\":[\"Missing field: Note\"],\"details\":[{\"field\":\"note\",\"code\":\"MISSING_FIELD\",\"message\":\"Missing field: Note\"}]}",
 			ExpectedStatusCode: 400,
 		},
 
 		{
 			Name:               "** Testing: JSON with missing field - Serial **",
-			Request:            events.APIGatewayProxyRequest{Body: "{\"id\":\"1\" , \"deviceModel\":\"testDeviceModel\" , \"name\":\"testName\" , \"note\":\"testNote\" , \"serial\":\"\" }"},
-			ExpectedBody:       "Missing field: Serial",
+			Request:            events.APIGatewayProxyRequest{Headers: jsonContent(), Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\" , \"deviceModel\":\"testDeviceModel\" , \"name\":\"testName\" , \"note\":\"testNote\" , \"serial\":\"\" }"},
+			ExpectedBody:       "{\"message\":\"Validation failed.\",\"code\":\"VALIDATION_FAILED\",\"errors\":[\"Missing field: Serial\"],\"details\":[{\"field\":\"serial\",\"code\":\"MISSING_FIELD\",\"message\":\"Missing field: Serial\"}]}",
+			ExpectedStatusCode: 400,
+		},
+
+		{
+			Name:               "** Testing: JSON with missing fields - ID, Name & Serial **",
+			Request:            events.APIGatewayProxyRequest{Headers: jsonContent(), Body: "{\"id\":\"\" , \"deviceModel\":\"testDeviceModel\" , \"name\":\"\" , \"note\":\"testNote\" , \"serial\":\"\" }"},
+			ExpectedBody:       "{\"message\":\"Validation failed.\",\"code\":\"VALIDATION_FAILED\",\"errors\":[\"Missing field: ID\",\"Missing field: Name\",\"Missing field: Serial\"],\"details\":[{\"field\":\"id\",\"code\":\"MISSING_FIELD\",\"message\":\"Missing field: ID\"},{\"field\":\"name\",\"code\":\"MISSING_FIELD\",\"message\":\"Missing field: Name\"},{\"field\":\"serial\",\"code\":\"MISSING_FIELD\",\"message\":\"Missing field: Serial\"}]}",
 			ExpectedStatusCode: 400,
 		},
 
 		{ // In Testing environment, as we don't access AWS's OS environment variable and other real world parameters, can not reach to
 			// HTTP code 201 point in here, unless we prepare a mock server for it.
-			Name:         "** Testing: JSON with proper fields. **",
-			Request:      events.APIGatewayProxyRequest{Body: "{\"id\":\"1\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}"},
-			ExpectedBody: "Internal Server Error\nDatabase error.",
-			//ExpectedBody:        "{\"id\":\"1\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}" ,
+			Name:    "** Testing: JSON with proper fields. **",
+			Request: events.APIGatewayProxyRequest{Headers: jsonContent(), Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}"},
+			// AWS_REGION is unset below, so the session is misconfigured.
+			ExpectedBody: "{\"message\":\"Service misconfigured: AWS_REGION not set\",\"code\":\"SERVICE_MISCONFIGURED\"}",
+			//ExpectedBody:        "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}" ,
 			ExpectedStatusCode: 500, //201
 		},
 	}
 
+	// Unsetting AWS_REGION whatever the environment running the tests has, t.Setenv restores it afterwards.
+	t.Setenv("AWS_REGION", "")
+	os.Unsetenv("AWS_REGION")
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}, ConfigError: validateConfig()}
+	defer func() { TestAws = realAws }()
+
 	for _, test := range testCases {
 		// Executing each test cases scenario.
-		response, _ := AddDevice(test.Request)
+		response, _ := AddDevice(context.Background(), test.Request)
 		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
 			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
 		}
 	}
 
 } // end of TestAddDevice function
+
+// AddDevice function against a mocked DynamoDB, so the 201 and 409 points can be reached.
+func TestAddDeviceWithMockedDatabase(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{ExistingIDs: map[string]bool{"9b2e1d4a-3f5c-4e8a-b6d7-0c1f2a3b4c5d": true}}}
+	defer func() { TestAws = realAws }()
+
+	testCases := []TestCase{
+		{
+			Name:               "** Testing: JSON with an already existing id. **",
+			Request:            events.APIGatewayProxyRequest{Headers: jsonContent(), Body: "{\"id\":\"9b2e1d4a-3f5c-4e8a-b6d7-0c1f2a3b4c5d\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}"},
+			ExpectedBody:       "{\"message\":\"Device with this ID already exists.\",\"code\":\"DEVICE_EXISTS\"}",
+			ExpectedStatusCode: 409,
+		},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := AddDevice(context.Background(), test.Request)
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+} // End of TestAddDeviceWithMockedDatabase function
+
+// The 201 response of AddDevice has to carry a server side RFC3339 creation timestamp.
+func TestAddDeviceTimestamps(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}}
+	defer func() { TestAws = realAws }()
+
+	// The user supplied timestamps have to be ignored.
+	request := events.APIGatewayProxyRequest{Headers: jsonContent(), Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\",\"createdAt\":\"2000-01-01T00:00:00Z\",\"updatedAt\":\"2000-01-01T00:00:00Z\"}"}
+	before := time.Now().UTC().Truncate(time.Second)
+	response, _ := AddDevice(context.Background(), request)
+	after := time.Now().UTC()
+
+	if response.StatusCode != 201 {
+		t.Fatalf("** Testing: JSON with proper fields. ** \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", 201, response.StatusCode, response.Body)
+	}
+
+	CreatedDevice := types.Device{}
+	json.Unmarshal([]byte(response.Body), &types.SuccessResponse{Data: &CreatedDevice})
+
+	createdAt, err := time.Parse(time.RFC3339, CreatedDevice.CreatedAt)
+	if err != nil || createdAt.Before(before) || createdAt.After(after) {
+		t.Errorf("** Testing: createdAt timestamp. ** \n \t<expected RFC3339 time between %s and %s> <resulted createdAt: %s>", before.Format(time.RFC3339), after.Format(time.RFC3339), CreatedDevice.CreatedAt)
+	}
+	if CreatedDevice.UpdatedAt != "" {
+		t.Errorf("** Testing: updatedAt timestamp. ** \n \t<expected updatedAt: \"\"> <resulted updatedAt: %s>", CreatedDevice.UpdatedAt)
+	}
+	if CreatedDevice.ID != "7c9e6679-7425-40de-944b-e07fc1f90ae7" || CreatedDevice.DeviceModel != "testDeviceModel" || CreatedDevice.Name != "testName" || CreatedDevice.Note != "testNote" || CreatedDevice.Serial != "testSerial" {
+		t.Errorf("** Testing: JSON with proper fields. ** \n \t<resulted body: %s>", response.Body)
+	}
+} // End of TestAddDeviceTimestamps function
+
+// The created device is returned in the same envelope as every successful response.
+func TestAddDeviceEnvelope(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}}
+	defer func() { TestAws = realAws }()
+
+	response, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{Headers: jsonContent(), Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}"})
+	envelope := map[string]json.RawMessage{}
+	json.Unmarshal([]byte(response.Body), &envelope)
+	if response.StatusCode != 201 || len(envelope) != 2 || string(envelope["meta"]) != "{}" || !strings.HasPrefix(string(envelope["data"]), "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\"") {
+		t.Errorf("** Testing: Envelope of a 201 response. ** \n \t<expected body: {\"data\":{\"id\":...},\"meta\":{}}> <resulted error-code: %d> <resulted body: %s>", response.StatusCode, response.Body)
+	}
+} // End of TestAddDeviceEnvelope function
+
+// The created device is encoded as XML or JSON by the Accept header, JSON by default.
+func TestAddDeviceContentNegotiation(t *testing.T) {
+	testCases := []struct {
+		Name                string
+		Accept              string
+		ExpectedStatusCode  int
+		ExpectedContentType string
+	}{
+		{Name: "** Testing: XML requested. **", Accept: "application/xml", ExpectedStatusCode: 201, ExpectedContentType: "application/xml"},
+		{Name: "** Testing: JSON requested. **", Accept: "application/json", ExpectedStatusCode: 201, ExpectedContentType: "application/json"},
+		{Name: "** Testing: JSON by default. **", Accept: "", ExpectedStatusCode: 201, ExpectedContentType: "application/json"},
+		{Name: "** Testing: Any media type. **", Accept: "*/*", ExpectedStatusCode: 201, ExpectedContentType: "application/json"},
+		{Name: "** Testing: XML preferred by quality. **", Accept: "application/json;q=0.5, application/xml", ExpectedStatusCode: 201, ExpectedContentType: "application/xml"},
+		{Name: "** Testing: Unsupported media type. **", Accept: "text/plain", ExpectedStatusCode: 406, ExpectedContentType: "application/json"},
+	}
+
+	realAws := TestAws
+	defer func() { TestAws = realAws }()
+	for _, test := range testCases {
+		TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}}
+
+		// Executing each test cases scenario.
+		response, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{
+			Headers: map[string]string{"Accept": test.Accept, "Content-Type": "application/json"},
+			Body:    "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}",
+		})
+		if response.StatusCode != test.ExpectedStatusCode || response.Headers["Content-Type"] != test.ExpectedContentType {
+			t.Errorf("%s \n \t<expected error-code: %d, content-type: %s> <resulted error-code: %d, content-type: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, test.ExpectedContentType, response.StatusCode, response.Headers["Content-Type"], response.Body)
+			continue
+		}
+		if response.StatusCode != 201 {
+			continue
+		}
+
+		// Decoding the body in the media type it claims to be.
+		CreatedDevice := types.Device{}
+		var err error
+		if test.ExpectedContentType == "application/xml" {
+			envelope := struct {
+				XMLName xml.Name     `xml:"response"`
+				Data    types.Device `xml:"data"`
+			}{}
+			err = xml.Unmarshal([]byte(response.Body), &envelope)
+			CreatedDevice = envelope.Data
+		} else {
+			err = json.Unmarshal([]byte(response.Body), &types.SuccessResponse{Data: &CreatedDevice})
+		}
+		if err != nil || CreatedDevice.ID != "7c9e6679-7425-40de-944b-e07fc1f90ae7" || CreatedDevice.Name != "testName" || CreatedDevice.Version != 1 {
+			t.Errorf("%s \n \t<expected a device with id: 7c9e6679-7425-40de-944b-e07fc1f90ae7> <resulted error: %v> <resulted body: %s>", test.Name, err, response.Body)
+		}
+	}
+} // End of TestAddDeviceContentNegotiation function
+
+// Error responses of AddDevice have to be JSON bodies of types.ErrorResponse.
+func TestAddDeviceErrorResponses(t *testing.T) {
+	testCases := []struct {
+		Name            string
+		Request         events.APIGatewayProxyRequest
+		ExpectedMessage string
+		ExpectedCode    string
+		ExpectedErrors  []string
+	}{
+		{
+			Name:            "** Testing: Wrong JSON format. **",
+			Request:         events.APIGatewayProxyRequest{Headers: jsonContent(), Body: "{{{}"},
+			ExpectedMessage: "Wrong format: Inputs must be a valid JSON, invalid character '{' looking for beginning of object key string at offset 2.",
+			ExpectedCode:    "INVALID_INPUT",
+		},
+
+		{
+			Name:            "** Testing: JSON with missing fields - Note & Serial **",
+			Request:         events.APIGatewayProxyRequest{Headers: jsonContent(), Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\"}"},
+			ExpectedMessage: "Validation failed.",
+			ExpectedCode:    "VALIDATION_FAILED",
+			ExpectedErrors:  []string{"Missing field: Note", "Missing field: Serial"},
+		},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := AddDevice(context.Background(), test.Request)
+
+		if response.Headers["Content-Type"] != "application/json" {
+			t.Errorf("%s \n \t<expected Content-Type: application/json> <resulted Content-Type: %s>", test.Name, response.Headers["Content-Type"])
+		}
+
+		ErrorBody := types.ErrorResponse{}
+		err := json.Unmarshal([]byte(response.Body), &ErrorBody)
+		if err != nil || ErrorBody.Message != test.ExpectedMessage || ErrorBody.Code != test.ExpectedCode || len(ErrorBody.Errors) != len(test.ExpectedErrors) {
+			t.Errorf("%s \n \t<expected message: %s, code: %s, errors: %v> <resulted body: %s>", test.Name, test.ExpectedMessage, test.ExpectedCode, test.ExpectedErrors, response.Body)
+			continue
+		}
+		for i := range test.ExpectedErrors {
+			if ErrorBody.Errors[i] != test.ExpectedErrors[i] {
+				t.Errorf("%s \n \t<expected errors: %v> <resulted errors: %v>", test.Name, test.ExpectedErrors, ErrorBody.Errors)
+			}
+		}
+	}
+} // End of TestAddDeviceErrorResponses function
+
+// Both success and error responses of AddDevice have to carry the content type and CORS headers.
+func TestAddDeviceHeaders(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}}
+	defer func() { TestAws = realAws }()
+
+	testCases := []struct {
+		Name               string
+		Request            events.APIGatewayProxyRequest
+		AllowedOrigin      string
+		ExpectedStatusCode int
+		ExpectedHeaders    map[string]string
+	}{
+		{
+			Name:               "** Testing: Headers of a 201 response with the default origin. **",
+			Request:            events.APIGatewayProxyRequest{Headers: jsonContent(), Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}"},
+			ExpectedStatusCode: 201,
+			ExpectedHeaders:    map[string]string{"Content-Type": "application/json", "Access-Control-Allow-Origin": "*", "Access-Control-Allow-Methods": "POST, OPTIONS"},
+		},
+
+		{
+			Name:               "** Testing: Headers of a 400 response with a configured origin. **",
+			Request:            events.APIGatewayProxyRequest{Headers: jsonContent(), Body: ""},
+			AllowedOrigin:      "https://example.com",
+			ExpectedStatusCode: 400,
+			ExpectedHeaders:    map[string]string{"Content-Type": "application/json", "Access-Control-Allow-Origin": "https://example.com", "Access-Control-Allow-Methods": "POST, OPTIONS"},
+		},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		os.Setenv("ALLOWED_ORIGIN", test.AllowedOrigin)
+		response, _ := AddDevice(context.Background(), test.Request)
+		os.Unsetenv("ALLOWED_ORIGIN")
+
+		if response.StatusCode != test.ExpectedStatusCode {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d>", test.Name, test.ExpectedStatusCode, response.StatusCode)
+		}
+		for header, value := range test.ExpectedHeaders {
+			if response.Headers[header] != value {
+				t.Errorf("%s \n \t<expected %s: %s> <resulted %s: %s>", test.Name, header, value, header, response.Headers[header])
+			}
+		}
+	}
+} // End of TestAddDeviceHeaders function
+
+// The ID of a new device has to be a well-formed UUID.
+func TestAddDeviceIDValidation(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}}
+	defer func() { TestAws = realAws }()
+
+	testCases := []struct {
+		Name               string
+		ID                 string
+		ExpectedStatusCode int
+		ExpectedError      string
+	}{
+		{Name: "** Testing: Valid lowercase UUID. **", ID: "7c9e6679-7425-40de-944b-e07fc1f90ae7", ExpectedStatusCode: 201},
+		{Name: "** Testing: Valid uppercase UUID. **", ID: "7C9E6679-7425-40DE-944B-E07FC1F90AE7", ExpectedStatusCode: 201},
+		{Name: "** Testing: Plain string. **", ID: "id1", ExpectedStatusCode: 400, ExpectedError: "Invalid field: ID must be a UUID"},
+		{Name: "** Testing: UUID without dashes. **", ID: "7c9e6679742540de944be07fc1f90ae7", ExpectedStatusCode: 400, ExpectedError: "Invalid field: ID must be a UUID"},
+		{Name: "** Testing: UUID with a non hex digit. **", ID: "7c9e6679-7425-40de-944b-e07fc1f90aez", ExpectedStatusCode: 400, ExpectedError: "Invalid field: ID must be a UUID"},
+		{Name: "** Testing: UUID with a missing digit. **", ID: "7c9e6679-7425-40de-944b-e07fc1f90ae", ExpectedStatusCode: 400, ExpectedError: "Invalid field: ID must be a UUID"},
+		{Name: "** Testing: Empty ID. **", ID: "", ExpectedStatusCode: 400, ExpectedError: "Missing field: ID"},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{Headers: jsonContent(), Body: "{\"id\":\"" + test.ID + "\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}"})
+		if response.StatusCode != test.ExpectedStatusCode {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, response.Body)
+			continue
+		}
+		if test.ExpectedError == "" {
+			continue
+		}
+		ErrorBody := types.ErrorResponse{}
+		json.Unmarshal([]byte(response.Body), &ErrorBody)
+		if len(ErrorBody.Errors) != 1 || ErrorBody.Errors[0] != test.ExpectedError {
+			t.Errorf("%s \n \t<expected errors: [%s]> <resulted errors: %v>", test.Name, test.ExpectedError, ErrorBody.Errors)
+		}
+	}
+} // End of TestAddDeviceIDValidation function
+
+// A device without id is rejected unless SERVER_GENERATED_IDS=true, then it's created with a generated UUID,
+// which the body and the Location header both return. A provided id is kept and checked either way.
+func TestAddDeviceGeneratedID(t *testing.T) {
+	realAws := TestAws
+	defer func() { TestAws = realAws }()
+
+	testCases := []struct {
+		Name               string
+		GeneratedIDs       string
+		Body               string
+		ExpectedStatusCode int
+		ExpectedID         string
+		ExpectedError      string
+	}{
+		{Name: "** Testing: Omitted id with the flag off. **", GeneratedIDs: "", Body: "{\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}", ExpectedStatusCode: 400, ExpectedError: "Missing field: ID"},
+		{Name: "** Testing: Empty id with the flag off. **", GeneratedIDs: "false", Body: "{\"id\":\"\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}", ExpectedStatusCode: 400, ExpectedError: "Missing field: ID"},
+		{Name: "** Testing: Omitted id with the flag on. **", GeneratedIDs: "true", Body: "{\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}", ExpectedStatusCode: 201},
+		{Name: "** Testing: Empty id with the flag on. **", GeneratedIDs: "true", Body: "{\"id\":\" \",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}", ExpectedStatusCode: 201},
+		{Name: "** Testing: Provided id with the flag on. **", GeneratedIDs: "true", Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}", ExpectedStatusCode: 201, ExpectedID: "7c9e6679-7425-40de-944b-e07fc1f90ae7"},
+		{Name: "** Testing: Invalid id with the flag on. **", GeneratedIDs: "true", Body: "{\"id\":\"id1\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}", ExpectedStatusCode: 400, ExpectedError: "Invalid field: ID must be a UUID"},
+	}
+
+	for _, test := range testCases {
+		t.Setenv("SERVER_GENERATED_IDS", test.GeneratedIDs)
+		mock := &MockDynamoDB{}
+		TestAws = &AmazonWebServices{DynamoDB: mock}
+
+		// Executing each test cases scenario.
+		response, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{Headers: jsonContent(), Body: test.Body})
+		if response.StatusCode != test.ExpectedStatusCode {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, response.Body)
+			continue
+		}
+		if test.ExpectedStatusCode == 400 {
+			ErrorBody := types.ErrorResponse{}
+			json.Unmarshal([]byte(response.Body), &ErrorBody)
+			if len(ErrorBody.Errors) != 1 || ErrorBody.Errors[0] != test.ExpectedError {
+				t.Errorf("%s \n \t<expected errors: [%s]> <resulted errors: %v>", test.Name, test.ExpectedError, ErrorBody.Errors)
+			}
+			continue
+		}
+
+		CreatedDevice := types.Device{}
+		json.Unmarshal([]byte(response.Body), &types.SuccessResponse{Data: &CreatedDevice})
+		stored := aws.StringValue(mock.DeviceItem["id"].S)
+		if test.ExpectedID != "" && CreatedDevice.ID != test.ExpectedID {
+			t.Errorf("%s \n \t<expected id: %s> <resulted id: %s>", test.Name, test.ExpectedID, CreatedDevice.ID)
+		}
+		if len(validation.ValidateDevice(CreatedDevice)) > 0 || stored != CreatedDevice.ID || response.Headers["Location"] != "/devices/"+CreatedDevice.ID {
+			t.Errorf("%s \n \t<expected a stored UUID in the body and the location> <resulted id: %s, stored id: %s, location: %s>", test.Name, CreatedDevice.ID, stored, response.Headers["Location"])
+		}
+	}
+} // End of TestAddDeviceGeneratedID function
+
+// Device fields are accepted up to their maximum length and rejected just over it.
+func TestAddDeviceFieldLengths(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}}
+	defer func() { TestAws = realAws }()
+
+	testCases := []struct {
+		Name               string
+		Device             types.Device
+		ExpectedStatusCode int
+		ExpectedError      string
+	}{
+		{Name: "** Testing: Device Model at its limit. **", Device: types.Device{DeviceModel: strings.Repeat("m", validation.MaxDeviceModelLength)}, ExpectedStatusCode: 201},
+		{Name: "** Testing: Device Model over its limit. **", Device: types.Device{DeviceModel: strings.Repeat("m", validation.MaxDeviceModelLength+1)}, ExpectedStatusCode: 400, ExpectedError: "Invalid field: Device Model must be at most 100 characters"},
+		{Name: "** Testing: Name at its limit. **", Device: types.Device{Name: strings.Repeat("n", validation.MaxNameLength)}, ExpectedStatusCode: 201},
+		{Name: "** Testing: Name over its limit. **", Device: types.Device{Name: strings.Repeat("n", validation.MaxNameLength+1)}, ExpectedStatusCode: 400, ExpectedError: "Invalid field: Name must be at most 100 characters"},
+		{Name: "** Testing: Note at its limit. **", Device: types.Device{Note: strings.Repeat("t", validation.MaxNoteLength)}, ExpectedStatusCode: 201},
+		{Name: "** Testing: Note over its limit. **", Device: types.Device{Note: strings.Repeat("t", validation.MaxNoteLength+1)}, ExpectedStatusCode: 400, ExpectedError: "Invalid field: Note must be at most 500 characters"},
+		{Name: "** Testing: Serial at its limit. **", Device: types.Device{Serial: strings.Repeat("s", validation.MaxSerialLength)}, ExpectedStatusCode: 201},
+		{Name: "** Testing: Serial over its limit. **", Device: types.Device{Serial: strings.Repeat("s", validation.MaxSerialLength+1)}, ExpectedStatusCode: 400, ExpectedError: "Invalid field: Serial must be at most 64 characters"},
+		{Name: "** Testing: Multi-byte Name at its limit. **", Device: types.Device{Name: strings.Repeat("é", validation.MaxNameLength)}, ExpectedStatusCode: 201},
+	}
+
+	for _, test := range testCases {
+		// Filling the fields which are not under test with valid values.
+		device := test.Device
+		device.ID = "7c9e6679-7425-40de-944b-e07fc1f90ae7"
+		if device.DeviceModel == "" {
+			device.DeviceModel = "testDeviceModel"
+		}
+		if device.Name == "" {
+			device.Name = "testName"
+		}
+		if device.Note == "" {
+			device.Note = "testNote"
+		}
+		if device.Serial == "" {
+			device.Serial = "testSerial"
+		}
+		body, _ := json.Marshal(device)
+
+		// Executing each test cases scenario.
+		response, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{Headers: jsonContent(), Body: string(body)})
+		if response.StatusCode != test.ExpectedStatusCode {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, response.Body)
+			continue
+		}
+		if test.ExpectedError == "" {
+			continue
+		}
+		ErrorBody := types.ErrorResponse{}
+		json.Unmarshal([]byte(response.Body), &ErrorBody)
+		if len(ErrorBody.Errors) != 1 || ErrorBody.Errors[0] != test.ExpectedError {
+			t.Errorf("%s \n \t<expected errors: [%s]> <resulted errors: %v>", test.Name, test.ExpectedError, ErrorBody.Errors)
+		}
+	}
+} // End of TestAddDeviceFieldLengths function
+
+// Every failure of a rejected device is detailed with its field and a stable code, along with its message.
+func TestAddDeviceErrorDetails(t *testing.T) {
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}}
+	defer func() { TestAws = realAws }()
+
+	testCases := []struct {
+		Name            string
+		Body            string
+		ExpectedDetails []types.FieldError
+	}{
+		{
+			Name:            "** Testing: Missing field. **",
+			Body:            "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\"}",
+			ExpectedDetails: []types.FieldError{{Field: "serial", Code: validation.CodeMissingField, Message: "Missing field: Serial"}},
+		},
+		{
+			Name:            "** Testing: Over-length field. **",
+			Body:            "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"" + strings.Repeat("n", validation.MaxNameLength+1) + "\",\"note\":\"testNote\",\"serial\":\"testSerial\"}",
+			ExpectedDetails: []types.FieldError{{Field: "name", Code: validation.CodeTooLong, Message: "Invalid field: Name must be at most 100 characters"}},
+		},
+		{
+			Name: "** Testing: Several failures. **",
+			Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"\",\"status\":\"broken\"}",
+			ExpectedDetails: []types.FieldError{
+				{Field: "serial", Code: validation.CodeMissingField, Message: "Missing field: Serial"},
+				{Field: "status", Code: validation.CodeInvalidValue, Message: "Invalid field: Status must be one of active, inactive, retired"},
+			},
+		},
+		{
+			Name:            "** Testing: Id which is not a UUID. **",
+			Body:            "{\"id\":\"id1\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}",
+			ExpectedDetails: []types.FieldError{{Field: "id", Code: validation.CodeInvalidUUID, Message: "Invalid field: ID must be a UUID"}},
+		},
+		{
+			Name:            "** Testing: Field of the wrong type. **",
+			Body:            "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":1,\"note\":\"testNote\",\"serial\":\"testSerial\"}",
+			ExpectedDetails: []types.FieldError{{Field: "name", Code: validation.CodeInvalidType, Message: "Invalid field: Name must be of type string"}},
+		},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{Headers: jsonContent(), Body: test.Body})
+		ErrorBody := types.ErrorResponse{}
+		json.Unmarshal([]byte(response.Body), &ErrorBody)
+		if response.StatusCode != 400 || !reflect.DeepEqual(ErrorBody.Details, test.ExpectedDetails) {
+			t.Errorf("%s \n \t<expected error-code: %d, details: %+v> <resulted error-code: %d, details: %+v>", test.Name, 400, test.ExpectedDetails, response.StatusCode, ErrorBody.Details)
+		}
+	}
+} // End of TestAddDeviceErrorDetails function
+
+// Fields of the wrong type are reported by the schema of a device, instead of failing the decoding of the body.
+func TestAddDeviceSchema(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}}
+	defer func() { TestAws = realAws }()
+
+	testCases := []struct {
+		Name           string
+		Body           string
+		ExpectedErrors []string
+	}{
+		{
+			Name:           "** Testing: Numeric Name. **",
+			Body:           "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":42,\"note\":\"testNote\",\"serial\":\"testSerial\"}",
+			ExpectedErrors: []string{"Invalid field: Name must be of type string"},
+		},
+
+		{
+			Name:           "** Testing: Several mismatched fields, in the order of the device. **",
+			Body:           "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":true,\"serial\":[\"testSerial\"],\"version\":\"1\"}",
+			ExpectedErrors: []string{"Invalid field: Note must be of type string", "Invalid field: Serial must be of type string", "Invalid field: Version must be of type integer"},
+		},
+
+		{
+			Name:           "** Testing: Missing and mismatched fields. **",
+			Body:           "{\"id\":7.5,\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}",
+			ExpectedErrors: []string{"Invalid field: ID must be of type string or integer", "Missing field: Device Model"},
+		},
+
+		{
+			Name:           "** Testing: Body which is not an object. **",
+			Body:           "[\"7c9e6679-7425-40de-944b-e07fc1f90ae7\"]",
+			ExpectedErrors: []string{"Invalid field: Inputs must be of type object"},
+		},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{Headers: jsonContent(), Body: test.Body})
+		ErrorBody := types.ErrorResponse{}
+		json.Unmarshal([]byte(response.Body), &ErrorBody)
+		if response.StatusCode != 400 || ErrorBody.Code != "VALIDATION_FAILED" || strings.Join(ErrorBody.Errors, "; ") != strings.Join(test.ExpectedErrors, "; ") {
+			t.Errorf("%s \n \t<expected error-code: %d, errors: %v> <resulted error-code: %d, errors: %v> <resulted body: %s>", test.Name, 400, test.ExpectedErrors, response.StatusCode, ErrorBody.Errors, response.Body)
+		}
+	}
+} // End of TestAddDeviceSchema function
+
+// An id sent as an integer is decoded as its decimal string, so it's stored, and validated, like the same id in a string.
+func TestAddDeviceNumericID(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}}
+	defer func() { TestAws = realAws }()
+
+	fields := ",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}"
+	testCases := []struct {
+		Name           string
+		ID             string
+		ExpectedErrors []string
+	}{
+		{Name: "** Testing: Numeric id sent as a string. **", ID: "\"12345\"", ExpectedErrors: []string{"Invalid field: ID must be a UUID"}},
+		{Name: "** Testing: Floating point id. **", ID: "1.5", ExpectedErrors: []string{"Invalid field: ID must be of type string or integer"}},
+		{Name: "** Testing: Boolean id. **", ID: "true", ExpectedErrors: []string{"Invalid field: ID must be of type string or integer"}},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{Headers: jsonContent(), Body: "{\"id\":" + test.ID + fields})
+		ErrorBody := types.ErrorResponse{}
+		json.Unmarshal([]byte(response.Body), &ErrorBody)
+		if response.StatusCode != 400 || !reflect.DeepEqual(ErrorBody.Errors, test.ExpectedErrors) {
+			t.Errorf("%s \n \t<expected error-code: %d, errors: %v> <resulted error-code: %d, errors: %v> <resulted body: %s>", test.Name, 400, test.ExpectedErrors, response.StatusCode, ErrorBody.Errors, response.Body)
+		}
+	}
+
+	// Decoding the bodies themselves, the numeric and the string id give the same device, any other number is rejected.
+	var numeric, quoted types.Device
+	if err := json.Unmarshal([]byte("{\"id\":12345"+fields), &numeric); err != nil {
+		t.Errorf("** Testing: Decoding a numeric id. ** \n \t<expected no error> <resulted error: %s>", err.Error())
+	}
+	json.Unmarshal([]byte("{\"id\":\"12345\""+fields), &quoted)
+	numericJson, _ := json.Marshal(numeric)
+	quotedJson, _ := json.Marshal(quoted)
+	if string(numericJson) != string(quotedJson) || numeric.ID != "12345" || !numeric.NumericID() || quoted.NumericID() {
+		t.Errorf("** Testing: Numeric and string ids decode alike. ** \n \t<expected device: %s, numeric> <resulted device: %s, numeric: %t>", quotedJson, numericJson, numeric.NumericID())
+	}
+	// Only the id sent as an integer is exempt from the UUID rule.
+	if Failures := validation.ValidateDevice(numeric); len(Failures) != 0 {
+		t.Errorf("** Testing: Validating a numeric id. ** \n \t<expected no errors> <resulted errors: %v>", Failures.Messages())
+	}
+	for _, id := range []string{"1.5", "1e3", "false", "{}"} {
+		var device types.Device
+		err := json.Unmarshal([]byte("{\"id\":"+id+fields), &device)
+		if typeErr, ok := err.(*json.UnmarshalTypeError); !ok || typeErr.Field != "id" || typeErr.Offset != int64(len("{\"id\":"+id)) {
+			t.Errorf("** Testing: Decoding the id %s. ** \n \t<expected a type error of the id at offset %d> <resulted error: %v>", id, len("{\"id\":"+id), err)
+		}
+	}
+} // End of TestAddDeviceNumericID function
+
+// The TTL of a temporary device has to be in the future, a device without one never expires.
+func TestAddDeviceExpiresAt(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}}
+	defer func() { TestAws = realAws }()
+
+	testCases := []struct {
+		Name               string
+		ExpiresAt          int64
+		ExpectedStatusCode int
+	}{
+		{Name: "** Testing: Without ExpiresAt. **", ExpiresAt: 0, ExpectedStatusCode: 201},
+		{Name: "** Testing: ExpiresAt in the future. **", ExpiresAt: time.Now().Add(time.Hour).Unix(), ExpectedStatusCode: 201},
+		{Name: "** Testing: ExpiresAt in the past. **", ExpiresAt: time.Now().Add(-time.Hour).Unix(), ExpectedStatusCode: 400},
+		{Name: "** Testing: Negative ExpiresAt. **", ExpiresAt: -1, ExpectedStatusCode: 400},
+	}
+
+	for _, test := range testCases {
+		body, _ := json.Marshal(types.Device{ID: "7c9e6679-7425-40de-944b-e07fc1f90ae7", DeviceModel: "testDeviceModel", Name: "testName", Note: "testNote", Serial: "testSerial", ExpiresAt: test.ExpiresAt})
+
+		// Executing each test cases scenario.
+		response, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{Headers: jsonContent(), Body: string(body)})
+		if response.StatusCode != test.ExpectedStatusCode {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, response.Body)
+			continue
+		}
+		if test.ExpectedStatusCode != 400 {
+			continue
+		}
+		ErrorBody := types.ErrorResponse{}
+		json.Unmarshal([]byte(response.Body), &ErrorBody)
+		if len(ErrorBody.Errors) != 1 || ErrorBody.Errors[0] != "Invalid field: ExpiresAt must be in the future" {
+			t.Errorf("%s \n \t<expected errors: [Invalid field: ExpiresAt must be in the future]> <resulted errors: %v>", test.Name, ErrorBody.Errors)
+		}
+	}
+} // End of TestAddDeviceExpiresAt function
+
+// The model code of a device is stored as a DynamoDB number, and has to be a positive integer when it's given.
+func TestAddDeviceModelCode(t *testing.T) {
+	realAws := TestAws
+	defer func() { TestAws = realAws }()
+
+	testCases := []struct {
+		Name               string
+		ModelCode          string
+		ExpectedStatusCode int
+		ExpectedError      string
+	}{
+		{Name: "** Testing: Without ModelCode. **", ModelCode: "", ExpectedStatusCode: 201},
+		{Name: "** Testing: Positive ModelCode. **", ModelCode: "42", ExpectedStatusCode: 201},
+		{Name: "** Testing: Negative ModelCode. **", ModelCode: "-42", ExpectedStatusCode: 400, ExpectedError: "Invalid field: Model Code must be a positive integer"},
+		{Name: "** Testing: ModelCode as a string. **", ModelCode: "\"42\"", ExpectedStatusCode: 400, ExpectedError: "Invalid field: Model Code must be of type integer"},
+		{Name: "** Testing: Fractional ModelCode. **", ModelCode: "4.2", ExpectedStatusCode: 400, ExpectedError: "Invalid field: Model Code must be of type integer"},
+	}
+
+	for _, test := range testCases {
+		mock := &MockDynamoDB{}
+		TestAws = &AmazonWebServices{DynamoDB: mock}
+		body := "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\""
+		if test.ModelCode != "" {
+			body += ",\"modelCode\":" + test.ModelCode
+		}
+
+		// Executing each test cases scenario.
+		response, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{Headers: jsonContent(), Body: body + "}"})
+		if response.StatusCode != test.ExpectedStatusCode {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, response.Body)
+			continue
+		}
+		if test.ExpectedStatusCode == 400 {
+			ErrorBody := types.ErrorResponse{}
+			json.Unmarshal([]byte(response.Body), &ErrorBody)
+			if len(ErrorBody.Errors) != 1 || ErrorBody.Errors[0] != test.ExpectedError {
+				t.Errorf("%s \n \t<expected errors: [%s]> <resulted errors: %v>", test.Name, test.ExpectedError, ErrorBody.Errors)
+			}
+			continue
+		}
+		// A number type attribute, so the list filter compares it as a number, and none at all when it's omitted.
+		modelCode, stored := mock.DeviceItem["modelCode"]
+		if stored != (test.ModelCode != "") || (stored && (modelCode.S != nil || aws.StringValue(modelCode.N) != test.ModelCode)) {
+			t.Errorf("%s \n \t<expected modelCode attribute: %s> <resulted item: %v>", test.Name, test.ModelCode, mock.DeviceItem)
+		}
+	}
+} // End of TestAddDeviceModelCode function
+
+// The status of a created device is validated, and is active when it's omitted.
+func TestAddDeviceStatus(t *testing.T) {
+	realAws := TestAws
+	defer func() { TestAws = realAws }()
+
+	testCases := []struct {
+		Name               string
+		Status             string
+		ExpectedStatusCode int
+		ExpectedStatus     string
+		ExpectedErrors     []string
+	}{
+		{Name: "** Testing: Valid status. **", Status: "retired", ExpectedStatusCode: 201, ExpectedStatus: "retired"},
+		{Name: "** Testing: Omitted status. **", Status: "", ExpectedStatusCode: 201, ExpectedStatus: "active"},
+		{Name: "** Testing: Invalid status. **", Status: "broken", ExpectedStatusCode: 400, ExpectedErrors: []string{"Invalid field: Status must be one of active, inactive, retired"}},
+		{Name: "** Testing: Status of the wrong case. **", Status: "Retired", ExpectedStatusCode: 400, ExpectedErrors: []string{"Invalid field: Status must be one of active, inactive, retired"}},
+	}
+
+	for _, test := range testCases {
+		mock := &MockDynamoDB{}
+		TestAws = &AmazonWebServices{DynamoDB: mock}
+		body, _ := json.Marshal(types.Device{ID: "7c9e6679-7425-40de-944b-e07fc1f90ae7", DeviceModel: "testDeviceModel", Name: "testName", Note: "testNote", Serial: "testSerial", Status: test.Status})
+
+		// Executing each test cases scenario.
+		response, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{Headers: jsonContent(), Body: string(body)})
+		if response.StatusCode != test.ExpectedStatusCode {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, response.Body)
+			continue
+		}
+		if test.ExpectedStatusCode == 201 {
+			if stored := aws.StringValue(mock.DeviceItem["status"].S); stored != test.ExpectedStatus {
+				t.Errorf("%s \n \t<expected stored status: %s> <resulted stored status: %s>", test.Name, test.ExpectedStatus, stored)
+			}
+			continue
+		}
+		ErrorBody := types.ErrorResponse{}
+		json.Unmarshal([]byte(response.Body), &ErrorBody)
+		if !reflect.DeepEqual(ErrorBody.Errors, test.ExpectedErrors) {
+			t.Errorf("%s \n \t<expected errors: %v> <resulted errors: %v>", test.Name, test.ExpectedErrors, ErrorBody.Errors)
+		}
+	}
+} // End of TestAddDeviceStatus function
+
+// The firmware version of a created device is optional, but has to be a semantic version when it's given.
+func TestAddDeviceFirmwareVersion(t *testing.T) {
+	realAws := TestAws
+	defer func() { TestAws = realAws }()
+
+	testCases := []struct {
+		Name               string
+		FirmwareVersion    string
+		ExpectedStatusCode int
+	}{
+		{Name: "** Testing: Valid firmware version. **", FirmwareVersion: "1.10.0-rc.1", ExpectedStatusCode: 201},
+		{Name: "** Testing: Omitted firmware version. **", FirmwareVersion: "", ExpectedStatusCode: 201},
+		{Name: "** Testing: Firmware version without a patch. **", FirmwareVersion: "1.2", ExpectedStatusCode: 400},
+		{Name: "** Testing: Firmware version with a prefix. **", FirmwareVersion: "v1.2.3", ExpectedStatusCode: 400},
+	}
+
+	for _, test := range testCases {
+		mock := &MockDynamoDB{}
+		TestAws = &AmazonWebServices{DynamoDB: mock}
+		body, _ := json.Marshal(types.Device{ID: "7c9e6679-7425-40de-944b-e07fc1f90ae7", DeviceModel: "testDeviceModel", Name: "testName", Note: "testNote", Serial: "testSerial", FirmwareVersion: test.FirmwareVersion})
+
+		// Executing each test cases scenario.
+		response, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{Headers: jsonContent(), Body: string(body)})
+		if response.StatusCode != test.ExpectedStatusCode {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, response.Body)
+			continue
+		}
+		if test.ExpectedStatusCode == 201 {
+			if stored := mock.DeviceItem["firmwareVersion"]; (stored != nil) != (test.FirmwareVersion != "") {
+				t.Errorf("%s \n \t<expected stored firmware version: %q> <resulted stored firmware version: %v>", test.Name, test.FirmwareVersion, stored)
+			}
+			continue
+		}
+		ErrorBody := types.ErrorResponse{}
+		json.Unmarshal([]byte(response.Body), &ErrorBody)
+		if expected := []string{"Invalid field: Firmware Version must be a semantic version, i.e: 1.2.3"}; !reflect.DeepEqual(ErrorBody.Errors, expected) {
+			t.Errorf("%s \n \t<expected errors: %v> <resulted errors: %v>", test.Name, expected, ErrorBody.Errors)
+		}
+	}
+} // End of TestAddDeviceFirmwareVersion function
+
+// The note is required unless REQUIRE_NOTE=false, whether it's empty or omitted, and the other fields are still checked.
+func TestAddDeviceRequireNote(t *testing.T) {
+	realAws := TestAws
+	defer func() { TestAws = realAws }()
+
+	testCases := []struct {
+		Name               string
+		RequireNote        string
+		Body               string
+		ExpectedStatusCode int
+		ExpectedErrors     []string
+	}{
+		{Name: "** Testing: Empty note by default. **", RequireNote: "", Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"\",\"serial\":\"testSerial\"}", ExpectedStatusCode: 400, ExpectedErrors: []string{"Missing field: Note"}},
+		{Name: "** Testing: Empty note with the flag on. **", RequireNote: "true", Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"  \",\"serial\":\"testSerial\"}", ExpectedStatusCode: 400, ExpectedErrors: []string{"Missing field: Note"}},
+		{Name: "** Testing: Empty note with the flag off. **", RequireNote: "false", Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"\",\"serial\":\"testSerial\"}", ExpectedStatusCode: 201},
+		{Name: "** Testing: Omitted note with the flag off. **", RequireNote: "false", Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"serial\":\"testSerial\"}", ExpectedStatusCode: 201},
+		{Name: "** Testing: Other fields with the flag off. **", RequireNote: "false", Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"\",\"serial\":\"testSerial\"}", ExpectedStatusCode: 400, ExpectedErrors: []string{"Missing field: Name"}},
+		{Name: "** Testing: Long note with the flag off. **", RequireNote: "false", Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"" + strings.Repeat("n", validation.MaxNoteLength+1) + "\",\"serial\":\"testSerial\"}", ExpectedStatusCode: 400, ExpectedErrors: []string{fmt.Sprintf("Invalid field: Note must be at most %d characters", validation.MaxNoteLength)}},
+	}
+
+	for _, test := range testCases {
+		t.Setenv("REQUIRE_NOTE", test.RequireNote)
+		TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}}
+
+		// Executing each test cases scenario.
+		response, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{Headers: jsonContent(), Body: test.Body})
+		if response.StatusCode != test.ExpectedStatusCode {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, response.Body)
+			continue
+		}
+		if test.ExpectedStatusCode != 400 {
+			continue
+		}
+		ErrorBody := types.ErrorResponse{}
+		json.Unmarshal([]byte(response.Body), &ErrorBody)
+		if !reflect.DeepEqual(ErrorBody.Errors, test.ExpectedErrors) {
+			t.Errorf("%s \n \t<expected errors: %v> <resulted errors: %v>", test.Name, test.ExpectedErrors, ErrorBody.Errors)
+		}
+	}
+} // End of TestAddDeviceRequireNote function
+
+// An empty optional note is stored as an empty attribute by default, and left out of the item with OMIT_EMPTY_ATTRIBUTES=true.
+func TestAddDeviceOmitEmptyAttributes(t *testing.T) {
+	realAws := TestAws
+	defer func() { TestAws = realAws }()
+	t.Setenv("REQUIRE_NOTE", "false")
+
+	testCases := []struct {
+		Name         string
+		OmitEmpty    string
+		ExpectedNote bool
+	}{
+		{Name: "** Testing: Empty note stored by default. **", OmitEmpty: "", ExpectedNote: true},
+		{Name: "** Testing: Empty note omitted with the flag on. **", OmitEmpty: "true", ExpectedNote: false},
+	}
+
+	for _, test := range testCases {
+		t.Setenv("OMIT_EMPTY_ATTRIBUTES", test.OmitEmpty)
+		mock := &MockDynamoDB{}
+		TestAws = &AmazonWebServices{DynamoDB: mock}
+
+		// Executing each test cases scenario.
+		response, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{Headers: jsonContent(), Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"\",\"serial\":\"testSerial\"}"})
+		if response.StatusCode != 201 {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", test.Name, 201, response.StatusCode, response.Body)
+			continue
+		}
+		if _, stored := mock.DeviceItem["note"]; stored != test.ExpectedNote {
+			t.Errorf("%s \n \t<expected note stored: %t> <resulted item: %v>", test.Name, test.ExpectedNote, mock.DeviceItem)
+		}
+		// The required fields are stored either way.
+		for _, attribute := range []string{"id", "device_model", "name", "serial"} {
+			if mock.DeviceItem[attribute] == nil || aws.StringValue(mock.DeviceItem[attribute].S) == "" {
+				t.Errorf("%s \n \t<expected %s stored> <resulted item: %v>", test.Name, attribute, mock.DeviceItem)
+			}
+		}
+	}
+} // End of TestAddDeviceOmitEmptyAttributes function
+
+// The required fields are the five of a device by default, REQUIRED_FIELDS narrows them down while the id stays required.
+func TestAddDeviceRequiredFields(t *testing.T) {
+	realAws := TestAws
+	defer func() { TestAws = realAws }()
+
+	testCases := []struct {
+		Name               string
+		RequiredFields     string
+		Body               string
+		ExpectedStatusCode int
+		ExpectedErrors     []string
+	}{
+		{Name: "** Testing: Only an id by default. **", RequiredFields: "", Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\"}", ExpectedStatusCode: 400, ExpectedErrors: []string{"Missing field: Device Model", "Missing field: Name", "Missing field: Note", "Missing field: Serial"}},
+		{Name: "** Testing: Custom required fields provided. **", RequiredFields: "ID,Name,Serial", Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"name\":\"testName\",\"serial\":\"testSerial\"}", ExpectedStatusCode: 201},
+		{Name: "** Testing: Custom required fields missing. **", RequiredFields: " id , name ,serial", Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"\"}", ExpectedStatusCode: 400, ExpectedErrors: []string{"Missing field: Name", "Missing field: Serial"}},
+		{Name: "** Testing: Id required anyway. **", RequiredFields: "Name", Body: "{\"name\":\"testName\"}", ExpectedStatusCode: 400, ExpectedErrors: []string{"Missing field: ID"}},
+		{Name: "** Testing: Optional fields still checked. **", RequiredFields: "ID", Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"serial\":\"" + strings.Repeat("s", validation.MaxSerialLength+1) + "\"}", ExpectedStatusCode: 400, ExpectedErrors: []string{fmt.Sprintf("Invalid field: Serial must be at most %d characters", validation.MaxSerialLength)}},
+	}
+
+	for _, test := range testCases {
+		t.Setenv("REQUIRED_FIELDS", test.RequiredFields)
+		mock := &MockDynamoDB{}
+		TestAws = &AmazonWebServices{DynamoDB: mock}
+
+		// Executing each test cases scenario.
+		response, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{Headers: jsonContent(), Body: test.Body})
+		if response.StatusCode != test.ExpectedStatusCode {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, response.Body)
+			continue
+		}
+		if test.ExpectedStatusCode != 400 {
+			// An empty field which keys an index is left out of the stored device.
+			if _, stored := mock.DeviceItem["device_model"]; stored {
+				t.Errorf("%s \n \t<expected no deviceModel attribute> <resulted item: %v>", test.Name, mock.DeviceItem)
+			}
+			continue
+		}
+		ErrorBody := types.ErrorResponse{}
+		json.Unmarshal([]byte(response.Body), &ErrorBody)
+		if !reflect.DeepEqual(ErrorBody.Errors, test.ExpectedErrors) {
+			t.Errorf("%s \n \t<expected errors: %v> <resulted errors: %v>", test.Name, test.ExpectedErrors, ErrorBody.Errors)
+		}
+	}
+} // End of TestAddDeviceRequiredFields function
+
+// Tags of a created device are stored as a DynamoDB map, within their limits.
+func TestAddDeviceTags(t *testing.T) {
+	realAws := TestAws
+	defer func() { TestAws = realAws }()
+
+	tooMany := map[string]string{}
+	for i := 0; i <= validation.MaxTags; i++ {
+		tooMany[fmt.Sprintf("key%d", i)] = "value"
+	}
+	tooManyJson, _ := json.Marshal(tooMany)
+	testCases := []struct {
+		Name               string
+		Tags               string
+		ExpectedStatusCode int
+		ExpectedErrors     []string
+	}{
+		{Name: "** Testing: Device with tags. **", Tags: "{\"floor\":\"2\",\"room\":\"kitchen\"}", ExpectedStatusCode: 201},
+		{Name: "** Testing: Too many tags. **", Tags: string(tooManyJson), ExpectedStatusCode: 400, ExpectedErrors: []string{"Invalid field: Tags must be at most 50 tags"}},
+		{Name: "** Testing: Tag value too long. **", Tags: "{\"floor\":\"" + strings.Repeat("2", validation.MaxTagValueLength+1) + "\"}", ExpectedStatusCode: 400, ExpectedErrors: []string{"Invalid field: Tags.floor must be at most 256 characters"}},
+		{Name: "** Testing: Tag key too long. **", Tags: "{\"" + strings.Repeat("k", validation.MaxTagKeyLength+1) + "\":\"2\"}", ExpectedStatusCode: 400, ExpectedErrors: []string{"Invalid field: Tags keys must be at most 128 characters"}},
+		{Name: "** Testing: Empty tag key. **", Tags: "{\"\":\"2\"}", ExpectedStatusCode: 400, ExpectedErrors: []string{"Invalid field: Tags must not have an empty key"}},
+		{Name: "** Testing: Tag value of the wrong type. **", Tags: "{\"floor\":2}", ExpectedStatusCode: 400, ExpectedErrors: []string{"Invalid field: Tags.floor must be of type string"}},
+	}
+
+	for _, test := range testCases {
+		mock := &MockDynamoDB{}
+		TestAws = &AmazonWebServices{DynamoDB: mock}
+
+		// Executing each test cases scenario.
+		response, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{
+			Headers: jsonContent(),
+			Body:    "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\",\"tags\":" + test.Tags + "}",
+		})
+		if response.StatusCode != test.ExpectedStatusCode {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, response.Body)
+			continue
+		}
+		if test.ExpectedStatusCode == 201 {
+			tags := mock.DeviceItem["tags"]
+			if tags == nil || aws.StringValue(tags.M["floor"].S) != "2" || aws.StringValue(tags.M["room"].S) != "kitchen" {
+				t.Errorf("%s \n \t<expected stored map: floor=2, room=kitchen> <resulted stored tags: %v>", test.Name, tags)
+			}
+			continue
+		}
+		ErrorBody := types.ErrorResponse{}
+		json.Unmarshal([]byte(response.Body), &ErrorBody)
+		if !reflect.DeepEqual(ErrorBody.Errors, test.ExpectedErrors) {
+			t.Errorf("%s \n \t<expected errors: %v> <resulted errors: %v>", test.Name, test.ExpectedErrors, ErrorBody.Errors)
+		}
+	}
+
+	// XML has no maps, so the tags are rendered as elements sorted by key.
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}}
+	response, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{
+		Headers: map[string]string{"Accept": "application/xml", "Content-Type": "application/json"},
+		Body:    "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\",\"tags\":{\"room\":\"kitchen\",\"floor\":\"2\"}}",
+	})
+	if expected := "<tags><tag key=\"floor\">2</tag><tag key=\"room\">kitchen</tag></tags>"; response.StatusCode != 201 || !strings.Contains(response.Body, expected) {
+		t.Errorf("** Testing: Tags of an XML response. ** \n \t<expected body containing: %s> <resulted error-code: %d> <resulted body: %s>", expected, response.StatusCode, response.Body)
+	}
+} // End of TestAddDeviceTags function
+
+// Only a body declared as JSON is accepted, whatever the case of the header or its parameters.
+func TestAddDeviceContentType(t *testing.T) {
+	realAws := TestAws
+	defer func() { TestAws = realAws }()
+
+	testCases := []struct {
+		Name               string
+		Headers            map[string]string
+		ExpectedStatusCode int
+	}{
+		{Name: "** Testing: JSON content type. **", Headers: map[string]string{"Content-Type": "application/json"}, ExpectedStatusCode: 201},
+		{Name: "** Testing: JSON content type with a charset. **", Headers: map[string]string{"Content-Type": "application/json; charset=utf-8"}, ExpectedStatusCode: 201},
+		{Name: "** Testing: JSON content type in another case. **", Headers: map[string]string{"content-type": "Application/JSON"}, ExpectedStatusCode: 201},
+		{Name: "** Testing: Missing content type. **", Headers: nil, ExpectedStatusCode: 415},
+		{Name: "** Testing: Text content type. **", Headers: map[string]string{"Content-Type": "text/plain"}, ExpectedStatusCode: 415},
+		{Name: "** Testing: Form content type. **", Headers: map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, ExpectedStatusCode: 415},
+	}
+
+	for _, test := range testCases {
+		mock := &MockDynamoDB{}
+		TestAws = &AmazonWebServices{DynamoDB: mock}
+
+		// Executing each test cases scenario.
+		response, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{
+			Headers: test.Headers,
+			Body:    "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}",
+		})
+		if response.StatusCode != test.ExpectedStatusCode {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, response.Body)
+			continue
+		}
+		if test.ExpectedStatusCode != 415 {
+			continue
+		}
+		expected := "{\"message\":\"Unsupported Media Type: Content-Type must be application/json.\",\"code\":\"UNSUPPORTED_MEDIA_TYPE\"}"
+		if response.Body != expected || mock.DevicePuts != 0 {
+			t.Errorf("%s \n \t<expected body: %s, no put> <resulted body: %s, puts: %d>", test.Name, expected, response.Body, mock.DevicePuts)
+		}
+	}
+} // End of TestAddDeviceContentType function
+
+// A dry run validates the device and returns it as it would be created, without any PutItem call.
+func TestAddDeviceDryRun(t *testing.T) {
+	realAws := TestAws
+	defer func() { TestAws = realAws }()
+
+	body := "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}"
+	testCases := []struct {
+		Name               string
+		Request            events.APIGatewayProxyRequest
+		ExpectedStatusCode int
+		ExpectedPuts       int
+	}{
+		{
+			Name:               "** Testing: Dry run by query parameter. **",
+			Request:            events.APIGatewayProxyRequest{Headers: jsonContent(), QueryStringParameters: map[string]string{"dryRun": "true"}, Body: body},
+			ExpectedStatusCode: 200,
+			ExpectedPuts:       0,
+		},
+		{
+			Name:               "** Testing: Dry run by header. **",
+			Request:            events.APIGatewayProxyRequest{Headers: map[string]string{"Content-Type": "application/json", "x-dry-run": "TRUE"}, Body: body},
+			ExpectedStatusCode: 200,
+			ExpectedPuts:       0,
+		},
+		{
+			Name:               "** Testing: Dry run of an invalid device. **",
+			Request:            events.APIGatewayProxyRequest{Headers: jsonContent(), QueryStringParameters: map[string]string{"dryRun": "true"}, Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\"}"},
+			ExpectedStatusCode: 400,
+			ExpectedPuts:       0,
+		},
+		{
+			Name:               "** Testing: Dry run turned off. **",
+			Request:            events.APIGatewayProxyRequest{Headers: jsonContent(), QueryStringParameters: map[string]string{"dryRun": "false"}, Body: body},
+			ExpectedStatusCode: 201,
+			ExpectedPuts:       1,
+		},
+	}
+
+	for _, test := range testCases {
+		mock := &MockDynamoDB{}
+		TestAws = &AmazonWebServices{DynamoDB: mock}
+
+		// Executing each test cases scenario.
+		response, _ := AddDevice(context.Background(), test.Request)
+		if response.StatusCode != test.ExpectedStatusCode || mock.PutAttempts != test.ExpectedPuts {
+			t.Errorf("%s \n \t<expected error-code: %d, puts: %d> <resulted error-code: %d, puts: %d> <resulted body: %s>", test.Name, test.ExpectedStatusCode, test.ExpectedPuts, response.StatusCode, mock.PutAttempts, response.Body)
+			continue
+		}
+		if test.ExpectedStatusCode != 200 {
+			continue
+		}
+		Device := types.Device{}
+		json.Unmarshal([]byte(response.Body), &types.SuccessResponse{Data: &Device})
+		if Device.ID != "7c9e6679-7425-40de-944b-e07fc1f90ae7" || Device.Version != 1 || Device.Status != "active" || Device.CreatedAt == "" {
+			t.Errorf("%s \n \t<expected the would-be-created device> <resulted body: %s>", test.Name, response.Body)
+		}
+		if _, ok := response.Headers["Location"]; ok {
+			t.Errorf("%s \n \t<expected no Location header> <resulted headers: %v>", test.Name, response.Headers)
+		}
+	}
+} // End of TestAddDeviceDryRun function
+
+// The owner of a created device is the caller, whatever the body claims.
+func TestAddDeviceOwner(t *testing.T) {
+	realAws := TestAws
+	defer func() { TestAws = realAws }()
+
+	testCases := []struct {
+		Name            string
+		Authorizer      map[string]interface{}
+		ExpectedOwnerID string
+	}{
+		{Name: "** Testing: Owner from the claims of the caller. **", Authorizer: map[string]interface{}{"claims": map[string]interface{}{"sub": "tenant-a"}}, ExpectedOwnerID: "tenant-a"},
+		{Name: "** Testing: Owner from the context of a Lambda authorizer. **", Authorizer: map[string]interface{}{"sub": "tenant-b"}, ExpectedOwnerID: "tenant-b"},
+		{Name: "** Testing: No owner without an authorizer. **", Authorizer: nil, ExpectedOwnerID: ""},
+	}
+
+	for _, test := range testCases {
+		TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}}
+
+		// Executing each test cases scenario.
+		response, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{
+			Headers:        jsonContent(),
+			RequestContext: events.APIGatewayProxyRequestContext{Authorizer: test.Authorizer},
+			Body:           "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\",\"ownerId\":\"tenant-z\"}",
+		})
+		CreatedDevice := types.Device{}
+		json.Unmarshal([]byte(response.Body), &types.SuccessResponse{Data: &CreatedDevice})
+		if response.StatusCode != 201 || CreatedDevice.OwnerID != test.ExpectedOwnerID {
+			t.Errorf("%s \n \t<expected error-code: %d, owner: %s> <resulted error-code: %d, owner: %s>", test.Name, 201, test.ExpectedOwnerID, response.StatusCode, CreatedDevice.OwnerID)
+		}
+	}
+} // End of TestAddDeviceOwner function
+
+// Whitespace around the fields is trimmed before the checks, so a field of only whitespace is missing.
+func TestAddDeviceWhitespace(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}}
+	defer func() { TestAws = realAws }()
+
+	testCases := []struct {
+		Name               string
+		Request            events.APIGatewayProxyRequest
+		ExpectedStatusCode int
+		ExpectedErrors     []string
+	}{
+		{
+			Name:               "** Testing: JSON with whitespace only fields - Name & Serial **",
+			Request:            events.APIGatewayProxyRequest{Headers: jsonContent(), Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"   \",\"note\":\"testNote\",\"serial\":\"\\t\\n\"}"},
+			ExpectedStatusCode: 400,
+			ExpectedErrors:     []string{"Missing field: Name", "Missing field: Serial"},
+		},
+
+		{
+			Name:               "** Testing: JSON with a Serial at its limit between spaces. **",
+			Request:            events.APIGatewayProxyRequest{Headers: jsonContent(), Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"  " + strings.Repeat("s", validation.MaxSerialLength) + "  \"}"},
+			ExpectedStatusCode: 201,
+		},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := AddDevice(context.Background(), test.Request)
+		if response.StatusCode != test.ExpectedStatusCode {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, response.Body)
+			continue
+		}
+		ErrorBody := types.ErrorResponse{}
+		json.Unmarshal([]byte(response.Body), &ErrorBody)
+		if strings.Join(ErrorBody.Errors, "; ") != strings.Join(test.ExpectedErrors, "; ") {
+			t.Errorf("%s \n \t<expected errors: %v> <resulted errors: %v>", test.Name, test.ExpectedErrors, ErrorBody.Errors)
+		}
+	}
+
+	// The stored device has no stray whitespace, i.e: " A020000102 " doesn't look like another serial.
+	response, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{Headers: jsonContent(), Body: "{\"id\":\" 7c9e6679-7425-40de-944b-e07fc1f90ae7 \",\"deviceModel\":\" testDeviceModel\",\"name\":\"testName \",\"note\":\"  testNote\\n\",\"serial\":\" A020000102 \"}"})
+	CreatedDevice := types.Device{}
+	json.Unmarshal([]byte(response.Body), &types.SuccessResponse{Data: &CreatedDevice})
+	if response.StatusCode != 201 || CreatedDevice.ID != "7c9e6679-7425-40de-944b-e07fc1f90ae7" || CreatedDevice.DeviceModel != "testDeviceModel" ||
+		CreatedDevice.Name != "testName" || CreatedDevice.Note != "testNote" || CreatedDevice.Serial != "A020000102" {
+		t.Errorf("** Testing: JSON with spaces around the fields. ** \n \t<expected error-code: %d and trimmed fields> <resulted error-code: %d> <resulted body: %s>", 201, response.StatusCode, response.Body)
+	}
+} // End of TestAddDeviceWhitespace function
+
+// HTML in the free text fields is neutralized before the device is stored, unless SANITIZE_INPUT=false.
+func TestAddDeviceSanitization(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	mock := &MockDynamoDB{}
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	testCases := []struct {
+		Name         string
+		Sanitize     string
+		DeviceName   string
+		Note         string
+		ExpectedName string
+		ExpectedNote string
+	}{
+		{
+			Name:         "** Testing: Script payloads are stripped. **",
+			DeviceName:   "Sensor<script>alert(1)</script>",
+			Note:         "<img src=x onerror=alert(1)>Testing a <b onclick=\"alert(1)\">sensor</b>.",
+			ExpectedName: "Sensor",
+			ExpectedNote: "Testing a <b>sensor</b>.",
+		},
+
+		{
+			Name:         "** Testing: Brackets outside a tag are escaped. **",
+			DeviceName:   "Sensor <3",
+			Note:         "a < b <<script>script>alert(1)<</script>/script>",
+			ExpectedName: "Sensor &lt;3",
+			ExpectedNote: "a &lt; b",
+		},
+
+		{
+			Name:         "** Testing: Sanitization turned off. **",
+			Sanitize:     "false",
+			DeviceName:   "Sensor<script>alert(1)</script>",
+			Note:         "testNote",
+			ExpectedName: "Sensor<script>alert(1)</script>",
+			ExpectedNote: "testNote",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Setenv("SANITIZE_INPUT", test.Sanitize)
+		body, _ := json.Marshal(types.Device{ID: "7c9e6679-7425-40de-944b-e07fc1f90ae7", DeviceModel: "testDeviceModel", Name: test.DeviceName, Note: test.Note, Serial: "testSerial"})
+
+		// Executing each test cases scenario.
+		response, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{Headers: jsonContent(), Body: string(body)})
+		if response.StatusCode != 201 {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", test.Name, 201, response.StatusCode, response.Body)
+			continue
+		}
+		storedName, storedNote := aws.StringValue(mock.DeviceItem["name"].S), aws.StringValue(mock.DeviceItem["note"].S)
+		if storedName != test.ExpectedName || storedNote != test.ExpectedNote {
+			t.Errorf("%s \n \t<expected stored name: %s, note: %s> <resulted stored name: %s, note: %s>", test.Name, test.ExpectedName, test.ExpectedNote, storedName, storedNote)
+		}
+	}
+
+	// A name of only a script is missing once it's neutralized.
+	t.Setenv("SANITIZE_INPUT", "")
+	response, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{Headers: jsonContent(), Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"<script>alert(1)</script>\",\"note\":\"testNote\",\"serial\":\"testSerial\"}"})
+	expectedBody := "{\"message\":\"Validation failed.\",\"code\":\"VALIDATION_FAILED\",\"errors\":[\"Missing field: Name\"],\"details\":[{\"field\":\"name\",\"code\":\"MISSING_FIELD\",\"message\":\"Missing field: Name\"}]}"
+	if response.StatusCode != 400 || response.Body != expectedBody {
+		t.Errorf("** Testing: JSON with a script only name. ** \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", 400, response.StatusCode, expectedBody, response.Body)
+	}
+} // End of TestAddDeviceSanitization function
+
+// A body over MAX_BODY_BYTES is rejected with 413 before it's unmarshalled, a base64 body by its decoded length.
+func TestAddDeviceBodySize(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}}
+	defer func() { TestAws = realAws }()
+	t.Setenv("MAX_BODY_BYTES", "1024")
+
+	device := "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}"
+	atLimit := device + strings.Repeat(" ", 1024-len(device))
+	testCases := []struct {
+		Name               string
+		Request            events.APIGatewayProxyRequest
+		ExpectedStatusCode int
+	}{
+		{
+			Name:               "** Testing: Body at the limit. **",
+			Request:            events.APIGatewayProxyRequest{Headers: jsonContent(), Body: atLimit},
+			ExpectedStatusCode: 201,
+		},
+
+		{
+			Name:               "** Testing: Body over the limit. **",
+			Request:            events.APIGatewayProxyRequest{Headers: jsonContent(), Body: atLimit + " "},
+			ExpectedStatusCode: 413,
+		},
+
+		{
+			Name:               "** Testing: Base64 body at the limit once decoded. **",
+			Request:            events.APIGatewayProxyRequest{Headers: jsonContent(), Body: base64.StdEncoding.EncodeToString([]byte(atLimit)), IsBase64Encoded: true},
+			ExpectedStatusCode: 201,
+		},
+
+		{
+			Name:               "** Testing: Base64 body over the limit once decoded. **",
+			Request:            events.APIGatewayProxyRequest{Headers: jsonContent(), Body: base64.StdEncoding.EncodeToString([]byte(atLimit + " ")), IsBase64Encoded: true},
+			ExpectedStatusCode: 413,
+		},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := AddDevice(context.Background(), test.Request)
+		if response.StatusCode != test.ExpectedStatusCode {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, response.Body)
+		}
+		if test.ExpectedStatusCode == 413 && response.Body != "{\"message\":\"Payload Too Large: body exceeds the maximum size.\",\"code\":\"PAYLOAD_TOO_LARGE\"}" {
+			t.Errorf("%s \n \t<resulted body: %s>", test.Name, response.Body)
+		}
+	}
+} // End of TestAddDeviceBodySize function
+
+// A retry with the same Idempotency-Key returns the original response without inserting again.
+func TestAddDeviceIdempotency(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	mock := &MockDynamoDB{}
+	TestAws = &AmazonWebServices{DynamoDB: mock, IdempotencyTableName: MockIdempotencyTable}
+	defer func() { TestAws = realAws }()
+
+	body := "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}"
+	otherBody := "{\"id\":\"9b2e1d4a-3f5c-4e8a-b6d7-0c1f2a3b4c5d\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}"
+	headers := map[string]string{"Idempotency-Key": "key_test", "Content-Type": "application/json"}
+
+	first, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{Headers: headers, Body: body})
+	if first.StatusCode != 201 {
+		t.Fatalf("** Testing: First use of an Idempotency-Key. ** \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", 201, first.StatusCode, first.Body)
+	}
+
+	// Header names are case-insensitive.
+	retry, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{Headers: map[string]string{"idempotency-key": "key_test", "content-type": "application/json"}, Body: body})
+	if retry.StatusCode != 201 || retry.Body != first.Body || mock.DevicePuts != 1 {
+		t.Errorf("** Testing: Repeat with the same body. ** \n \t<expected error-code: %d, body: %s, device puts: 1> <resulted error-code: %d, body: %s, device puts: %d>", 201, first.Body, retry.StatusCode, retry.Body, mock.DevicePuts)
+	}
+
+	conflict, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{Headers: headers, Body: otherBody})
+	if conflict.StatusCode != 422 || mock.DevicePuts != 1 {
+		t.Errorf("** Testing: Repeat with a different body. ** \n \t<expected error-code: %d, device puts: 1> <resulted error-code: %d, device puts: %d>", 422, conflict.StatusCode, mock.DevicePuts)
+	}
+
+	// An expired record is not used anymore, even if DynamoDB has not purged it yet.
+	mock.IdempotencyRecords["key_test"]["expiresAt"] = &dynamodb.AttributeValue{N: aws.String("1")}
+	expired, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{Headers: headers, Body: otherBody})
+	if expired.StatusCode != 201 || mock.DevicePuts != 2 {
+		t.Errorf("** Testing: Repeat after the TTL window. ** \n \t<expected error-code: %d, device puts: 2> <resulted error-code: %d, device puts: %d>", 201, expired.StatusCode, mock.DevicePuts)
+	}
+} // End of TestAddDeviceIdempotency function
+
+// A throttled PutItem is retried, so the user sees a single 201 instead of a 500.
+func TestAddDeviceThrottlingRetries(t *testing.T) {
+	realAws := TestAws
+	realDelay := retryBaseDelay
+	retryBaseDelay = time.Millisecond
+	defer func() {
+		TestAws = realAws
+		retryBaseDelay = realDelay
+	}()
+
+	request := events.APIGatewayProxyRequest{Headers: jsonContent(), Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}"}
+
+	// Fails twice then succeeds, within the default 3 attempts.
+	mock := &MockDynamoDB{Throttles: 2}
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	response, _ := AddDevice(context.Background(), request)
+	if response.StatusCode != 201 || mock.PutAttempts != 3 || mock.DevicePuts != 1 {
+		t.Errorf("** Testing: Throttled twice then succeeded. ** \n \t<expected error-code: %d, attempts: 3, device puts: 1> <resulted error-code: %d, attempts: %d, device puts: %d>", 201, response.StatusCode, mock.PutAttempts, mock.DevicePuts)
+	}
+
+	// Fails more than the configured attempts, so still a 500.
+	os.Setenv("DDB_MAX_RETRIES", "2")
+	defer os.Unsetenv("DDB_MAX_RETRIES")
+	mock = &MockDynamoDB{Throttles: 2}
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	response, _ = AddDevice(context.Background(), request)
+	if response.StatusCode != 500 || mock.PutAttempts != 2 || mock.DevicePuts != 0 {
+		t.Errorf("** Testing: Throttled more than DDB_MAX_RETRIES. ** \n \t<expected error-code: %d, attempts: 2, device puts: 0> <resulted error-code: %d, attempts: %d, device puts: %d>", 500, response.StatusCode, mock.PutAttempts, mock.DevicePuts)
+	}
+} // End of TestAddDeviceThrottlingRetries function
+
+// A request of another method than POST is answered with HTTP 405, listing POST in its Allow header.
+func TestAddDeviceMethodNotAllowed(t *testing.T) {
+	mock := &MockDynamoDB{}
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	body := "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}"
+	response, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: "DELETE", Headers: jsonContent(), Body: body})
+	expectedBody := "{\"message\":\"Method Not Allowed: use POST.\",\"code\":\"METHOD_NOT_ALLOWED\"}"
+	if response.StatusCode != 405 || response.Headers["Allow"] != "POST" || response.Body != expectedBody || mock.DevicePuts != 0 {
+		t.Errorf("** Testing: DELETE request. ** \n \t<expected error-code: %d, allow: POST, device puts: 0> <resulted error-code: %d, allow: %s, device puts: %d> \n \t<expected body: %s> <resulted body: %s>", 405, response.StatusCode, response.Headers["Allow"], mock.DevicePuts, expectedBody, response.Body)
+	}
+
+	response, _ = AddDevice(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: "POST", Headers: jsonContent(), Body: body})
+	if response.StatusCode != 201 {
+		t.Errorf("** Testing: POST request. ** \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", 201, response.StatusCode, response.Body)
+	}
+} // End of TestAddDeviceMethodNotAllowed function
+
+// A failed DynamoDB call is answered with a generic message, unless ERROR_VERBOSITY=debug lists the error itself.
+func TestAddDeviceErrorVerbosity(t *testing.T) {
+	t.Setenv("DDB_MAX_RETRIES", "1")
+	realAws := TestAws
+	realBreaker := writeBreaker
+	// A breaker of its own, so the failures of this test don't open the shared one.
+	writeBreaker = breaker.New(100, time.Millisecond)
+	defer func() {
+		TestAws = realAws
+		writeBreaker = realBreaker
+	}()
+
+	testCases := []struct {
+		Name         string
+		Verbosity    string
+		ExpectedBody string
+	}{
+		{Name: "** Testing: Default verbosity. **", Verbosity: "", ExpectedBody: "{\"message\":\"Internal Server Error.\",\"code\":\"DATABASE_ERROR\"}"},
+		{Name: "** Testing: Public verbosity. **", Verbosity: "public", ExpectedBody: "{\"message\":\"Internal Server Error.\",\"code\":\"DATABASE_ERROR\"}"},
+		{Name: "** Testing: Unknown verbosity. **", Verbosity: "verbose", ExpectedBody: "{\"message\":\"Internal Server Error.\",\"code\":\"DATABASE_ERROR\"}"},
+		{Name: "** Testing: Debug verbosity. **", Verbosity: "debug", ExpectedBody: "{\"message\":\"Internal Server Error.\",\"code\":\"DATABASE_ERROR\",\"errors\":[\"ProvisionedThroughputExceededException: The level of configured provisioned throughput for the table was exceeded\"]}"},
+	}
+
+	request := events.APIGatewayProxyRequest{Headers: jsonContent(), Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}"}
+	for _, test := range testCases {
+		t.Setenv("ERROR_VERBOSITY", test.Verbosity)
+		TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{Throttles: 1}}
+
+		// Executing each test cases scenario.
+		response, _ := AddDevice(context.Background(), request)
+		if response.StatusCode != 500 || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, 500, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+} // End of TestAddDeviceErrorVerbosity function
+
+// Consecutive failures of DynamoDB open the circuit breaker: the next creates fail fast with HTTP 503 without calling
+// DynamoDB, till the cooldown has passed and a trial create closes it again, or a failed one reopens it.
+func TestAddDeviceCircuitBreaker(t *testing.T) {
+	t.Setenv("DDB_MAX_RETRIES", "1")
+	realAws := TestAws
+	realBreaker := writeBreaker
+	writeBreaker = breaker.New(2, 20*time.Millisecond)
+	defer func() {
+		TestAws = realAws
+		writeBreaker = realBreaker
+	}()
+
+	request := events.APIGatewayProxyRequest{Headers: jsonContent(), Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}"}
+	mock := &MockDynamoDB{Throttles: 100}
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+
+	steps := []struct {
+		Name               string
+		Throttles          int
+		Wait               time.Duration
+		ExpectedStatusCode int
+		ExpectedAttempts   int
+	}{
+		{Name: "** Testing: First failure. **", Throttles: 100, ExpectedStatusCode: 500, ExpectedAttempts: 1},
+		{Name: "** Testing: Failure opening the breaker. **", Throttles: 100, ExpectedStatusCode: 500, ExpectedAttempts: 2},
+		{Name: "** Testing: Fast failure of an open breaker. **", Throttles: 100, ExpectedStatusCode: 503, ExpectedAttempts: 2},
+		{Name: "** Testing: Failed trial of a half-open breaker. **", Throttles: 100, Wait: 30 * time.Millisecond, ExpectedStatusCode: 500, ExpectedAttempts: 3},
+		{Name: "** Testing: Fast failure of a reopened breaker. **", Throttles: 0, ExpectedStatusCode: 503, ExpectedAttempts: 3},
+		{Name: "** Testing: Trial closing the breaker. **", Throttles: 0, Wait: 30 * time.Millisecond, ExpectedStatusCode: 201, ExpectedAttempts: 4},
+		{Name: "** Testing: Closed breaker. **", Throttles: 0, ExpectedStatusCode: 409, ExpectedAttempts: 5},
+	}
+
+	for _, step := range steps {
+		time.Sleep(step.Wait)
+		mock.Throttles = step.Throttles
+		mock.ExistingIDs = map[string]bool{}
+		if step.ExpectedStatusCode == 409 {
+			mock.ExistingIDs["7c9e6679-7425-40de-944b-e07fc1f90ae7"] = true
+		}
+
+		// Executing each step of the scenario.
+		response, _ := AddDevice(context.Background(), request)
+		if response.StatusCode != step.ExpectedStatusCode || mock.PutAttempts != step.ExpectedAttempts {
+			t.Errorf("%s \n \t<expected error-code: %d, attempts: %d> <resulted error-code: %d, attempts: %d> <resulted body: %s>", step.Name, step.ExpectedStatusCode, step.ExpectedAttempts, response.StatusCode, mock.PutAttempts, response.Body)
+		}
+		if step.ExpectedStatusCode == 503 && (response.Headers["Retry-After"] != "1" || !strings.Contains(response.Body, "SERVICE_UNAVAILABLE")) {
+			t.Errorf("%s \n \t<expected Retry-After: 1 and the SERVICE_UNAVAILABLE code> <resulted Retry-After: %s> <resulted body: %s>", step.Name, response.Headers["Retry-After"], response.Body)
+		}
+	}
+} // End of TestAddDeviceCircuitBreaker function
+
+// A DynamoDB call which runs out of time is answered with HTTP 504.
+func TestAddDeviceCancelledContext(t *testing.T) {
+	realAws := TestAws
+	mock := &MockDynamoDB{}
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	request := events.APIGatewayProxyRequest{Headers: jsonContent(), Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}"}
+	response, _ := AddDevice(ctx, request)
+	if response.StatusCode != 504 || mock.DevicePuts != 0 {
+		t.Errorf("** Testing: Already cancelled context. ** \n \t<expected error-code: %d, device puts: 0> <resulted error-code: %d, device puts: %d> <resulted body: %s>", 504, response.StatusCode, mock.DevicePuts, response.Body)
+	}
+} // End of TestAddDeviceCancelledContext function
+
+// Every request is logged as a JSON line carrying its API Gateway request ID, and DynamoDB errors at error level.
+func TestAddDeviceLogging(t *testing.T) {
+	realAws := TestAws
+	realLogger := logger
+	var output bytes.Buffer
+	logger = slog.New(slog.NewJSONHandler(&output, nil))
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}}
+	defer func() {
+		TestAws = realAws
+		logger = realLogger
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	request := events.APIGatewayProxyRequest{
+		Headers:        jsonContent(),
+		Body:           "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}",
+		RequestContext: events.APIGatewayProxyRequestContext{RequestID: "test-request-id"},
+	}
+	AddDevice(ctx, request)
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("** Testing: Log lines of a failed request. ** \n \t<expected 2 log lines> <resulted log: %s>", output.String())
+	}
+	var databaseError, handled map[string]interface{}
+	json.Unmarshal([]byte(lines[0]), &databaseError)
+	json.Unmarshal([]byte(lines[1]), &handled)
+	if databaseError["level"] != "ERROR" || databaseError["requestId"] != "test-request-id" || databaseError["error"] == nil {
+		t.Errorf("** Testing: Log of the DynamoDB error. ** \n \t<expected an ERROR line with the request ID and the error> <resulted log line: %s>", lines[0])
+	}
+	if handled["requestId"] != "test-request-id" || handled["handler"] != "AddDevice" || handled["statusCode"] != float64(504) || handled["latencyMs"] == nil {
+		t.Errorf("** Testing: Log of the handled request. ** \n \t<expected the request ID, handler, status code and latency> <resulted log line: %s>", lines[1])
+	}
+} // End of TestAddDeviceLogging function
+
+// The X-Request-ID of the caller, or else the trace ID of its traceparent, correlates the request: it's echoed in the
+// X-Request-ID header of the response and carried by the log line. Without a usable one a new ID is generated.
+func TestAddDeviceCorrelationID(t *testing.T) {
+	realAws := TestAws
+	realLogger := logger
+	defer func() {
+		TestAws = realAws
+		logger = realLogger
+	}()
+
+	testCases := []struct {
+		Name       string
+		Headers    map[string]string
+		ExpectedID string
+	}{
+		{Name: "** Testing: X-Request-ID of the caller. **", Headers: map[string]string{"x-request-id": "caller-request-42"}, ExpectedID: "caller-request-42"},
+		{Name: "** Testing: Trace ID of the traceparent. **", Headers: map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}, ExpectedID: "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{Name: "** Testing: X-Request-ID over the traceparent. **", Headers: map[string]string{"X-Request-ID": "caller-request-42", "traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}, ExpectedID: "caller-request-42"},
+		{Name: "** Testing: Generated ID. **", Headers: map[string]string{}},
+		{Name: "** Testing: Unprintable X-Request-ID. **", Headers: map[string]string{"X-Request-ID": "forged\nline"}},
+		{Name: "** Testing: Invalid traceparent. **", Headers: map[string]string{"traceparent": "00-00000000000000000000000000000000-00f067aa0ba902b7-01"}},
+	}
+
+	for _, test := range testCases {
+		var output bytes.Buffer
+		logger = slog.New(slog.NewJSONHandler(&output, nil))
+		TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}, TableName: "devices_test", SkipSerialCheck: true}
+		headers := jsonContent()
+		for name, value := range test.Headers {
+			headers[name] = value
+		}
+
+		// Executing each test cases scenario.
+		response, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{
+			Headers: headers,
+			Body:    "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}",
+		})
+		var handled map[string]interface{}
+		for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+			var logged map[string]interface{}
+			if json.Unmarshal([]byte(line), &logged) == nil && logged["msg"] == "Request handled" {
+				handled = logged
+			}
+		}
+		echoed := response.Headers["X-Request-ID"]
+		if echoed == "" || handled["correlationId"] != echoed || (test.ExpectedID != "" && echoed != test.ExpectedID) {
+			t.Errorf("%s \n \t<expected correlation ID: %q> <resulted header: %q, log line: %s>", test.Name, test.ExpectedID, echoed, output.String())
+		}
+		if test.ExpectedID == "" && (echoed == "forged\nline" || strings.HasPrefix(echoed, "0000")) {
+			t.Errorf("%s \n \t<expected a generated correlation ID> <resulted header: %q>", test.Name, echoed)
+		}
+	}
+} // End of TestAddDeviceCorrelationID function
+
+// Only the first request of a container is logged as its cold start.
+func TestAddDeviceColdStart(t *testing.T) {
+	realAws := TestAws
+	realLogger := logger
+	realColdStart := coldStart
+	var output bytes.Buffer
+	logger = slog.New(slog.NewJSONHandler(&output, nil))
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}, TableName: "devices_test", SkipSerialCheck: true}
+	coldStart = true
+	defer func() {
+		TestAws = realAws
+		logger = realLogger
+		coldStart = realColdStart
+	}()
+
+	// Two invocations of the same container.
+	for i := 0; i < 2; i++ {
+		AddDevice(context.Background(), events.APIGatewayProxyRequest{
+			Headers: jsonContent(),
+			Body:    "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}",
+		})
+	}
+
+	var coldStarts []interface{}
+	for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		var logged map[string]interface{}
+		json.Unmarshal([]byte(line), &logged)
+		if logged["msg"] == "Request handled" {
+			coldStarts = append(coldStarts, logged["coldStart"])
+		}
+	}
+	if !reflect.DeepEqual(coldStarts, []interface{}{true, false}) || coldStart {
+		t.Errorf("** Testing: Cold start of the container. ** \n \t<expected coldStart: [true false]> <resulted coldStart: %v> <resulted log: %s>", coldStarts, output.String())
+	}
+} // End of TestAddDeviceColdStart function
+
+// A rejected body counts on the DeviceValidationFailures metric once for every failing field, as an EMF log line.
+func TestAddDeviceValidationMetric(t *testing.T) {
+	realOutput := metrics.Output
+	defer func() { metrics.Output = realOutput }()
+
+	testCases := []struct {
+		Name           string
+		Body           string
+		ExpectedFields []string
+	}{
+		{
+			Name:           "** Testing: Missing fields. **",
+			Body:           "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"name\":\"testName\",\"note\":\"testNote\"}",
+			ExpectedFields: []string{"deviceModel", "serial"},
+		},
+		{
+			Name:           "** Testing: Invalid JSON. **",
+			Body:           "{\"id\":",
+			ExpectedFields: []string{"body"},
+		},
+		{
+			Name:           "** Testing: Valid device. **",
+			Body:           "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}",
+			ExpectedFields: nil,
+		},
+	}
+
+	for _, test := range testCases {
+		var output bytes.Buffer
+		metrics.Output = &output
+
+		// Executing each test cases scenario.
+		AddDevice(context.Background(), events.APIGatewayProxyRequest{Headers: jsonContent(), Body: test.Body})
+		var fields []string
+		for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+			if line == "" {
+				continue
+			}
+			var metric struct {
+				Aws struct {
+					CloudWatchMetrics []struct {
+						Namespace  string
+						Dimensions [][]string
+						Metrics    []struct{ Name, Unit string }
+					}
+				} `json:"_aws"`
+				Field                    string
+				DeviceValidationFailures int
+			}
+			json.Unmarshal([]byte(line), &metric)
+			definitions := metric.Aws.CloudWatchMetrics
+			if len(definitions) != 1 || len(definitions[0].Metrics) != 1 || definitions[0].Metrics[0].Name != "DeviceValidationFailures" || !reflect.DeepEqual(definitions[0].Dimensions, [][]string{{"Field"}}) || metric.DeviceValidationFailures != 1 {
+				t.Errorf("%s \n \t<expected an EMF line of DeviceValidationFailures by Field> <resulted line: %s>", test.Name, line)
+			}
+			fields = append(fields, metric.Field)
+		}
+		if !reflect.DeepEqual(fields, test.ExpectedFields) {
+			t.Errorf("%s \n \t<expected fields: %v> <resulted fields: %v>", test.Name, test.ExpectedFields, fields)
+		}
+	}
+} // End of TestAddDeviceValidationMetric function
+
+// With RETURN_CAPACITY=true the consumed capacity of the put is asked for and logged, it's not asked for otherwise.
+func TestAddDeviceConsumedCapacity(t *testing.T) {
+	realAws := TestAws
+	realLogger := logger
+	defer func() {
+		TestAws = realAws
+		logger = realLogger
+	}()
+
+	testCases := []struct {
+		Name                   string
+		ReturnCapacity         string
+		ExpectedReturnCapacity string
+	}{
+		{Name: "** Testing: Capacity asked for. **", ReturnCapacity: "true", ExpectedReturnCapacity: "TOTAL"},
+		{Name: "** Testing: Capacity not asked for. **", ReturnCapacity: "", ExpectedReturnCapacity: ""},
+	}
+
+	for _, test := range testCases {
+		t.Setenv("RETURN_CAPACITY", test.ReturnCapacity)
+		var output bytes.Buffer
+		logger = slog.New(slog.NewJSONHandler(&output, nil))
+		mock := &MockDynamoDB{}
+		TestAws = &AmazonWebServices{DynamoDB: mock, TableName: "devices_test", SkipSerialCheck: true}
+
+		// Executing each test cases scenario.
+		response, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{
+			Headers: jsonContent(),
+			Body:    "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}",
+		})
+		if response.StatusCode != 201 || mock.DeviceReturnCapacity != test.ExpectedReturnCapacity {
+			t.Errorf("%s \n \t<expected error-code: %d, ReturnConsumedCapacity: %q> <resulted error-code: %d, ReturnConsumedCapacity: %q>", test.Name, 201, test.ExpectedReturnCapacity, response.StatusCode, mock.DeviceReturnCapacity)
+		}
+		logged := strings.Contains(output.String(), "\"msg\":\"Consumed capacity\",\"operation\":\"PutItem\",\"table\":\"devices_test\",\"capacityUnits\":1")
+		if expectedLogged := test.ExpectedReturnCapacity != ""; logged != expectedLogged {
+			t.Errorf("%s \n \t<expected the consumed capacity logged: %t> <resulted log: %s>", test.Name, expectedLogged, output.String())
+		}
+	}
+} // End of TestAddDeviceConsumedCapacity function
+
+// Without _X_AMZN_TRACE_ID, i.e: outside of Lambda, tracing is skipped and the handler still works.
+func TestAddDeviceTracingDisabled(t *testing.T) {
+	t.Setenv("_X_AMZN_TRACE_ID", "")
+	realAws := TestAws
+	mock := &MockDynamoDB{}
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	request := events.APIGatewayProxyRequest{Headers: jsonContent(), Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}"}
+	response, _ := AddDevice(context.Background(), request)
+	if response.StatusCode != 201 || mock.DevicePuts != 1 {
+		t.Errorf("** Testing: Adding a device with tracing disabled. ** \n \t<expected error-code: %d, device puts: 1> <resulted error-code: %d, device puts: %d> <resulted body: %s>", 201, response.StatusCode, mock.DevicePuts, response.Body)
+	}
+} // End of TestAddDeviceTracingDisabled function
+
+// A missing required setting is reported by validateConfig, and AddDevice answers with it instead of calling DynamoDB.
+func TestAddDeviceMisconfigured(t *testing.T) {
+	t.Setenv("AWS_REGION", "us-east-2")
+	t.Setenv("DEVICES_TABLE_NAME", "")
+	realAws := TestAws
+	mock := &MockDynamoDB{}
+	TestAws = &AmazonWebServices{DynamoDB: mock, ConfigError: validateConfig()}
+	defer func() { TestAws = realAws }()
+
+	request := events.APIGatewayProxyRequest{Headers: jsonContent(), Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}"}
+	expectedBody := "{\"message\":\"Service misconfigured: DEVICES_TABLE_NAME not set\",\"code\":\"SERVICE_MISCONFIGURED\"}"
+	response, _ := AddDevice(context.Background(), request)
+	if response.StatusCode != 500 || response.Body != expectedBody || mock.PutAttempts != 0 {
+		t.Errorf("** Testing: Missing DEVICES_TABLE_NAME. ** \n \t<expected error-code: %d, put attempts: 0> <resulted error-code: %d, put attempts: %d> \n \t<expected body: %s> <resulted body: %s>", 500, response.StatusCode, mock.PutAttempts, expectedBody, response.Body)
+	}
+
+	t.Setenv("DEVICES_TABLE_NAME", "devices_test")
+	if err := validateConfig(); err != nil {
+		t.Errorf("** Testing: Complete configuration. ** \n \t<expected error: %v> <resulted error: %v>", nil, err)
+	}
+} // End of TestAddDeviceMisconfigured function
+
+// A device with a serial which is already registered is rejected, unless the check is skipped.
+func TestAddDeviceDuplicateSerial(t *testing.T) {
+	realAws := TestAws
+	mock := &MockDynamoDB{ExistingSerials: map[string]bool{"testSerial": true}}
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	request := events.APIGatewayProxyRequest{Headers: jsonContent(), Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}"}
+	expectedBody := "{\"message\":\"Serial already registered\",\"code\":\"SERIAL_EXISTS\"}"
+	response, _ := AddDevice(context.Background(), request)
+	if response.StatusCode != 409 || response.Body != expectedBody || mock.DevicePuts != 0 {
+		t.Errorf("** Testing: Already registered serial. ** \n \t<expected error-code: %d, device puts: 0> <resulted error-code: %d, device puts: %d> \n \t<expected body: %s> <resulted body: %s>", 409, response.StatusCode, mock.DevicePuts, expectedBody, response.Body)
+	}
+
+	// Data migrations skip the check.
+	TestAws.SkipSerialCheck = true
+	response, _ = AddDevice(context.Background(), request)
+	if response.StatusCode != 201 || mock.DevicePuts != 1 {
+		t.Errorf("** Testing: Already registered serial with the check skipped. ** \n \t<expected error-code: %d, device puts: 1> <resulted error-code: %d, device puts: %d> <resulted body: %s>", 201, response.StatusCode, mock.DevicePuts, response.Body)
+	}
+} // End of TestAddDeviceDuplicateSerial function
+
+// The duplicate serial check only fetches the keys of the devices having the serial.
+func TestSerialExistsProjection(t *testing.T) {
+	mock := &MockDynamoDB{ExistingSerials: map[string]bool{"A020000102": true}}
+	test_aws := &AmazonWebServices{DynamoDB: mock, TableName: "devices_test"}
+
+	exists, err := test_aws.SerialExists(context.Background(), "A020000102", "")
+	if err != nil || !exists || mock.SerialProjection != "#id" {
+		t.Errorf("** Testing: Projection of the serial check. ** \n \t<expected exists: true, projection: #id> <resulted exists: %t, projection: %s, error: %v>", exists, mock.SerialProjection, err)
+	}
+} // End of TestSerialExistsProjection function
+
+// With a serials table, the device and its serial marker are written in one transaction,
+// and a cancelled transaction tells which constraint has failed.
+func TestAddDeviceTransactPut(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	mock := &MockDynamoDB{ExistingIDs: map[string]bool{"16fd2706-8baf-433b-82eb-8c7fada847da": true}, ExistingSerials: map[string]bool{"A020000102": true}}
+	TestAws = &AmazonWebServices{DynamoDB: mock, TableName: "devices_test", SerialsTableName: "serials_test"}
+	defer func() { TestAws = realAws }()
+
+	testCases := []struct {
+		Name               string
+		Request            events.APIGatewayProxyRequest
+		ExpectedBody       string
+		ExpectedStatusCode int
+	}{
+		{
+			Name:               "** Testing: JSON with an already registered serial. **",
+			Request:            events.APIGatewayProxyRequest{Headers: jsonContent(), Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"A020000102\"}"},
+			ExpectedBody:       "{\"message\":\"Serial already registered\",\"code\":\"SERIAL_EXISTS\"}",
+			ExpectedStatusCode: 409,
+		},
+
+		{
+			Name:               "** Testing: JSON with an already existing id. **",
+			Request:            events.APIGatewayProxyRequest{Headers: jsonContent(), Body: "{\"id\":\"16fd2706-8baf-433b-82eb-8c7fada847da\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"B020000102\"}"},
+			ExpectedBody:       "{\"message\":\"Device with this ID already exists.\",\"code\":\"DEVICE_EXISTS\"}",
+			ExpectedStatusCode: 409,
+		},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := AddDevice(context.Background(), test.Request)
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+	if mock.DevicePuts != 0 {
+		t.Errorf("** Testing: Cancelled transactions. ** \n \t<expected device puts: 0> <resulted device puts: %d>", mock.DevicePuts)
+	}
+
+	// A unique serial is written along with the device.
+	response, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{Headers: jsonContent(), Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"B020000102\"}"})
+	if response.StatusCode != 201 || mock.DevicePuts != 1 || mock.DeviceTable != "devices_test" {
+		t.Errorf("** Testing: JSON with a unique serial. ** \n \t<expected error-code: %d, device puts: 1> <resulted error-code: %d, device puts: %d> <resulted body: %s>", 201, response.StatusCode, mock.DevicePuts, response.Body)
+	}
+} // End of TestAddDeviceTransactPut function
+
+// An existing device is rejected with HTTP 409 by default, ALLOW_OVERWRITE=true overwrites it with HTTP 200 instead,
+// with or without a serials table, while a new device is still created with HTTP 201.
+func TestAddDeviceAllowOverwrite(t *testing.T) {
+	realAws := TestAws
+	defer func() { TestAws = realAws }()
+
+	existingBody := "{\"id\":\"16fd2706-8baf-433b-82eb-8c7fada847da\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"A020000102\"}"
+	newBody := "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"B020000102\"}"
+	testCases := []struct {
+		Name               string
+		AllowOverwrite     bool
+		SerialsTableName   string
+		Body               string
+		ExpectedStatusCode int
+		ExpectedAction     string
+	}{
+		{Name: "** Testing: Existing device by default. **", AllowOverwrite: false, Body: existingBody, ExpectedStatusCode: 409},
+		{Name: "** Testing: Existing device in a transaction by default. **", AllowOverwrite: false, SerialsTableName: "serials_test", Body: existingBody, ExpectedStatusCode: 409},
+		{Name: "** Testing: Existing device overwritten. **", AllowOverwrite: true, Body: existingBody, ExpectedStatusCode: 200, ExpectedAction: "update"},
+		{Name: "** Testing: Existing device overwritten in a transaction. **", AllowOverwrite: true, SerialsTableName: "serials_test", Body: existingBody, ExpectedStatusCode: 200, ExpectedAction: "update"},
+		{Name: "** Testing: New device with overwrites allowed. **", AllowOverwrite: true, Body: newBody, ExpectedStatusCode: 201, ExpectedAction: "create"},
+	}
+
+	existing := map[string]*dynamodb.AttributeValue{
+		"id":     {S: aws.String("16fd2706-8baf-433b-82eb-8c7fada847da")},
+		"name":   {S: aws.String("oldName")},
+		"serial": {S: aws.String("A020000102")},
+	}
+	for _, test := range testCases {
+		mock := &MockDynamoDB{ExistingIDs: map[string]bool{"16fd2706-8baf-433b-82eb-8c7fada847da": true}, ExistingDevices: map[string]map[string]*dynamodb.AttributeValue{"16fd2706-8baf-433b-82eb-8c7fada847da": existing}}
+		if test.SerialsTableName != "" {
+			mock.ExistingSerials = map[string]bool{"A020000102": true}
+		}
+		TestAws = &AmazonWebServices{DynamoDB: mock, TableName: "devices_test", SerialsTableName: test.SerialsTableName, AuditTableName: MockAuditTable, AllowOverwrite: test.AllowOverwrite}
+
+		// Executing each test cases scenario.
+		response, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{Headers: jsonContent(), Body: test.Body})
+		if response.StatusCode != test.ExpectedStatusCode {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, response.Body)
+			continue
+		}
+		if test.ExpectedAction == "" {
+			if mock.DevicePuts != 0 {
+				t.Errorf("%s \n \t<expected device puts: 0> <resulted device puts: %d>", test.Name, mock.DevicePuts)
+			}
+			continue
+		}
+		// Only a created device is pointed to, and the audit trail tells an overwrite from a create.
+		_, located := response.Headers["Location"]
+		if mock.DevicePuts != 1 || located != (test.ExpectedStatusCode == 201) || len(mock.AuditRecords) != 1 || aws.StringValue(mock.AuditRecords[0]["action"].S) != test.ExpectedAction {
+			t.Errorf("%s \n \t<expected device puts: 1, location: %t, audit action: %s> <resulted device puts: %d, location: %t, audit records: %v>", test.Name, test.ExpectedStatusCode == 201, test.ExpectedAction, mock.DevicePuts, located, mock.AuditRecords)
+			continue
+		}
+		// The audit trail keeps the overwritten device as the one before.
+		if before := mock.AuditRecords[0]["beforeHash"]; test.ExpectedStatusCode == 200 && (before == nil || aws.StringValue(before.S) != audit.Hash(existing)) {
+			t.Errorf("%s \n \t<expected before hash: %s> <resulted before hash: %v>", test.Name, audit.Hash(existing), before)
+		}
+	}
+} // End of TestAddDeviceAllowOverwrite function
+
+// An overwrite replaces the stored device like an update: it keeps its createdAt and allowedGroups, continues from its
+// version, moves the marker of its serial and keeps it in the history. A soft deleted device, or one of another tenant,
+// is never overwritten, and one which has changed since it was read is answered with HTTP 409 to be retried.
+func TestAddDeviceOverwrite(t *testing.T) {
+	realAws := TestAws
+	defer func() { TestAws = realAws }()
+
+	id := "16fd2706-8baf-433b-82eb-8c7fada847da"
+	stored := func(attributes map[string]*dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+		item := map[string]*dynamodb.AttributeValue{
+			"id":            {S: aws.String(id)},
+			"name":          {S: aws.String("oldName")},
+			"serial":        {S: aws.String("A020000102")},
+			"createdAt":     {S: aws.String("2018-11-02T10:04:05Z")},
+			"version":       {N: aws.String("2")},
+			"ownerId":       {S: aws.String("owner-1")},
+			"allowedGroups": {L: []*dynamodb.AttributeValue{{S: aws.String("ops")}}},
+		}
+		for name, attribute := range attributes {
+			item[name] = attribute
+		}
+		return item
+	}
+	body := func(serial string) string {
+		return "{\"id\":\"" + id + "\",\"deviceModel\":\"testDeviceModel\",\"name\":\"newName\",\"note\":\"testNote\",\"serial\":\"" + serial + "\"}"
+	}
+	caller := events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"claims": map[string]interface{}{"sub": "owner-1"}}}
+	other := events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"claims": map[string]interface{}{"sub": "owner-2"}}}
+	testCases := []struct {
+		Name               string
+		Stored             map[string]*dynamodb.AttributeValue
+		Context            events.APIGatewayProxyRequestContext
+		Serial             string
+		ExpectedStatusCode int
+		// Writes of the transaction: the device, then the new marker, the freed marker and the history, if any.
+		ExpectedWrites []string
+	}{
+		{Name: "** Testing: Overwrite of the owner's device. **", Stored: stored(nil), Context: caller, Serial: "A020000102", ExpectedStatusCode: 200, ExpectedWrites: []string{"devices_test", "serials_test", "history_test"}},
+		{Name: "** Testing: Overwrite with another serial. **", Stored: stored(nil), Context: caller, Serial: "B020000102", ExpectedStatusCode: 200, ExpectedWrites: []string{"devices_test", "serials_test", "-serials_test", "history_test"}},
+		{Name: "** Testing: Overwrite with the serial of another device. **", Stored: stored(nil), Context: caller, Serial: "C020000102", ExpectedStatusCode: 409},
+		{Name: "** Testing: Overwrite of a device of another tenant. **", Stored: stored(nil), Context: other, Serial: "A020000102", ExpectedStatusCode: 409},
+		{Name: "** Testing: Overwrite of a soft deleted device. **", Stored: stored(map[string]*dynamodb.AttributeValue{"deleted": {BOOL: aws.Bool(true)}}), Context: caller, Serial: "A020000102", ExpectedStatusCode: 409},
+		{Name: "** Testing: Overwrite of a device deleted meanwhile. **", Context: caller, Serial: "A020000102", ExpectedStatusCode: 409},
+	}
+
+	for _, test := range testCases {
+		mock := &MockDynamoDB{
+			ExistingIDs:     map[string]bool{id: true},
+			ExistingSerials: map[string]bool{"A020000102": true, "C020000102": true},
+			ExistingDevices: map[string]map[string]*dynamodb.AttributeValue{},
+		}
+		if test.Stored != nil {
+			mock.ExistingDevices[id] = test.Stored
+		}
+		TestAws = &AmazonWebServices{DynamoDB: mock, TableName: "devices_test", SerialsTableName: "serials_test", HistoryTableName: "history_test", AllowOverwrite: true}
+
+		// Executing each test cases scenario.
+		response, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{Headers: jsonContent(), Body: body(test.Serial), RequestContext: test.Context})
+		if response.StatusCode != test.ExpectedStatusCode {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, response.Body)
+			continue
+		}
+		if test.ExpectedStatusCode != 200 {
+			if mock.DevicePuts != 0 {
+				t.Errorf("%s \n \t<expected device puts: 0> <resulted device puts: %d>", test.Name, mock.DevicePuts)
+			}
+			continue
+		}
+		var writes []string
+		for _, write := range mock.OverwriteItems {
+			if write.Delete != nil {
+				writes = append(writes, "-"+aws.StringValue(write.Delete.TableName))
+			} else {
+				writes = append(writes, aws.StringValue(write.Put.TableName))
+			}
+		}
+		if !reflect.DeepEqual(writes, test.ExpectedWrites) {
+			t.Errorf("%s \n \t<expected writes: %v> <resulted writes: %v>", test.Name, test.ExpectedWrites, writes)
+		}
+		// The freed marker is the one of the stored serial, and the history keeps the stored device at its version.
+		if len(writes) == 4 && aws.StringValue(mock.OverwriteItems[2].Delete.Key["serial"].S) != "A020000102" {
+			t.Errorf("%s \n \t<expected freed serial: A020000102> <resulted freed serial: %v>", test.Name, mock.OverwriteItems[2].Delete.Key)
+		}
+		if revision := aws.StringValue(mock.OverwriteItems[len(writes)-1].Put.Item["revision"].S); revision != "2018-11-02T10:04:05Z#0000000002" {
+			t.Errorf("%s \n \t<expected history revision: 2018-11-02T10:04:05Z#0000000002> <resulted history revision: %s>", test.Name, revision)
+		}
+		Overwritten := types.Device{}
+		json.Unmarshal([]byte(response.Body), &types.SuccessResponse{Data: &Overwritten})
+		if Overwritten.Name != "newName" || Overwritten.CreatedAt != "2018-11-02T10:04:05Z" || Overwritten.UpdatedAt == "" || Overwritten.Version != 3 || !reflect.DeepEqual(Overwritten.AllowedGroups, []string{"ops"}) {
+			t.Errorf("%s \n \t<expected name: newName, createdAt: 2018-11-02T10:04:05Z, version: 3, allowedGroups: [ops]> <resulted body: %s>", test.Name, response.Body)
+		}
+	}
+} // End of TestAddDeviceOverwrite function
+
+// A retried create gets the device which it has already stored, while a create of another device with the same id conflicts.
+func TestAddDeviceReturnExisting(t *testing.T) {
+	realAws := TestAws
+	defer func() { TestAws = realAws }()
+
+	id := "16fd2706-8baf-433b-82eb-8c7fada847da"
+	existing := map[string]*dynamodb.AttributeValue{
+		"id":           {S: aws.String(id)},
+		"device_model": {S: aws.String("testDeviceModel")},
+		"name":         {S: aws.String("testName")},
+		"note":         {S: aws.String("testNote")},
+		"serial":       {S: aws.String("A020000102")},
+		"createdAt":    {S: aws.String("2024-01-02T03:04:05Z")},
+		"version":      {N: aws.String("1")},
+	}
+	sameBody := "{\"id\":\"16fd2706-8baf-433b-82eb-8c7fada847da\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"A020000102\"}"
+	otherBody := "{\"id\":\"16fd2706-8baf-433b-82eb-8c7fada847da\",\"deviceModel\":\"testDeviceModel\",\"name\":\"otherName\",\"note\":\"testNote\",\"serial\":\"A020000102\"}"
+	testCases := []struct {
+		Name               string
+		ReturnExisting     bool
+		SerialsTableName   string
+		Body               string
+		ExpectedStatusCode int
+	}{
+		{Name: "** Testing: Same device returned. **", ReturnExisting: true, Body: sameBody, ExpectedStatusCode: 200},
+		{Name: "** Testing: Same device returned in a transaction. **", ReturnExisting: true, SerialsTableName: "serials_test", Body: sameBody, ExpectedStatusCode: 200},
+		{Name: "** Testing: Different device with the same id. **", ReturnExisting: true, Body: otherBody, ExpectedStatusCode: 409},
+		{Name: "** Testing: Same device by default. **", ReturnExisting: false, Body: sameBody, ExpectedStatusCode: 409},
+	}
+
+	for _, test := range testCases {
+		mock := &MockDynamoDB{ExistingIDs: map[string]bool{id: true}, ExistingDevices: map[string]map[string]*dynamodb.AttributeValue{id: existing}}
+		if test.SerialsTableName != "" {
+			mock.ExistingSerials = map[string]bool{"A020000102": true}
+		}
+		TestAws = &AmazonWebServices{DynamoDB: mock, TableName: "devices_test", SerialsTableName: test.SerialsTableName, ReturnExisting: test.ReturnExisting}
+
+		// Executing each test cases scenario.
+		response, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{Headers: jsonContent(), Body: test.Body})
+		if response.StatusCode != test.ExpectedStatusCode || mock.DevicePuts != 0 {
+			t.Errorf("%s \n \t<expected error-code: %d, device puts: 0> <resulted error-code: %d, device puts: %d> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, mock.DevicePuts, response.Body)
+			continue
+		}
+		// The stored device is returned as is, with its own creation time.
+		if test.ExpectedStatusCode == 200 && !strings.Contains(response.Body, "\"createdAt\":\"2024-01-02T03:04:05Z\"") {
+			t.Errorf("%s \n \t<expected the existing device> <resulted body: %s>", test.Name, response.Body)
+		}
+	}
+} // End of TestAddDeviceReturnExisting function
+
+// Mocking EventBridge through eventbridgeiface.
+type MockEventBridge struct {
+	eventbridgeiface.EventBridgeAPI
+	// Entries which have been published, and the error which the mocked PutEvents returns.
+	Entries []*eventbridge.PutEventsRequestEntry
+	Error   error
+}
+
+// Custom PutEventsWithContext function for overriding the PutEventsWithContext of addDevice.go for using in test scenarios.
+func (self *MockEventBridge) PutEventsWithContext(ctx aws.Context, input *eventbridge.PutEventsInput, options ...request.Option) (*eventbridge.PutEventsOutput, error) {
+	if self.Error != nil {
+		return nil, self.Error
+	}
+	self.Entries = append(self.Entries, input.Entries...)
+	return &eventbridge.PutEventsOutput{FailedEntryCount: aws.Int64(0)}, nil
+}
+
+// A created device is published as a "device.created" event, and a failed publish doesn't fail the request.
+func TestAddDeviceEvent(t *testing.T) {
+	realAws := TestAws
+	bus := &MockEventBridge{}
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}, EventBridge: bus, EventBusName: "devices_test"}
+	defer func() { TestAws = realAws }()
+
+	request := events.APIGatewayProxyRequest{Headers: jsonContent(), Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}"}
+	response, _ := AddDevice(context.Background(), request)
+	if response.StatusCode != 201 || len(bus.Entries) != 1 {
+		t.Fatalf("** Testing: Publishing the created device. ** \n \t<expected error-code: %d, events: 1> <resulted error-code: %d, events: %d>", 201, response.StatusCode, len(bus.Entries))
+	}
+	entry := bus.Entries[0]
+	expectedDetail := "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\"}"
+	if aws.StringValue(entry.EventBusName) != "devices_test" || aws.StringValue(entry.DetailType) != "device.created" || aws.StringValue(entry.Detail) != expectedDetail {
+		t.Errorf("** Testing: Published event. ** \n \t<expected bus: devices_test, detail type: device.created, detail: %s> <resulted entry: %v>", expectedDetail, entry)
+	}
+
+	// An unavailable EventBridge only gets logged.
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}, EventBridge: &MockEventBridge{Error: errors.New("unexpected Error has occurred")}, EventBusName: "devices_test"}
+	response, _ = AddDevice(context.Background(), request)
+	if response.StatusCode != 201 {
+		t.Errorf("** Testing: Failed publish of the created device. ** \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", 201, response.StatusCode, response.Body)
+	}
+} // End of TestAddDeviceEvent function
+
+// A created device is recorded in the audit trail with its caller and the hash of what has been stored.
+func TestAddDeviceAudit(t *testing.T) {
+	realAws := TestAws
+	mock := &MockDynamoDB{}
+	TestAws = &AmazonWebServices{DynamoDB: mock, AuditTableName: MockAuditTable}
+	defer func() { TestAws = realAws }()
+
+	request := events.APIGatewayProxyRequest{
+		Headers:        jsonContent(),
+		Body:           "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}",
+		RequestContext: events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"sub": "tenant-a"}},
+	}
+	response, _ := AddDevice(context.Background(), request)
+	if response.StatusCode != 201 || len(mock.AuditRecords) != 1 {
+		t.Fatalf("** Testing: Auditing the created device. ** \n \t<expected error-code: %d, audit records: 1> <resulted error-code: %d, audit records: %d>", 201, response.StatusCode, len(mock.AuditRecords))
+	}
+	record := types.AuditRecord{}
+	dynamodbattribute.UnmarshalMap(mock.AuditRecords[0], &record)
+	if record.DeviceID != "7c9e6679-7425-40de-944b-e07fc1f90ae7" || record.Action != "create" || record.Caller != "tenant-a" || record.Timestamp == "" {
+		t.Errorf("** Testing: Audit record of the created device. ** \n \t<expected device: 7c9e6679-7425-40de-944b-e07fc1f90ae7, action: create, caller: tenant-a, a timestamp> <resulted record: %+v>", record)
+	}
+	if record.BeforeHash != "" || record.AfterHash != audit.Hash(mock.DeviceItem) {
+		t.Errorf("** Testing: Hashes of the created device. ** \n \t<expected before: \"\", after: %s> <resulted before: %q, after: %s>", audit.Hash(mock.DeviceItem), record.BeforeHash, record.AfterHash)
+	}
+
+	// An unavailable audit table only gets logged.
+	mock = &MockDynamoDB{AuditFails: true}
+	TestAws = &AmazonWebServices{DynamoDB: mock, AuditTableName: MockAuditTable}
+	response, _ = AddDevice(context.Background(), request)
+	if response.StatusCode != 201 || mock.DevicePuts != 1 {
+		t.Errorf("** Testing: Failed audit of the created device. ** \n \t<expected error-code: %d, puts: 1> <resulted error-code: %d, puts: %d> <resulted body: %s>", 201, response.StatusCode, mock.DevicePuts, response.Body)
+	}
+} // End of TestAddDeviceAudit function
+
+// A body which API Gateway has base64 encoded is decoded before validation.
+func TestAddDeviceBase64Body(t *testing.T) {
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}}
+	defer func() { TestAws = realAws }()
+
+	body := "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}"
+	testCases := []struct {
+		Name               string
+		Request            events.APIGatewayProxyRequest
+		ExpectedStatusCode int
+	}{
+		{
+			Name:               "** Testing: Base64 encoded valid device. **",
+			Request:            events.APIGatewayProxyRequest{Headers: jsonContent(), Body: base64.StdEncoding.EncodeToString([]byte(body)), IsBase64Encoded: true},
+			ExpectedStatusCode: 201,
+		},
+
+		{
+			Name:               "** Testing: Body flagged as base64 which is not. **",
+			Request:            events.APIGatewayProxyRequest{Headers: jsonContent(), Body: body, IsBase64Encoded: true},
+			ExpectedStatusCode: 400,
+		},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := AddDevice(context.Background(), test.Request)
+		if response.StatusCode != test.ExpectedStatusCode {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, response.Body)
+		}
+	}
+} // End of TestAddDeviceBase64Body function
+
+// A created device is pointed to by the Location header, under the configured base path.
+func TestAddDeviceLocation(t *testing.T) {
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}}
+	defer func() { TestAws = realAws }()
+
+	request := events.APIGatewayProxyRequest{Headers: jsonContent(), Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}"}
+	testCases := []struct {
+		Name             string
+		BasePath         string
+		ExpectedLocation string
+	}{
+		{Name: "** Testing: Location with the default base path. **", BasePath: "", ExpectedLocation: "/devices/7c9e6679-7425-40de-944b-e07fc1f90ae7"},
+		{Name: "** Testing: Location with a configured base path. **", BasePath: "/api/devices/", ExpectedLocation: "/api/devices/7c9e6679-7425-40de-944b-e07fc1f90ae7"},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		t.Setenv("DEVICES_BASE_PATH", test.BasePath)
+		response, _ := AddDevice(context.Background(), request)
+		if response.StatusCode != 201 || response.Headers["Location"] != test.ExpectedLocation {
+			t.Errorf("%s \n \t<expected error-code: %d, location: %s> <resulted error-code: %d, location: %s>", test.Name, 201, test.ExpectedLocation, response.StatusCode, response.Headers["Location"])
+		}
+	}
+
+	// Failures don't point anywhere.
+	response, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{})
+	if _, ok := response.Headers["Location"]; ok {
+		t.Errorf("** Testing: Location of a failed request. ** \n \t<expected no location> <resulted location: %s>", response.Headers["Location"])
+	}
+} // End of TestAddDeviceLocation function
+
+// A panic of the handler is answered with HTTP 500 and a body telling nothing about its cause.
+func TestAddDeviceRecover(t *testing.T) {
+	panicking := recovery.WithRecoverContext(func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		var devices map[string]types.Device
+		devices["7c9e6679-7425-40de-944b-e07fc1f90ae7"] = types.Device{}
+		return events.APIGatewayProxyResponse{StatusCode: 201}, nil
+	})
+	response, err := panicking(context.Background(), events.APIGatewayProxyRequest{})
+	if err != nil || response.StatusCode != 500 || response.Body != "{\"message\":\"Internal Server Error\"}" || response.Headers["Content-Type"] != "application/json" {
+		t.Errorf("** Testing: Panicking handler. ** \n \t<expected error-code: %d> <resulted error-code: %d, error: %v> <resulted body: %s>", 500, response.StatusCode, err, response.Body)
+	}
+
+	// Without a panic, the response of the handler is returned as it is.
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}}
+	defer func() { TestAws = realAws }()
+	response, err = recovery.WithRecoverContext(AddDevice)(context.Background(), events.APIGatewayProxyRequest{Headers: jsonContent(), Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}"})
+	if err != nil || response.StatusCode != 201 {
+		t.Errorf("** Testing: Handler without a panic. ** \n \t<expected error-code: %d> <resulted error-code: %d, error: %v> <resulted body: %s>", 201, response.StatusCode, err, response.Body)
+	}
+} // End of TestAddDeviceRecover function
+
+// A caller taking more tokens than its bucket holds is answered with HTTP 429 and a Retry-After header, while the
+// other callers still go through.
+func TestAddDeviceRateLimit(t *testing.T) {
+	mock := &MockDynamoDB{}
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: mock, RateLimitTableName: "rate_limit_test"}
+	defer func() { TestAws = realAws }()
+	t.Setenv("RATE_LIMIT_REQUESTS", "2")
+	t.Setenv("RATE_LIMIT_WINDOW_SECONDS", "60")
+
+	request := func(id string, sourceIP string) events.APIGatewayProxyRequest {
+		return events.APIGatewayProxyRequest{
+			Headers:        jsonContent(),
+			Body:           fmt.Sprintf("{\"id\":\"%s\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"%s\"}", id, id),
+			RequestContext: events.APIGatewayProxyRequestContext{Identity: events.APIGatewayRequestIdentity{SourceIP: sourceIP}},
+		}
+	}
+	testCases := []struct {
+		Name               string
+		Request            events.APIGatewayProxyRequest
+		ExpectedStatusCode int
+	}{
+		{Name: "** Testing: First request of a caller. **", Request: request("7c9e6679-7425-40de-944b-e07fc1f90ae7", "192.0.2.1"), ExpectedStatusCode: 201},
+		{Name: "** Testing: Last token of a caller. **", Request: request("8c9e6679-7425-40de-944b-e07fc1f90ae7", "192.0.2.1"), ExpectedStatusCode: 201},
+		{Name: "** Testing: Caller exceeding its rate limit. **", Request: request("9c9e6679-7425-40de-944b-e07fc1f90ae7", "192.0.2.1"), ExpectedStatusCode: 429},
+		{Name: "** Testing: Another caller. **", Request: request("9c9e6679-7425-40de-944b-e07fc1f90ae7", "192.0.2.2"), ExpectedStatusCode: 201},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := AddDevice(context.Background(), test.Request)
+		if response.StatusCode != test.ExpectedStatusCode {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, response.Body)
+		}
+		if test.ExpectedStatusCode != 429 {
+			continue
+		}
+		retryAfter, err := strconv.Atoi(response.Headers["Retry-After"])
+		if err != nil || retryAfter < 1 || retryAfter > 60 {
+			t.Errorf("%s \n \t<expected Retry-After within the window> <resulted Retry-After: %s>", test.Name, response.Headers["Retry-After"])
+		}
+		if !strings.Contains(response.Body, "TOO_MANY_REQUESTS") {
+			t.Errorf("%s \n \t<expected code: TOO_MANY_REQUESTS> <resulted body: %s>", test.Name, response.Body)
+		}
+	}
+
+	// The device of a rejected request is not put.
+	if mock.DevicePuts != 3 {
+		t.Errorf("** Testing: Devices put under the rate limit. ** \n \t<expected puts: %d> <resulted puts: %d>", 3, mock.DevicePuts)
+	}
+} // End of TestAddDeviceRateLimit function
+
+// A field is stored as the attribute named by its dynamodbav tag, while the response keeps the JSON name of the field.
+func TestAddDeviceStoredNames(t *testing.T) {
+	mock := &MockDynamoDB{}
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	response, _ := AddDevice(context.Background(), events.APIGatewayProxyRequest{Headers: jsonContent(), Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}"})
+	if response.StatusCode != 201 {
+		t.Fatalf("** Testing: Stored names. ** \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", 201, response.StatusCode, response.Body)
+	}
+	if _, client := mock.DeviceItem["deviceModel"]; client || aws.StringValue(mock.DeviceItem["device_model"].S) != "testDeviceModel" {
+		t.Errorf("** Testing: Attribute of the stored device. ** \n \t<expected attribute: device_model> <resulted item: %v>", mock.DeviceItem)
+	}
+	if !strings.Contains(response.Body, "\"deviceModel\":\"testDeviceModel\"") || strings.Contains(response.Body, "device_model") {
+		t.Errorf("** Testing: Field of the response. ** \n \t<expected field: deviceModel> <resulted body: %s>", response.Body)
+	}
+} // End of TestAddDeviceStoredNames function
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"attributes"
+	"audit"
+	"encoding/json"
+	"fmt"
+	"gateway"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"os"
+	"owner"
+	"placeholder"
+	"recovery"
+	"time"
+	"types"
+	"validation"
+)
+
+type AmazonWebServices struct {
+	Config   *aws.Config
+	Session  *session.Session
+	DynamoDB dynamodbiface.DynamoDBAPI
+}
+
+// Prepare a new AWS & DynamoDB session, then configure it.
+var TestAws *AmazonWebServices
+
+// Number of devices written by a single TransactWriteItems call, along with the markers of their serials.
+const batchSize = 25
+
+// How many times a throttled chunk is sent again, and the delay before the first retry which doubles each time.
+const maxBatchRetries = 5
+
+var batchRetryDelay = 50 * time.Millisecond
+
+func init() {
+	region := os.Getenv("AWS_REGION")
+	var Aws *AmazonWebServices = new(AmazonWebServices)
+	Aws.Config = &aws.Config{Region: aws.String(region)}
+	// Pointing the client to a local DynamoDB, i.e: DynamoDB Local for the integration tests. It's unset in production.
+	if endpoint := os.Getenv("DYNAMODB_ENDPOINT"); endpoint != "" {
+		Aws.Config.Endpoint = aws.String(endpoint)
+	}
+	var err error
+	Aws.Session, err = session.NewSession(Aws.Config)
+	if err != nil {
+		// Logs error on Amazon CloudWatch. It's sysadmin's duty to handle it.
+		fmt.Println(fmt.Sprintf("Failed to connect to AWS: %s", err.Error()))
+	} else {
+		var svc *dynamodb.DynamoDB = dynamodb.New(Aws.Session)
+		Aws.DynamoDB = dynamodbiface.DynamoDBAPI(svc)
+	}
+	// Instantiate a global session in TestAws
+	TestAws = Aws
+}
+
+// Preparing DynamoDB Session and Calling DB's TransactWriteItems function inside, in chunks of 25 devices.
+// Unlike BatchWriteItem, a transaction can be conditional, so a device whose id already exists, of this tenant or of
+// another one, is never overwritten. With SERIALS_TABLE_NAME, each device is written along with the marker of its
+// serial, same as AddDevice, so no two devices can share a serial. A device failing its condition cancels its whole
+// chunk, so it's reported and the rest of the chunk is sent again. A chunk cancelled without a device to blame,
+// i.e: because of throttling, is retried as a whole with an exponential backoff.
+// Returns why each of the devices which have not been written has failed, by id.
+func (self *AmazonWebServices) BatchPut(items []map[string]*dynamodb.AttributeValue) map[string]string {
+	// Get table names from OS's environment
+	tableName := aws.String(os.Getenv("DEVICES_TABLE_NAME"))
+	serialsTableName := aws.String(os.Getenv("SERIALS_TABLE_NAME"))
+	failed := map[string]string{}
+
+	deviceNames, markerNames := placeholder.Names{}, placeholder.Names{}
+	deviceCondition := aws.String(fmt.Sprintf("attribute_not_exists(%s)", deviceNames.Of("id")))
+	markerCondition := aws.String(fmt.Sprintf("attribute_not_exists(%s)", markerNames.Of("serial")))
+
+	for start := 0; start < len(items); start += batchSize {
+		end := start + batchSize
+		if end > len(items) {
+			end = len(items)
+		}
+		chunk := items[start:end]
+
+		delay := batchRetryDelay
+		for retries := 0; len(chunk) > 0; {
+			// The position in the chunk of the device which each item of the transaction writes, in their order.
+			var transactItems []*dynamodb.TransactWriteItem
+			var devices []int
+			for i, item := range chunk {
+				transactItems = append(transactItems, &dynamodb.TransactWriteItem{Put: &dynamodb.Put{
+					TableName:                tableName,
+					Item:                     item,
+					ConditionExpression:      deviceCondition,
+					ExpressionAttributeNames: deviceNames,
+				}})
+				devices = append(devices, i)
+				if marksSerials() && item["serial"] != nil {
+					transactItems = append(transactItems, &dynamodb.TransactWriteItem{Put: &dynamodb.Put{
+						TableName:                serialsTableName,
+						Item:                     map[string]*dynamodb.AttributeValue{"serial": item["serial"], "id": item["id"]},
+						ConditionExpression:      markerCondition,
+						ExpressionAttributeNames: markerNames,
+					}})
+					devices = append(devices, i)
+				}
+			}
+			// Calling either TransactWriteItems function of interface, defined in batchAddDevices_test.go file, or api with the input we've provided.
+			// In real deployment environment, the TransactWriteItems function of aws (api.go) will be called.
+			_, err := self.DynamoDB.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: transactItems})
+			if err == nil {
+				break
+			}
+
+			// The reasons are in the order of the items, "None" for an item which has not caused the cancellation.
+			rejected := map[int]bool{}
+			canceled, ok := err.(*dynamodb.TransactionCanceledException)
+			if ok && len(canceled.CancellationReasons) == len(transactItems) {
+				for j, reason := range canceled.CancellationReasons {
+					if aws.StringValue(reason.Code) != "ConditionalCheckFailed" {
+						continue
+					}
+					rejected[devices[j]] = true
+					id := aws.StringValue(chunk[devices[j]]["id"].S)
+					if transactItems[j].Put.TableName == tableName {
+						failed[id] = "Device with this ID already exists."
+					} else if failed[id] == "" {
+						failed[id] = "Serial already registered"
+					}
+				}
+			}
+			// Without a device to blame, i.e: a throttled or conflicting transaction, the whole chunk is sent again.
+			if len(rejected) == 0 && ok && retries < maxBatchRetries {
+				retries++
+				time.Sleep(delay)
+				delay *= 2
+				continue
+			}
+			if len(rejected) == 0 {
+				// Logs error on Amazon CloudWatch, the rest of the chunk is reported as failed.
+				fmt.Println(fmt.Sprintf("Failed to write a batch of devices: %s", err.Error()))
+				for _, item := range chunk {
+					failed[aws.StringValue(item["id"].S)] = "Internal Server Error: device could not be written."
+				}
+				break
+			}
+			var remaining []map[string]*dynamodb.AttributeValue
+			for i, item := range chunk {
+				if !rejected[i] {
+					remaining = append(remaining, item)
+				}
+			}
+			chunk = remaining
+		}
+	}
+	return failed
+}
+
+// Checking whether the devices are written along with the markers of their serials, see BatchPut.
+// As in AddDevice, SKIP_SERIAL_CHECK=true writes them without, i.e: while migrating data known to be unique.
+func marksSerials() bool {
+	return os.Getenv("SERIALS_TABLE_NAME") != "" && os.Getenv("SKIP_SERIAL_CHECK") != "true"
+}
+
+// The handler function which will be first started from main function.
+// Each device of the JSON array in the body is validated like in AddDevice, and only valid ones are written.
+// The response reports the outcome of every device, so a partially failed batch is still useful.
+func BatchAddDevices(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !gateway.AllowsMethod(request, "POST") {
+		return gateway.MethodNotAllowed("POST"), nil
+	}
+
+	if len(request.Body) == 0 {
+		return events.APIGatewayProxyResponse{
+			Body:       "No inputs provided, please provide inputs in JSON format.",
+			StatusCode: 400,
+		}, nil
+	}
+
+	if err := validation.CheckBodySize(request); err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:       err.Error(),
+			StatusCode: 413,
+		}, nil
+	}
+
+	// De-serialize "request.Body" which is a JSON array into "NewDevices" in Go objects.
+	var NewDevices []types.Device
+	err := json.Unmarshal([]byte(request.Body), &NewDevices)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:       "Wrong format: Inputs must be a valid JSON array of devices.",
+			StatusCode: 400,
+		}, nil
+	}
+	if len(NewDevices) == 0 {
+		return events.APIGatewayProxyResponse{
+			Body:       "No devices provided, please provide at least one device.",
+			StatusCode: 400,
+		}, nil
+	}
+
+	results := make([]types.BatchItemResult, len(NewDevices))
+	var items []map[string]*dynamodb.AttributeValue
+	// Position of each valid device in the request, by its id, and the serials of the valid devices.
+	indexes := map[string]int{}
+	serials := map[string]bool{}
+	createdAt := time.Now().UTC().Format(time.RFC3339)
+	ownerID := owner.Caller(request)
+
+	for i, NewDevice := range NewDevices {
+		NewDevice = validation.NormalizeDevice(validation.SanitizeDevice(NewDevice))
+		results[i] = types.BatchItemResult{Index: i, ID: NewDevice.ID}
+		Failures := validation.ValidateDevice(NewDevice)
+		// DynamoDB rejects a whole batch which writes the same id twice.
+		if _, duplicate := indexes[NewDevice.ID]; duplicate {
+			Failures = append(Failures, types.FieldError{Field: "id", Code: validation.CodeInvalidValue, Message: "Invalid field: ID is duplicated in the batch"})
+		}
+		// Nor can a transaction write the marker of the same serial twice.
+		if marksSerials() && NewDevice.Serial != "" && serials[NewDevice.Serial] {
+			Failures = append(Failures, types.FieldError{Field: "serial", Code: validation.CodeInvalidValue, Message: "Invalid field: Serial is duplicated in the batch"})
+		}
+		if len(Failures) > 0 {
+			results[i].Errors = Failures.Messages()
+			continue
+		}
+
+		// Timestamps, version and owner are set on the server side, same as AddDevice.
+		NewDevice.CreatedAt = createdAt
+		NewDevice.OwnerID = ownerID
+		NewDevice.UpdatedAt = ""
+		NewDevice.Deleted = false
+		NewDevice.DeletedAt = ""
+		NewDevice.Version = 1
+		if NewDevice.Status == "" {
+			NewDevice.Status = types.StatusActive
+		}
+		item, _ := attributes.Marshal(NewDevice)
+		items = append(items, item)
+		indexes[NewDevice.ID] = i
+		serials[NewDevice.Serial] = true
+		results[i].Success = true
+	}
+
+	// Marking the devices which DynamoDB has not written as failed.
+	failed := TestAws.BatchPut(items)
+	for id, failure := range failed {
+		i := indexes[id]
+		results[i].Success = false
+		results[i].Errors = []string{failure}
+	}
+
+	// Recording who has created each written device for the audit trail, like AddDevice does.
+	// They have been written anyway, so a failure is only logged.
+	for _, item := range items {
+		id := aws.StringValue(item["id"].S)
+		if _, ok := failed[id]; ok {
+			continue
+		}
+		if err := audit.Write(TestAws.DynamoDB, audit.NewRecord(id, audit.ActionCreate, ownerID, nil, item)); err != nil {
+			fmt.Println(fmt.Sprintf("Failed to write the audit record: %s", err.Error()))
+		}
+	}
+
+	// Serialization/Encoding the results to JSON.
+	resultsJson, _ := json.Marshal(types.BatchResult{Results: results})
+	return events.APIGatewayProxyResponse{
+		Body:       string(resultsJson),
+		StatusCode: 200,
+	}, nil
+} // End of BatchAddDevices function
+
+func main() {
+	lambda.Start(gateway.Adapt(recovery.WithRecover(BatchAddDevices)))
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"strings"
+	"testing"
+	"types"
+	"validation"
+)
+
+// Mocking DynamoDB through dynamodbiface.
+type MockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	// Ids which throttle their transaction the first time they are sent.
+	Throttled map[string]bool
+	// Ids and serials which are already stored in the mocked tables.
+	ExistingIDs     map[string]bool
+	ExistingSerials map[string]bool
+	// Ids which have been written, and the size of every TransactWriteItems call.
+	Written          map[string]bool
+	TransactionSizes []int
+	// Device ids of the records appended to the mocked audit table, in their order.
+	Audited []string
+}
+
+// Custom PutItem function for overriding the PutItem of batchAddDevices.go for using in test scenarios.
+// Only the audit records are put on their own.
+func (self *MockDynamoDB) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	self.Audited = append(self.Audited, aws.StringValue(input.Item["deviceId"].S))
+	return new(dynamodb.PutItemOutput), nil
+}
+
+// Custom TransactWriteItems function for overriding the TransactWriteItems of batchAddDevices.go for using in test scenarios.
+// Cancels the transaction like DynamoDB when one of its items fails its condition or is throttled, with a reason for
+// each item in their order. Items of the serials table are told apart by their lack of a name.
+func (self *MockDynamoDB) TransactWriteItems(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+	self.TransactionSizes = append(self.TransactionSizes, len(input.TransactItems))
+	reasons := make([]*dynamodb.CancellationReason, 0, len(input.TransactItems))
+	canceled := false
+	for _, item := range input.TransactItems {
+		reason := &dynamodb.CancellationReason{Code: aws.String("None")}
+		id := aws.StringValue(item.Put.Item["id"].S)
+		marker := item.Put.Item["name"] == nil
+		switch {
+		case !marker && self.Throttled[id]:
+			delete(self.Throttled, id)
+			reason.Code, canceled = aws.String("ThrottlingError"), true
+		case !marker && self.ExistingIDs[id]:
+			reason.Code, canceled = aws.String("ConditionalCheckFailed"), true
+		case marker && self.ExistingSerials[aws.StringValue(item.Put.Item["serial"].S)]:
+			reason.Code, canceled = aws.String("ConditionalCheckFailed"), true
+		}
+		reasons = append(reasons, reason)
+	}
+	if canceled {
+		return nil, &dynamodb.TransactionCanceledException{Message_: aws.String("Transaction cancelled"), CancellationReasons: reasons}
+	}
+	for _, item := range input.TransactItems {
+		if item.Put.Item["name"] != nil {
+			self.Written[aws.StringValue(item.Put.Item["id"].S)] = true
+		}
+	}
+	return new(dynamodb.TransactWriteItemsOutput), nil
+}
+
+// Building the UUID of the i-th device of the batch.
+func testID(i int) string {
+	return fmt.Sprintf("7c9e6679-7425-40de-944b-%012d", i)
+}
+
+// BatchAddDevices function in batchAddDevices.go signature: input: (request events.APIGatewayProxyRequest), output: (events.APIGatewayProxyResponse, error)
+func TestBatchAddDevices(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	mock := &MockDynamoDB{Throttled: map[string]bool{testID(27): true}, Written: map[string]bool{}}
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	realDelay := batchRetryDelay
+	batchRetryDelay = 0
+	defer func() {
+		TestAws = realAws
+		batchRetryDelay = realDelay
+	}()
+
+	// 31 devices spanning two chunks: 30 valid ones, and the one at index 3 without a Name.
+	var devices []types.Device
+	for i := 0; i < 31; i++ {
+		devices = append(devices, types.Device{ID: testID(i), DeviceModel: "testDeviceModel", Name: "testName", Note: "testNote", Serial: "testSerial"})
+	}
+	devices[3].Name = ""
+	body, _ := json.Marshal(devices)
+
+	response, _ := BatchAddDevices(events.APIGatewayProxyRequest{Body: string(body)})
+	if response.StatusCode != 200 {
+		t.Fatalf("** Testing: Batch spanning two chunks. ** \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", 200, response.StatusCode, response.Body)
+	}
+
+	// The invalid device is never sent, the chunk of the throttled one is sent again as a whole.
+	if len(mock.TransactionSizes) != 3 || mock.TransactionSizes[0] != 25 || mock.TransactionSizes[1] != 5 || mock.TransactionSizes[2] != 5 {
+		t.Errorf("** Testing: Chunks of the batch. ** \n \t<expected transaction sizes: [25 5 5]> <resulted transaction sizes: %v>", mock.TransactionSizes)
+	}
+	if len(mock.Written) != 30 || !mock.Written[testID(27)] || mock.Written[testID(3)] {
+		t.Errorf("** Testing: Written devices. ** \n \t<expected 30 written devices including the retried one> <resulted %d written devices>", len(mock.Written))
+	}
+
+	Result := types.BatchResult{}
+	json.Unmarshal([]byte(response.Body), &Result)
+	if len(Result.Results) != 31 {
+		t.Fatalf("** Testing: Results of the batch. ** \n \t<expected 31 results> <resulted body: %s>", response.Body)
+	}
+	for i, result := range Result.Results {
+		if result.Index != i || result.ID != testID(i) || result.Success != (i != 3) {
+			t.Errorf("** Testing: Result of device %d. ** \n \t<resulted: %+v>", i, result)
+		}
+	}
+	if len(Result.Results[3].Errors) != 1 || Result.Results[3].Errors[0] != "Missing field: Name" {
+		t.Errorf("** Testing: Errors of the invalid device. ** \n \t<expected errors: [Missing field: Name]> <resulted errors: %v>", Result.Results[3].Errors)
+	}
+} // End of TestBatchAddDevices function
+
+// A device whose id already exists is never overwritten, and with a serials table neither is a registered serial
+// reused, the rest of the batch is written anyway.
+func TestBatchAddDevicesExisting(t *testing.T) {
+	realAws := TestAws
+	defer func() { TestAws = realAws }()
+	t.Setenv("SERIALS_TABLE_NAME", "serials_test")
+	t.Setenv("AUDIT_TABLE_NAME", "audit_test")
+
+	mock := &MockDynamoDB{ExistingIDs: map[string]bool{testID(1): true}, ExistingSerials: map[string]bool{"serial_taken": true}, Written: map[string]bool{}}
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	devices := []types.Device{
+		{ID: testID(0), DeviceModel: "testDeviceModel", Name: "testName", Note: "testNote", Serial: "serial_0"},
+		{ID: testID(1), DeviceModel: "testDeviceModel", Name: "testName", Note: "testNote", Serial: "serial_1"},
+		{ID: testID(2), DeviceModel: "testDeviceModel", Name: "testName", Note: "testNote", Serial: "serial_taken"},
+		{ID: testID(3), DeviceModel: "testDeviceModel", Name: "testName", Note: "testNote", Serial: "serial_0"},
+	}
+	body, _ := json.Marshal(devices)
+
+	response, _ := BatchAddDevices(events.APIGatewayProxyRequest{Body: string(body)})
+	expected := "{\"results\":[{\"index\":0,\"id\":\"" + testID(0) + "\",\"success\":true}," +
+		"{\"index\":1,\"id\":\"" + testID(1) + "\",\"success\":false,\"errors\":[\"Device with this ID already exists.\"]}," +
+		"{\"index\":2,\"id\":\"" + testID(2) + "\",\"success\":false,\"errors\":[\"Serial already registered\"]}," +
+		"{\"index\":3,\"id\":\"" + testID(3) + "\",\"success\":false,\"errors\":[\"Invalid field: Serial is duplicated in the batch\"]}]}"
+	if response.StatusCode != 200 || response.Body != expected {
+		t.Errorf("** Testing: Existing id and serial. ** \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", 200, response.StatusCode, expected, response.Body)
+	}
+	// Each device is written along with its serial marker, the rejected ones are left out of the next transaction.
+	if len(mock.Written) != 1 || !mock.Written[testID(0)] || len(mock.TransactionSizes) == 0 || mock.TransactionSizes[0] != 6 || mock.TransactionSizes[len(mock.TransactionSizes)-1] != 2 {
+		t.Errorf("** Testing: Written devices. ** \n \t<expected only %s written, first transaction of 6 items and last of 2> <resulted written: %v, transaction sizes: %v>", testID(0), mock.Written, mock.TransactionSizes)
+	}
+	// Only the written device is recorded in the audit trail.
+	if len(mock.Audited) != 1 || mock.Audited[0] != testID(0) {
+		t.Errorf("** Testing: Audit records. ** \n \t<expected a record of %s only> <resulted records of: %v>", testID(0), mock.Audited)
+	}
+} // End of TestBatchAddDevicesExisting function
+
+// Request bodies which can't be a batch at all.
+func TestBatchAddDevicesWrongInputs(t *testing.T) {
+	testCases := []struct {
+		Name               string
+		Request            events.APIGatewayProxyRequest
+		ExpectedBody       string
+		ExpectedStatusCode int
+	}{
+		{
+			Name:               "** Testing: Empty body input. **",
+			Request:            events.APIGatewayProxyRequest{Body: ""},
+			ExpectedBody:       "No inputs provided, please provide inputs in JSON format.",
+			ExpectedStatusCode: 400,
+		},
+
+		{
+			Name:               "** Testing: A single device instead of an array. **",
+			Request:            events.APIGatewayProxyRequest{Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\"}"},
+			ExpectedBody:       "Wrong format: Inputs must be a valid JSON array of devices.",
+			ExpectedStatusCode: 400,
+		},
+
+		{
+			Name:               "** Testing: Empty array. **",
+			Request:            events.APIGatewayProxyRequest{Body: "[]"},
+			ExpectedBody:       "No devices provided, please provide at least one device.",
+			ExpectedStatusCode: 400,
+		},
+
+		{
+			Name:               "** Testing: Body over the default maximum size. **",
+			Request:            events.APIGatewayProxyRequest{Body: "[" + strings.Repeat(" ", validation.DefaultMaxBodyBytes) + "]"},
+			ExpectedBody:       "Payload Too Large: body exceeds the maximum size.",
+			ExpectedStatusCode: 413,
+		},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := BatchAddDevices(test.Request)
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+} // End of TestBatchAddDevicesWrongInputs function
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"gateway"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"os"
+	"owner"
+	"placeholder"
+	"recovery"
+	"strings"
+	"types"
+)
+
+type AmazonWebServices struct {
+	Config   *aws.Config
+	Session  *session.Session
+	DynamoDB dynamodbiface.DynamoDBAPI
+}
+
+// Prepare a new AWS & DynamoDB session, then configure it.
+var TestAws *AmazonWebServices
+
+func init() {
+	region := os.Getenv("AWS_REGION")
+	var Aws *AmazonWebServices = new(AmazonWebServices)
+	Aws.Config = &aws.Config{Region: aws.String(region)}
+	// Pointing the client to a local DynamoDB, i.e: DynamoDB Local for the integration tests. It's unset in production.
+	if endpoint := os.Getenv("DYNAMODB_ENDPOINT"); endpoint != "" {
+		Aws.Config.Endpoint = aws.String(endpoint)
+	}
+	var err error
+	Aws.Session, err = session.NewSession(Aws.Config)
+	if err != nil {
+		// Logs error on Amazon CloudWatch. It's sysadmin's duty to handle it.
+		fmt.Println(fmt.Sprintf("Failed to connect to AWS: %s", err.Error()))
+	} else {
+		var svc *dynamodb.DynamoDB = dynamodb.New(Aws.Session)
+		Aws.DynamoDB = dynamodbiface.DynamoDBAPI(svc)
+	}
+	// Instantiate a global session in TestAws
+	TestAws = Aws
+}
+
+// Preparing DynamoDB Session and Calling DB's Scan function inside, counting the devices instead of returning them.
+// A scan counts at most 1 MB of the table at a time, so it pages through the whole table to accumulate the total.
+// Soft deleted devices are never counted, a non empty nameContains or deviceModel counts only the matching devices,
+// and a non empty ownerID only its own.
+func (self *AmazonWebServices) Count(nameContains string, deviceModel string, ownerID string) (int64, error) {
+	// Get desire table's name from OS's environmental varible.
+	tableName := aws.String(os.Getenv("DEVICES_TABLE_NAME"))
+
+	names := placeholder.Names{}
+	conditions := []string{fmt.Sprintf("(attribute_not_exists(%[1]s) OR %[1]s = :false)", names.Of("deleted"))}
+	values := map[string]*dynamodb.AttributeValue{":false": {BOOL: aws.Bool(false)}}
+	if nameContains != "" {
+		conditions = append(conditions, fmt.Sprintf("contains(%s, :term)", names.Of("name")))
+		values[":term"] = &dynamodb.AttributeValue{S: aws.String(nameContains)}
+	}
+	if deviceModel != "" {
+		conditions = append(conditions, fmt.Sprintf("%s = :model", names.Field("deviceModel")))
+		values[":model"] = &dynamodb.AttributeValue{S: aws.String(deviceModel)}
+	}
+	if ownerID != "" {
+		conditions = append(conditions, fmt.Sprintf("%s = :owner", names.Of("ownerId")))
+		values[":owner"] = &dynamodb.AttributeValue{S: aws.String(ownerID)}
+	}
+
+	// Counting returns no attribute of the devices at all, so unlike the exists check it needs no key projection,
+	// which DynamoDB would reject along with it.
+	var input = &dynamodb.ScanInput{
+		TableName:                 tableName,
+		Select:                    aws.String(dynamodb.SelectCount),
+		FilterExpression:          aws.String(strings.Join(conditions, " AND ")),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	}
+
+	var total int64
+	for {
+		// Calling either Scan function of interface, defined in countDevices_test.go file, or api with the input we've provided.
+		// In real deployment environment, the Scan function of aws (api.go) will be called.
+		result, err := self.DynamoDB.Scan(input)
+		if err != nil {
+			return 0, err
+		}
+		total += aws.Int64Value(result.Count)
+		// DynamoDB has more items for us only when it returns a LastEvaluatedKey.
+		if len(result.LastEvaluatedKey) == 0 {
+			return total, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+// The handler function which will be first started from main function.
+// Accepts the same "name" and "model" filters as ListDevices, and counts the devices of the caller's tenant only.
+func CountDevices(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !gateway.AllowsMethod(request, "GET") {
+		return gateway.MethodNotAllowed("GET"), nil
+	}
+
+	count, err := TestAws.Count(request.QueryStringParameters["name"], request.QueryStringParameters["model"], owner.Caller(request))
+
+	// If an internal error have occurred in the database, return HTTP error code 500.
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:       "Internal Server Error.",
+			StatusCode: 500,
+		}, nil
+	}
+
+	// Serialization/Encoding the count to JSON.
+	countJson, _ := json.Marshal(types.DeviceCount{Count: count})
+	return events.APIGatewayProxyResponse{
+		Body:       string(countJson),
+		StatusCode: 200,
+	}, nil
+} // End of CountDevices function
+
+func main() {
+	lambda.Start(gateway.Adapt(recovery.WithRecover(CountDevices)))
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"strconv"
+	"testing"
+)
+
+// Mocking DynamoDB through dynamodbiface.
+type MockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	// Counts of the pages which the mocked Scan returns one after another, and the error instead of them.
+	PageCounts []int64
+	Error      error
+	// Inputs of every Scan call.
+	Inputs []*dynamodb.ScanInput
+}
+
+// Custom Scan function for overriding the Scan of countDevices.go for using in test scenarios.
+// Pages are keyed by their position, every page but the last one returns a LastEvaluatedKey.
+func (self *MockDynamoDB) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	self.Inputs = append(self.Inputs, input)
+	if self.Error != nil {
+		return nil, self.Error
+	}
+	page := 0
+	if input.ExclusiveStartKey != nil {
+		previous, _ := strconv.Atoi(aws.StringValue(input.ExclusiveStartKey["page"].N))
+		page = previous + 1
+	}
+	MockOutput := &dynamodb.ScanOutput{Count: aws.Int64(self.PageCounts[page])}
+	if page < len(self.PageCounts)-1 {
+		MockOutput.LastEvaluatedKey = map[string]*dynamodb.AttributeValue{"page": {N: aws.String(strconv.Itoa(page))}}
+	}
+	return MockOutput, nil
+}
+
+// CountDevices function in countDevices.go signature: input: (request events.APIGatewayProxyRequest), output: (events.APIGatewayProxyResponse, error)
+func TestCountDevices(t *testing.T) {
+	testCases := []struct {
+		Name               string
+		Request            events.APIGatewayProxyRequest
+		MockDatabase       *MockDynamoDB
+		ExpectedBody       string
+		ExpectedStatusCode int
+		ExpectedScans      int
+	}{
+		{
+			Name:               "** Testing: Count across two pages. **",
+			MockDatabase:       &MockDynamoDB{PageCounts: []int64{3, 2}},
+			ExpectedBody:       "{\"count\":5}",
+			ExpectedStatusCode: 200,
+			ExpectedScans:      2,
+		},
+
+		{
+			Name:               "** Testing: Empty table. **",
+			MockDatabase:       &MockDynamoDB{PageCounts: []int64{0}},
+			ExpectedBody:       "{\"count\":0}",
+			ExpectedStatusCode: 200,
+			ExpectedScans:      1,
+		},
+
+		{
+			Name:               "** Testing: Count filtered by name and model. **",
+			Request:            events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"name": "sensor", "model": "/devicemodels/id1"}},
+			MockDatabase:       &MockDynamoDB{PageCounts: []int64{1}},
+			ExpectedBody:       "{\"count\":1}",
+			ExpectedStatusCode: 200,
+			ExpectedScans:      1,
+		},
+
+		{
+			Name:               "** Database Unexpected Error **",
+			MockDatabase:       &MockDynamoDB{Error: errors.New("unexpected Error has occurred")},
+			ExpectedBody:       "Internal Server Error.",
+			ExpectedStatusCode: 500,
+			ExpectedScans:      1,
+		},
+	}
+
+	realAws := TestAws
+	defer func() { TestAws = realAws }()
+
+	for _, test := range testCases {
+		// Executing each test cases scenario against its own mocked database.
+		TestAws = &AmazonWebServices{DynamoDB: test.MockDatabase}
+		response, _ := CountDevices(test.Request)
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+		if len(test.MockDatabase.Inputs) != test.ExpectedScans || aws.StringValue(test.MockDatabase.Inputs[0].Select) != dynamodb.SelectCount {
+			t.Errorf("%s \n \t<expected %d scans with Select COUNT> <resulted scans: %d>", test.Name, test.ExpectedScans, len(test.MockDatabase.Inputs))
+		}
+	}
+
+	// The filters are combined with AND, after hiding the soft deleted devices.
+	filtered := &MockDynamoDB{PageCounts: []int64{1}}
+	TestAws = &AmazonWebServices{DynamoDB: filtered}
+	CountDevices(events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"name": "sensor", "model": "/devicemodels/id1"}})
+	expected := "(attribute_not_exists(#deleted) OR #deleted = :false) AND contains(#name, :term) AND #deviceModel = :model"
+	if expression := aws.StringValue(filtered.Inputs[0].FilterExpression); expression != expected {
+		t.Errorf("** Testing: Filter of the count. ** \n \t<expected filter: %s> <resulted filter: %s>", expected, expression)
+	}
+
+	// Behind an authorizer, only the devices of the caller's tenant are counted.
+	scoped := &MockDynamoDB{PageCounts: []int64{1}}
+	TestAws = &AmazonWebServices{DynamoDB: scoped}
+	CountDevices(events.APIGatewayProxyRequest{RequestContext: events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"claims": map[string]interface{}{"sub": "tenant-a"}}}})
+	expected = "(attribute_not_exists(#deleted) OR #deleted = :false) AND #ownerId = :owner"
+	if expression := aws.StringValue(scoped.Inputs[0].FilterExpression); expression != expected || aws.StringValue(scoped.Inputs[0].ExpressionAttributeValues[":owner"].S) != "tenant-a" {
+		t.Errorf("** Testing: Filter of the count of a tenant. ** \n \t<expected filter: %s> <resulted filter: %s>", expected, expression)
+	}
+} // End of TestCountDevices function
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"audit"
+	"errors"
+	"fmt"
+	"gateway"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"os"
+	"owner"
+	"placeholder"
+	"recovery"
+	"time"
+)
+
+type AmazonWebServices struct {
+	Config   *aws.Config
+	Session  *session.Session
+	DynamoDB dynamodbiface.DynamoDBAPI
+}
+
+// Prepare a new AWS & DynamoDB session, then configure it.
+var TestAws *AmazonWebServices
+
+// Positions of the writes of the transaction deleting a device along with the marker of its serial.
+const (
+	deviceWrite = iota
+	markerWrite
+)
+
+// Returned by DeleteWithMarker when the device has changed its serial since it's been read.
+var errModified = errors.New("the device has been modified meanwhile")
+
+func init() {
+	region := os.Getenv("AWS_REGION")
+	var Aws *AmazonWebServices = new(AmazonWebServices)
+	Aws.Config = &aws.Config{Region: aws.String(region)}
+	// Pointing the client to a local DynamoDB, i.e: DynamoDB Local for the integration tests. It's unset in production.
+	if endpoint := os.Getenv("DYNAMODB_ENDPOINT"); endpoint != "" {
+		Aws.Config.Endpoint = aws.String(endpoint)
+	}
+	var err error
+	Aws.Session, err = session.NewSession(Aws.Config)
+	if err != nil {
+		// Logs error on Amazon CloudWatch. It's sysadmin's duty to handle it.
+		fmt.Println(fmt.Sprintf("Failed to connect to AWS: %s", err.Error()))
+	} else {
+		var svc *dynamodb.DynamoDB = dynamodb.New(Aws.Session)
+		Aws.DynamoDB = dynamodbiface.DynamoDBAPI(svc)
+	}
+	// Instantiate a global session in TestAws
+	TestAws = Aws
+}
+
+// Preparing DynamoDB Session and Calling DB's DeleteItem function inside.
+// The condition makes the call fail for a missing device, so it can be reported instead of a silent success.
+// A non empty ownerID makes it fail for a device of another owner as well. The deleted item is returned, for the audit trail.
+func (self *AmazonWebServices) Delete(id string, ownerID string) (*dynamodb.DeleteItemOutput, error) {
+	// Get desire table's name from OS's environmental varible.
+	tableName := aws.String(os.Getenv("DEVICES_TABLE_NAME"))
+
+	var input = &dynamodb.DeleteItemInput{
+		TableName: tableName,
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(id),
+			},
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
+	}
+	names := placeholder.Names{}
+	condition := fmt.Sprintf("attribute_exists(%s)", names.Of("id"))
+	if ownerID != "" {
+		condition += fmt.Sprintf(" AND %s = :owner", names.Of("ownerId"))
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{":owner": {S: aws.String(ownerID)}}
+	}
+	input.ConditionExpression = aws.String(condition)
+	input.ExpressionAttributeNames = names
+
+	// Calling either DeleteItem function of interface, defined in deleteDevice_test.go file, or api with the input we've provided.
+	// In real deployment environment, the DeleteItem function of aws (api.go) will be called.
+	result, err := self.DynamoDB.DeleteItem(input)
+	return result, err
+}
+
+// Preparing DynamoDB Session and Calling DB's UpdateItem function inside, flagging the device as deleted instead of deleting it.
+// The condition makes the call fail for a missing device or an already deleted one, both are reported as not found.
+// A non empty ownerID makes it fail for a device of another owner as well. The item as it was before is returned, for the audit trail.
+func (self *AmazonWebServices) SoftDelete(id string, deletedAt string, ownerID string) (*dynamodb.UpdateItemOutput, error) {
+	// Get desire table's name from OS's environmental varible.
+	tableName := aws.String(os.Getenv("DEVICES_TABLE_NAME"))
+
+	var input = &dynamodb.UpdateItemInput{
+		TableName: tableName,
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(id),
+			},
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":deleted":   {BOOL: aws.Bool(true)},
+			":deletedAt": {S: aws.String(deletedAt)},
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
+	}
+	names := placeholder.Names{}
+	input.UpdateExpression = aws.String(fmt.Sprintf("SET %s = :deleted, %s = :deletedAt", names.Of("deleted"), names.Of("deletedAt")))
+	condition := fmt.Sprintf("attribute_exists(%s) AND attribute_not_exists(%s)", names.Of("id"), names.Of("deleted"))
+	if ownerID != "" {
+		condition += fmt.Sprintf(" AND %s = :owner", names.Of("ownerId"))
+		input.ExpressionAttributeValues[":owner"] = &dynamodb.AttributeValue{S: aws.String(ownerID)}
+	}
+	input.ConditionExpression = aws.String(condition)
+	input.ExpressionAttributeNames = names
+
+	// Calling either UpdateItem function of interface, defined in deleteDevice_test.go file, or api with the input we've provided.
+	// In real deployment environment, the UpdateItem function of aws (api.go) will be called.
+	result, err := self.DynamoDB.UpdateItem(input)
+	return result, err
+}
+
+// Preparing DynamoDB Session and Calling DB's GetItem function inside, reading the device to delete along with the
+// marker of its serial. The read is strongly consistent, its serial is the one which DeleteWithMarker expects.
+func (self *AmazonWebServices) Get(id string) (*dynamodb.GetItemOutput, error) {
+	// Get desire table's name from OS's environmental varible.
+	tableName := aws.String(os.Getenv("DEVICES_TABLE_NAME"))
+
+	var input = &dynamodb.GetItemInput{
+		TableName: tableName,
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(id),
+			},
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+
+	// Calling either GetItem function of interface, defined in deleteDevice_test.go file, or api with the input we've provided.
+	// In real deployment environment, the GetItem function of aws (api.go) will be called.
+	result, err := self.DynamoDB.GetItem(input)
+	return result, err
+}
+
+// Preparing DynamoDB Session and Calling DB's TransactWriteItems function inside, deleting the stored device read by
+// Get, or flagging it as deleted for a non empty deletedAt, along with the marker of its serial in SERIALS_TABLE_NAME,
+// the one written by AddDevice. So the serial is free to be registered again exactly when its device is gone.
+// The device is written with the same condition as by Delete or SoftDelete, a failed one is returned as a
+// *dynamodb.ConditionalCheckFailedException, while it still has the serial which has been read, errModified otherwise.
+// The marker is only deleted while it's the device's one, the devices created before the serials table have none.
+func (self *AmazonWebServices) DeleteWithMarker(stored map[string]*dynamodb.AttributeValue, deletedAt string, ownerID string) error {
+	// Get desire tables' names from OS's environmental varibles.
+	tableName := aws.String(os.Getenv("DEVICES_TABLE_NAME"))
+	serialsTableName := aws.String(os.Getenv("SERIALS_TABLE_NAME"))
+	key := map[string]*dynamodb.AttributeValue{"id": stored["id"]}
+
+	names, markerNames := placeholder.Names{}, placeholder.Names{}
+	values := map[string]*dynamodb.AttributeValue{":serial": stored["serial"]}
+	condition := fmt.Sprintf("attribute_exists(%s) AND %s = :serial", names.Of("id"), names.Of("serial"))
+	if ownerID != "" {
+		condition += fmt.Sprintf(" AND %s = :owner", names.Of("ownerId"))
+		values[":owner"] = &dynamodb.AttributeValue{S: aws.String(ownerID)}
+	}
+	device := &dynamodb.TransactWriteItem{Delete: &dynamodb.Delete{
+		Key:                                 key,
+		TableName:                           tableName,
+		ConditionExpression:                 aws.String(condition),
+		ExpressionAttributeNames:            names,
+		ExpressionAttributeValues:           values,
+		ReturnValuesOnConditionCheckFailure: aws.String(dynamodb.ReturnValuesOnConditionCheckFailureAllOld),
+	}}
+	if deletedAt != "" {
+		condition += fmt.Sprintf(" AND attribute_not_exists(%s)", names.Of("deleted"))
+		values[":deleted"] = &dynamodb.AttributeValue{BOOL: aws.Bool(true)}
+		values[":deletedAt"] = &dynamodb.AttributeValue{S: aws.String(deletedAt)}
+		device = &dynamodb.TransactWriteItem{Update: &dynamodb.Update{
+			Key:                                 key,
+			TableName:                           tableName,
+			UpdateExpression:                    aws.String(fmt.Sprintf("SET %s = :deleted, %s = :deletedAt", names.Of("deleted"), names.Of("deletedAt"))),
+			ConditionExpression:                 aws.String(condition),
+			ExpressionAttributeNames:            names,
+			ExpressionAttributeValues:           values,
+			ReturnValuesOnConditionCheckFailure: aws.String(dynamodb.ReturnValuesOnConditionCheckFailureAllOld),
+		}}
+	}
+	var input = &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			deviceWrite: device,
+			markerWrite: {Delete: &dynamodb.Delete{
+				Key:                       map[string]*dynamodb.AttributeValue{"serial": stored["serial"]},
+				TableName:                 serialsTableName,
+				ConditionExpression:       aws.String(fmt.Sprintf("attribute_not_exists(%s) OR %s = :id", markerNames.Of("serial"), markerNames.Of("id"))),
+				ExpressionAttributeNames:  markerNames,
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":id": stored["id"]},
+			}},
+		},
+	}
+
+	// Calling either TransactWriteItems function of interface, defined in deleteDevice_test.go file, or api with the input we've provided.
+	// In real deployment environment, the TransactWriteItems function of aws (api.go) will be called.
+	_, err := self.DynamoDB.TransactWriteItems(input)
+	if canceled, ok := err.(*dynamodb.TransactionCanceledException); ok {
+		if reasonFailed(canceled, deviceWrite) {
+			// The device is still there, but with another serial, so it's been rotated meanwhile.
+			if item := canceled.CancellationReasons[deviceWrite].Item; len(item) > 0 && item["serial"] != nil && aws.StringValue(item["serial"].S) != aws.StringValue(stored["serial"].S) {
+				return errModified
+			}
+			return &dynamodb.ConditionalCheckFailedException{Message_: aws.String("The conditional request failed")}
+		}
+		if reasonFailed(canceled, markerWrite) {
+			return fmt.Errorf("the marker of the serial %s belongs to another device", aws.StringValue(stored["serial"].S))
+		}
+	}
+	return err
+}
+
+// Deleting the device, or flagging it as deleted for a non empty deletedAt. When the serials are marked, the marker of
+// its serial is deleted along with it. The item as it was before is returned, for the audit trail.
+func remove(id string, deletedAt string, ownerID string) (map[string]*dynamodb.AttributeValue, error) {
+	if os.Getenv("SERIALS_TABLE_NAME") != "" {
+		result, err := TestAws.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		// A device without a serial, or a missing one, has no marker to delete.
+		if result.Item["serial"] != nil {
+			return result.Item, TestAws.DeleteWithMarker(result.Item, deletedAt, ownerID)
+		}
+	}
+	if deletedAt != "" {
+		result, err := TestAws.SoftDelete(id, deletedAt, ownerID)
+		if err != nil {
+			return nil, err
+		}
+		return result.Attributes, nil
+	}
+	result, err := TestAws.Delete(id, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	return result.Attributes, nil
+}
+
+// Checking whether a write of a cancelled transaction has failed its condition.
+func reasonFailed(canceled *dynamodb.TransactionCanceledException, write int) bool {
+	return write < len(canceled.CancellationReasons) && aws.StringValue(canceled.CancellationReasons[write].Code) == "ConditionalCheckFailed"
+}
+
+// The handler function which will be first started from main function.
+// With SOFT_DELETE=true in OS's environment the device is kept for auditing, only flagged as deleted.
+// With SERIALS_TABLE_NAME set, the marker of its serial is deleted in the same transaction, freeing the serial.
+func DeleteDevice(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !gateway.AllowsMethod(request, "DELETE") {
+		return gateway.MethodNotAllowed("DELETE"), nil
+	}
+
+	// The id which user has sent through DELETE method.
+	id := request.PathParameters["id"]
+
+	// If no id have been provided, return HTTP error code 400.
+	if id == "" {
+		return events.APIGatewayProxyResponse{
+			Body:       "Missing field: id",
+			StatusCode: 400,
+		}, nil
+	}
+
+	// Only the devices of the caller's tenant can be deleted.
+	ownerID := owner.Caller(request)
+	var deletedAt string
+	if os.Getenv("SOFT_DELETE") == "true" {
+		deletedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	deleted, err := remove(id, deletedAt, ownerID)
+
+	// The serial of the device has been rotated since it's been read, the client may retry with the current one.
+	if err == errModified {
+		return events.APIGatewayProxyResponse{
+			Body:       "The device has been modified meanwhile, please retry.",
+			StatusCode: 409,
+		}, nil
+	}
+	if err != nil {
+		// The condition has failed, so there is no device with this id in the table, or not of this owner,
+		// return HTTP error code 404.
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return events.APIGatewayProxyResponse{
+				Body:       "Desired device not found.",
+				StatusCode: 404,
+			}, nil
+		}
+		// If internal database errors occurred, return HTTP error code 500.
+		fmt.Println(fmt.Sprintf("Failed to delete the device: %s", err.Error()))
+		return events.APIGatewayProxyResponse{
+			Body:       "Internal Server Error\nDatabase error.",
+			StatusCode: 500,
+		}, nil
+	}
+
+	// Recording who has deleted the device for the audit trail. It has been deleted anyway, so a failure is only logged.
+	if err := audit.Write(TestAws.DynamoDB, audit.NewRecord(id, audit.ActionDelete, ownerID, deleted, nil)); err != nil {
+		fmt.Println(fmt.Sprintf("Failed to write the audit record: %s", err.Error()))
+	}
+
+	// Everything looks fine, return HTTP 204 with no content.
+	return events.APIGatewayProxyResponse{
+		StatusCode: 204,
+	}, nil
+} // End of DeleteDevice function
+
+func main() {
+	lambda.Start(gateway.Adapt(recovery.WithRecover(DeleteDevice)))
+}
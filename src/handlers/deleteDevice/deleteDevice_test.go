@@ -0,0 +1,291 @@
+package main
+
+import (
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type TestCase struct {
+	Name               string
+	Request            events.APIGatewayProxyRequest
+	ExpectedBody       string
+	ExpectedStatusCode int
+}
+
+// Mocking DynamoDB through dynamodbiface.
+type MockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	// Ids of the devices which are already stored in the mocked table, and the soft deleted ones with their deletedAt.
+	ExistingIDs map[string]bool
+	DeletedAt   map[string]string
+	Owners      map[string]string
+	// Serials of the stored devices by their ids, and the ids of the devices which the serial markers are registered to.
+	Serials map[string]string
+	Markers map[string]string
+}
+
+// Custom DeleteItem function for overriding the DeleteItem of deleteDevice.go for using in test scenarios.
+// Mocking the "attribute_exists(#id)" condition against the ExistingIDs of the mock, and the owner one against its Owners.
+func (self *MockDynamoDB) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	id := aws.StringValue(input.Key["id"].S)
+	if strings.HasPrefix(aws.StringValue(input.ConditionExpression), "attribute_exists(#id)") && !self.ExistingIDs[id] {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
+	if ownerID := input.ExpressionAttributeValues[":owner"]; ownerID != nil && self.Owners[id] != aws.StringValue(ownerID.S) {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
+	delete(self.ExistingIDs, id)
+	return new(dynamodb.DeleteItemOutput), nil
+}
+
+// Custom UpdateItem function for overriding the UpdateItem of deleteDevice.go for using in test scenarios.
+// Mocking the "attribute_exists(#id) AND attribute_not_exists(#deleted)" condition of a soft delete.
+func (self *MockDynamoDB) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	id := aws.StringValue(input.Key["id"].S)
+	if _, deleted := self.DeletedAt[id]; !self.ExistingIDs[id] || deleted {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
+	if ownerID := input.ExpressionAttributeValues[":owner"]; ownerID != nil && self.Owners[id] != aws.StringValue(ownerID.S) {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
+	self.DeletedAt[id] = aws.StringValue(input.ExpressionAttributeValues[":deletedAt"].S)
+	return new(dynamodb.UpdateItemOutput), nil
+}
+
+// Custom GetItem function for overriding the GetItem of deleteDevice.go for using in test scenarios.
+// Returning the stored device of the mock with its serial, its owner and its deleted flag.
+func (self *MockDynamoDB) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	id := aws.StringValue(input.Key["id"].S)
+	if !self.ExistingIDs[id] {
+		return new(dynamodb.GetItemOutput), nil
+	}
+	item := map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}}
+	if serial, ok := self.Serials[id]; ok {
+		item["serial"] = &dynamodb.AttributeValue{S: aws.String(serial)}
+	}
+	if ownerID, ok := self.Owners[id]; ok {
+		item["ownerId"] = &dynamodb.AttributeValue{S: aws.String(ownerID)}
+	}
+	if _, deleted := self.DeletedAt[id]; deleted {
+		item["deleted"] = &dynamodb.AttributeValue{BOOL: aws.Bool(true)}
+	}
+	return &dynamodb.GetItemOutput{Item: item}, nil
+}
+
+// Custom TransactWriteItems function for overriding the TransactWriteItems of deleteDevice.go for using in test scenarios.
+// The device is deleted like by DeleteItem, or flagged like by UpdateItem, while it still has the serial which has
+// been read, and the marker is only deleted while it's missing or the device's one. Cancels the transaction like
+// DynamoDB, with a reason for each item in their order.
+func (self *MockDynamoDB) TransactWriteItems(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+	device, marker := input.TransactItems[deviceWrite], input.TransactItems[markerWrite].Delete
+	var key, values map[string]*dynamodb.AttributeValue
+	if device.Delete != nil {
+		key, values = device.Delete.Key, device.Delete.ExpressionAttributeValues
+	} else {
+		key, values = device.Update.Key, device.Update.ExpressionAttributeValues
+	}
+	id, serial := aws.StringValue(key["id"].S), aws.StringValue(marker.Key["serial"].S)
+	reasons := []*dynamodb.CancellationReason{{Code: aws.String("None")}, {Code: aws.String("None")}}
+	canceled := false
+	_, deleted := self.DeletedAt[id]
+	if !self.ExistingIDs[id] || self.Serials[id] != aws.StringValue(values[":serial"].S) || device.Update != nil && deleted {
+		stored, _ := self.GetItem(&dynamodb.GetItemInput{Key: key})
+		reasons[deviceWrite].Code, reasons[deviceWrite].Item, canceled = aws.String("ConditionalCheckFailed"), stored.Item, true
+	}
+	if ownerID := values[":owner"]; ownerID != nil && self.Owners[id] != aws.StringValue(ownerID.S) {
+		reasons[deviceWrite].Code, canceled = aws.String("ConditionalCheckFailed"), true
+	}
+	if markedID, ok := self.Markers[serial]; ok && markedID != id {
+		reasons[markerWrite].Code, canceled = aws.String("ConditionalCheckFailed"), true
+	}
+	if canceled {
+		return nil, &dynamodb.TransactionCanceledException{Message_: aws.String("Transaction cancelled"), CancellationReasons: reasons}
+	}
+	if device.Delete != nil {
+		delete(self.ExistingIDs, id)
+	} else {
+		self.DeletedAt[id] = aws.StringValue(values[":deletedAt"].S)
+	}
+	delete(self.Markers, serial)
+	return new(dynamodb.TransactWriteItemsOutput), nil
+}
+
+// DeleteDevice function in deleteDevice.go signature: input: (request events.APIGatewayProxyRequest), output: (events.APIGatewayProxyResponse, error)
+func TestDeleteDevice(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	mock := &MockDynamoDB{ExistingIDs: map[string]bool{"id_test": true}, DeletedAt: map[string]string{}}
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	testCases := []TestCase{
+		{
+			Name:               "** Testing: Empty id input. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": ""}},
+			ExpectedBody:       "Missing field: id",
+			ExpectedStatusCode: 400,
+		},
+
+		{
+			// Answered with the same JSON error as any other handler, even though the other errors are plain text.
+			Name:               "** Testing: Request of another method. **",
+			Request:            events.APIGatewayProxyRequest{HTTPMethod: "GET", PathParameters: map[string]string{"id": "id_test"}},
+			ExpectedBody:       "{\"message\":\"Method Not Allowed: use DELETE.\",\"code\":\"METHOD_NOT_ALLOWED\"}",
+			ExpectedStatusCode: 405,
+		},
+
+		{
+			Name:               "** Testing: Proper id which does exist on DB. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}},
+			ExpectedBody:       "",
+			ExpectedStatusCode: 204,
+		},
+
+		{
+			// The device has been deleted by the previous case, so it does not exist anymore.
+			Name:               "** Testing: Desire id does not exist. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}},
+			ExpectedBody:       "Desired device not found.",
+			ExpectedStatusCode: 404,
+		},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := DeleteDevice(test.Request)
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+	if len(mock.DeletedAt) != 0 {
+		t.Errorf("** Testing: Hard delete. ** \n \t<expected no soft deleted device> <resulted soft deleted devices: %v>", mock.DeletedAt)
+	}
+} // End of TestDeleteDevice function
+
+// With SOFT_DELETE=true the device is kept, only flagged as deleted, and can't be deleted twice.
+func TestDeleteDeviceSoftDelete(t *testing.T) {
+	t.Setenv("SOFT_DELETE", "true")
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	mock := &MockDynamoDB{ExistingIDs: map[string]bool{"id_test": true}, DeletedAt: map[string]string{}}
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	testCases := []TestCase{
+		{
+			Name:               "** Testing: Soft delete of an existing device. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}},
+			ExpectedBody:       "",
+			ExpectedStatusCode: 204,
+		},
+
+		{
+			// The device has been soft deleted by the previous case.
+			Name:               "** Testing: Soft delete of a deleted device. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}},
+			ExpectedBody:       "Desired device not found.",
+			ExpectedStatusCode: 404,
+		},
+
+		{
+			Name:               "** Testing: Soft delete of a missing device. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "NotExistedTestID"}},
+			ExpectedBody:       "Desired device not found.",
+			ExpectedStatusCode: 404,
+		},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := DeleteDevice(test.Request)
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+	if !mock.ExistingIDs["id_test"] || mock.DeletedAt["id_test"] == "" {
+		t.Errorf("** Testing: Soft deleted device is kept. ** \n \t<expected a kept device with its deletedAt> <resulted existing: %t, deletedAt: %q>", mock.ExistingIDs["id_test"], mock.DeletedAt["id_test"])
+	}
+} // End of TestDeleteDeviceSoftDelete function
+
+// Only the owner of a device can delete it, hard or soft, a device of another tenant is reported as missing.
+func TestDeleteDeviceOwner(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	mock := &MockDynamoDB{ExistingIDs: map[string]bool{"id_test": true, "id_soft": true}, DeletedAt: map[string]string{}, Owners: map[string]string{"id_test": "tenant-a", "id_soft": "tenant-a"}}
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	testCases := []struct {
+		Name               string
+		ID                 string
+		Caller             string
+		SoftDelete         string
+		ExpectedStatusCode int
+	}{
+		{Name: "** Testing: Delete by another tenant. **", ID: "id_test", Caller: "tenant-b", ExpectedStatusCode: 404},
+		{Name: "** Testing: Delete by the owner. **", ID: "id_test", Caller: "tenant-a", ExpectedStatusCode: 204},
+		{Name: "** Testing: Soft delete by another tenant. **", ID: "id_soft", Caller: "tenant-b", SoftDelete: "true", ExpectedStatusCode: 404},
+		{Name: "** Testing: Soft delete by the owner. **", ID: "id_soft", Caller: "tenant-a", SoftDelete: "true", ExpectedStatusCode: 204},
+	}
+
+	for _, test := range testCases {
+		t.Setenv("SOFT_DELETE", test.SoftDelete)
+
+		// Executing each test cases scenario.
+		response, _ := DeleteDevice(events.APIGatewayProxyRequest{
+			PathParameters: map[string]string{"id": test.ID},
+			RequestContext: events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"sub": test.Caller}},
+		})
+		if response.StatusCode != test.ExpectedStatusCode {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, response.Body)
+		}
+	}
+} // End of TestDeleteDeviceOwner function
+
+// With SERIALS_TABLE_NAME set, the marker of the serial of a deleted device, hard or soft, is deleted along with it, so
+// the serial can be registered again. A device without a marker is deleted all the same.
+func TestDeleteDeviceMarker(t *testing.T) {
+	t.Setenv("SERIALS_TABLE_NAME", "serials_test")
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	defer func() { TestAws = realAws }()
+
+	testCases := []struct {
+		Name               string
+		ID                 string
+		SoftDelete         string
+		Markers            map[string]string
+		ExpectedStatusCode int
+		ExpectedMarkers    map[string]string
+	}{
+		{Name: "** Testing: Delete of a device with a marker. **", ID: "id_test", Markers: map[string]string{"serial_test": "id_test", "serial_other": "id_other"}, ExpectedStatusCode: 204, ExpectedMarkers: map[string]string{"serial_other": "id_other"}},
+		{Name: "** Testing: Soft delete of a device with a marker. **", ID: "id_test", SoftDelete: "true", Markers: map[string]string{"serial_test": "id_test"}, ExpectedStatusCode: 204, ExpectedMarkers: map[string]string{}},
+		{Name: "** Testing: Delete of a device without a marker. **", ID: "id_test", Markers: map[string]string{}, ExpectedStatusCode: 204, ExpectedMarkers: map[string]string{}},
+		{Name: "** Testing: Delete of a device without a serial. **", ID: "id_unserialized", Markers: map[string]string{"serial_test": "id_test"}, ExpectedStatusCode: 204, ExpectedMarkers: map[string]string{"serial_test": "id_test"}},
+		{Name: "** Testing: Delete of a device whose marker is another device's. **", ID: "id_test", Markers: map[string]string{"serial_test": "id_other"}, ExpectedStatusCode: 500, ExpectedMarkers: map[string]string{"serial_test": "id_other"}},
+		{Name: "** Testing: Delete of a missing device. **", ID: "NotExistedTestID", Markers: map[string]string{"serial_test": "id_test"}, ExpectedStatusCode: 404, ExpectedMarkers: map[string]string{"serial_test": "id_test"}},
+	}
+
+	for _, test := range testCases {
+		t.Setenv("SOFT_DELETE", test.SoftDelete)
+		mock := &MockDynamoDB{
+			ExistingIDs: map[string]bool{"id_test": true, "id_unserialized": true},
+			DeletedAt:   map[string]string{},
+			Serials:     map[string]string{"id_test": "serial_test"},
+			Markers:     test.Markers,
+		}
+		TestAws = &AmazonWebServices{DynamoDB: mock}
+
+		// Executing each test cases scenario.
+		response, _ := DeleteDevice(events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": test.ID}})
+		if response.StatusCode != test.ExpectedStatusCode || !reflect.DeepEqual(mock.Markers, test.ExpectedMarkers) {
+			t.Errorf("%s \n \t<expected error-code: %d, markers: %v> <resulted error-code: %d, markers: %v> <resulted body: %s>", test.Name, test.ExpectedStatusCode, test.ExpectedMarkers, response.StatusCode, mock.Markers, response.Body)
+		}
+	}
+} // End of TestDeleteDeviceMarker function
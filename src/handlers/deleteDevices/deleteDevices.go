@@ -0,0 +1,329 @@
+package main
+
+import (
+	"audit"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gateway"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"os"
+	"owner"
+	"placeholder"
+	"recovery"
+	"time"
+	"types"
+	"validation"
+)
+
+type AmazonWebServices struct {
+	Config   *aws.Config
+	Session  *session.Session
+	DynamoDB dynamodbiface.DynamoDBAPI
+}
+
+// Prepare a new AWS & DynamoDB session, then configure it.
+var TestAws *AmazonWebServices
+
+// DynamoDB accepts at most 25 delete requests in a single BatchWriteItem call, and 100 keys in a BatchGetItem call.
+const (
+	batchSize    = 25
+	getBatchSize = 100
+)
+
+// How many times unprocessed items are sent again, and the delay before the first retry which doubles each time.
+const maxBatchRetries = 5
+
+var batchRetryDelay = 50 * time.Millisecond
+
+func init() {
+	region := os.Getenv("AWS_REGION")
+	var Aws *AmazonWebServices = new(AmazonWebServices)
+	Aws.Config = &aws.Config{Region: aws.String(region)}
+	// Pointing the client to a local DynamoDB, i.e: DynamoDB Local for the integration tests. It's unset in production.
+	if endpoint := os.Getenv("DYNAMODB_ENDPOINT"); endpoint != "" {
+		Aws.Config.Endpoint = aws.String(endpoint)
+	}
+	var err error
+	Aws.Session, err = session.NewSession(Aws.Config)
+	if err != nil {
+		// Logs error on Amazon CloudWatch. It's sysadmin's duty to handle it.
+		fmt.Println(fmt.Sprintf("Failed to connect to AWS: %s", err.Error()))
+	} else {
+		var svc *dynamodb.DynamoDB = dynamodb.New(Aws.Session)
+		Aws.DynamoDB = dynamodbiface.DynamoDBAPI(svc)
+	}
+	// Instantiate a global session in TestAws
+	TestAws = Aws
+}
+
+// Preparing DynamoDB Session and Calling DB's BatchGetItem function inside, in chunks of 100 ids, reading the devices
+// about to be deleted. Keys left unprocessed by DynamoDB are retried with an exponential backoff, as in BatchDelete.
+// Returns the found devices by id, ids which don't exist are simply not returned.
+func (self *AmazonWebServices) BatchGet(ids []string) (map[string]map[string]*dynamodb.AttributeValue, error) {
+	// Get table name from OS's environment
+	tableName := os.Getenv("DEVICES_TABLE_NAME")
+	items := map[string]map[string]*dynamodb.AttributeValue{}
+
+	for start := 0; start < len(ids); start += getBatchSize {
+		end := start + getBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		keys := &dynamodb.KeysAndAttributes{ConsistentRead: aws.Bool(true)}
+		for _, id := range ids[start:end] {
+			keys.Keys = append(keys.Keys, map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}})
+		}
+
+		delay := batchRetryDelay
+		for attempt := 0; keys != nil && len(keys.Keys) > 0; attempt++ {
+			if attempt > maxBatchRetries {
+				return nil, errors.New("keys left unprocessed after retries")
+			}
+			if attempt > 0 {
+				time.Sleep(delay)
+				delay *= 2
+			}
+			var input = &dynamodb.BatchGetItemInput{
+				RequestItems: map[string]*dynamodb.KeysAndAttributes{tableName: keys},
+			}
+			// Calling either BatchGetItem function of interface, defined in deleteDevices_test.go file, or api with the input we've provided.
+			// In real deployment environment, the BatchGetItem function of aws (api.go) will be called.
+			result, err := self.DynamoDB.BatchGetItem(input)
+			if err != nil {
+				return nil, err
+			}
+			for _, item := range result.Responses[tableName] {
+				items[aws.StringValue(item["id"].S)] = item
+			}
+			keys = result.UnprocessedKeys[tableName]
+		}
+	}
+	return items, nil
+}
+
+// Preparing DynamoDB Session and Calling DB's BatchWriteItem function inside with delete requests, in chunks of 25 ids.
+// Items left unprocessed by DynamoDB, i.e: because of throttling, are retried with an exponential backoff.
+// Returns the ids which could not be deleted at all. Note that BatchWriteItem can't be conditional,
+// so deleting a missing device succeeds as well.
+func (self *AmazonWebServices) BatchDelete(ids []string) []string {
+	// Get table name from OS's environment
+	tableName := os.Getenv("DEVICES_TABLE_NAME")
+	var failed []string
+
+	for start := 0; start < len(ids); start += batchSize {
+		end := start + batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		requests := make([]*dynamodb.WriteRequest, 0, end-start)
+		for _, id := range ids[start:end] {
+			requests = append(requests, &dynamodb.WriteRequest{DeleteRequest: &dynamodb.DeleteRequest{
+				Key: map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}},
+			}})
+		}
+
+		delay := batchRetryDelay
+		for attempt := 0; len(requests) > 0; attempt++ {
+			if attempt > maxBatchRetries {
+				break
+			}
+			if attempt > 0 {
+				time.Sleep(delay)
+				delay *= 2
+			}
+			var input = &dynamodb.BatchWriteItemInput{
+				RequestItems: map[string][]*dynamodb.WriteRequest{tableName: requests},
+			}
+			// Calling either BatchWriteItem function of interface, defined in deleteDevices_test.go file, or api with the input we've provided.
+			// In real deployment environment, the BatchWriteItem function of aws (api.go) will be called.
+			result, err := self.DynamoDB.BatchWriteItem(input)
+			if err != nil {
+				// Logs error on Amazon CloudWatch, the whole chunk is reported as failed.
+				fmt.Println(fmt.Sprintf("Failed to delete a batch of devices: %s", err.Error()))
+				break
+			}
+			requests = result.UnprocessedItems[tableName]
+		}
+
+		for _, request := range requests {
+			failed = append(failed, aws.StringValue(request.DeleteRequest.Key["id"].S))
+		}
+	}
+	return failed
+}
+
+// Preparing DynamoDB Session and Calling DB's TransactWriteItems function inside, deleting a stored device read by
+// BatchGet along with the marker of its serial in SERIALS_TABLE_NAME, the one written by AddDevice, like DeleteDevice.
+// The device is only deleted while it still has the serial which has been read, and the marker only while it's the
+// device's one, the devices created before the serials table have none.
+func (self *AmazonWebServices) DeleteWithMarker(stored map[string]*dynamodb.AttributeValue) error {
+	// Get desire tables' names from OS's environmental varibles.
+	tableName := aws.String(os.Getenv("DEVICES_TABLE_NAME"))
+	serialsTableName := aws.String(os.Getenv("SERIALS_TABLE_NAME"))
+
+	names, markerNames := placeholder.Names{}, placeholder.Names{}
+	var input = &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{Delete: &dynamodb.Delete{
+				Key:                       map[string]*dynamodb.AttributeValue{"id": stored["id"]},
+				TableName:                 tableName,
+				ConditionExpression:       aws.String(fmt.Sprintf("attribute_exists(%s) AND %s = :serial", names.Of("id"), names.Of("serial"))),
+				ExpressionAttributeNames:  names,
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":serial": stored["serial"]},
+			}},
+			{Delete: &dynamodb.Delete{
+				Key:                       map[string]*dynamodb.AttributeValue{"serial": stored["serial"]},
+				TableName:                 serialsTableName,
+				ConditionExpression:       aws.String(fmt.Sprintf("attribute_not_exists(%s) OR %s = :id", markerNames.Of("serial"), markerNames.Of("id"))),
+				ExpressionAttributeNames:  markerNames,
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":id": stored["id"]},
+			}},
+		},
+	}
+
+	// Calling either TransactWriteItems function of interface, defined in deleteDevices_test.go file, or api with the input we've provided.
+	// In real deployment environment, the TransactWriteItems function of aws (api.go) will be called.
+	_, err := self.DynamoDB.TransactWriteItems(input)
+	return err
+}
+
+// The handler function which will be first started from main function.
+// The body is a JSON array of the ids to delete, the response lists which of them have been deleted and which have failed.
+// With SERIALS_TABLE_NAME set, a device with a serial is deleted along with its marker in a transaction of its own, as
+// a batch can't be one, and it's reported as failed when it has changed its serial since it's been read.
+func DeleteDevices(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !gateway.AllowsMethod(request, "POST") {
+		return gateway.MethodNotAllowed("POST"), nil
+	}
+
+	// A bulk delete can only remove devices, which would lose the devices kept for auditing.
+	if os.Getenv("SOFT_DELETE") == "true" {
+		return events.APIGatewayProxyResponse{
+			Body:       "Bulk delete is not available while SOFT_DELETE is enabled.",
+			StatusCode: 400,
+		}, nil
+	}
+
+	if len(request.Body) == 0 {
+		return events.APIGatewayProxyResponse{
+			Body:       "No inputs provided, please provide inputs in JSON format.",
+			StatusCode: 400,
+		}, nil
+	}
+
+	if err := validation.CheckBodySize(request); err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:       err.Error(),
+			StatusCode: 413,
+		}, nil
+	}
+
+	// De-serialize "request.Body" which is a JSON array into "ids" in Go objects.
+	var ids []string
+	err := json.Unmarshal([]byte(request.Body), &ids)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:       "Wrong format: Inputs must be a valid JSON array of ids.",
+			StatusCode: 400,
+		}, nil
+	}
+	if len(ids) == 0 {
+		return events.APIGatewayProxyResponse{
+			Body:       "No ids provided, please provide at least one id.",
+			StatusCode: 400,
+		}, nil
+	}
+
+	// DynamoDB rejects a whole batch which deletes the same id twice, and an empty id is no device at all.
+	Result := types.BulkDeleteResult{Deleted: []string{}, Failed: []string{}}
+	var unique []string
+	seen := map[string]bool{}
+	for _, id := range ids {
+		if id == "" {
+			Result.Failed = append(Result.Failed, id)
+			continue
+		}
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+
+	// BatchWriteItem can't be conditional, so the devices are read first: the ones which don't exist, and behind an
+	// authorizer the ones of another tenant, are reported as failed and left out of the batch, as DeleteDevice reports
+	// them as not found.
+	stored, err := TestAws.BatchGet(unique)
+	if err != nil {
+		// Logs error on Amazon CloudWatch. It's sysadmin's duty to handle it.
+		fmt.Println(fmt.Sprintf("Failed to read the devices to delete: %s", err.Error()))
+		return events.APIGatewayProxyResponse{
+			Body:       "Internal Server Error\nDatabase error.",
+			StatusCode: 500,
+		}, nil
+	}
+	caller := owner.Caller(request)
+	var owned []string
+	for _, id := range unique {
+		if item, found := stored[id]; !found || !owner.Matches(caller, storedOwner(item)) {
+			Result.Failed = append(Result.Failed, id)
+			continue
+		}
+		owned = append(owned, id)
+	}
+
+	failed := map[string]bool{}
+	var batched []string
+	for _, id := range owned {
+		item := stored[id]
+		if os.Getenv("SERIALS_TABLE_NAME") == "" || item["serial"] == nil {
+			batched = append(batched, id)
+			continue
+		}
+		if err := TestAws.DeleteWithMarker(item); err != nil {
+			// Logs error on Amazon CloudWatch, the device is reported as failed.
+			fmt.Println(fmt.Sprintf("Failed to delete the device %s along with its marker: %s", id, err.Error()))
+			failed[id] = true
+			Result.Failed = append(Result.Failed, id)
+		}
+	}
+	for _, id := range TestAws.BatchDelete(batched) {
+		failed[id] = true
+		Result.Failed = append(Result.Failed, id)
+	}
+	for _, id := range owned {
+		if failed[id] {
+			continue
+		}
+		Result.Deleted = append(Result.Deleted, id)
+		// Recording who has deleted each device for the audit trail, like DeleteDevice does.
+		// It has been deleted anyway, so a failure is only logged.
+		if err := audit.Write(TestAws.DynamoDB, audit.NewRecord(id, audit.ActionDelete, caller, stored[id], nil)); err != nil {
+			fmt.Println(fmt.Sprintf("Failed to write the audit record: %s", err.Error()))
+		}
+	}
+
+	// Serialization/Encoding the result to JSON.
+	resultJson, _ := json.Marshal(Result)
+	return events.APIGatewayProxyResponse{
+		Body:       string(resultJson),
+		StatusCode: 200,
+	}, nil
+} // End of DeleteDevices function
+
+// Finding the owner of a stored device, empty for the devices created without a caller.
+func storedOwner(item map[string]*dynamodb.AttributeValue) string {
+	if ownerID := item["ownerId"]; ownerID != nil {
+		return aws.StringValue(ownerID.S)
+	}
+	return ""
+}
+
+func main() {
+	lambda.Start(gateway.Adapt(recovery.WithRecover(DeleteDevices)))
+}
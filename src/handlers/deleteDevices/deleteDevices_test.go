@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"reflect"
+	"testing"
+	"types"
+)
+
+// Mocking DynamoDB through dynamodbiface.
+type MockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	// Ids which are left unprocessed the first time they are sent, to simulate throttling.
+	Throttled map[string]bool
+	// Ids which have been deleted, and the size of every BatchWriteItem call.
+	Deleted    map[string]bool
+	BatchSizes []int
+	// Devices which are stored in the mocked table by id, as read by BatchGetItem.
+	Stored map[string]map[string]*dynamodb.AttributeValue
+	// Device ids of the records appended to the mocked audit table, in their order.
+	Audited []string
+	// Ids of the devices which the serial markers are registered to, by serial.
+	Markers map[string]string
+}
+
+// Custom PutItem function for overriding the PutItem of deleteDevices.go for using in test scenarios.
+// Only the audit records are put on their own.
+func (self *MockDynamoDB) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	self.Audited = append(self.Audited, aws.StringValue(input.Item["deviceId"].S))
+	return new(dynamodb.PutItemOutput), nil
+}
+
+// Custom BatchGetItem function for overriding the BatchGetItem of deleteDevices.go for using in test scenarios.
+func (self *MockDynamoDB) BatchGetItem(input *dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error) {
+	MockOutput := &dynamodb.BatchGetItemOutput{Responses: map[string][]map[string]*dynamodb.AttributeValue{}}
+	for table, keys := range input.RequestItems {
+		for _, key := range keys.Keys {
+			if item, ok := self.Stored[aws.StringValue(key["id"].S)]; ok {
+				MockOutput.Responses[table] = append(MockOutput.Responses[table], item)
+			}
+		}
+	}
+	return MockOutput, nil
+}
+
+// Custom BatchWriteItem function for overriding the BatchWriteItem of deleteDevices.go for using in test scenarios.
+func (self *MockDynamoDB) BatchWriteItem(input *dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error) {
+	MockOutput := &dynamodb.BatchWriteItemOutput{UnprocessedItems: map[string][]*dynamodb.WriteRequest{}}
+	for table, requests := range input.RequestItems {
+		self.BatchSizes = append(self.BatchSizes, len(requests))
+		for _, request := range requests {
+			id := aws.StringValue(request.DeleteRequest.Key["id"].S)
+			if self.Throttled[id] {
+				delete(self.Throttled, id)
+				MockOutput.UnprocessedItems[table] = append(MockOutput.UnprocessedItems[table], request)
+				continue
+			}
+			self.Deleted[id] = true
+		}
+	}
+	return MockOutput, nil
+}
+
+// Custom TransactWriteItems function for overriding the TransactWriteItems of deleteDevices.go for using in test scenarios.
+// The device is only deleted while it still has the serial which has been read, and its marker only while it's
+// missing or the device's one.
+func (self *MockDynamoDB) TransactWriteItems(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+	device, marker := input.TransactItems[0].Delete, input.TransactItems[1].Delete
+	id, serial := aws.StringValue(device.Key["id"].S), aws.StringValue(marker.Key["serial"].S)
+	reasons := []*dynamodb.CancellationReason{{Code: aws.String("None")}, {Code: aws.String("None")}}
+	canceled := false
+	if stored, ok := self.Stored[id]; !ok || aws.StringValue(stored["serial"].S) != aws.StringValue(device.ExpressionAttributeValues[":serial"].S) {
+		reasons[0].Code, canceled = aws.String("ConditionalCheckFailed"), true
+	}
+	if markedID, ok := self.Markers[serial]; ok && markedID != id {
+		reasons[1].Code, canceled = aws.String("ConditionalCheckFailed"), true
+	}
+	if canceled {
+		return nil, &dynamodb.TransactionCanceledException{Message_: aws.String("Transaction cancelled"), CancellationReasons: reasons}
+	}
+	self.Deleted[id] = true
+	delete(self.Markers, serial)
+	return new(dynamodb.TransactWriteItemsOutput), nil
+}
+
+// DeleteDevices function in deleteDevices.go signature: input: (request events.APIGatewayProxyRequest), output: (events.APIGatewayProxyResponse, error)
+func TestDeleteDevices(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	mock := &MockDynamoDB{Throttled: map[string]bool{"id_test27": true}, Deleted: map[string]bool{}, Stored: map[string]map[string]*dynamodb.AttributeValue{}}
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	realDelay := batchRetryDelay
+	batchRetryDelay = 0
+	defer func() {
+		TestAws = realAws
+		batchRetryDelay = realDelay
+	}()
+
+	// 30 stored ids spanning two chunks, and a missing one which fails even without an authorizer.
+	var ids []string
+	for i := 0; i < 30; i++ {
+		id := fmt.Sprintf("id_test%d", i)
+		ids = append(ids, id)
+		mock.Stored[id] = map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}}
+	}
+	body, _ := json.Marshal(append(ids, "id_missing"))
+
+	response, _ := DeleteDevices(events.APIGatewayProxyRequest{Body: string(body)})
+	if response.StatusCode != 200 {
+		t.Fatalf("** Testing: Bulk delete spanning two chunks. ** \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", 200, response.StatusCode, response.Body)
+	}
+
+	// The throttled id is sent again on its own.
+	if len(mock.BatchSizes) != 3 || mock.BatchSizes[0] != 25 || mock.BatchSizes[1] != 5 || mock.BatchSizes[2] != 1 {
+		t.Errorf("** Testing: Chunks of the bulk delete. ** \n \t<expected batch sizes: [25 5 1]> <resulted batch sizes: %v>", mock.BatchSizes)
+	}
+	Result := types.BulkDeleteResult{}
+	json.Unmarshal([]byte(response.Body), &Result)
+	if len(Result.Deleted) != 30 || len(Result.Failed) != 1 || Result.Failed[0] != "id_missing" || !mock.Deleted["id_test27"] || mock.Deleted["id_missing"] {
+		t.Errorf("** Testing: Result of the bulk delete. ** \n \t<expected 30 deleted ids including the retried one, id_missing failed> <resulted body: %s>", response.Body)
+	}
+} // End of TestDeleteDevices function
+
+// Behind an authorizer, only the devices of the caller's tenant are deleted, the others are reported as failed.
+func TestDeleteDevicesOwner(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	mock := &MockDynamoDB{Deleted: map[string]bool{}, Stored: map[string]map[string]*dynamodb.AttributeValue{
+		"id_owned":   {"id": {S: aws.String("id_owned")}, "ownerId": {S: aws.String("tenant-a")}},
+		"id_foreign": {"id": {S: aws.String("id_foreign")}, "ownerId": {S: aws.String("tenant-b")}},
+	}}
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+	t.Setenv("AUDIT_TABLE_NAME", "audit_test")
+
+	tenant := events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"claims": map[string]interface{}{"sub": "tenant-a"}}}
+	response, _ := DeleteDevices(events.APIGatewayProxyRequest{Body: "[\"id_owned\",\"id_foreign\",\"id_missing\"]", RequestContext: tenant})
+
+	expected := "{\"deleted\":[\"id_owned\"],\"failed\":[\"id_foreign\",\"id_missing\"]}"
+	if response.StatusCode != 200 || response.Body != expected {
+		t.Errorf("** Testing: Bulk delete of a tenant. ** \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", 200, response.StatusCode, expected, response.Body)
+	}
+	if mock.Deleted["id_foreign"] || mock.Deleted["id_missing"] || !mock.Deleted["id_owned"] {
+		t.Errorf("** Testing: Devices deleted for a tenant. ** \n \t<expected only id_owned deleted> <resulted deleted: %v>", mock.Deleted)
+	}
+	// Only the deleted device is recorded in the audit trail.
+	if len(mock.Audited) != 1 || mock.Audited[0] != "id_owned" {
+		t.Errorf("** Testing: Audit records. ** \n \t<expected a record of id_owned only> <resulted records of: %v>", mock.Audited)
+	}
+} // End of TestDeleteDevicesOwner function
+
+// Request bodies which can't be a bulk delete at all.
+func TestDeleteDevicesWrongInputs(t *testing.T) {
+	testCases := []struct {
+		Name               string
+		Request            events.APIGatewayProxyRequest
+		ExpectedBody       string
+		ExpectedStatusCode int
+	}{
+		{
+			Name:               "** Testing: Empty body input. **",
+			Request:            events.APIGatewayProxyRequest{Body: ""},
+			ExpectedBody:       "No inputs provided, please provide inputs in JSON format.",
+			ExpectedStatusCode: 400,
+		},
+
+		{
+			Name:               "** Testing: A single id instead of an array. **",
+			Request:            events.APIGatewayProxyRequest{Body: "\"id_test\""},
+			ExpectedBody:       "Wrong format: Inputs must be a valid JSON array of ids.",
+			ExpectedStatusCode: 400,
+		},
+
+		{
+			Name:               "** Testing: Empty array. **",
+			Request:            events.APIGatewayProxyRequest{Body: "[]"},
+			ExpectedBody:       "No ids provided, please provide at least one id.",
+			ExpectedStatusCode: 400,
+		},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := DeleteDevices(test.Request)
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+} // End of TestDeleteDevicesWrongInputs function
+
+// With SERIALS_TABLE_NAME set, the devices with a serial are deleted along with their markers, the others in a batch.
+// A device whose marker is another device's is reported as failed and kept, along with the marker.
+func TestDeleteDevicesMarkers(t *testing.T) {
+	t.Setenv("SERIALS_TABLE_NAME", "serials_test")
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	mock := &MockDynamoDB{
+		Deleted: map[string]bool{},
+		Stored: map[string]map[string]*dynamodb.AttributeValue{
+			"id_marked":       {"id": {S: aws.String("id_marked")}, "serial": {S: aws.String("serial_marked")}},
+			"id_legacy":       {"id": {S: aws.String("id_legacy")}, "serial": {S: aws.String("serial_legacy")}},
+			"id_unserialized": {"id": {S: aws.String("id_unserialized")}},
+			"id_conflicting":  {"id": {S: aws.String("id_conflicting")}, "serial": {S: aws.String("serial_taken")}},
+		},
+		Markers: map[string]string{"serial_marked": "id_marked", "serial_taken": "id_other", "serial_kept": "id_kept"},
+	}
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	response, _ := DeleteDevices(events.APIGatewayProxyRequest{Body: "[\"id_marked\",\"id_legacy\",\"id_unserialized\",\"id_conflicting\"]"})
+	expected := "{\"deleted\":[\"id_marked\",\"id_legacy\",\"id_unserialized\"],\"failed\":[\"id_conflicting\"]}"
+	if response.StatusCode != 200 || response.Body != expected {
+		t.Errorf("** Testing: Bulk delete with markers. ** \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", 200, response.StatusCode, expected, response.Body)
+	}
+	expectedMarkers := map[string]string{"serial_taken": "id_other", "serial_kept": "id_kept"}
+	if !reflect.DeepEqual(mock.Markers, expectedMarkers) || len(mock.BatchSizes) != 1 || mock.BatchSizes[0] != 1 || mock.Deleted["id_conflicting"] {
+		t.Errorf("** Testing: Markers of the deleted devices. ** \n \t<expected markers: %v, batch sizes: [1]> <resulted markers: %v, batch sizes: %v, deleted: %v>", expectedMarkers, mock.Markers, mock.BatchSizes, mock.Deleted)
+	}
+} // End of TestDeleteDevicesMarkers function
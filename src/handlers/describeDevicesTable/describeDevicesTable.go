@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"gateway"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"os"
+	"owner"
+	"recovery"
+	"types"
+)
+
+type AmazonWebServices struct {
+	Config   *aws.Config
+	Session  *session.Session
+	DynamoDB dynamodbiface.DynamoDBAPI
+}
+
+// Prepare a new AWS & DynamoDB session, then configure it.
+var TestAws *AmazonWebServices
+
+func init() {
+	region := os.Getenv("AWS_REGION")
+	var Aws *AmazonWebServices = new(AmazonWebServices)
+	Aws.Config = &aws.Config{Region: aws.String(region)}
+	// Pointing the client to a local DynamoDB, i.e: DynamoDB Local for the integration tests. It's unset in production.
+	if endpoint := os.Getenv("DYNAMODB_ENDPOINT"); endpoint != "" {
+		Aws.Config.Endpoint = aws.String(endpoint)
+	}
+	var err error
+	Aws.Session, err = session.NewSession(Aws.Config)
+	if err != nil {
+		// Logs error on Amazon CloudWatch. It's sysadmin's duty to handle it.
+		fmt.Println(fmt.Sprintf("Failed to connect to AWS: %s", err.Error()))
+	} else {
+		var svc *dynamodb.DynamoDB = dynamodb.New(Aws.Session)
+		Aws.DynamoDB = dynamodbiface.DynamoDBAPI(svc)
+	}
+	// Instantiate a global session in TestAws
+	TestAws = Aws
+}
+
+// Preparing DynamoDB Session and Calling DB's DescribeTable function inside.
+// Only the metadata worth to an operator is kept of the table description: its status, size and indexes.
+func (self *AmazonWebServices) Describe() (types.TableSummary, error) {
+	// Get desire table's name from OS's environmental varible.
+	tableName := aws.String(os.Getenv("DEVICES_TABLE_NAME"))
+
+	// Calling either DescribeTable function of interface, defined in describeDevicesTable_test.go file, or api with the input we've provided.
+	// In real deployment environment, the DescribeTable function of aws (api.go) will be called.
+	result, err := self.DynamoDB.DescribeTable(&dynamodb.DescribeTableInput{TableName: tableName})
+	if err != nil {
+		return types.TableSummary{}, err
+	}
+	summary := types.TableSummary{
+		Name:      aws.StringValue(result.Table.TableName),
+		Status:    aws.StringValue(result.Table.TableStatus),
+		ItemCount: aws.Int64Value(result.Table.ItemCount),
+		SizeBytes: aws.Int64Value(result.Table.TableSizeBytes),
+		// Starting from an empty slice, so a table without indexes is returned with "[]" instead of "null".
+		Indexes: []string{},
+	}
+	for _, index := range result.Table.GlobalSecondaryIndexes {
+		summary.Indexes = append(summary.Indexes, aws.StringValue(index.IndexName))
+	}
+	return summary, nil
+}
+
+// The handler function which will be first started from main function.
+// Returns the metadata of the devices table to the administrators only, see owner.IsAdmin, any other caller gets
+// HTTP 403.
+func DescribeDevicesTable(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !gateway.AllowsMethod(request, "GET") {
+		return gateway.MethodNotAllowed("GET"), nil
+	}
+
+	if !owner.IsAdmin(request) {
+		return events.APIGatewayProxyResponse{
+			Body:       "Forbidden: only administrators may describe the table.",
+			StatusCode: 403,
+		}, nil
+	}
+
+	summary, err := TestAws.Describe()
+
+	// If an internal error have occurred in the database, return HTTP error code 500.
+	if err != nil {
+		// Logs error on Amazon CloudWatch. It's sysadmin's duty to handle it.
+		fmt.Println(fmt.Sprintf("Failed to describe the table: %s", err.Error()))
+		return events.APIGatewayProxyResponse{
+			Body:       "Internal Server Error.",
+			StatusCode: 500,
+		}, nil
+	}
+
+	// Serialization/Encoding the summary to JSON.
+	summaryJson, _ := json.Marshal(summary)
+	return events.APIGatewayProxyResponse{
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(summaryJson),
+		StatusCode: 200,
+	}, nil
+} // End of DescribeDevicesTable function
+
+func main() {
+	lambda.Start(gateway.Adapt(recovery.WithRecover(DescribeDevicesTable)))
+}
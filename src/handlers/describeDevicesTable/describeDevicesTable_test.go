@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"testing"
+)
+
+// Mocking DynamoDB through dynamodbiface.
+type MockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	// Error which the mocked DescribeTable returns, and the number of DescribeTable calls.
+	Error     error
+	Describes int
+}
+
+// Custom DescribeTable function for overriding the DescribeTable of describeDevicesTable.go for using in test scenarios.
+func (self *MockDynamoDB) DescribeTable(input *dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error) {
+	self.Describes++
+	if self.Error != nil {
+		return nil, self.Error
+	}
+	return &dynamodb.DescribeTableOutput{Table: &dynamodb.TableDescription{
+		TableName:      input.TableName,
+		TableStatus:    aws.String(dynamodb.TableStatusActive),
+		ItemCount:      aws.Int64(42),
+		TableSizeBytes: aws.Int64(8192),
+		TableArn:       aws.String("arn:aws:dynamodb:eu-west-1:123456789012:table/devices_test"),
+		GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndexDescription{
+			{IndexName: aws.String("DeviceModel-index"), ItemCount: aws.Int64(40)},
+			{IndexName: aws.String("OwnerSerial-index"), ItemCount: aws.Int64(42)},
+		},
+	}}, nil
+}
+
+// Request of a caller in the given Cognito groups, as claimed through a REST API's authorizer.
+func requestOf(groups string) events.APIGatewayProxyRequest {
+	return events.APIGatewayProxyRequest{RequestContext: events.APIGatewayProxyRequestContext{
+		Authorizer: map[string]interface{}{"claims": map[string]interface{}{"sub": "user_test", "cognito:groups": groups}},
+	}}
+}
+
+// DescribeDevicesTable function in describeDevicesTable.go signature: input: (request events.APIGatewayProxyRequest), output: (events.APIGatewayProxyResponse, error)
+func TestDescribeDevicesTable(t *testing.T) {
+	t.Setenv("DEVICES_TABLE_NAME", "devices_test")
+	testCases := []struct {
+		Name               string
+		AdminGroup         string
+		Request            events.APIGatewayProxyRequest
+		MockDatabase       *MockDynamoDB
+		ExpectedBody       string
+		ExpectedStatusCode int
+		ExpectedDescribes  int
+	}{
+		{
+			Name:               "** Testing: Administrator. **",
+			AdminGroup:         "admin",
+			Request:            requestOf("ops,admin"),
+			MockDatabase:       &MockDynamoDB{},
+			ExpectedBody:       "{\"name\":\"devices_test\",\"status\":\"ACTIVE\",\"itemCount\":42,\"sizeBytes\":8192,\"indexes\":[\"DeviceModel-index\",\"OwnerSerial-index\"]}",
+			ExpectedStatusCode: 200,
+			ExpectedDescribes:  1,
+		},
+
+		{
+			Name:               "** Testing: Administrator through an HTTP API. **",
+			AdminGroup:         "admin",
+			Request:            requestOf("[ops admin]"),
+			MockDatabase:       &MockDynamoDB{},
+			ExpectedBody:       "{\"name\":\"devices_test\",\"status\":\"ACTIVE\",\"itemCount\":42,\"sizeBytes\":8192,\"indexes\":[\"DeviceModel-index\",\"OwnerSerial-index\"]}",
+			ExpectedStatusCode: 200,
+			ExpectedDescribes:  1,
+		},
+
+		{
+			Name:               "** Testing: Caller out of the admin group. **",
+			AdminGroup:         "admin",
+			Request:            requestOf("ops,administrators"),
+			MockDatabase:       &MockDynamoDB{},
+			ExpectedBody:       "Forbidden: only administrators may describe the table.",
+			ExpectedStatusCode: 403,
+			ExpectedDescribes:  0,
+		},
+
+		{
+			Name:               "** Testing: Caller without groups. **",
+			AdminGroup:         "admin",
+			Request:            events.APIGatewayProxyRequest{},
+			MockDatabase:       &MockDynamoDB{},
+			ExpectedBody:       "Forbidden: only administrators may describe the table.",
+			ExpectedStatusCode: 403,
+			ExpectedDescribes:  0,
+		},
+
+		{
+			Name:               "** Testing: No admin group configured. **",
+			AdminGroup:         "",
+			Request:            requestOf("admin"),
+			MockDatabase:       &MockDynamoDB{},
+			ExpectedBody:       "Forbidden: only administrators may describe the table.",
+			ExpectedStatusCode: 403,
+			ExpectedDescribes:  0,
+		},
+
+		{
+			Name:               "** Testing: Unreachable table. **",
+			AdminGroup:         "admin",
+			Request:            requestOf("admin"),
+			MockDatabase:       &MockDynamoDB{Error: errors.New("unexpected Error has occurred")},
+			ExpectedBody:       "Internal Server Error.",
+			ExpectedStatusCode: 500,
+			ExpectedDescribes:  1,
+		},
+	}
+
+	realAws := TestAws
+	defer func() { TestAws = realAws }()
+
+	for _, test := range testCases {
+		// Executing each test cases scenario against its own mocked database.
+		t.Setenv("ADMIN_GROUP", test.AdminGroup)
+		TestAws = &AmazonWebServices{DynamoDB: test.MockDatabase}
+		response, _ := DescribeDevicesTable(test.Request)
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody || test.MockDatabase.Describes != test.ExpectedDescribes {
+			t.Errorf("%s \n \t<expected error-code: %d, describes: %d> <resulted error-code: %d, describes: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, test.ExpectedDescribes, response.StatusCode, test.MockDatabase.Describes, test.ExpectedBody, response.Body)
+		}
+	}
+} // End of TestDescribeDevicesTable function
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"gateway"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"os"
+	"owner"
+	"projection"
+	"recovery"
+)
+
+type AmazonWebServices struct {
+	Config   *aws.Config
+	Session  *session.Session
+	DynamoDB dynamodbiface.DynamoDBAPI
+}
+
+// Prepare a new AWS & DynamoDB session, then configure it.
+var TestAws *AmazonWebServices
+
+func init() {
+	region := os.Getenv("AWS_REGION")
+	var Aws *AmazonWebServices = new(AmazonWebServices)
+	Aws.Config = &aws.Config{Region: aws.String(region)}
+	// Pointing the client to a local DynamoDB, i.e: DynamoDB Local for the integration tests. It's unset in production.
+	if endpoint := os.Getenv("DYNAMODB_ENDPOINT"); endpoint != "" {
+		Aws.Config.Endpoint = aws.String(endpoint)
+	}
+	var err error
+	Aws.Session, err = session.NewSession(Aws.Config)
+	if err != nil {
+		// Logs error on Amazon CloudWatch. It's sysadmin's duty to handle it.
+		fmt.Println(fmt.Sprintf("Failed to connect to AWS: %s", err.Error()))
+	} else {
+		var svc *dynamodb.DynamoDB = dynamodb.New(Aws.Session)
+		Aws.DynamoDB = dynamodbiface.DynamoDBAPI(svc)
+	}
+	// Instantiate a global session in TestAws
+	TestAws = Aws
+}
+
+// Preparing DynamoDB Session and Calling DB's GetItem function inside, fetching only the key, the deleted flag and
+// who may see the device. Returns the device if one which is not soft deleted exists with the id, nil otherwise.
+func (self *AmazonWebServices) Exists(id string) (map[string]*dynamodb.AttributeValue, error) {
+	// Get desire table's name from OS's environmental varible.
+	tableName := aws.String(os.Getenv("DEVICES_TABLE_NAME"))
+
+	// Fetching the rest of the device, i.e: its note, only to probe it would be a waste.
+	fields := projection.Keys().With("deleted").With("ownerId").With("allowedGroups")
+	var input = &dynamodb.GetItemInput{
+		TableName: tableName,
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(id),
+			},
+		},
+		ProjectionExpression:     fields.Expression,
+		ExpressionAttributeNames: fields.Names,
+	}
+
+	// Calling either GetItem function of interface, defined in deviceExists_test.go file, or api with the input we've provided.
+	// In real deployment environment, the GetItem function of aws (api.go) will be called.
+	result, err := self.DynamoDB.GetItem(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Item) == 0 {
+		return nil, nil
+	}
+	if deleted := result.Item["deleted"]; deleted != nil && aws.BoolValue(deleted.BOOL) {
+		return nil, nil
+	}
+	return result.Item, nil
+}
+
+// The handler function which will be first started from main function.
+// Responds with an empty body, only the status code tells whether the device exists.
+func DeviceExists(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !gateway.AllowsMethod(request, "GET") {
+		return gateway.MethodNotAllowed("GET"), nil
+	}
+
+	// The id which user has sent through GET method.
+	id := request.PathParameters["id"]
+
+	// If no id have been provided, return HTTP error code 404.
+	if id == "" {
+		return events.APIGatewayProxyResponse{
+			Body:       "Missing field : id",
+			StatusCode: 404,
+		}, nil
+	}
+
+	item, err := TestAws.Exists(id)
+
+	// If an internal error have occurred in the database, return HTTP error code 500.
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:       "Internal Server Error.",
+			StatusCode: 500,
+		}, nil
+	}
+
+	// A device of another tenant doesn't exist for the caller, as in GetDeviceById, unless it's shared with the caller.
+	if item == nil || !owner.Allows(request, storedOwner(item), storedGroups(item)) {
+		return events.APIGatewayProxyResponse{StatusCode: 404}, nil
+	}
+	return events.APIGatewayProxyResponse{StatusCode: 200}, nil
+} // End of DeviceExists function
+
+// Finding the groups which a stored device is shared with, none for the devices which aren't shared.
+func storedGroups(item map[string]*dynamodb.AttributeValue) []string {
+	var groups []string
+	if allowedGroups := item["allowedGroups"]; allowedGroups != nil {
+		dynamodbattribute.Unmarshal(allowedGroups, &groups)
+	}
+	return groups
+}
+
+// Finding the owner of a stored device, empty for the devices created without a caller.
+func storedOwner(item map[string]*dynamodb.AttributeValue) string {
+	if ownerID := item["ownerId"]; ownerID != nil {
+		return aws.StringValue(ownerID.S)
+	}
+	return ""
+}
+
+func main() {
+	lambda.Start(gateway.Adapt(recovery.WithRecover(DeviceExists)))
+}
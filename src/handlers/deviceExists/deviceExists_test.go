@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"strings"
+	"testing"
+)
+
+// Mocking DynamoDB through dynamodbiface.
+type MockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	// Projection of the last GetItem call.
+	ProjectionExpression string
+}
+
+// Custom GetItem function for overriding the GetItem of deviceExists.go for using in test scenarios.
+func (self *MockDynamoDB) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	self.ProjectionExpression = aws.StringValue(input.ProjectionExpression)
+	switch aws.StringValue(input.Key["id"].S) {
+	case "id_test":
+		return &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{"id": {S: aws.String("id_test")}}}, nil
+	case "id_owned":
+		return &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{"id": {S: aws.String("id_owned")}, "ownerId": {S: aws.String("tenant-a")}}}, nil
+	case "id_deleted":
+		return &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{"id": {S: aws.String("id_deleted")}, "deleted": {BOOL: aws.Bool(true)}}}, nil
+	case "id_error":
+		return nil, errors.New("Internal Server Error")
+	}
+	return &dynamodb.GetItemOutput{}, nil
+}
+
+// DeviceExists function in deviceExists.go signature: input: (request events.APIGatewayProxyRequest), output: (events.APIGatewayProxyResponse, error)
+func TestDeviceExists(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	mock := &MockDynamoDB{}
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	testCases := []struct {
+		Name               string
+		ID                 string
+		Caller             string
+		ExpectedStatusCode int
+	}{
+		{Name: "** Testing: Existing device. **", ID: "id_test", ExpectedStatusCode: 200},
+		{Name: "** Testing: Absent device. **", ID: "id_absent", ExpectedStatusCode: 404},
+		{Name: "** Testing: Soft deleted device. **", ID: "id_deleted", ExpectedStatusCode: 404},
+		{Name: "** Testing: Missing id. **", ID: "", ExpectedStatusCode: 404},
+		{Name: "** Testing: Internal database error. **", ID: "id_error", ExpectedStatusCode: 500},
+		{Name: "** Testing: Device of the caller's tenant. **", ID: "id_owned", Caller: "tenant-a", ExpectedStatusCode: 200},
+		{Name: "** Testing: Device of another tenant. **", ID: "id_owned", Caller: "tenant-b", ExpectedStatusCode: 404},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		request := events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": test.ID}}
+		if test.Caller != "" {
+			request.RequestContext.Authorizer = map[string]interface{}{"claims": map[string]interface{}{"sub": test.Caller}}
+		}
+		response, _ := DeviceExists(request)
+		if response.StatusCode != test.ExpectedStatusCode {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d>", test.Name, test.ExpectedStatusCode, response.StatusCode)
+		}
+		if test.ExpectedStatusCode != 500 && test.ID != "" && response.Body != "" {
+			t.Errorf("%s \n \t<expected body: \"\"> <resulted body: %s>", test.Name, response.Body)
+		}
+	}
+
+	// Only the key, the deleted flag and who may see the device are fetched, never the note.
+	if mock.ProjectionExpression != "#id, #deleted, #ownerId, #allowedGroups" || strings.Contains(mock.ProjectionExpression, "note") {
+		t.Errorf("** Testing: Projection of the exists check. ** \n \t<expected the key and the deleted flag> <resulted projection: %s>", mock.ProjectionExpression)
+	}
+} // End of TestDeviceExists function
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"gateway"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"negotiation"
+	"os"
+	"owner"
+	"placeholder"
+	"projection"
+	"recovery"
+	"strconv"
+	"strings"
+	"time"
+	"types"
+)
+
+type AmazonWebServices struct {
+	Config   *aws.Config
+	Session  *session.Session
+	DynamoDB dynamodbiface.DynamoDBAPI
+}
+
+// Prepare a new AWS & DynamoDB session, then configure it.
+var TestAws *AmazonWebServices
+
+// Columns of the CSV export, in the order of the device fields written by writeCSV.
+var csvHeader = []string{"ID", "Name", "DeviceModel", "Serial", "Note"}
+
+func init() {
+	region := os.Getenv("AWS_REGION")
+	var Aws *AmazonWebServices = new(AmazonWebServices)
+	Aws.Config = &aws.Config{Region: aws.String(region)}
+	// Pointing the client to a local DynamoDB, i.e: DynamoDB Local for the integration tests. It's unset in production.
+	if endpoint := os.Getenv("DYNAMODB_ENDPOINT"); endpoint != "" {
+		Aws.Config.Endpoint = aws.String(endpoint)
+	}
+	var err error
+	Aws.Session, err = session.NewSession(Aws.Config)
+	if err != nil {
+		// Logs error on Amazon CloudWatch. It's sysadmin's duty to handle it.
+		fmt.Println(fmt.Sprintf("Failed to connect to AWS: %s", err.Error()))
+	} else {
+		var svc *dynamodb.DynamoDB = dynamodb.New(Aws.Session)
+		Aws.DynamoDB = dynamodbiface.DynamoDBAPI(svc)
+	}
+	// Instantiate a global session in TestAws
+	TestAws = Aws
+}
+
+// Preparing DynamoDB Session and Calling DB's Scan function inside, till the whole table has been read.
+// A scan reads at most 1 MB of the table at a time, so it pages through LastEvaluatedKey.
+// Soft deleted and expired devices are filtered out, same as in ListDevices, and so are the devices of another owner
+// when ownerID is set.
+func (self *AmazonWebServices) ScanAll(ownerID string) ([]types.Device, error) {
+	// Get desire table's name from OS's environmental varible.
+	tableName := aws.String(os.Getenv("DEVICES_TABLE_NAME"))
+
+	names := placeholder.Names{}
+	filter := fmt.Sprintf("(attribute_not_exists(%[1]s) OR %[1]s > :now) AND (attribute_not_exists(%[2]s) OR %[2]s = :false)", names.Of("expiresAt"), names.Of("deleted"))
+	values := map[string]*dynamodb.AttributeValue{
+		":now":   {N: aws.String(strconv.FormatInt(time.Now().Unix(), 10))},
+		":false": {BOOL: aws.Bool(false)},
+	}
+	if ownerID != "" {
+		filter += fmt.Sprintf(" AND %s = :owner", names.Of("ownerId"))
+		values[":owner"] = &dynamodb.AttributeValue{S: aws.String(ownerID)}
+	}
+	var input = &dynamodb.ScanInput{
+		TableName:                 tableName,
+		FilterExpression:          aws.String(filter),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	}
+
+	devices := []types.Device{}
+	for {
+		// Calling either Scan function of interface, defined in exportDevices_test.go file, or api with the input we've provided.
+		// In real deployment environment, the Scan function of aws (api.go) will be called.
+		result, err := self.DynamoDB.Scan(input)
+		if err != nil {
+			return nil, err
+		}
+		var page []types.Device
+		// Deserialization/Decoding the scanned items to Go structs.
+		if err := dynamodbattribute.UnmarshalListOfMaps(result.Items, &page); err != nil {
+			return nil, err
+		}
+		devices = append(devices, page...)
+		// DynamoDB has more items for us only when it returns a LastEvaluatedKey.
+		if len(result.LastEvaluatedKey) == 0 {
+			return devices, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+// The handler function which will be first started from main function.
+// Returns every device as a CSV file for the clients accepting "text/csv", i.e: to open it as a spreadsheet,
+// and as a JSON list otherwise.
+func ExportDevices(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !gateway.AllowsMethod(request, "GET") {
+		return gateway.MethodNotAllowed("GET"), nil
+	}
+
+	// Only the devices of the caller's tenant are exported.
+	devices, err := TestAws.ScanAll(owner.Caller(request))
+
+	// If an internal error have occurred in the database, return HTTP error code 500.
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:       "Internal Server Error.",
+			StatusCode: 500,
+		}, nil
+	}
+
+	if acceptsCSV(headerValue(request.Headers, "Accept")) {
+		return events.APIGatewayProxyResponse{
+			Headers: map[string]string{
+				"Content-Type":        "text/csv",
+				"Content-Disposition": "attachment; filename=\"devices.csv\"",
+			},
+			Body:       writeCSV(devices),
+			StatusCode: 200,
+		}, nil
+	}
+
+	// Serialization/Encoding the devices to JSON.
+	devicesJson, _ := negotiation.Marshal(negotiation.JSON, types.DeviceList{Devices: devices})
+	return events.APIGatewayProxyResponse{
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(devicesJson),
+		StatusCode: 200,
+	}, nil
+} // End of ExportDevices function
+
+// Encoding the devices as CSV with a header row, the csv writer quotes the values having commas, quotes or newlines.
+func writeCSV(devices []types.Device) string {
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	// Only the columns of the fields which RESPONSE_FIELDS allows are written.
+	var header []string
+	var columns []int
+	for i, name := range csvHeader {
+		if projection.Allowed(name) {
+			header = append(header, name)
+			columns = append(columns, i)
+		}
+	}
+	writer.Write(header)
+	for _, device := range devices {
+		values := []string{device.ID, device.Name, device.DeviceModel, device.Serial, device.Note}
+		record := make([]string, 0, len(columns))
+		for _, i := range columns {
+			record = append(record, values[i])
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+	return buffer.String()
+}
+
+// Checking whether one of the media types of an Accept header is "text/csv", ignoring their parameters.
+func acceptsCSV(accept string) bool {
+	for _, mediaType := range strings.Split(accept, ",") {
+		mediaType = strings.TrimSpace(strings.SplitN(mediaType, ";", 2)[0])
+		if strings.EqualFold(mediaType, "text/csv") {
+			return true
+		}
+	}
+	return false
+}
+
+// Finding a header of the request regardless of its case, as clients and proxies may change it.
+func headerValue(headers map[string]string, name string) string {
+	for header, value := range headers {
+		if strings.EqualFold(header, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+func main() {
+	lambda.Start(gateway.Adapt(recovery.WithRecover(ExportDevices)))
+}
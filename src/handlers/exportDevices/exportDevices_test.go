@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"strconv"
+	"testing"
+)
+
+// Mocking DynamoDB through dynamodbiface.
+type MockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	// Pages of items which the mocked Scan returns one after another, and the error instead of them.
+	Pages [][]map[string]*dynamodb.AttributeValue
+	Error error
+	// Inputs of every Scan call.
+	Inputs []*dynamodb.ScanInput
+}
+
+// Custom Scan function for overriding the Scan of exportDevices.go for using in test scenarios.
+// Pages are keyed by their position, every page but the last one returns a LastEvaluatedKey.
+func (self *MockDynamoDB) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	self.Inputs = append(self.Inputs, input)
+	if self.Error != nil {
+		return nil, self.Error
+	}
+	page := 0
+	if input.ExclusiveStartKey != nil {
+		previous, _ := strconv.Atoi(aws.StringValue(input.ExclusiveStartKey["page"].N))
+		page = previous + 1
+	}
+	MockOutput := &dynamodb.ScanOutput{Items: self.Pages[page]}
+	if page < len(self.Pages)-1 {
+		MockOutput.LastEvaluatedKey = map[string]*dynamodb.AttributeValue{"page": {N: aws.String(strconv.Itoa(page))}}
+	}
+	return MockOutput, nil
+}
+
+// ExportDevices function in exportDevices.go signature: input: (request events.APIGatewayProxyRequest), output: (events.APIGatewayProxyResponse, error)
+func TestExportDevices(t *testing.T) {
+	// Two devices on two pages, the note of the second one has to be quoted.
+	TwoDevices := [][]map[string]*dynamodb.AttributeValue{
+		{
+			{
+				"id":           {S: aws.String("id_test1")},
+				"device_model": {S: aws.String("/devicemodels/id1")},
+				"name":         {S: aws.String("Sensor")},
+				"note":         {S: aws.String("Testing a sensor.")},
+				"serial":       {S: aws.String("A020000102")},
+			},
+		},
+		{
+			{
+				"id":           {S: aws.String("id_test2")},
+				"device_model": {S: aws.String("/devicemodels/id2")},
+				"name":         {S: aws.String("Light")},
+				"note":         {S: aws.String("Kitchen, \"main\" light")},
+				"serial":       {S: aws.String("A020000103")},
+			},
+		},
+	}
+
+	testCases := []struct {
+		Name                string
+		Request             events.APIGatewayProxyRequest
+		MockDatabase        *MockDynamoDB
+		ExpectedBody        string
+		ExpectedStatusCode  int
+		ExpectedContentType string
+	}{
+		{
+			Name:                "** Testing: CSV of two devices. **",
+			Request:             events.APIGatewayProxyRequest{Headers: map[string]string{"accept": "text/csv"}},
+			MockDatabase:        &MockDynamoDB{Pages: TwoDevices},
+			ExpectedBody:        "ID,Name,DeviceModel,Serial,Note\nid_test1,Sensor,/devicemodels/id1,A020000102,Testing a sensor.\nid_test2,Light,/devicemodels/id2,A020000103,\"Kitchen, \"\"main\"\" light\"\n",
+			ExpectedStatusCode:  200,
+			ExpectedContentType: "text/csv",
+		},
+
+		{
+			Name:                "** Testing: CSV among other media types. **",
+			Request:             events.APIGatewayProxyRequest{Headers: map[string]string{"Accept": "application/json;q=0.5, text/csv"}},
+			MockDatabase:        &MockDynamoDB{Pages: [][]map[string]*dynamodb.AttributeValue{{}}},
+			ExpectedBody:        "ID,Name,DeviceModel,Serial,Note\n",
+			ExpectedStatusCode:  200,
+			ExpectedContentType: "text/csv",
+		},
+
+		{
+			Name:                "** Testing: JSON by default. **",
+			Request:             events.APIGatewayProxyRequest{},
+			MockDatabase:        &MockDynamoDB{Pages: TwoDevices[:1]},
+			ExpectedBody:        "{\"devices\":[{\"id\":\"id_test1\",\"deviceModel\":\"/devicemodels/id1\",\"name\":\"Sensor\",\"note\":\"Testing a sensor.\",\"serial\":\"A020000102\"}]}",
+			ExpectedStatusCode:  200,
+			ExpectedContentType: "application/json",
+		},
+
+		{
+			Name:               "** Testing: Database error. **",
+			Request:            events.APIGatewayProxyRequest{Headers: map[string]string{"Accept": "text/csv"}},
+			MockDatabase:       &MockDynamoDB{Error: errors.New("mocked database error")},
+			ExpectedBody:       "Internal Server Error.",
+			ExpectedStatusCode: 500,
+		},
+	}
+
+	realAws := TestAws
+	defer func() { TestAws = realAws }()
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		TestAws = &AmazonWebServices{DynamoDB: test.MockDatabase}
+		response, _ := ExportDevices(test.Request)
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody || response.Headers["Content-Type"] != test.ExpectedContentType {
+			t.Errorf("%s \n \t<expected error-code: %d, content-type: %s> <resulted error-code: %d, content-type: %s> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, test.ExpectedContentType, response.StatusCode, response.Headers["Content-Type"], test.ExpectedBody, response.Body)
+		}
+	}
+
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{Pages: TwoDevices}}
+	response, _ := ExportDevices(events.APIGatewayProxyRequest{Headers: map[string]string{"Accept": "text/csv"}})
+	if response.Headers["Content-Disposition"] != "attachment; filename=\"devices.csv\"" {
+		t.Errorf("** Testing: CSV file name. ** \n \t<expected content-disposition: %s> <resulted content-disposition: %s>", "attachment; filename=\"devices.csv\"", response.Headers["Content-Disposition"])
+	}
+} // End of TestExportDevices function
+
+// Behind an authorizer, only the devices of the caller's tenant are exported.
+func TestExportDevicesOwner(t *testing.T) {
+	realAws := TestAws
+	defer func() { TestAws = realAws }()
+	mock := &MockDynamoDB{Pages: [][]map[string]*dynamodb.AttributeValue{{}}}
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+
+	ExportDevices(events.APIGatewayProxyRequest{RequestContext: events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"claims": map[string]interface{}{"sub": "tenant-a"}}}})
+	expected := "(attribute_not_exists(#expiresAt) OR #expiresAt > :now) AND (attribute_not_exists(#deleted) OR #deleted = :false) AND #ownerId = :owner"
+	if expression := aws.StringValue(mock.Inputs[0].FilterExpression); expression != expected || aws.StringValue(mock.Inputs[0].ExpressionAttributeValues[":owner"].S) != "tenant-a" {
+		t.Errorf("** Testing: Filter of the export. ** \n \t<expected filter: %s> <resulted filter: %s>", expected, expression)
+	}
+} // End of TestExportDevicesOwner function
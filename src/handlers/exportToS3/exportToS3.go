@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"gateway"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
+	"os"
+	"owner"
+	"placeholder"
+	"projection"
+	"recovery"
+	"strconv"
+	"time"
+	"types"
+)
+
+type AmazonWebServices struct {
+	Config   *aws.Config
+	Session  *session.Session
+	DynamoDB dynamodbiface.DynamoDBAPI
+	// S3 presigns the links of the exports, which Uploader has uploaded.
+	S3       s3iface.S3API
+	Uploader s3manageriface.UploaderAPI
+}
+
+// Prepare a new AWS & DynamoDB session, then configure it.
+var TestAws *AmazonWebServices
+
+// Columns of the CSV export, in the order of the device fields written by writeCSV.
+var csvHeader = []string{"ID", "Name", "DeviceModel", "Serial", "Note"}
+
+func init() {
+	region := os.Getenv("AWS_REGION")
+	var Aws *AmazonWebServices = new(AmazonWebServices)
+	Aws.Config = &aws.Config{Region: aws.String(region)}
+	// Pointing the client to a local DynamoDB, i.e: DynamoDB Local for the integration tests. It's unset in production.
+	if endpoint := os.Getenv("DYNAMODB_ENDPOINT"); endpoint != "" {
+		Aws.Config.Endpoint = aws.String(endpoint)
+	}
+	var err error
+	Aws.Session, err = session.NewSession(Aws.Config)
+	if err != nil {
+		// Logs error on Amazon CloudWatch. It's sysadmin's duty to handle it.
+		fmt.Println(fmt.Sprintf("Failed to connect to AWS: %s", err.Error()))
+	} else {
+		var svc *dynamodb.DynamoDB = dynamodb.New(Aws.Session)
+		Aws.DynamoDB = dynamodbiface.DynamoDBAPI(svc)
+		// The endpoint override is meant for DynamoDB only, an empty one resolves the regular S3 endpoint.
+		var bucket *s3.S3 = s3.New(Aws.Session, &aws.Config{Endpoint: aws.String("")})
+		Aws.S3 = s3iface.S3API(bucket)
+		Aws.Uploader = s3manager.NewUploaderWithClient(bucket)
+	}
+	// Instantiate a global session in TestAws
+	TestAws = Aws
+}
+
+// Preparing DynamoDB Session and Calling DB's Scan function inside, till the whole table has been read.
+// A scan reads at most 1 MB of the table at a time, so it pages through LastEvaluatedKey.
+// Soft deleted and expired devices are filtered out, same as in ListDevices, and so are the devices of another owner
+// when ownerID is set.
+func (self *AmazonWebServices) ScanAll(ownerID string) ([]types.Device, error) {
+	// Get desire table's name from OS's environmental varible.
+	tableName := aws.String(os.Getenv("DEVICES_TABLE_NAME"))
+
+	names := placeholder.Names{}
+	filter := fmt.Sprintf("(attribute_not_exists(%[1]s) OR %[1]s > :now) AND (attribute_not_exists(%[2]s) OR %[2]s = :false)", names.Of("expiresAt"), names.Of("deleted"))
+	values := map[string]*dynamodb.AttributeValue{
+		":now":   {N: aws.String(strconv.FormatInt(time.Now().Unix(), 10))},
+		":false": {BOOL: aws.Bool(false)},
+	}
+	if ownerID != "" {
+		filter += fmt.Sprintf(" AND %s = :owner", names.Of("ownerId"))
+		values[":owner"] = &dynamodb.AttributeValue{S: aws.String(ownerID)}
+	}
+	var input = &dynamodb.ScanInput{
+		TableName:                 tableName,
+		FilterExpression:          aws.String(filter),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	}
+
+	devices := []types.Device{}
+	for {
+		// Calling either Scan function of interface, defined in exportToS3_test.go file, or api with the input we've provided.
+		// In real deployment environment, the Scan function of aws (api.go) will be called.
+		result, err := self.DynamoDB.Scan(input)
+		if err != nil {
+			return nil, err
+		}
+		var page []types.Device
+		// Deserialization/Decoding the scanned items to Go structs.
+		if err := dynamodbattribute.UnmarshalListOfMaps(result.Items, &page); err != nil {
+			return nil, err
+		}
+		devices = append(devices, page...)
+		// DynamoDB has more items for us only when it returns a LastEvaluatedKey.
+		if len(result.LastEvaluatedKey) == 0 {
+			return devices, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+// Uploading the CSV of an export to key of the bucket, then presigning a GET of it which is valid for ttl.
+// The uploader splits a large export into a multipart upload on its own.
+func (self *AmazonWebServices) UploadExport(bucket string, key string, export []byte, ttl time.Duration) (string, error) {
+	// Calling either Upload function of interface, defined in exportToS3_test.go file, or api with the input we've provided.
+	_, err := self.Uploader.Upload(&s3manager.UploadInput{
+		Bucket:             aws.String(bucket),
+		Key:                aws.String(key),
+		Body:               bytes.NewReader(export),
+		ContentType:        aws.String("text/csv"),
+		ContentDisposition: aws.String("attachment; filename=\"devices.csv\""),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	request, _ := self.S3.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	return request.Presign(ttl)
+}
+
+// The handler function which will be first started from main function.
+// Writes every device as a CSV file to the bucket named by EXPORT_BUCKET_NAME and returns a presigned link to it,
+// since an inventory too large for a response of ExportDevices can still be downloaded from S3.
+func ExportToS3(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !gateway.AllowsMethod(request, "POST") {
+		return gateway.MethodNotAllowed("POST"), nil
+	}
+
+	bucket := os.Getenv("EXPORT_BUCKET_NAME")
+	if bucket == "" {
+		// Logs error on Amazon CloudWatch. It's sysadmin's duty to handle it.
+		fmt.Println("Failed to export the devices: EXPORT_BUCKET_NAME is not set")
+		return events.APIGatewayProxyResponse{
+			Body:       "Internal Server Error.",
+			StatusCode: 500,
+		}, nil
+	}
+
+	// Only the devices of the caller's tenant are exported, same as in ExportDevices.
+	devices, err := TestAws.ScanAll(owner.Caller(request))
+
+	// If an internal error have occurred in the database, return HTTP error code 500.
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:       "Internal Server Error.",
+			StatusCode: 500,
+		}, nil
+	}
+
+	// Every export gets its own key, so a link handed out earlier keeps pointing to the same devices.
+	now := time.Now().UTC()
+	key := fmt.Sprintf("exports/devices-%s.csv", now.Format("20060102T150405.000000000Z"))
+	ttl := exportURLTTL()
+	url, err := TestAws.UploadExport(bucket, key, writeCSV(devices), ttl)
+
+	// If an internal error have occurred in S3, return HTTP error code 500.
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Failed to upload the export: %s", err.Error()))
+		return events.APIGatewayProxyResponse{
+			Body:       "Internal Server Error.",
+			StatusCode: 500,
+		}, nil
+	}
+
+	// Serialization/Encoding the link to JSON.
+	linkJson, _ := json.Marshal(types.ExportLink{URL: url, ExpiresAt: now.Add(ttl).Format(time.RFC3339)})
+	return events.APIGatewayProxyResponse{
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(linkJson),
+		StatusCode: 200,
+	}, nil
+} // End of ExportToS3 function
+
+// Encoding the devices as CSV with a header row, the csv writer quotes the values having commas, quotes or newlines.
+func writeCSV(devices []types.Device) []byte {
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	// Only the columns of the fields which RESPONSE_FIELDS allows are written.
+	var header []string
+	var columns []int
+	for i, name := range csvHeader {
+		if projection.Allowed(name) {
+			header = append(header, name)
+			columns = append(columns, i)
+		}
+	}
+	writer.Write(header)
+	for _, device := range devices {
+		values := []string{device.ID, device.Name, device.DeviceModel, device.Serial, device.Note}
+		record := make([]string, 0, len(columns))
+		for _, i := range columns {
+			record = append(record, values[i])
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+	return buffer.Bytes()
+}
+
+// How long the link of an export is valid, taken from OS's environment in seconds (EXPORT_URL_TTL_SECONDS) and
+// defaulting to an hour. S3 doesn't accept a presigned link valid for longer than a week.
+func exportURLTTL() time.Duration {
+	seconds, err := strconv.Atoi(os.Getenv("EXPORT_URL_TTL_SECONDS"))
+	if err != nil || seconds <= 0 {
+		seconds = 60 * 60
+	}
+	if seconds > 7*24*60*60 {
+		seconds = 7 * 24 * 60 * 60
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func main() {
+	lambda.Start(gateway.Adapt(recovery.WithRecover(ExportToS3)))
+}
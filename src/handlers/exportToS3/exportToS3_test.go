@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"types"
+)
+
+// Mocking DynamoDB through dynamodbiface.
+type MockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	// Inputs of every Scan call.
+	Inputs []*dynamodb.ScanInput
+}
+
+// Custom Scan function for overriding the Scan of exportToS3.go for using in test scenarios.
+func (self *MockDynamoDB) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	self.Inputs = append(self.Inputs, input)
+	return &dynamodb.ScanOutput{Items: []map[string]*dynamodb.AttributeValue{
+		{
+			"id":           {S: aws.String("id_test")},
+			"device_model": {S: aws.String("/devicemodels/id1")},
+			"name":         {S: aws.String("Sensor")},
+			"note":         {S: aws.String("Testing a sensor, in the kitchen.")},
+			"serial":       {S: aws.String("A020000102")},
+		},
+	}}, nil
+}
+
+// Mocking the S3 uploader through s3manageriface, keeping what has been uploaded.
+type MockUploader struct {
+	s3manageriface.UploaderAPI
+	Input *s3manager.UploadInput
+	Body  string
+	Error error
+}
+
+// Custom Upload function for overriding the Upload of exportToS3.go for using in test scenarios.
+func (self *MockUploader) Upload(input *s3manager.UploadInput, options ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+	self.Input = input
+	body, _ := ioutil.ReadAll(input.Body)
+	self.Body = string(body)
+	if self.Error != nil {
+		return nil, self.Error
+	}
+	return &s3manager.UploadOutput{}, nil
+}
+
+// A real S3 client with static credentials, presigning is done locally without calling S3.
+func testS3() *s3.S3 {
+	return s3.New(session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-2"),
+		Credentials: credentials.NewStaticCredentials("AKID", "SECRET", ""),
+	})))
+}
+
+// ExportToS3 function in exportToS3.go signature: input: (request events.APIGatewayProxyRequest), output: (events.APIGatewayProxyResponse, error)
+func TestExportToS3(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	uploader := &MockUploader{}
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}, S3: testS3(), Uploader: uploader}
+	os.Setenv("EXPORT_BUCKET_NAME", "exports-test")
+	os.Setenv("EXPORT_URL_TTL_SECONDS", "900")
+	defer func() {
+		TestAws = realAws
+		os.Unsetenv("EXPORT_BUCKET_NAME")
+		os.Unsetenv("EXPORT_URL_TTL_SECONDS")
+	}()
+
+	response, _ := ExportToS3(events.APIGatewayProxyRequest{})
+	if response.StatusCode != 200 {
+		t.Fatalf("** Testing: Export uploaded to S3. ** \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", 200, response.StatusCode, response.Body)
+	}
+
+	// The CSV of the devices is uploaded to the bucket.
+	expectedCSV := "ID,Name,DeviceModel,Serial,Note\nid_test,Sensor,/devicemodels/id1,A020000102,\"Testing a sensor, in the kitchen.\"\n"
+	if uploader.Input == nil || aws.StringValue(uploader.Input.Bucket) != "exports-test" || uploader.Body != expectedCSV {
+		t.Errorf("** Testing: Uploaded export. ** \n \t<expected body: %s> <resulted body: %s>", expectedCSV, uploader.Body)
+	}
+
+	// The link is a GET of the uploaded key, presigned for the configured 15 minutes.
+	link := types.ExportLink{}
+	json.Unmarshal([]byte(response.Body), &link)
+	if !strings.Contains(link.URL, "exports-test") || !strings.Contains(link.URL, aws.StringValue(uploader.Input.Key)) || !strings.Contains(link.URL, "X-Amz-Expires=900") || link.ExpiresAt == "" {
+		t.Errorf("** Testing: Presigned link. ** \n \t<resulted body: %s>", response.Body)
+	}
+} // End of TestExportToS3 function
+
+// S3 and configuration errors are answered with HTTP 500.
+func TestExportToS3Errors(t *testing.T) {
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}, S3: testS3(), Uploader: &MockUploader{Error: errors.New("access denied")}}
+	defer func() { TestAws = realAws }()
+
+	response, _ := ExportToS3(events.APIGatewayProxyRequest{})
+	if response.StatusCode != 500 {
+		t.Errorf("** Testing: Missing bucket. ** \n \t<expected error-code: %d> <resulted error-code: %d>", 500, response.StatusCode)
+	}
+
+	os.Setenv("EXPORT_BUCKET_NAME", "exports-test")
+	defer os.Unsetenv("EXPORT_BUCKET_NAME")
+	response, _ = ExportToS3(events.APIGatewayProxyRequest{})
+	if response.StatusCode != 500 {
+		t.Errorf("** Testing: S3 Unexpected Error. ** \n \t<expected error-code: %d> <resulted error-code: %d>", 500, response.StatusCode)
+	}
+} // End of TestExportToS3Errors function
+
+// Behind an authorizer, only the devices of the caller's tenant are exported.
+func TestExportToS3Owner(t *testing.T) {
+	realAws := TestAws
+	mock := &MockDynamoDB{}
+	TestAws = &AmazonWebServices{DynamoDB: mock, S3: testS3(), Uploader: &MockUploader{}}
+	os.Setenv("EXPORT_BUCKET_NAME", "exports-test")
+	defer func() {
+		TestAws = realAws
+		os.Unsetenv("EXPORT_BUCKET_NAME")
+	}()
+
+	ExportToS3(events.APIGatewayProxyRequest{RequestContext: events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"claims": map[string]interface{}{"sub": "tenant-a"}}}})
+	expected := "(attribute_not_exists(#expiresAt) OR #expiresAt > :now) AND (attribute_not_exists(#deleted) OR #deleted = :false) AND #ownerId = :owner"
+	if len(mock.Inputs) == 0 {
+		t.Fatalf("** Testing: Filter of the export. ** \n \t<expected filter: %s> <resulted filter: none>", expected)
+	}
+	if expression := aws.StringValue(mock.Inputs[0].FilterExpression); expression != expected || aws.StringValue(mock.Inputs[0].ExpressionAttributeValues[":owner"].S) != "tenant-a" {
+		t.Errorf("** Testing: Filter of the export. ** \n \t<expected filter: %s> <resulted filter: %s>", expected, expression)
+	}
+} // End of TestExportToS3Owner function
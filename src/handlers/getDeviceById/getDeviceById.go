@@ -2,7 +2,9 @@ package main
 
 import (
 	"encoding/json"
+	"etag"
 	"fmt"
+	"gateway"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
@@ -10,7 +12,15 @@ import (
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"negotiation"
 	"os"
+	"owner"
+	"placeholder"
+	"projection"
+	"recovery"
+	"strconv"
+	"strings"
+	"time"
 	"types"
 )
 
@@ -23,10 +33,17 @@ type AmazonWebServices struct {
 // Prepare a new AWS & DynamoDB session, then configure it.
 var TestAws *AmazonWebServices
 
+// Pages of the table which a case insensitive lookup scans at most, each of them up to 1 MB.
+const caseInsensitiveScanPages = 5
+
 func init() {
 	region := os.Getenv("AWS_REGION")
 	var Aws *AmazonWebServices = new(AmazonWebServices)
 	Aws.Config = &aws.Config{Region: aws.String(region)}
+	// Pointing the client to a local DynamoDB, i.e: DynamoDB Local for the integration tests. It's unset in production.
+	if endpoint := os.Getenv("DYNAMODB_ENDPOINT"); endpoint != "" {
+		Aws.Config.Endpoint = aws.String(endpoint)
+	}
 	var err error
 	Aws.Session, err = session.NewSession(Aws.Config)
 	if err != nil {
@@ -41,7 +58,9 @@ func init() {
 }
 
 // Preparing DynamoDB Session and Calling DB's GetItem function inside.
-func (self *AmazonWebServices) Get(id string) (*dynamodb.GetItemOutput, error) {
+// A non nil projection fetches only its attributes of the device. A consistent read costs twice the RCUs,
+// but always sees the latest write.
+func (self *AmazonWebServices) Get(id string, fields *projection.Projection, consistent bool) (*dynamodb.GetItemOutput, error) {
 	// Get desire table's name from OS's environmental varible.
 	tableName := aws.String(os.Getenv("DEVICES_TABLE_NAME"))
 
@@ -53,6 +72,11 @@ func (self *AmazonWebServices) Get(id string) (*dynamodb.GetItemOutput, error) {
 				S: aws.String(id),
 			},
 		},
+		ConsistentRead: aws.Bool(consistent),
+	}
+	if fields != nil {
+		input.ProjectionExpression = fields.Expression
+		input.ExpressionAttributeNames = fields.Names
 	}
 
 	// Calling either GetItem function of interface, defined in getDeviceById_test.go file, or api with the input we've provided.
@@ -62,8 +86,47 @@ func (self *AmazonWebServices) Get(id string) (*dynamodb.GetItemOutput, error) {
 	return result, err
 }
 
+// Preparing DynamoDB Session and Calling DB's Scan function inside, to find the stored id which equals the given one
+// regardless of its case. DynamoDB can't compare lowercased keys, so the ids of the table are read page by page and
+// compared here: it costs the RCUs of scanning the table and gets slow as the table grows, unlike the single read of Get.
+// So it's only run as an opt-in fallback after Get has missed, and it gives up after caseInsensitiveScanPages pages.
+// Returns an empty id when no device matches within them.
+func (self *AmazonWebServices) FindID(id string) (string, error) {
+	// Get desire table's name from OS's environmental varible.
+	tableName := aws.String(os.Getenv("DEVICES_TABLE_NAME"))
+
+	names := placeholder.Names{}
+	var input = &dynamodb.ScanInput{
+		TableName:                tableName,
+		ProjectionExpression:     aws.String(names.Of("id")),
+		ExpressionAttributeNames: names,
+	}
+	for page := 0; page < caseInsensitiveScanPages; page++ {
+		// Calling either Scan function of interface, defined in getDeviceById_test.go file, or api with the input we've provided.
+		// In real deployment environment, the Scan function of aws (api.go) will be called.
+		result, err := self.DynamoDB.Scan(input)
+		if err != nil {
+			return "", err
+		}
+		for _, item := range result.Items {
+			if storedID := aws.StringValue(item["id"].S); strings.ToLower(storedID) == strings.ToLower(id) {
+				return storedID, nil
+			}
+		}
+		if len(result.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+	return "", nil
+}
+
 // The handler function which will be first started from main function.
 func GetDeviceById(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !gateway.AllowsMethod(request, "GET") {
+		return gateway.MethodNotAllowed("GET"), nil
+	}
+
 	// The id which user has sent through GET method.
 	id := request.PathParameters["id"]
 
@@ -75,18 +138,184 @@ func GetDeviceById(request events.APIGatewayProxyRequest) (events.APIGatewayProx
 		}, nil
 	}
 
+	// The fields which user wants back, i.e: "fields=ID,Name", the whole device by default.
+	fields, err := projection.Parse(request.QueryStringParameters["fields"])
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:       err.Error(),
+			StatusCode: 400,
+		}, nil
+	}
+
+	// Eventually consistent by default, "consistent=true" right after a write makes sure it's seen.
+	consistent, err := boolParameter(request.QueryStringParameters, "consistent")
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:       err.Error(),
+			StatusCode: 400,
+		}, nil
+	}
+
+	// Soft deleted devices are hidden, unless "includeDeleted=true" is asked for.
+	includeDeleted, err := boolParameter(request.QueryStringParameters, "includeDeleted")
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:       err.Error(),
+			StatusCode: 400,
+		}, nil
+	}
+	// An id which differs only in case, i.e: typed by hand, is looked up with "caseInsensitive=true" at the cost of a scan.
+	caseInsensitive, err := boolParameter(request.QueryStringParameters, "caseInsensitive")
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:       err.Error(),
+			StatusCode: 400,
+		}, nil
+	}
+	// The deleted flag has to be fetched to hide a deleted device, even if user has not asked for it.
+	// And so have the version, as the ETag of the device is made of it, the expiry of a temporary device, its owner and
+	// the groups which it's shared with.
+	fetchedFields := fields
+	if fields != nil && !includeDeleted {
+		fetchedFields = fields.With("deleted")
+	}
+	if fields != nil {
+		fetchedFields = fetchedFields.With("version").With("expiresAt").With("ownerId").With("allowedGroups")
+	}
+
 	// Till now the user have provided an id in string type.
 	// Let's see whether it's existed on DB or not.
-	result, err := TestAws.Get(id)
+	result, err := TestAws.Get(id, fetchedFields, consistent)
+	if err == nil && len(result.Item) == 0 && caseInsensitive {
+		var storedID string
+		storedID, err = TestAws.FindID(id)
+		if err == nil && storedID != "" {
+			result, err = TestAws.Get(storedID, fetchedFields, consistent)
+		}
+	}
+	if err == nil && !includeDeleted {
+		if deleted := result.Item["deleted"]; deleted != nil && aws.BoolValue(deleted.BOOL) {
+			result = &dynamodb.GetItemOutput{}
+		}
+		if fields != nil && !fields.Has("deleted") {
+			delete(result.Item, "deleted")
+		}
+	}
+	var version int
+	if err == nil {
+		// DynamoDB purges expired items only within a few days, till then they are reported as missing.
+		if expiresAt := result.Item["expiresAt"]; expiresAt != nil && expired(aws.StringValue(expiresAt.N)) {
+			result = &dynamodb.GetItemOutput{}
+		}
+		if fields != nil && !fields.Has("expiresAt") {
+			delete(result.Item, "expiresAt")
+		}
+		// A device of another tenant is reported as missing, so its existence isn't leaked either, unless it's shared
+		// with one of the groups of the caller.
+		if !owner.Allows(request, storedOwner(result.Item), storedGroups(result.Item)) {
+			result = &dynamodb.GetItemOutput{}
+		}
+		if fields != nil && !fields.Has("ownerId") {
+			delete(result.Item, "ownerId")
+		}
+		if fields != nil && !fields.Has("allowedGroups") {
+			delete(result.Item, "allowedGroups")
+		}
+		if stored := result.Item["version"]; stored != nil {
+			version, _ = strconv.Atoi(aws.StringValue(stored.N))
+		}
+		if fields != nil && !fields.Has("version") {
+			delete(result.Item, "version")
+		}
+	}
 
 	// Checking the result of the DynamoDB query.
-	ValidationResult := ValidateDatabaseResult(result, err)
+	ValidationResult := ValidateDatabaseResult(result, err, fields)
+
+	// A found device may be cached by the client for CACHE_MAX_AGE seconds, but not by shared caches as it's the caller's.
+	if ValidationResult.StatusCode == 200 {
+		if ValidationResult.Headers == nil {
+			ValidationResult.Headers = map[string]string{}
+		}
+		ValidationResult.Headers["Cache-Control"] = fmt.Sprintf("private, max-age=%d", cacheMaxAge())
+	}
+
+	// The ETag lets the client update the device conditionally, through the If-Match header of UpdateDevice.
+	// It also revalidates a cached device: an If-None-Match of the current ETag returns HTTP 304 without a body.
+	// A device stored before versioning is at version 0, "0" being its ETag.
+	if ValidationResult.StatusCode == 200 {
+		ValidationResult.Headers["ETag"] = etag.Format(version)
+		if etag.Matches(headerValue(request.Headers, "If-None-Match"), version) {
+			return events.APIGatewayProxyResponse{
+				Headers: map[string]string{
+					"Cache-Control": ValidationResult.Headers["Cache-Control"],
+					"ETag":          ValidationResult.Headers["ETag"],
+				},
+				StatusCode: 304,
+			}, nil
+		}
+	}
 
 	// Return the result in ...
 	return ValidationResult, nil
 } // End of GetDeviceById function
 
-func ValidateDatabaseResult(result *dynamodb.GetItemOutput, err error) events.APIGatewayProxyResponse {
+// Finding the owner of a stored device, empty for the devices created without a caller.
+func storedOwner(item map[string]*dynamodb.AttributeValue) string {
+	if ownerID := item["ownerId"]; ownerID != nil {
+		return aws.StringValue(ownerID.S)
+	}
+	return ""
+}
+
+// Finding the groups which a stored device is shared with, none for the devices which aren't shared.
+func storedGroups(item map[string]*dynamodb.AttributeValue) []string {
+	var groups []string
+	if allowedGroups := item["allowedGroups"]; allowedGroups != nil {
+		dynamodbattribute.Unmarshal(allowedGroups, &groups)
+	}
+	return groups
+}
+
+// Checking whether the TTL attribute of a device, in unix epoch seconds, has passed.
+func expired(expiresAt string) bool {
+	seconds, err := strconv.ParseInt(expiresAt, 10, 64)
+	return err == nil && seconds > 0 && seconds <= time.Now().Unix()
+}
+
+// Seconds which a client may cache a device for, taken from OS's environment (CACHE_MAX_AGE) and defaulting to 60.
+func cacheMaxAge() int {
+	maxAge, err := strconv.Atoi(os.Getenv("CACHE_MAX_AGE"))
+	if err != nil || maxAge < 0 {
+		return 60
+	}
+	return maxAge
+}
+
+// Finding a header of the request regardless of its case, as clients and proxies may change it.
+func headerValue(headers map[string]string, name string) string {
+	for header, value := range headers {
+		if strings.EqualFold(header, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// Parsing an optional boolean query parameter, false when it's missing.
+func boolParameter(query map[string]string, name string) (bool, error) {
+	raw, ok := query[name]
+	if !ok {
+		return false, nil
+	}
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("Invalid parameter: %s must be a boolean.", name)
+	}
+	return value, nil
+}
+
+func ValidateDatabaseResult(result *dynamodb.GetItemOutput, err error, fields *projection.Projection) events.APIGatewayProxyResponse {
 
 	// If an internal error have occurred in the database, return HTTP error code 500.
 	if err != nil {
@@ -98,12 +327,24 @@ func ValidateDatabaseResult(result *dynamodb.GetItemOutput, err error) events.AP
 
 	// If no item have been founded, return HTTP error code 404.
 	if len(result.Item) == 0 {
+		NotFoundJson, _ := json.Marshal(types.ErrorResponse{Message: "Device not found"})
 		return events.APIGatewayProxyResponse{
-			Body:       string("Desired device not found."),
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       string(NotFoundJson),
 			StatusCode: 404,
 		}
 	}
 
+	// Only the projected attributes have been fetched, return just them instead of a device with empty fields.
+	if fields != nil {
+		partial, _ := projection.Decode(result.Item)
+		PartialDeviceJson, _ := negotiation.Marshal(negotiation.JSON, partial)
+		return events.APIGatewayProxyResponse{
+			Body:       string(PartialDeviceJson),
+			StatusCode: 200,
+		}
+	}
+
 	// Till now the input id have been founded.
 	// Let's convert this founded "result.item" from DB which is in DynamoDB type to Go struct.
 	item := types.Device{}
@@ -111,7 +352,7 @@ func ValidateDatabaseResult(result *dynamodb.GetItemOutput, err error) events.AP
 	dynamodbattribute.UnmarshalMap(result.Item, &item)
 
 	// Serialization/Encoding item to JSON.
-	FoundedDeviceJson, _ := json.Marshal(item)
+	FoundedDeviceJson, _ := negotiation.Marshal(negotiation.JSON, item)
 
 	// Return founded item as JSON type with 200 HTTP status code.
 	return events.APIGatewayProxyResponse{
@@ -121,5 +362,5 @@ func ValidateDatabaseResult(result *dynamodb.GetItemOutput, err error) events.AP
 } // End of ValidateDatabaseResult function
 
 func main() {
-	lambda.Start(GetDeviceById)
+	lambda.Start(gateway.Adapt(recovery.WithRecover(GetDeviceById)))
 }
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
+	"gateway"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"strings"
 	"testing"
 )
 
@@ -24,27 +27,140 @@ type TestCase struct {
 type MockDynamoDB struct {
 	dynamodbiface.DynamoDBAPI
 	// Other return values expected to store, i.e: "payload map[string]string" or "err error"
+	// ConsistentRead of the last GetItem input.
+	ConsistentRead bool
+	// Ids which each page of the mocked Scan returns, the last page is repeated forever if Endless, and the number of Scan calls.
+	Pages   [][]string
+	Endless bool
+	Scans   int
+}
+
+// Custom Scan function for overriding the Scan of getDeviceById.go for using in test scenarios.
+// Mocking the pages of ids read by a case insensitive lookup.
+func (self *MockDynamoDB) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	page := self.Scans
+	self.Scans++
+	if page >= len(self.Pages) {
+		page = len(self.Pages) - 1
+	}
+	output := new(dynamodb.ScanOutput)
+	for _, id := range self.Pages[page] {
+		output.Items = append(output.Items, map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}})
+	}
+	if self.Endless || self.Scans < len(self.Pages) {
+		output.LastEvaluatedKey = map[string]*dynamodb.AttributeValue{"id": {S: aws.String("page")}}
+	}
+	return output, nil
 }
 
 // Custom GetItem function for overriding the GetItem of getDeviceById.go for using in test scenarios.
-// Mocking GetItem output to the a desire valid response.
+// Mocking GetItem output to the a desire valid response, keeping only the attributes of a projection.
 func (self *MockDynamoDB) GetItem(input *dynamodb.GetItemInput) (output *dynamodb.GetItemOutput, err error) {
 	mockOutput := new(dynamodb.GetItemOutput)
 	inputID := input.Key["id"].S
+	self.ConsistentRead = aws.BoolValue(input.ConsistentRead)
 
 	// Checking whether the test case id input is equal to the mocked DB's id value or not.
 	if *inputID == "id_test" {
 		mockOutput.SetItem(
 			// Setting mocked values.
 			map[string]*dynamodb.AttributeValue{
-				"id":          &dynamodb.AttributeValue{S: aws.String("id_test")},
-				"deviceModel": &dynamodb.AttributeValue{S: aws.String("deviceModel_test")},
+				"id":           &dynamodb.AttributeValue{S: aws.String("id_test")},
+				"device_model": &dynamodb.AttributeValue{S: aws.String("deviceModel_test")},
+				"name":         &dynamodb.AttributeValue{S: aws.String("name_test")},
+				"note":         &dynamodb.AttributeValue{S: aws.String("note_test")},
+				"serial":       &dynamodb.AttributeValue{S: aws.String("serial_test")},
+			},
+		)
+	}
+	// A soft deleted device.
+	if *inputID == "id_deleted" {
+		mockOutput.SetItem(
+			map[string]*dynamodb.AttributeValue{
+				"id":           &dynamodb.AttributeValue{S: aws.String("id_deleted")},
+				"device_model": &dynamodb.AttributeValue{S: aws.String("deviceModel_test")},
+				"name":         &dynamodb.AttributeValue{S: aws.String("name_test")},
+				"note":         &dynamodb.AttributeValue{S: aws.String("note_test")},
+				"serial":       &dynamodb.AttributeValue{S: aws.String("serial_test")},
+				"deleted":      &dynamodb.AttributeValue{BOOL: aws.Bool(true)},
+				"deletedAt":    &dynamodb.AttributeValue{S: aws.String("2018-11-02T10:04:05Z")},
+			},
+		)
+	}
+	// A temporary device whose TTL has passed, not purged by DynamoDB yet.
+	if *inputID == "id_expired" {
+		mockOutput.SetItem(
+			map[string]*dynamodb.AttributeValue{
+				"id":           &dynamodb.AttributeValue{S: aws.String("id_expired")},
+				"device_model": &dynamodb.AttributeValue{S: aws.String("deviceModel_test")},
+				"name":         &dynamodb.AttributeValue{S: aws.String("name_test")},
+				"note":         &dynamodb.AttributeValue{S: aws.String("note_test")},
+				"serial":       &dynamodb.AttributeValue{S: aws.String("serial_test")},
+				"expiresAt":    &dynamodb.AttributeValue{N: aws.String("1541153045")},
+			},
+		)
+	}
+	// A device of the "tenant-a" tenant.
+	if *inputID == "id_owned" {
+		mockOutput.SetItem(
+			map[string]*dynamodb.AttributeValue{
+				"id":           &dynamodb.AttributeValue{S: aws.String("id_owned")},
+				"device_model": &dynamodb.AttributeValue{S: aws.String("deviceModel_test")},
+				"name":         &dynamodb.AttributeValue{S: aws.String("name_test")},
+				"note":         &dynamodb.AttributeValue{S: aws.String("note_test")},
+				"serial":       &dynamodb.AttributeValue{S: aws.String("serial_test")},
+				"ownerId":      &dynamodb.AttributeValue{S: aws.String("tenant-a")},
+			},
+		)
+	}
+	// A device of the "tenant-a" tenant, shared with the "support" group.
+	if *inputID == "id_shared" {
+		mockOutput.SetItem(
+			map[string]*dynamodb.AttributeValue{
+				"id":            &dynamodb.AttributeValue{S: aws.String("id_shared")},
+				"device_model":  &dynamodb.AttributeValue{S: aws.String("deviceModel_test")},
+				"name":          &dynamodb.AttributeValue{S: aws.String("name_test")},
+				"note":          &dynamodb.AttributeValue{S: aws.String("note_test")},
+				"serial":        &dynamodb.AttributeValue{S: aws.String("serial_test")},
+				"ownerId":       &dynamodb.AttributeValue{S: aws.String("tenant-a")},
+				"allowedGroups": &dynamodb.AttributeValue{L: []*dynamodb.AttributeValue{{S: aws.String("support")}}},
+			},
+		)
+	}
+	// A device which has been updated twice.
+	if *inputID == "id_versioned" {
+		mockOutput.SetItem(
+			map[string]*dynamodb.AttributeValue{
+				"id":           &dynamodb.AttributeValue{S: aws.String("id_versioned")},
+				"device_model": &dynamodb.AttributeValue{S: aws.String("deviceModel_test")},
+				"name":         &dynamodb.AttributeValue{S: aws.String("name_test")},
+				"note":         &dynamodb.AttributeValue{S: aws.String("note_test")},
+				"serial":       &dynamodb.AttributeValue{S: aws.String("serial_test")},
+				"version":      &dynamodb.AttributeValue{N: aws.String("3")},
+			},
+		)
+	}
+	// A device written before its model was stored as "device_model", not migrated yet.
+	if *inputID == "id_legacy" {
+		mockOutput.SetItem(
+			map[string]*dynamodb.AttributeValue{
+				"id":          &dynamodb.AttributeValue{S: aws.String("id_legacy")},
+				"deviceModel": &dynamodb.AttributeValue{S: aws.String("deviceModel_legacy")},
 				"name":        &dynamodb.AttributeValue{S: aws.String("name_test")},
 				"note":        &dynamodb.AttributeValue{S: aws.String("note_test")},
 				"serial":      &dynamodb.AttributeValue{S: aws.String("serial_test")},
 			},
 		)
 	}
+	if input.ProjectionExpression != nil {
+		projected := map[string]*dynamodb.AttributeValue{}
+		for _, attribute := range input.ExpressionAttributeNames {
+			if value, ok := mockOutput.Item[*attribute]; ok {
+				projected[*attribute] = value
+			}
+		}
+		mockOutput.Item = projected
+	}
 	return mockOutput, err
 }
 
@@ -57,11 +173,11 @@ func TestGet(t *testing.T) {
 	// Setting mock items values
 	MockOutput.SetItem(
 		map[string]*dynamodb.AttributeValue{
-			"id":          &dynamodb.AttributeValue{S: aws.String("id_test")},
-			"deviceModel": &dynamodb.AttributeValue{S: aws.String("deviceModel_test")},
-			"name":        &dynamodb.AttributeValue{S: aws.String("name_test")},
-			"note":        &dynamodb.AttributeValue{S: aws.String("note_test")},
-			"serial":      &dynamodb.AttributeValue{S: aws.String("serial_test")},
+			"id":           &dynamodb.AttributeValue{S: aws.String("id_test")},
+			"device_model": &dynamodb.AttributeValue{S: aws.String("deviceModel_test")},
+			"name":         &dynamodb.AttributeValue{S: aws.String("name_test")},
+			"note":         &dynamodb.AttributeValue{S: aws.String("note_test")},
+			"serial":       &dynamodb.AttributeValue{S: aws.String("serial_test")},
 		},
 	)
 
@@ -86,7 +202,7 @@ func TestGet(t *testing.T) {
 
 	for _, test := range TestCases {
 		// Executing each test cases scenario.
-		response, _ := test_aws.Get(test.Input)
+		response, _ := test_aws.Get(test.Input, nil, false)
 		if len(response.GoString()) != len(test.ExpectedDatabaseOutput.GoString()) {
 			t.Errorf("%s \n \t<expected output: \n%s> \n<resulted output: \n%s>", test.Name, test.ExpectedDatabaseOutput.GoString(), response.GoString())
 		}
@@ -141,11 +257,11 @@ func TestValidateDatabaseResult(t *testing.T) {
 	// Setting mock items values
 	MockOutput.SetItem(
 		map[string]*dynamodb.AttributeValue{
-			"id":          &dynamodb.AttributeValue{S: aws.String("id_test")},
-			"deviceModel": &dynamodb.AttributeValue{S: aws.String("deviceModel_test")},
-			"name":        &dynamodb.AttributeValue{S: aws.String("name_test")},
-			"note":        &dynamodb.AttributeValue{S: aws.String("note_test")},
-			"serial":      &dynamodb.AttributeValue{S: aws.String("serial_test")},
+			"id":           &dynamodb.AttributeValue{S: aws.String("id_test")},
+			"device_model": &dynamodb.AttributeValue{S: aws.String("deviceModel_test")},
+			"name":         &dynamodb.AttributeValue{S: aws.String("name_test")},
+			"note":         &dynamodb.AttributeValue{S: aws.String("note_test")},
+			"serial":       &dynamodb.AttributeValue{S: aws.String("serial_test")},
 		},
 	)
 
@@ -164,7 +280,7 @@ func TestValidateDatabaseResult(t *testing.T) {
 			Name:               "** Database Returns Empty Result **",
 			Request:            events.APIGatewayProxyRequest{},
 			MockDatabaseOutput: EmptyOutput,
-			ExpectedBody:       "Desired device not found.",
+			ExpectedBody:       "{\"message\":\"Device not found\"}",
 			ExpectedStatusCode: 404,
 		},
 
@@ -178,10 +294,576 @@ func TestValidateDatabaseResult(t *testing.T) {
 
 	for _, test := range TestCases {
 		// Executing each test cases scenario.
-		response := ValidateDatabaseResult(&test.MockDatabaseOutput, test.Error)
+		response := ValidateDatabaseResult(&test.MockDatabaseOutput, test.Error, nil)
 
 		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
 			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
 		}
 	}
 }
+
+// GetDeviceById function against a mocked DynamoDB, so both the found and the not-found points can be reached.
+func TestGetDeviceByIdWithMockedDatabase(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}}
+	defer func() { TestAws = realAws }()
+
+	TestCases := []TestCase{
+		{
+			Name:               "** Testing: Proper id which does exist on DB. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}},
+			ExpectedBody:       "{\"id\":\"id_test\",\"deviceModel\":\"deviceModel_test\",\"name\":\"name_test\",\"note\":\"note_test\",\"serial\":\"serial_test\"}",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Testing: Desire id does not exist. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "NotExistedTestID"}},
+			ExpectedBody:       "{\"message\":\"Device not found\"}",
+			ExpectedStatusCode: 404,
+		},
+
+		{
+			Name:               "** Testing: Device with its model stored as deviceModel. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_legacy"}},
+			ExpectedBody:       "{\"id\":\"id_legacy\",\"deviceModel\":\"deviceModel_legacy\",\"name\":\"name_test\",\"note\":\"note_test\",\"serial\":\"serial_test\"}",
+			ExpectedStatusCode: 200,
+		},
+	}
+
+	for _, test := range TestCases {
+		// Executing each test cases scenario.
+		response, _ := GetDeviceById(test.Request)
+
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+} // End of TestGetDeviceByIdWithMockedDatabase function
+
+// Only the fields asked for by the "fields" query parameter are fetched and returned.
+func TestGetDeviceByIdFields(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}}
+	defer func() { TestAws = realAws }()
+
+	TestCases := []TestCase{
+		{
+			Name:               "** Testing: Only ID and Name. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}, QueryStringParameters: map[string]string{"fields": "ID,Name"}},
+			ExpectedBody:       "{\"id\":\"id_test\",\"name\":\"name_test\"}",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Testing: Model stored as deviceModel. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_legacy"}, QueryStringParameters: map[string]string{"fields": "ID,DeviceModel"}},
+			ExpectedBody:       "{\"deviceModel\":\"deviceModel_legacy\",\"id\":\"id_legacy\"}",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Testing: Unknown field. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}, QueryStringParameters: map[string]string{"fields": "ID,Color"}},
+			ExpectedBody:       "Invalid parameter: fields, unknown field \"Color\".",
+			ExpectedStatusCode: 400,
+		},
+	}
+
+	for _, test := range TestCases {
+		// Executing each test cases scenario.
+		response, _ := GetDeviceById(test.Request)
+
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+} // End of TestGetDeviceByIdFields function
+
+// The "consistent" query parameter is passed through to the ConsistentRead of the GetItem input.
+func TestGetDeviceByIdConsistentRead(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	mock := &MockDynamoDB{}
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	TestCases := []struct {
+		Name                   string
+		Query                  map[string]string
+		ExpectedStatusCode     int
+		ExpectedConsistentRead bool
+	}{
+		{Name: "** Testing: Eventually consistent by default. **", Query: nil, ExpectedStatusCode: 200, ExpectedConsistentRead: false},
+		{Name: "** Testing: consistent=true. **", Query: map[string]string{"consistent": "true"}, ExpectedStatusCode: 200, ExpectedConsistentRead: true},
+		{Name: "** Testing: consistent=false. **", Query: map[string]string{"consistent": "false"}, ExpectedStatusCode: 200, ExpectedConsistentRead: false},
+	}
+
+	for _, test := range TestCases {
+		// Executing each test cases scenario.
+		mock.ConsistentRead = !test.ExpectedConsistentRead
+		response, _ := GetDeviceById(events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}, QueryStringParameters: test.Query})
+		if response.StatusCode != test.ExpectedStatusCode || mock.ConsistentRead != test.ExpectedConsistentRead {
+			t.Errorf("%s \n \t<expected error-code: %d, consistent read: %t> <resulted error-code: %d, consistent read: %t>", test.Name, test.ExpectedStatusCode, test.ExpectedConsistentRead, response.StatusCode, mock.ConsistentRead)
+		}
+	}
+
+	response, _ := GetDeviceById(events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}, QueryStringParameters: map[string]string{"consistent": "maybe"}})
+	if response.StatusCode != 400 || response.Body != "Invalid parameter: consistent must be a boolean." {
+		t.Errorf("** Testing: consistent is not a boolean. ** \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", 400, response.StatusCode, response.Body)
+	}
+} // End of TestGetDeviceByIdConsistentRead function
+
+// Soft deleted devices are not found, unless "includeDeleted=true" is asked for.
+func TestGetDeviceByIdSoftDeleted(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}}
+	defer func() { TestAws = realAws }()
+
+	TestCases := []TestCase{
+		{
+			Name:               "** Testing: Soft deleted device is hidden. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_deleted"}},
+			ExpectedBody:       "{\"message\":\"Device not found\"}",
+			ExpectedStatusCode: 404,
+		},
+
+		{
+			Name:               "** Testing: Soft deleted device is hidden with fields too. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_deleted"}, QueryStringParameters: map[string]string{"fields": "ID"}},
+			ExpectedBody:       "{\"message\":\"Device not found\"}",
+			ExpectedStatusCode: 404,
+		},
+
+		{
+			Name:               "** Testing: Soft deleted device with includeDeleted. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_deleted"}, QueryStringParameters: map[string]string{"includeDeleted": "true"}},
+			ExpectedBody:       "{\"id\":\"id_deleted\",\"deviceModel\":\"deviceModel_test\",\"name\":\"name_test\",\"note\":\"note_test\",\"serial\":\"serial_test\",\"deleted\":true,\"deletedAt\":\"2018-11-02T10:04:05Z\"}",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			// The deleted flag is fetched to hide deleted devices, but isn't returned as it hasn't been asked for.
+			Name:               "** Testing: Not deleted device with fields. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}, QueryStringParameters: map[string]string{"fields": "ID"}},
+			ExpectedBody:       "{\"id\":\"id_test\"}",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Testing: includeDeleted is not a boolean. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_deleted"}, QueryStringParameters: map[string]string{"includeDeleted": "yes please"}},
+			ExpectedBody:       "Invalid parameter: includeDeleted must be a boolean.",
+			ExpectedStatusCode: 400,
+		},
+	}
+
+	for _, test := range TestCases {
+		// Executing each test cases scenario.
+		response, _ := GetDeviceById(test.Request)
+
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+} // End of TestGetDeviceByIdSoftDeleted function
+
+// The ETag header is made of the version of the device, with or without fields.
+func TestGetDeviceByIdETag(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}}
+	defer func() { TestAws = realAws }()
+
+	TestCases := []struct {
+		Name               string
+		Request            events.APIGatewayProxyRequest
+		ExpectedBody       string
+		ExpectedStatusCode int
+		ExpectedETag       string
+	}{
+		{
+			Name:               "** Testing: ETag of a versioned device. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_versioned"}},
+			ExpectedBody:       "{\"id\":\"id_versioned\",\"deviceModel\":\"deviceModel_test\",\"name\":\"name_test\",\"note\":\"note_test\",\"serial\":\"serial_test\",\"version\":3}",
+			ExpectedStatusCode: 200,
+			ExpectedETag:       "\"3\"",
+		},
+
+		{
+			// The version is fetched for the ETag, but isn't returned as it hasn't been asked for.
+			Name:               "** Testing: ETag of a versioned device with fields. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_versioned"}, QueryStringParameters: map[string]string{"fields": "ID"}},
+			ExpectedBody:       "{\"id\":\"id_versioned\"}",
+			ExpectedStatusCode: 200,
+			ExpectedETag:       "\"3\"",
+		},
+
+		{
+			Name:               "** Testing: ETag of a device stored before versioning. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}},
+			ExpectedBody:       "{\"id\":\"id_test\",\"deviceModel\":\"deviceModel_test\",\"name\":\"name_test\",\"note\":\"note_test\",\"serial\":\"serial_test\"}",
+			ExpectedStatusCode: 200,
+			ExpectedETag:       "\"0\"",
+		},
+
+		{
+			Name:               "** Testing: No ETag for a device not found. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "NotExistedTestID"}},
+			ExpectedBody:       "{\"message\":\"Device not found\"}",
+			ExpectedStatusCode: 404,
+			ExpectedETag:       "",
+		},
+	}
+
+	for _, test := range TestCases {
+		// Executing each test cases scenario.
+		response, _ := GetDeviceById(test.Request)
+
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody || response.Headers["ETag"] != test.ExpectedETag {
+			t.Errorf("%s \n \t<expected error-code: %d, ETag: %s> <resulted error-code: %d, ETag: %s> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, test.ExpectedETag, response.StatusCode, response.Headers["ETag"], test.ExpectedBody, response.Body)
+		}
+	}
+} // End of TestGetDeviceByIdETag function
+
+// A found device is cacheable for CACHE_MAX_AGE seconds, and an If-None-Match of its current ETag returns HTTP 304
+// without a body, while a stale one returns the device again.
+func TestGetDeviceByIdCaching(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}}
+	defer func() { TestAws = realAws }()
+
+	versionedBody := "{\"id\":\"id_versioned\",\"deviceModel\":\"deviceModel_test\",\"name\":\"name_test\",\"note\":\"note_test\",\"serial\":\"serial_test\",\"version\":3}"
+	TestCases := []struct {
+		Name                 string
+		MaxAge               string
+		IfNoneMatch          string
+		ExpectedBody         string
+		ExpectedStatusCode   int
+		ExpectedCacheControl string
+	}{
+		{Name: "** Testing: Fresh device with the default max age. **", ExpectedBody: versionedBody, ExpectedStatusCode: 200, ExpectedCacheControl: "private, max-age=60"},
+		{Name: "** Testing: Fresh device with a configured max age. **", MaxAge: "300", ExpectedBody: versionedBody, ExpectedStatusCode: 200, ExpectedCacheControl: "private, max-age=300"},
+		{Name: "** Testing: Stale ETag. **", IfNoneMatch: "\"2\"", ExpectedBody: versionedBody, ExpectedStatusCode: 200, ExpectedCacheControl: "private, max-age=60"},
+		{Name: "** Testing: Current ETag. **", IfNoneMatch: "\"3\"", ExpectedBody: "", ExpectedStatusCode: 304, ExpectedCacheControl: "private, max-age=60"},
+		{Name: "** Testing: Current ETag among others. **", IfNoneMatch: "\"1\", W/\"3\"", ExpectedBody: "", ExpectedStatusCode: 304, ExpectedCacheControl: "private, max-age=60"},
+		{Name: "** Testing: Any ETag. **", IfNoneMatch: "*", ExpectedBody: "", ExpectedStatusCode: 304, ExpectedCacheControl: "private, max-age=60"},
+	}
+
+	for _, test := range TestCases {
+		t.Setenv("CACHE_MAX_AGE", test.MaxAge)
+		request := events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_versioned"}}
+		if test.IfNoneMatch != "" {
+			request.Headers = map[string]string{"if-none-match": test.IfNoneMatch}
+		}
+
+		// Executing each test cases scenario.
+		response, _ := GetDeviceById(request)
+
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+		if response.Headers["ETag"] != "\"3\"" || response.Headers["Cache-Control"] != test.ExpectedCacheControl {
+			t.Errorf("%s \n \t<expected ETag: \"3\", Cache-Control: %s> <resulted ETag: %s, Cache-Control: %s>", test.Name, test.ExpectedCacheControl, response.Headers["ETag"], response.Headers["Cache-Control"])
+		}
+	}
+
+	// A device not found is never cached.
+	response, _ := GetDeviceById(events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "NotExistedTestID"}, Headers: map[string]string{"If-None-Match": "*"}})
+	if response.StatusCode != 404 || response.Headers["Cache-Control"] != "" {
+		t.Errorf("** Testing: Device not found. ** \n \t<expected error-code: 404 without Cache-Control> <resulted error-code: %d, Cache-Control: %s>", response.StatusCode, response.Headers["Cache-Control"])
+	}
+} // End of TestGetDeviceByIdCaching function
+
+// A device whose TTL has passed is not found, even before DynamoDB has purged it.
+func TestGetDeviceByIdExpired(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}}
+	defer func() { TestAws = realAws }()
+
+	TestCases := []TestCase{
+		{
+			Name:               "** Testing: Expired device. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_expired"}},
+			ExpectedBody:       "{\"message\":\"Device not found\"}",
+			ExpectedStatusCode: 404,
+		},
+
+		{
+			// The expiry is fetched to hide an expired device, even if it hasn't been asked for.
+			Name:               "** Testing: Expired device with fields. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_expired"}, QueryStringParameters: map[string]string{"fields": "ID"}},
+			ExpectedBody:       "{\"message\":\"Device not found\"}",
+			ExpectedStatusCode: 404,
+		},
+
+		{
+			Name:               "** Testing: Expired device with includeDeleted. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_expired"}, QueryStringParameters: map[string]string{"includeDeleted": "true"}},
+			ExpectedBody:       "{\"message\":\"Device not found\"}",
+			ExpectedStatusCode: 404,
+		},
+	}
+
+	for _, test := range TestCases {
+		// Executing each test cases scenario.
+		response, _ := GetDeviceById(test.Request)
+
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+} // End of TestGetDeviceByIdExpired function
+
+// A device of another tenant is not found, the owner is checked with fields too.
+func TestGetDeviceByIdOwner(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}}
+	defer func() { TestAws = realAws }()
+
+	tenant := func(sub string) events.APIGatewayProxyRequestContext {
+		return events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"claims": map[string]interface{}{"sub": sub}}}
+	}
+	TestCases := []TestCase{
+		{
+			Name:               "** Testing: Device of the caller's tenant. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_owned"}, QueryStringParameters: map[string]string{"fields": "ID"}, RequestContext: tenant("tenant-a")},
+			ExpectedBody:       "{\"id\":\"id_owned\"}",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Testing: Device of another tenant. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_owned"}, RequestContext: tenant("tenant-b")},
+			ExpectedBody:       "{\"message\":\"Device not found\"}",
+			ExpectedStatusCode: 404,
+		},
+
+		{
+			Name:               "** Testing: Device of another tenant with fields. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_owned"}, QueryStringParameters: map[string]string{"fields": "ID"}, RequestContext: tenant("tenant-b")},
+			ExpectedBody:       "{\"message\":\"Device not found\"}",
+			ExpectedStatusCode: 404,
+		},
+
+		{
+			// Devices created without a caller belong to no tenant.
+			Name:               "** Testing: Device without an owner. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}, RequestContext: tenant("tenant-b")},
+			ExpectedBody:       "{\"message\":\"Device not found\"}",
+			ExpectedStatusCode: 404,
+		},
+	}
+
+	for _, test := range TestCases {
+		// Executing each test cases scenario.
+		response, _ := GetDeviceById(test.Request)
+
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+} // End of TestGetDeviceByIdOwner function
+
+// A device shared with groups is found by the members of one of them, and still by its owner only otherwise.
+func TestGetDeviceByIdAllowedGroups(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}}
+	defer func() { TestAws = realAws }()
+
+	member := func(sub string, groups string) events.APIGatewayProxyRequestContext {
+		return events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"claims": map[string]interface{}{"sub": sub, "cognito:groups": groups}}}
+	}
+	TestCases := []TestCase{
+		{
+			Name:               "** Testing: Caller in an allowed group. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_shared"}, QueryStringParameters: map[string]string{"fields": "ID"}, RequestContext: member("tenant-b", "ops,support")},
+			ExpectedBody:       "{\"id\":\"id_shared\"}",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Testing: Owner out of the allowed groups. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_shared"}, QueryStringParameters: map[string]string{"fields": "ID"}, RequestContext: member("tenant-a", "")},
+			ExpectedBody:       "{\"id\":\"id_shared\"}",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Testing: Caller out of the allowed groups. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_shared"}, RequestContext: member("tenant-b", "ops")},
+			ExpectedBody:       "{\"message\":\"Device not found\"}",
+			ExpectedStatusCode: 404,
+		},
+
+		{
+			Name:               "** Testing: Caller in a group of an unshared device. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_owned"}, RequestContext: member("tenant-b", "support")},
+			ExpectedBody:       "{\"message\":\"Device not found\"}",
+			ExpectedStatusCode: 404,
+		},
+	}
+
+	for _, test := range TestCases {
+		// Executing each test cases scenario.
+		response, _ := GetDeviceById(test.Request)
+
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+} // End of TestGetDeviceByIdAllowedGroups function
+
+// A device whose stored id differs only in case is found with caseInsensitive=true, through a capped scan.
+func TestGetDeviceByIdCaseInsensitive(t *testing.T) {
+	realAws := TestAws
+	defer func() { TestAws = realAws }()
+
+	TestCases := []struct {
+		Name               string
+		Request            events.APIGatewayProxyRequest
+		MockDatabase       *MockDynamoDB
+		ExpectedBody       string
+		ExpectedStatusCode int
+		ExpectedScans      int
+	}{
+		{
+			Name:               "** Testing: Id differing in case, found on the second page. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "ID_Test"}, QueryStringParameters: map[string]string{"caseInsensitive": "true", "fields": "ID"}},
+			MockDatabase:       &MockDynamoDB{Pages: [][]string{{"id_owned"}, {"id_versioned", "id_test"}}},
+			ExpectedBody:       "{\"id\":\"id_test\"}",
+			ExpectedStatusCode: 200,
+			ExpectedScans:      2,
+		},
+
+		{
+			Name:               "** Testing: Id differing in case without caseInsensitive. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "ID_Test"}},
+			MockDatabase:       &MockDynamoDB{Pages: [][]string{{"id_test"}}},
+			ExpectedBody:       "{\"message\":\"Device not found\"}",
+			ExpectedStatusCode: 404,
+			ExpectedScans:      0,
+		},
+
+		{
+			// An exact match is read directly, never scanned.
+			Name:               "** Testing: Exact id with caseInsensitive. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}, QueryStringParameters: map[string]string{"caseInsensitive": "true", "fields": "ID"}},
+			MockDatabase:       &MockDynamoDB{Pages: [][]string{{"id_test"}}},
+			ExpectedBody:       "{\"id\":\"id_test\"}",
+			ExpectedStatusCode: 200,
+			ExpectedScans:      0,
+		},
+
+		{
+			Name:               "** Testing: No id matching in any case. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "unknown"}, QueryStringParameters: map[string]string{"caseInsensitive": "true"}},
+			MockDatabase:       &MockDynamoDB{Pages: [][]string{{"id_test"}}},
+			ExpectedBody:       "{\"message\":\"Device not found\"}",
+			ExpectedStatusCode: 404,
+			ExpectedScans:      1,
+		},
+
+		{
+			// The scan gives up on a large table, even though a later page might match.
+			Name:               "** Testing: Scan capped on a large table. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "ID_Test"}, QueryStringParameters: map[string]string{"caseInsensitive": "true"}},
+			MockDatabase:       &MockDynamoDB{Pages: [][]string{{"id_owned"}}, Endless: true},
+			ExpectedBody:       "{\"message\":\"Device not found\"}",
+			ExpectedStatusCode: 404,
+			ExpectedScans:      caseInsensitiveScanPages,
+		},
+
+		{
+			Name:               "** Testing: Invalid caseInsensitive. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "ID_Test"}, QueryStringParameters: map[string]string{"caseInsensitive": "maybe"}},
+			MockDatabase:       &MockDynamoDB{Pages: [][]string{{"id_test"}}},
+			ExpectedBody:       "Invalid parameter: caseInsensitive must be a boolean.",
+			ExpectedStatusCode: 400,
+			ExpectedScans:      0,
+		},
+	}
+
+	for _, test := range TestCases {
+		// Executing each test cases scenario against its own mocked database.
+		TestAws = &AmazonWebServices{DynamoDB: test.MockDatabase}
+		response, _ := GetDeviceById(test.Request)
+
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+		if test.MockDatabase.Scans != test.ExpectedScans {
+			t.Errorf("%s \n \t<expected scans: %d> <resulted scans: %d>", test.Name, test.ExpectedScans, test.MockDatabase.Scans)
+		}
+	}
+} // End of TestGetDeviceByIdCaseInsensitive function
+
+// The same device is served for a REST API (payload v1) and an HTTP API (payload v2) event, whose path and query differ.
+func TestGetDeviceByIdPayloadVersions(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}}
+	defer func() { TestAws = realAws }()
+
+	handler := gateway.Adapt(GetDeviceById)
+	expectedBody := "{\"id\":\"id_test\",\"name\":\"name_test\"}"
+
+	// A v1 event has no version, and its query string is already parsed.
+	v1, err := handler(json.RawMessage(`{"httpMethod":"GET","path":"/devices/id_test","pathParameters":{"id":"id_test"},"queryStringParameters":{"fields":"ID,Name"}}`))
+	response, ok := v1.(events.APIGatewayProxyResponse)
+	if err != nil || !ok || response.StatusCode != 200 || response.Body != expectedBody {
+		t.Errorf("** Testing: Payload v1. ** \n \t<expected error-code: %d> <resulted: %#v> <error: %v> \n \t<expected body: %s>", 200, v1, err, expectedBody)
+	}
+
+	// A v2 event has a raw query string, its method is in the request context and its response is a v2 one.
+	v2, err := handler(json.RawMessage(`{"version":"2.0","routeKey":"GET /devices/{id}","rawPath":"/devices/id_test","rawQueryString":"fields=ID%2CName","pathParameters":{"id":"id_test"},"requestContext":{"http":{"method":"GET","path":"/devices/id_test"}}}`))
+	responseV2, ok := v2.(events.APIGatewayV2HTTPResponse)
+	if err != nil || !ok || responseV2.StatusCode != 200 || responseV2.Body != expectedBody {
+		t.Errorf("** Testing: Payload v2. ** \n \t<expected error-code: %d> <resulted: %#v> <error: %v> \n \t<expected body: %s>", 200, v2, err, expectedBody)
+	}
+} // End of TestGetDeviceByIdPayloadVersions function
+
+// With RESPONSE_FIELDS set, a field which isn't listed is left out of the device even though it's stored, and can't be
+// asked for through the "fields" query parameter either.
+func TestGetDeviceByIdResponseFields(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{}}
+	defer func() { TestAws = realAws }()
+	t.Setenv("RESPONSE_FIELDS", "id,deviceModel,name,serial")
+
+	TestCases := []TestCase{
+		{
+			Name:               "** Testing: Whole device without the note. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}},
+			ExpectedBody:       "{\"id\":\"id_test\",\"deviceModel\":\"deviceModel_test\",\"name\":\"name_test\",\"serial\":\"serial_test\"}",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Testing: Asking for the note. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}, QueryStringParameters: map[string]string{"fields": "ID,Note"}},
+			ExpectedBody:       "Invalid parameter: fields, unknown field \"Note\".",
+			ExpectedStatusCode: 400,
+		},
+	}
+
+	for _, test := range TestCases {
+		// Executing each test cases scenario.
+		response, _ := GetDeviceById(test.Request)
+
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+		if strings.Contains(response.Body, "note_test") {
+			t.Errorf("%s \n \t<expected no note> <resulted body: %s>", test.Name, response.Body)
+		}
+	}
+} // End of TestGetDeviceByIdResponseFields function
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"gateway"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"negotiation"
+	"os"
+	"owner"
+	"placeholder"
+	"recovery"
+	"types"
+)
+
+type AmazonWebServices struct {
+	Config   *aws.Config
+	Session  *session.Session
+	DynamoDB dynamodbiface.DynamoDBAPI
+}
+
+// Prepare a new AWS & DynamoDB session, then configure it.
+var TestAws *AmazonWebServices
+
+func init() {
+	region := os.Getenv("AWS_REGION")
+	var Aws *AmazonWebServices = new(AmazonWebServices)
+	Aws.Config = &aws.Config{Region: aws.String(region)}
+	// Pointing the client to a local DynamoDB, i.e: DynamoDB Local for the integration tests. It's unset in production.
+	if endpoint := os.Getenv("DYNAMODB_ENDPOINT"); endpoint != "" {
+		Aws.Config.Endpoint = aws.String(endpoint)
+	}
+	var err error
+	Aws.Session, err = session.NewSession(Aws.Config)
+	if err != nil {
+		// Logs error on Amazon CloudWatch. It's sysadmin's duty to handle it.
+		fmt.Println(fmt.Sprintf("Failed to connect to AWS: %s", err.Error()))
+	} else {
+		var svc *dynamodb.DynamoDB = dynamodb.New(Aws.Session)
+		Aws.DynamoDB = dynamodbiface.DynamoDBAPI(svc)
+	}
+	// Instantiate a global session in TestAws
+	TestAws = Aws
+}
+
+// Preparing DynamoDB Session and Calling DB's Query function inside, following all the pages of the result.
+// The history table (HISTORY_TABLE_NAME) is keyed by "id" (S) and sorted by "revision" (S), the createdAt and the
+// zero padded version of the device (see history.Revision), so the replaced versions of a device are read in the order
+// they have been stored, even across a device deleted and created again. A non empty ownerID keeps only the versions of
+// its tenant.
+func (self *AmazonWebServices) QueryHistory(id string, ownerID string) ([]map[string]*dynamodb.AttributeValue, error) {
+	// Get desire table's name from OS's environmental varible.
+	tableName := aws.String(os.Getenv("HISTORY_TABLE_NAME"))
+
+	names := placeholder.Names{}
+	var input = &dynamodb.QueryInput{
+		TableName:                tableName,
+		KeyConditionExpression:   aws.String(fmt.Sprintf("%s = :id", names.Of("id"))),
+		ExpressionAttributeNames: names,
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":id": {S: aws.String(id)},
+		},
+		ScanIndexForward: aws.Bool(true),
+	}
+	if ownerID != "" {
+		input.FilterExpression = aws.String(fmt.Sprintf("%s = :owner", names.Of("ownerId")))
+		input.ExpressionAttributeValues[":owner"] = &dynamodb.AttributeValue{S: aws.String(ownerID)}
+	}
+
+	var items []map[string]*dynamodb.AttributeValue
+	for {
+		// Calling either Query function of interface, defined in getDeviceHistory_test.go file, or api with the input we've provided.
+		// In real deployment environment, the Query function of aws (api.go) will be called.
+		result, err := self.DynamoDB.Query(input)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, result.Items...)
+		// DynamoDB has more items for us only when it returns a LastEvaluatedKey.
+		if len(result.LastEvaluatedKey) == 0 {
+			return items, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+// The handler function which will be first started from main function.
+// Returns the versions of a device which its updates, patches, upserts and rotations have replaced, oldest first.
+// A device which has never been updated, or has been updated before the history table, has no history yet.
+func GetDeviceHistory(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !gateway.AllowsMethod(request, "GET") {
+		return gateway.MethodNotAllowed("GET"), nil
+	}
+
+	// The id which user has sent through GET method.
+	id := request.PathParameters["id"]
+
+	// If no id have been provided, return HTTP error code 400.
+	if id == "" {
+		return events.APIGatewayProxyResponse{
+			Body:       "Missing field: id",
+			StatusCode: 400,
+		}, nil
+	}
+
+	// Never the history of a device of another tenant.
+	items, err := TestAws.QueryHistory(id, owner.Caller(request))
+
+	// If an internal error have occurred in the database, return HTTP error code 500.
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Failed to query the history: %s", err.Error()))
+		return events.APIGatewayProxyResponse{
+			Body:       "Internal Server Error.",
+			StatusCode: 500,
+		}, nil
+	}
+
+	// Deserialization/Decoding "items" to Go structs.
+	// Starting from an empty slice, so no history is returned as "[]" instead of "null".
+	versions := []types.Device{}
+	err = dynamodbattribute.UnmarshalListOfMaps(items, &versions)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:       "Internal Server Error.",
+			StatusCode: 500,
+		}, nil
+	}
+
+	// Return the versions as a JSON array with 200 HTTP status code.
+	versionsJson, _ := negotiation.Marshal(negotiation.JSON, versions)
+	return events.APIGatewayProxyResponse{
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(versionsJson),
+		StatusCode: 200,
+	}, nil
+} // End of GetDeviceHistory function
+
+func main() {
+	lambda.Start(gateway.Adapt(recovery.WithRecover(GetDeviceHistory)))
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"strconv"
+	"testing"
+)
+
+type TestCase struct {
+	Name               string
+	Request            events.APIGatewayProxyRequest
+	ExpectedBody       string
+	ExpectedStatusCode int
+}
+
+// Mocking DynamoDB through dynamodbiface.
+type MockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	// Items of the mocked history table, in the order of their versions, and the error which the mocked Query returns.
+	Items []map[string]*dynamodb.AttributeValue
+	Error error
+}
+
+// Custom Query function for overriding the Query of getDeviceHistory.go for using in test scenarios.
+// Mocking the "#id = :id" key condition, returning one version per page to exercise the paging.
+func (self *MockDynamoDB) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	if self.Error != nil {
+		return nil, self.Error
+	}
+	id := aws.StringValue(input.ExpressionAttributeValues[":id"].S)
+	var matching []map[string]*dynamodb.AttributeValue
+	for _, item := range self.Items {
+		if aws.StringValue(item["id"].S) == id {
+			matching = append(matching, item)
+		}
+	}
+	start := 0
+	if input.ExclusiveStartKey != nil {
+		for i, item := range matching {
+			if aws.StringValue(item["version"].N) == aws.StringValue(input.ExclusiveStartKey["version"].N) {
+				start = i + 1
+			}
+		}
+	}
+	MockOutput := new(dynamodb.QueryOutput)
+	if start < len(matching) {
+		MockOutput.SetItems(matching[start : start+1])
+		if start+1 < len(matching) {
+			MockOutput.SetLastEvaluatedKey(map[string]*dynamodb.AttributeValue{"id": matching[start]["id"], "version": matching[start]["version"]})
+		}
+	}
+	return MockOutput, nil
+}
+
+// Building a stored version of a device of the mocked history table.
+func testVersion(id string, name string, version int) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"id":           {S: aws.String(id)},
+		"device_model": {S: aws.String("/devicemodels/id1")},
+		"name":         {S: aws.String(name)},
+		"note":         {S: aws.String("note_test")},
+		"serial":       {S: aws.String("serial_test")},
+		"version":      {N: aws.String(strconv.Itoa(version))},
+	}
+}
+
+// GetDeviceHistory function in getDeviceHistory.go signature: input: (request events.APIGatewayProxyRequest), output: (events.APIGatewayProxyResponse, error)
+func TestGetDeviceHistory(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{Items: []map[string]*dynamodb.AttributeValue{
+		testVersion("id_test", "Sensor", 1),
+		testVersion("id_test", "Kitchen sensor", 2),
+		testVersion("id_other", "Other", 1),
+	}}}
+	defer func() { TestAws = realAws }()
+
+	testCases := []TestCase{
+		{
+			Name:               "** Testing: Two historical versions in order. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}},
+			ExpectedBody:       "[{\"id\":\"id_test\",\"deviceModel\":\"/devicemodels/id1\",\"name\":\"Sensor\",\"note\":\"note_test\",\"serial\":\"serial_test\",\"version\":1},{\"id\":\"id_test\",\"deviceModel\":\"/devicemodels/id1\",\"name\":\"Kitchen sensor\",\"note\":\"note_test\",\"serial\":\"serial_test\",\"version\":2}]",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Testing: Device without history. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_new"}},
+			ExpectedBody:       "[]",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Testing: Missing id. **",
+			Request:            events.APIGatewayProxyRequest{},
+			ExpectedBody:       "Missing field: id",
+			ExpectedStatusCode: 400,
+		},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := GetDeviceHistory(test.Request)
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{Error: errors.New("unexpected Error has occurred")}}
+	response, _ := GetDeviceHistory(events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}})
+	if response.StatusCode != 500 {
+		t.Errorf("** Database Unexpected Error ** \n \t<expected error-code: %d> <resulted error-code: %d>", 500, response.StatusCode)
+	}
+} // End of TestGetDeviceHistory function
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gateway"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"negotiation"
+	"os"
+	"owner"
+	"recovery"
+	"strings"
+	"time"
+	"types"
+	"validation"
+)
+
+type AmazonWebServices struct {
+	Config   *aws.Config
+	Session  *session.Session
+	DynamoDB dynamodbiface.DynamoDBAPI
+}
+
+// Prepare a new AWS & DynamoDB session, then configure it.
+var TestAws *AmazonWebServices
+
+// DynamoDB accepts at most 100 keys in a single BatchGetItem call.
+const batchSize = 100
+
+// How many times unprocessed keys are sent again, and the delay before the first retry which doubles each time.
+const maxBatchRetries = 5
+
+var batchRetryDelay = 50 * time.Millisecond
+
+func init() {
+	region := os.Getenv("AWS_REGION")
+	var Aws *AmazonWebServices = new(AmazonWebServices)
+	Aws.Config = &aws.Config{Region: aws.String(region)}
+	// Pointing the client to a local DynamoDB, i.e: DynamoDB Local for the integration tests. It's unset in production.
+	if endpoint := os.Getenv("DYNAMODB_ENDPOINT"); endpoint != "" {
+		Aws.Config.Endpoint = aws.String(endpoint)
+	}
+	var err error
+	Aws.Session, err = session.NewSession(Aws.Config)
+	if err != nil {
+		// Logs error on Amazon CloudWatch. It's sysadmin's duty to handle it.
+		fmt.Println(fmt.Sprintf("Failed to connect to AWS: %s", err.Error()))
+	} else {
+		var svc *dynamodb.DynamoDB = dynamodb.New(Aws.Session)
+		Aws.DynamoDB = dynamodbiface.DynamoDBAPI(svc)
+	}
+	// Instantiate a global session in TestAws
+	TestAws = Aws
+}
+
+// Preparing DynamoDB Session and Calling DB's BatchGetItem function inside, in chunks of 100 ids.
+// Keys left unprocessed by DynamoDB, i.e: because of throttling, are retried with an exponential backoff.
+// Returns the items which have been found in no particular order, ids which don't exist are simply not returned.
+// The ids must be unique, DynamoDB rejects a batch which asks for the same key twice.
+func (self *AmazonWebServices) BatchGet(ids []string) ([]map[string]*dynamodb.AttributeValue, error) {
+	// Get table name from OS's environment
+	tableName := os.Getenv("DEVICES_TABLE_NAME")
+	var items []map[string]*dynamodb.AttributeValue
+
+	for start := 0; start < len(ids); start += batchSize {
+		end := start + batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		keys := &dynamodb.KeysAndAttributes{}
+		for _, id := range ids[start:end] {
+			keys.Keys = append(keys.Keys, map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}})
+		}
+
+		delay := batchRetryDelay
+		for attempt := 0; keys != nil && len(keys.Keys) > 0; attempt++ {
+			if attempt > maxBatchRetries {
+				return nil, errors.New("keys left unprocessed after retries")
+			}
+			if attempt > 0 {
+				time.Sleep(delay)
+				delay *= 2
+			}
+			var input = &dynamodb.BatchGetItemInput{
+				RequestItems: map[string]*dynamodb.KeysAndAttributes{tableName: keys},
+			}
+			// Calling either BatchGetItem function of interface, defined in getDevices_test.go file, or api with the input we've provided.
+			// In real deployment environment, the BatchGetItem function of aws (api.go) will be called.
+			result, err := self.DynamoDB.BatchGetItem(input)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, result.Responses[tableName]...)
+			keys = result.UnprocessedKeys[tableName]
+		}
+	}
+	return items, nil
+}
+
+// The handler function which will be first started from main function.
+// The ids are given either as "?ids=a,b,c" or as a JSON array in the body, i.e: for more ids than fit in a URL.
+// Found devices are returned in the order of the ids, soft deleted ones and the ones of other tenants are missing.
+func GetDevices(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !gateway.AllowsMethod(request, "GET", "POST") {
+		return gateway.MethodNotAllowed("GET", "POST"), nil
+	}
+
+	var ids []string
+	if len(request.Body) > 0 {
+		if err := validation.CheckBodySize(request); err != nil {
+			return events.APIGatewayProxyResponse{
+				Body:       err.Error(),
+				StatusCode: 413,
+			}, nil
+		}
+		// De-serialize "request.Body" which is a JSON array into "ids" in Go objects.
+		if err := json.Unmarshal([]byte(request.Body), &ids); err != nil {
+			return events.APIGatewayProxyResponse{
+				Body:       "Wrong format: Inputs must be a valid JSON array of ids.",
+				StatusCode: 400,
+			}, nil
+		}
+	} else if rawIds := request.QueryStringParameters["ids"]; rawIds != "" {
+		ids = strings.Split(rawIds, ",")
+	}
+
+	// Duplicated ids are fetched once, and an empty id is no device at all.
+	var unique []string
+	seen := map[string]bool{}
+	for _, id := range ids {
+		id = strings.TrimSpace(id)
+		if id != "" && !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	if len(unique) == 0 {
+		return events.APIGatewayProxyResponse{
+			Body:       "Missing parameter: ids",
+			StatusCode: 400,
+		}, nil
+	}
+
+	items, err := TestAws.BatchGet(unique)
+
+	// If an internal error have occurred in the database, return HTTP error code 500.
+	if err != nil {
+		// Logs error on Amazon CloudWatch.
+		fmt.Println(fmt.Sprintf("Failed to get a batch of devices: %s", err.Error()))
+		return events.APIGatewayProxyResponse{
+			Body:       "Internal Server Error.",
+			StatusCode: 500,
+		}, nil
+	}
+
+	// Deserialization/Decoding "items" to Go structs, by their id.
+	var devices []types.Device
+	if err = dynamodbattribute.UnmarshalListOfMaps(items, &devices); err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:       "Internal Server Error.",
+			StatusCode: 500,
+		}, nil
+	}
+	// A device of another tenant is reported as missing, as GetDeviceById does, unless it's shared with the caller.
+	found := map[string]types.Device{}
+	for _, device := range devices {
+		if !device.Deleted && owner.Allows(request, device.OwnerID, device.AllowedGroups) {
+			found[device.ID] = device
+		}
+	}
+
+	// Starting from empty slices, so none of them is returned as "null".
+	Result := types.BatchGetResult{Devices: []types.Device{}, Missing: []string{}}
+	for _, id := range unique {
+		if device, ok := found[id]; ok {
+			Result.Devices = append(Result.Devices, device)
+		} else {
+			Result.Missing = append(Result.Missing, id)
+		}
+	}
+
+	// Serialization/Encoding the result to JSON.
+	resultJson, _ := negotiation.Marshal(negotiation.JSON, Result)
+	return events.APIGatewayProxyResponse{
+		Body:       string(resultJson),
+		StatusCode: 200,
+	}, nil
+} // End of GetDevices function
+
+func main() {
+	lambda.Start(gateway.Adapt(recovery.WithRecover(GetDevices)))
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"strings"
+	"testing"
+	"types"
+)
+
+// Mocking DynamoDB through dynamodbiface.
+type MockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	// Devices of the mocked table by id, and the ids which are left unprocessed the first time they are asked for.
+	Items     map[string]map[string]*dynamodb.AttributeValue
+	Throttled map[string]bool
+	// Number of keys of every BatchGetItem call.
+	BatchSizes []int
+}
+
+// Custom BatchGetItem function for overriding the BatchGetItem of getDevices.go for using in test scenarios.
+// Found items are returned in reverse order, since DynamoDB doesn't keep the order of the keys.
+func (self *MockDynamoDB) BatchGetItem(input *dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error) {
+	MockOutput := &dynamodb.BatchGetItemOutput{
+		Responses:       map[string][]map[string]*dynamodb.AttributeValue{},
+		UnprocessedKeys: map[string]*dynamodb.KeysAndAttributes{},
+	}
+	for table, keys := range input.RequestItems {
+		self.BatchSizes = append(self.BatchSizes, len(keys.Keys))
+		seen := map[string]bool{}
+		for _, key := range keys.Keys {
+			id := aws.StringValue(key["id"].S)
+			if seen[id] {
+				return nil, fmt.Errorf("ValidationException: Provided list of item keys contains duplicates")
+			}
+			seen[id] = true
+			if self.Throttled[id] {
+				delete(self.Throttled, id)
+				if MockOutput.UnprocessedKeys[table] == nil {
+					MockOutput.UnprocessedKeys[table] = &dynamodb.KeysAndAttributes{}
+				}
+				MockOutput.UnprocessedKeys[table].Keys = append(MockOutput.UnprocessedKeys[table].Keys, key)
+				continue
+			}
+			if item, ok := self.Items[id]; ok {
+				MockOutput.Responses[table] = append([]map[string]*dynamodb.AttributeValue{item}, MockOutput.Responses[table]...)
+			}
+		}
+	}
+	return MockOutput, nil
+}
+
+// GetDevices function in getDevices.go signature: input: (request events.APIGatewayProxyRequest), output: (events.APIGatewayProxyResponse, error)
+func TestGetDevices(t *testing.T) {
+	device := func(id string, deleted bool) map[string]*dynamodb.AttributeValue {
+		item := map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}, "name": {S: aws.String("name_" + id)}}
+		if deleted {
+			item["deleted"] = &dynamodb.AttributeValue{BOOL: aws.Bool(true)}
+		}
+		return item
+	}
+	Items := map[string]map[string]*dynamodb.AttributeValue{
+		"id_test1":   device("id_test1", false),
+		"id_test2":   device("id_test2", false),
+		"id_deleted": device("id_deleted", true),
+		"id_owned":   device("id_owned", false),
+	}
+	Items["id_owned"]["ownerId"] = &dynamodb.AttributeValue{S: aws.String("tenant-a")}
+	tenant := events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"claims": map[string]interface{}{"sub": "tenant-a"}}}
+
+	testCases := []struct {
+		Name               string
+		Request            events.APIGatewayProxyRequest
+		ExpectedBody       string
+		ExpectedStatusCode int
+	}{
+		{
+			Name:               "** Testing: Found and missing ids from the query string. **",
+			Request:            events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"ids": "id_test2,id_absent,id_test1,id_test2,id_deleted"}},
+			ExpectedBody:       "{\"devices\":[{\"id\":\"id_test2\",\"deviceModel\":\"\",\"name\":\"name_id_test2\",\"note\":\"\",\"serial\":\"\"},{\"id\":\"id_test1\",\"deviceModel\":\"\",\"name\":\"name_id_test1\",\"note\":\"\",\"serial\":\"\"}],\"missing\":[\"id_absent\",\"id_deleted\"]}",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Testing: Ids from a JSON body. **",
+			Request:            events.APIGatewayProxyRequest{Body: "[\"id_absent\",\"id_test1\"]"},
+			ExpectedBody:       "{\"devices\":[{\"id\":\"id_test1\",\"deviceModel\":\"\",\"name\":\"name_id_test1\",\"note\":\"\",\"serial\":\"\"}],\"missing\":[\"id_absent\"]}",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			// Devices created without a caller belong to no tenant.
+			Name:               "** Testing: Ids of several tenants. **",
+			Request:            events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"ids": "id_owned,id_test1"}, RequestContext: tenant},
+			ExpectedBody:       "{\"devices\":[{\"id\":\"id_owned\",\"deviceModel\":\"\",\"name\":\"name_id_owned\",\"note\":\"\",\"serial\":\"\",\"ownerId\":\"tenant-a\"}],\"missing\":[\"id_test1\"]}",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Testing: No ids. **",
+			Request:            events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"ids": " , "}},
+			ExpectedBody:       "Missing parameter: ids",
+			ExpectedStatusCode: 400,
+		},
+
+		{
+			Name:               "** Testing: Body which is not a JSON array. **",
+			Request:            events.APIGatewayProxyRequest{Body: "{\"ids\":[]}"},
+			ExpectedBody:       "Wrong format: Inputs must be a valid JSON array of ids.",
+			ExpectedStatusCode: 400,
+		},
+	}
+
+	realAws := TestAws
+	defer func() { TestAws = realAws }()
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{Items: Items}}
+		response, _ := GetDevices(test.Request)
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+} // End of TestGetDevices function
+
+// More than 100 ids are fetched in chunks, and unprocessed keys are asked for again.
+func TestGetDevicesChunks(t *testing.T) {
+	Items := map[string]map[string]*dynamodb.AttributeValue{}
+	var ids []string
+	for i := 0; i < 120; i++ {
+		id := fmt.Sprintf("id_test%d", i)
+		Items[id] = map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}}
+		ids = append(ids, id)
+	}
+	mock := &MockDynamoDB{Items: Items, Throttled: map[string]bool{"id_test42": true}}
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	realDelay := batchRetryDelay
+	batchRetryDelay = 0
+	defer func() {
+		TestAws = realAws
+		batchRetryDelay = realDelay
+	}()
+
+	body, _ := json.Marshal(ids)
+	response, _ := GetDevices(events.APIGatewayProxyRequest{Body: string(body)})
+	Result := types.BatchGetResult{}
+	json.Unmarshal([]byte(response.Body), &Result)
+	if response.StatusCode != 200 || len(Result.Devices) != 120 || len(Result.Missing) != 0 || Result.Devices[42].ID != "id_test42" {
+		t.Errorf("** Testing: 120 ids with a throttled one. ** \n \t<expected error-code: %d and 120 devices in order> <resulted error-code: %d> <resulted body: %s>", 200, response.StatusCode, response.Body)
+	}
+	if fmt.Sprint(mock.BatchSizes) != "[100 1 20]" {
+		t.Errorf("** Testing: Chunks of the batch get. ** \n \t<expected batch sizes: [100 1 20]> <resulted batch sizes: %v>", mock.BatchSizes)
+	}
+	if strings.Contains(response.Body, "null") {
+		t.Errorf("** Testing: Empty missing list. ** \n \t<resulted body: %s>", response.Body)
+	}
+} // End of TestGetDevicesChunks function
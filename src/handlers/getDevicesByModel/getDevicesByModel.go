@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"gateway"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"negotiation"
+	"os"
+	"owner"
+	"placeholder"
+	"recovery"
+	"strconv"
+	"strings"
+	"time"
+	"types"
+	"validation"
+)
+
+type AmazonWebServices struct {
+	Config   *aws.Config
+	Session  *session.Session
+	DynamoDB dynamodbiface.DynamoDBAPI
+}
+
+// Prepare a new AWS & DynamoDB session, then configure it.
+var TestAws *AmazonWebServices
+
+// Default name of the global secondary index of the devices table which is keyed by deviceModel.
+const defaultDeviceModelIndex = "StoredModel-index"
+
+// Default name of the global secondary index of the devices table which is keyed by deviceModel and sorted by createdAt.
+const defaultModelCreatedIndex = "StoredModelCreatedAt-index"
+
+func init() {
+	region := os.Getenv("AWS_REGION")
+	var Aws *AmazonWebServices = new(AmazonWebServices)
+	Aws.Config = &aws.Config{Region: aws.String(region)}
+	// Pointing the client to a local DynamoDB, i.e: DynamoDB Local for the integration tests. It's unset in production.
+	if endpoint := os.Getenv("DYNAMODB_ENDPOINT"); endpoint != "" {
+		Aws.Config.Endpoint = aws.String(endpoint)
+	}
+	var err error
+	Aws.Session, err = session.NewSession(Aws.Config)
+	if err != nil {
+		// Logs error on Amazon CloudWatch. It's sysadmin's duty to handle it.
+		fmt.Println(fmt.Sprintf("Failed to connect to AWS: %s", err.Error()))
+	} else {
+		var svc *dynamodb.DynamoDB = dynamodb.New(Aws.Session)
+		Aws.DynamoDB = dynamodbiface.DynamoDBAPI(svc)
+	}
+	// Instantiate a global session in TestAws
+	TestAws = Aws
+}
+
+// Preparing DynamoDB Session and Calling DB's Query function inside, following all the pages of the result.
+// It requires a global secondary index on the devices table, named by deviceModelIndex, with "device_model" (S),
+// the attribute which the deviceModel field is stored as, as its HASH key and an ALL projection, so a model is looked up without scanning the whole table.
+// Soft deleted devices are filtered out unless includeDeleted, a non empty ownerID keeps only its devices
+// and a non empty status only the devices in it, the devices without a status are active.
+// A non empty since, an RFC3339 time in UTC, keeps only the devices created after it. They are found by the key condition
+// on the index named by modelCreatedIndex, which has "createdAt" (S) as its RANGE key, rather than by a filter.
+func (self *AmazonWebServices) QueryByModel(model string, since string, includeDeleted bool, ownerID string, status string) ([]map[string]*dynamodb.AttributeValue, error) {
+	// Get desire table's name from OS's environmental varible.
+	tableName := aws.String(os.Getenv("DEVICES_TABLE_NAME"))
+
+	names := placeholder.Names{}
+	var input = &dynamodb.QueryInput{
+		TableName:                tableName,
+		IndexName:                aws.String(deviceModelIndex()),
+		KeyConditionExpression:   aws.String(fmt.Sprintf("%s = :model", names.Field("deviceModel"))),
+		ExpressionAttributeNames: names,
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":model": {S: aws.String(model)},
+		},
+	}
+	var conditions []string
+	// The timestamps are RFC3339 in UTC, so they are in the order of time as strings too.
+	if since != "" {
+		input.IndexName = aws.String(modelCreatedIndex())
+		input.KeyConditionExpression = aws.String(fmt.Sprintf("%s = :model AND %s > :since", names.Field("deviceModel"), names.Field("createdAt")))
+		input.ExpressionAttributeValues[":since"] = &dynamodb.AttributeValue{S: aws.String(since)}
+	}
+	if !includeDeleted {
+		conditions = append(conditions, fmt.Sprintf("(attribute_not_exists(%[1]s) OR %[1]s = :false)", names.Of("deleted")))
+		input.ExpressionAttributeValues[":false"] = &dynamodb.AttributeValue{BOOL: aws.Bool(false)}
+	}
+	if ownerID != "" {
+		conditions = append(conditions, fmt.Sprintf("%s = :owner", names.Of("ownerId")))
+		input.ExpressionAttributeValues[":owner"] = &dynamodb.AttributeValue{S: aws.String(ownerID)}
+	}
+	if status == types.StatusActive {
+		conditions = append(conditions, fmt.Sprintf("(attribute_not_exists(%[1]s) OR %[1]s = :status)", names.Of("status")))
+		input.ExpressionAttributeValues[":status"] = &dynamodb.AttributeValue{S: aws.String(status)}
+	} else if status != "" {
+		conditions = append(conditions, fmt.Sprintf("%s = :status", names.Of("status")))
+		input.ExpressionAttributeValues[":status"] = &dynamodb.AttributeValue{S: aws.String(status)}
+	}
+	if len(conditions) > 0 {
+		input.FilterExpression = aws.String(strings.Join(conditions, " AND "))
+	}
+
+	var items []map[string]*dynamodb.AttributeValue
+	for {
+		// Calling either Query function of interface, defined in getDevicesByModel_test.go file, or api with the input we've provided.
+		// In real deployment environment, the Query function of aws (api.go) will be called.
+		result, err := self.DynamoDB.Query(input)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, result.Items...)
+		// DynamoDB has more items for us only when it returns a LastEvaluatedKey.
+		if len(result.LastEvaluatedKey) == 0 {
+			return items, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+// The handler function which will be first started from main function.
+// The model is taken from the path, or from the query string as model values usually contain slashes.
+func GetDevicesByModel(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !gateway.AllowsMethod(request, "GET") {
+		return gateway.MethodNotAllowed("GET"), nil
+	}
+
+	model := request.PathParameters["model"]
+	if model == "" {
+		model = request.QueryStringParameters["model"]
+	}
+	// if no model is provided, return HTTP error code 400.
+	if model == "" {
+		return events.APIGatewayProxyResponse{
+			Body:       "Missing parameter: model",
+			StatusCode: 400,
+		}, nil
+	}
+
+	// Soft deleted devices are hidden, unless "includeDeleted=true" is asked for.
+	includeDeleted := false
+	if rawIncludeDeleted, ok := request.QueryStringParameters["includeDeleted"]; ok {
+		var err error
+		includeDeleted, err = strconv.ParseBool(rawIncludeDeleted)
+		if err != nil {
+			return events.APIGatewayProxyResponse{
+				Body:       "Invalid parameter: includeDeleted must be a boolean.",
+				StatusCode: 400,
+			}, nil
+		}
+	}
+
+	// Only the devices in the status "status", if it's given.
+	status := request.QueryStringParameters["status"]
+	if status != "" && !validation.ValidStatus(status) {
+		return events.APIGatewayProxyResponse{
+			Body:       validation.StatusFailure("Invalid parameter: status") + ".",
+			StatusCode: 400,
+		}, nil
+	}
+
+	// Only the devices created after "since", i.e: "since=2024-01-02T03:04:05Z", if it's given.
+	// It's compared as stored, in UTC and to the second.
+	since := ""
+	if rawSince, ok := request.QueryStringParameters["since"]; ok {
+		sinceTime, err := time.Parse(time.RFC3339, rawSince)
+		if err != nil {
+			return events.APIGatewayProxyResponse{
+				Body:       "Invalid parameter: since must be an RFC3339 time, i.e: 2024-01-02T03:04:05Z.",
+				StatusCode: 400,
+			}, nil
+		}
+		since = sinceTime.UTC().Format(time.RFC3339)
+	}
+
+	// Never the devices of another tenant.
+	items, err := TestAws.QueryByModel(model, since, includeDeleted, owner.Caller(request), status)
+
+	// If an internal error have occurred in the database, return HTTP error code 500.
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:       "Internal Server Error.",
+			StatusCode: 500,
+		}, nil
+	}
+
+	// Deserialization/Decoding "items" to Go structs.
+	// Starting from an empty slice, so no matching device is returned as "[]" instead of "null".
+	devices := []types.Device{}
+	err = dynamodbattribute.UnmarshalListOfMaps(items, &devices)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:       "Internal Server Error.",
+			StatusCode: 500,
+		}, nil
+	}
+
+	// Return the matching devices as a JSON array with 200 HTTP status code.
+	devicesJson, _ := negotiation.Marshal(negotiation.JSON, devices)
+	return events.APIGatewayProxyResponse{
+		Body:       string(devicesJson),
+		StatusCode: 200,
+	}, nil
+} // End of GetDevicesByModel function
+
+// Name of the index keyed by deviceModel, taken from OS's environment (MODEL_INDEX_NAME) and defaulting to
+// "StoredModel-index", so a deployment whose index is named otherwise needs no code change.
+func deviceModelIndex() string {
+	if name := os.Getenv("MODEL_INDEX_NAME"); name != "" {
+		return name
+	}
+	return defaultDeviceModelIndex
+}
+
+// Name of the index keyed by deviceModel and sorted by createdAt, taken from OS's environment (MODEL_CREATED_INDEX_NAME)
+// and defaulting to "StoredModelCreatedAt-index".
+func modelCreatedIndex() string {
+	if name := os.Getenv("MODEL_CREATED_INDEX_NAME"); name != "" {
+		return name
+	}
+	return defaultModelCreatedIndex
+}
+
+func main() {
+	lambda.Start(gateway.Adapt(recovery.WithRecover(GetDevicesByModel)))
+}
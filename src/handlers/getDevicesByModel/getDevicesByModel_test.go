@@ -0,0 +1,325 @@
+package main
+
+import (
+	"errors"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"os"
+	"strings"
+	"testing"
+)
+
+type TestCase struct {
+	Name               string
+	Request            events.APIGatewayProxyRequest
+	ExpectedBody       string
+	ExpectedStatusCode int
+}
+
+// Mocking DynamoDB through dynamodbiface.
+type MockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	// Items of the mocked table, the error which the mocked Query returns, and the index and key condition it has been called with.
+	Items        []map[string]*dynamodb.AttributeValue
+	Error        error
+	IndexName    string
+	KeyCondition string
+}
+
+// Custom Query function for overriding the Query of getDevicesByModel.go for using in test scenarios.
+// Mocking the "deviceModel = :model" key condition, along with "createdAt > :since" when it's given, returning one item
+// per page to exercise the paging.
+// Soft deleted items, the ones of another owner and of another status are dropped by the filter, after the paging same as DynamoDB.
+func (self *MockDynamoDB) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	if self.Error != nil {
+		return nil, self.Error
+	}
+	self.IndexName = aws.StringValue(input.IndexName)
+	self.KeyCondition = aws.StringValue(input.KeyConditionExpression)
+	model := aws.StringValue(input.ExpressionAttributeValues[":model"].S)
+	since := input.ExpressionAttributeValues[":since"]
+	var matching []map[string]*dynamodb.AttributeValue
+	for _, item := range self.Items {
+		if aws.StringValue(item["device_model"].S) != model {
+			continue
+		}
+		// An item without the RANGE key of the index isn't in the index at all.
+		if since != nil && (item["createdAt"] == nil || aws.StringValue(item["createdAt"].S) <= aws.StringValue(since.S)) {
+			continue
+		}
+		matching = append(matching, item)
+	}
+	start := 0
+	if input.ExclusiveStartKey != nil {
+		for i, item := range matching {
+			if aws.StringValue(item["id"].S) == aws.StringValue(input.ExclusiveStartKey["id"].S) {
+				start = i + 1
+			}
+		}
+	}
+	MockOutput := new(dynamodb.QueryOutput)
+	if start < len(matching) {
+		MockOutput.SetItems(matching[start : start+1])
+		if start+1 < len(matching) {
+			MockOutput.SetLastEvaluatedKey(map[string]*dynamodb.AttributeValue{"id": matching[start]["id"]})
+		}
+		if deleted := matching[start]["deleted"]; input.ExpressionAttributeValues[":false"] != nil && deleted != nil && aws.BoolValue(deleted.BOOL) {
+			MockOutput.Items = nil
+		}
+		if ownerID := input.ExpressionAttributeValues[":owner"]; ownerID != nil && (matching[start]["ownerId"] == nil || aws.StringValue(matching[start]["ownerId"].S) != aws.StringValue(ownerID.S)) {
+			MockOutput.Items = nil
+		}
+		if status := input.ExpressionAttributeValues[":status"]; status != nil {
+			stored := matching[start]["status"]
+			if (stored == nil && !strings.Contains(aws.StringValue(input.FilterExpression), "attribute_not_exists(#status)")) || (stored != nil && aws.StringValue(stored.S) != aws.StringValue(status.S)) {
+				MockOutput.Items = nil
+			}
+		}
+	}
+	return MockOutput, nil
+}
+
+// Building a stored device of the mocked table.
+func testItem(id string, model string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"id":           {S: aws.String(id)},
+		"device_model": {S: aws.String(model)},
+		"name":         {S: aws.String("name_" + id)},
+		"note":         {S: aws.String("note_test")},
+		"serial":       {S: aws.String("serial_" + id)},
+	}
+}
+
+// GetDevicesByModel function in getDevicesByModel.go signature: input: (request events.APIGatewayProxyRequest), output: (events.APIGatewayProxyResponse, error)
+func TestGetDevicesByModel(t *testing.T) {
+	mock := &MockDynamoDB{Items: []map[string]*dynamodb.AttributeValue{
+		testItem("id_test1", "/devicemodels/id1"),
+		testItem("id_test2", "/devicemodels/id2"),
+		testItem("id_test3", "/devicemodels/id1"),
+		testItem("id_test4", "/devicemodels/id3"),
+	}}
+	mock.Items[3]["deleted"] = &dynamodb.AttributeValue{BOOL: aws.Bool(true)}
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	testCases := []TestCase{
+		{
+			Name:               "** Testing: Two devices of the model, over two pages. **",
+			Request:            events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"model": "/devicemodels/id1"}},
+			ExpectedBody:       "[{\"id\":\"id_test1\",\"deviceModel\":\"/devicemodels/id1\",\"name\":\"name_id_test1\",\"note\":\"note_test\",\"serial\":\"serial_id_test1\"},{\"id\":\"id_test3\",\"deviceModel\":\"/devicemodels/id1\",\"name\":\"name_id_test3\",\"note\":\"note_test\",\"serial\":\"serial_id_test3\"}]",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Testing: Model from the path. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"model": "/devicemodels/id2"}},
+			ExpectedBody:       "[{\"id\":\"id_test2\",\"deviceModel\":\"/devicemodels/id2\",\"name\":\"name_id_test2\",\"note\":\"note_test\",\"serial\":\"serial_id_test2\"}]",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Testing: No device of the model. **",
+			Request:            events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"model": "/devicemodels/id9"}},
+			ExpectedBody:       "[]",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Testing: Soft deleted device is hidden. **",
+			Request:            events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"model": "/devicemodels/id3"}},
+			ExpectedBody:       "[]",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Testing: Soft deleted device with includeDeleted. **",
+			Request:            events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"model": "/devicemodels/id3", "includeDeleted": "true"}},
+			ExpectedBody:       "[{\"id\":\"id_test4\",\"deviceModel\":\"/devicemodels/id3\",\"name\":\"name_id_test4\",\"note\":\"note_test\",\"serial\":\"serial_id_test4\",\"deleted\":true}]",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Testing: Missing model. **",
+			Request:            events.APIGatewayProxyRequest{},
+			ExpectedBody:       "Missing parameter: model",
+			ExpectedStatusCode: 400,
+		},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := GetDevicesByModel(test.Request)
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+	if mock.IndexName != defaultDeviceModelIndex {
+		t.Errorf("** Testing: Queried index. ** \n \t<expected index: %s> <resulted index: %s>", defaultDeviceModelIndex, mock.IndexName)
+	}
+
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{Error: errors.New("unexpected Error has occurred")}}
+	response, _ := GetDevicesByModel(events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"model": "/devicemodels/id1"}})
+	if response.StatusCode != 500 {
+		t.Errorf("** Database Unexpected Error ** \n \t<expected error-code: %d> <resulted error-code: %d>", 500, response.StatusCode)
+	}
+} // End of TestGetDevicesByModel function
+
+// Only the devices of the caller's tenant are returned.
+func TestGetDevicesByModelOwner(t *testing.T) {
+	owned := func(id string, ownerID string) map[string]*dynamodb.AttributeValue {
+		item := testItem(id, "/devicemodels/id1")
+		item["ownerId"] = &dynamodb.AttributeValue{S: aws.String(ownerID)}
+		return item
+	}
+	mock := &MockDynamoDB{Items: []map[string]*dynamodb.AttributeValue{owned("id_test1", "tenant-a"), owned("id_test2", "tenant-b")}}
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	testCases := []TestCase{
+		{
+			Name:               "** Testing: Devices of the caller's tenant. **",
+			Request:            events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"model": "/devicemodels/id1"}, RequestContext: events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"claims": map[string]interface{}{"sub": "tenant-a"}}}},
+			ExpectedBody:       "[{\"id\":\"id_test1\",\"deviceModel\":\"/devicemodels/id1\",\"name\":\"name_id_test1\",\"note\":\"note_test\",\"serial\":\"serial_id_test1\",\"ownerId\":\"tenant-a\"}]",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Testing: Tenant without devices of the model. **",
+			Request:            events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"model": "/devicemodels/id1", "includeDeleted": "true"}, RequestContext: events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"sub": "tenant-c"}}},
+			ExpectedBody:       "[]",
+			ExpectedStatusCode: 200,
+		},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := GetDevicesByModel(test.Request)
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+} // End of TestGetDevicesByModelOwner function
+
+// Only the devices of the model in the asked status are returned, the devices without a status are active.
+func TestGetDevicesByModelStatus(t *testing.T) {
+	retired := testItem("id_test2", "/devicemodels/id1")
+	retired["status"] = &dynamodb.AttributeValue{S: aws.String("retired")}
+	mock := &MockDynamoDB{Items: []map[string]*dynamodb.AttributeValue{testItem("id_test1", "/devicemodels/id1"), retired}}
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	testCases := []TestCase{
+		{
+			Name:               "** Testing: Active devices of the model. **",
+			Request:            events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"model": "/devicemodels/id1", "status": "active"}},
+			ExpectedBody:       "[{\"id\":\"id_test1\",\"deviceModel\":\"/devicemodels/id1\",\"name\":\"name_id_test1\",\"note\":\"note_test\",\"serial\":\"serial_id_test1\"}]",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Testing: Retired devices of the model. **",
+			Request:            events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"model": "/devicemodels/id1", "status": "retired"}},
+			ExpectedBody:       "[{\"id\":\"id_test2\",\"deviceModel\":\"/devicemodels/id1\",\"name\":\"name_id_test2\",\"note\":\"note_test\",\"serial\":\"serial_id_test2\",\"status\":\"retired\"}]",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Testing: Unknown status. **",
+			Request:            events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"model": "/devicemodels/id1", "status": "Active"}},
+			ExpectedBody:       "Invalid parameter: status must be one of active, inactive, retired.",
+			ExpectedStatusCode: 400,
+		},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := GetDevicesByModel(test.Request)
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+} // End of TestGetDevicesByModelStatus function
+
+// The index named by MODEL_INDEX_NAME is queried instead of the default one.
+func TestGetDevicesByModelIndexName(t *testing.T) {
+	mock := &MockDynamoDB{}
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	os.Setenv("MODEL_INDEX_NAME", "Model-gsi")
+	defer func() {
+		TestAws = realAws
+		os.Unsetenv("MODEL_INDEX_NAME")
+	}()
+
+	GetDevicesByModel(events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"model": "/devicemodels/id1"}})
+	if mock.IndexName != "Model-gsi" {
+		t.Errorf("** Testing: Configured index. ** \n \t<expected index: %s> <resulted index: %s>", "Model-gsi", mock.IndexName)
+	}
+} // End of TestGetDevicesByModelIndexName function
+
+// Only the devices of the model created after the asked time are returned, by the key condition of the sorted index.
+func TestGetDevicesByModelSince(t *testing.T) {
+	created := func(id string, model string, createdAt string) map[string]*dynamodb.AttributeValue {
+		item := testItem(id, model)
+		if createdAt != "" {
+			item["createdAt"] = &dynamodb.AttributeValue{S: aws.String(createdAt)}
+		}
+		return item
+	}
+	mock := &MockDynamoDB{Items: []map[string]*dynamodb.AttributeValue{
+		created("id_test1", "RPi4", "2024-01-01T00:00:00Z"),
+		created("id_test2", "RPi4", "2024-01-09T12:00:00Z"),
+		created("id_test3", "RPi3", "2024-01-09T12:00:00Z"),
+		created("id_test4", "RPi4", ""),
+	}}
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	expectedBody := "[{\"id\":\"id_test2\",\"deviceModel\":\"RPi4\",\"name\":\"name_id_test2\",\"note\":\"note_test\",\"serial\":\"serial_id_test2\",\"createdAt\":\"2024-01-09T12:00:00Z\"}]"
+	testCases := []TestCase{
+		{
+			Name:               "** Testing: Devices of the model created since a time. **",
+			Request:            events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"model": "RPi4", "since": "2024-01-02T00:00:00Z"}},
+			ExpectedBody:       expectedBody,
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Testing: Since in another time zone. **",
+			Request:            events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"model": "RPi4", "since": "2024-01-09T13:00:00+02:00"}},
+			ExpectedBody:       expectedBody,
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Testing: No device created since a time. **",
+			Request:            events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"model": "RPi4", "since": "2024-01-09T12:00:00Z"}},
+			ExpectedBody:       "[]",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Testing: Since without a time. **",
+			Request:            events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"model": "RPi4", "since": "2024-01-02"}},
+			ExpectedBody:       "Invalid parameter: since must be an RFC3339 time, i.e: 2024-01-02T03:04:05Z.",
+			ExpectedStatusCode: 400,
+		},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := GetDevicesByModel(test.Request)
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+	if expected := "#deviceModel = :model AND #createdAt > :since"; mock.IndexName != "StoredModelCreatedAt-index" || mock.KeyCondition != expected {
+		t.Errorf("** Testing: Combined key condition. ** \n \t<expected index: StoredModelCreatedAt-index, key condition: %s> <resulted index: %s, key condition: %s>", expected, mock.IndexName, mock.KeyCondition)
+	}
+} // End of TestGetDevicesByModelSince function
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"gateway"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"negotiation"
+	"os"
+	"owner"
+	"placeholder"
+	"recovery"
+	"strings"
+	"types"
+)
+
+type AmazonWebServices struct {
+	Config   *aws.Config
+	Session  *session.Session
+	DynamoDB dynamodbiface.DynamoDBAPI
+}
+
+// Prepare a new AWS & DynamoDB session, then configure it.
+var TestAws *AmazonWebServices
+
+// Default name of the global secondary index of the devices table which is keyed by ownerId and sorted by serial.
+const defaultOwnerSerialIndex = "OwnerSerial-index"
+
+func init() {
+	region := os.Getenv("AWS_REGION")
+	var Aws *AmazonWebServices = new(AmazonWebServices)
+	Aws.Config = &aws.Config{Region: aws.String(region)}
+	// Pointing the client to a local DynamoDB, i.e: DynamoDB Local for the integration tests. It's unset in production.
+	if endpoint := os.Getenv("DYNAMODB_ENDPOINT"); endpoint != "" {
+		Aws.Config.Endpoint = aws.String(endpoint)
+	}
+	var err error
+	Aws.Session, err = session.NewSession(Aws.Config)
+	if err != nil {
+		// Logs error on Amazon CloudWatch. It's sysadmin's duty to handle it.
+		fmt.Println(fmt.Sprintf("Failed to connect to AWS: %s", err.Error()))
+	} else {
+		var svc *dynamodb.DynamoDB = dynamodb.New(Aws.Session)
+		Aws.DynamoDB = dynamodbiface.DynamoDBAPI(svc)
+	}
+	// Instantiate a global session in TestAws
+	TestAws = Aws
+}
+
+// Preparing DynamoDB Session and looking up the devices whose serial starts with prefix, following all the pages of the result.
+// DynamoDB only takes begins_with on the sort key of an index, so it requires a global secondary index on the
+// devices table, named by ownerSerialIndex, with "ownerId" (S) as its HASH key, "serial" (S) as its RANGE key and an ALL
+// projection, which is queried for the devices of ownerID. A single tenant deployment has no owner to key the index on,
+// so without an ownerID the table is scanned with the same begins_with as a filter. Soft deleted devices are filtered out.
+func (self *AmazonWebServices) QueryPrefix(prefix string, ownerID string) ([]map[string]*dynamodb.AttributeValue, error) {
+	// Get desire table's name from OS's environmental varible.
+	tableName := aws.String(os.Getenv("DEVICES_TABLE_NAME"))
+
+	names := placeholder.Names{}
+	matches := fmt.Sprintf("begins_with(%s, :prefix)", names.Of("serial"))
+	notDeleted := fmt.Sprintf("(attribute_not_exists(%[1]s) OR %[1]s = :false)", names.Of("deleted"))
+	values := map[string]*dynamodb.AttributeValue{
+		":prefix": {S: aws.String(prefix)},
+		":false":  {BOOL: aws.Bool(false)},
+	}
+
+	var items []map[string]*dynamodb.AttributeValue
+	if ownerID == "" {
+		var input = &dynamodb.ScanInput{
+			TableName:                 tableName,
+			FilterExpression:          aws.String(matches + " AND " + notDeleted),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+		}
+		for {
+			// Calling either Scan function of interface, defined in getDevicesBySerial_test.go file, or api with the input we've provided.
+			result, err := self.DynamoDB.Scan(input)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, result.Items...)
+			// DynamoDB has more items for us only when it returns a LastEvaluatedKey.
+			if len(result.LastEvaluatedKey) == 0 {
+				return items, nil
+			}
+			input.ExclusiveStartKey = result.LastEvaluatedKey
+		}
+	}
+
+	values[":owner"] = &dynamodb.AttributeValue{S: aws.String(ownerID)}
+	var input = &dynamodb.QueryInput{
+		TableName:                 tableName,
+		IndexName:                 aws.String(ownerSerialIndex()),
+		KeyConditionExpression:    aws.String(fmt.Sprintf("%s = :owner AND %s", names.Of("ownerId"), matches)),
+		FilterExpression:          aws.String(notDeleted),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	}
+	for {
+		// Calling either Query function of interface, defined in getDevicesBySerial_test.go file, or api with the input we've provided.
+		// In real deployment environment, the Query function of aws (api.go) will be called.
+		result, err := self.DynamoDB.Query(input)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, result.Items...)
+		// DynamoDB has more items for us only when it returns a LastEvaluatedKey.
+		if len(result.LastEvaluatedKey) == 0 {
+			return items, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+// The handler function which will be first started from main function.
+// The prefix of the serials is taken from the query string, i.e: "?prefix=SN-2024-".
+func GetDevicesBySerial(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !gateway.AllowsMethod(request, "GET") {
+		return gateway.MethodNotAllowed("GET"), nil
+	}
+
+	prefix := request.QueryStringParameters["prefix"]
+	// if no prefix is provided, return HTTP error code 400. A prefix of only whitespace would match every serial.
+	if strings.TrimSpace(prefix) == "" {
+		return events.APIGatewayProxyResponse{
+			Body:       "Missing parameter: prefix",
+			StatusCode: 400,
+		}, nil
+	}
+
+	// Never the devices of another tenant.
+	items, err := TestAws.QueryPrefix(prefix, owner.Caller(request))
+
+	// If an internal error have occurred in the database, return HTTP error code 500.
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Failed to look up the serial prefix: %s", err.Error()))
+		return events.APIGatewayProxyResponse{
+			Body:       "Internal Server Error.",
+			StatusCode: 500,
+		}, nil
+	}
+
+	// Deserialization/Decoding "items" to Go structs.
+	// Starting from an empty slice, so no matching device is returned as "[]" instead of "null".
+	devices := []types.Device{}
+	err = dynamodbattribute.UnmarshalListOfMaps(items, &devices)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:       "Internal Server Error.",
+			StatusCode: 500,
+		}, nil
+	}
+
+	// Return the matching devices as a JSON array with 200 HTTP status code.
+	devicesJson, _ := negotiation.Marshal(negotiation.JSON, devices)
+	return events.APIGatewayProxyResponse{
+		Body:       string(devicesJson),
+		StatusCode: 200,
+	}, nil
+} // End of GetDevicesBySerial function
+
+// Name of the index keyed by ownerId and sorted by serial, taken from OS's environment (SERIAL_INDEX_NAME) and
+// defaulting to "OwnerSerial-index", so a deployment whose index is named otherwise needs no code change.
+func ownerSerialIndex() string {
+	if name := os.Getenv("SERIAL_INDEX_NAME"); name != "" {
+		return name
+	}
+	return defaultOwnerSerialIndex
+}
+
+func main() {
+	lambda.Start(gateway.Adapt(recovery.WithRecover(GetDevicesBySerial)))
+}
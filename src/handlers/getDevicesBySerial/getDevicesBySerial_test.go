@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"os"
+	"strings"
+	"testing"
+)
+
+type TestCase struct {
+	Name               string
+	Request            events.APIGatewayProxyRequest
+	ExpectedBody       string
+	ExpectedStatusCode int
+}
+
+// Mocking DynamoDB through dynamodbiface.
+type MockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	// Items of the mocked table, the error which the mocked Query and Scan return and the index which has been queried.
+	Items     []map[string]*dynamodb.AttributeValue
+	Error     error
+	IndexName string
+}
+
+// Keeping the items whose serial begins with ":prefix", of ":owner" if it's given, and which aren't soft deleted.
+func (self *MockDynamoDB) matching(values map[string]*dynamodb.AttributeValue) []map[string]*dynamodb.AttributeValue {
+	var matching []map[string]*dynamodb.AttributeValue
+	for _, item := range self.Items {
+		if !strings.HasPrefix(aws.StringValue(item["serial"].S), aws.StringValue(values[":prefix"].S)) {
+			continue
+		}
+		if ownerID := values[":owner"]; ownerID != nil && (item["ownerId"] == nil || aws.StringValue(item["ownerId"].S) != aws.StringValue(ownerID.S)) {
+			continue
+		}
+		if deleted := item["deleted"]; deleted != nil && aws.BoolValue(deleted.BOOL) {
+			continue
+		}
+		matching = append(matching, item)
+	}
+	return matching
+}
+
+// Custom Query function for overriding the Query of getDevicesBySerial.go for using in test scenarios.
+// Mocking the "#ownerId = :owner AND begins_with(#serial, :prefix)" key condition of the OwnerSerial-index.
+func (self *MockDynamoDB) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	if self.Error != nil {
+		return nil, self.Error
+	}
+	self.IndexName = aws.StringValue(input.IndexName)
+	return &dynamodb.QueryOutput{Items: self.matching(input.ExpressionAttributeValues)}, nil
+}
+
+// Custom Scan function for overriding the Scan of getDevicesBySerial.go for using in test scenarios.
+// Mocking the "begins_with(#serial, :prefix)" filter of a single tenant deployment.
+func (self *MockDynamoDB) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	if self.Error != nil {
+		return nil, self.Error
+	}
+	return &dynamodb.ScanOutput{Items: self.matching(input.ExpressionAttributeValues)}, nil
+}
+
+// Building a stored device of the mocked table.
+func testItem(id string, serial string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"id":           {S: aws.String(id)},
+		"device_model": {S: aws.String("/devicemodels/id1")},
+		"name":         {S: aws.String("name_" + id)},
+		"note":         {S: aws.String("note_test")},
+		"serial":       {S: aws.String(serial)},
+	}
+}
+
+// GetDevicesBySerial function in getDevicesBySerial.go signature: input: (request events.APIGatewayProxyRequest), output: (events.APIGatewayProxyResponse, error)
+func TestGetDevicesBySerial(t *testing.T) {
+	mock := &MockDynamoDB{Items: []map[string]*dynamodb.AttributeValue{
+		testItem("id_test1", "SN-2024-001"),
+		testItem("id_test2", "SN-2023-001"),
+		testItem("id_test3", "SN-2024-002"),
+	}}
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	testCases := []TestCase{
+		{
+			Name:               "** Testing: Prefix of two of three devices. **",
+			Request:            events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"prefix": "SN-2024-"}},
+			ExpectedBody:       "[{\"id\":\"id_test1\",\"deviceModel\":\"/devicemodels/id1\",\"name\":\"name_id_test1\",\"note\":\"note_test\",\"serial\":\"SN-2024-001\"},{\"id\":\"id_test3\",\"deviceModel\":\"/devicemodels/id1\",\"name\":\"name_id_test3\",\"note\":\"note_test\",\"serial\":\"SN-2024-002\"}]",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Testing: Prefix of no device. **",
+			Request:            events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"prefix": "SN-2025-"}},
+			ExpectedBody:       "[]",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Testing: Missing prefix. **",
+			Request:            events.APIGatewayProxyRequest{},
+			ExpectedBody:       "Missing parameter: prefix",
+			ExpectedStatusCode: 400,
+		},
+
+		{
+			Name:               "** Testing: Prefix of only whitespace. **",
+			Request:            events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"prefix": "  "}},
+			ExpectedBody:       "Missing parameter: prefix",
+			ExpectedStatusCode: 400,
+		},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := GetDevicesBySerial(test.Request)
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{Error: errors.New("unexpected Error has occurred")}}
+	response, _ := GetDevicesBySerial(events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"prefix": "SN-"}})
+	if response.StatusCode != 500 {
+		t.Errorf("** Database Unexpected Error ** \n \t<expected error-code: %d> <resulted error-code: %d>", 500, response.StatusCode)
+	}
+} // End of TestGetDevicesBySerial function
+
+// With a caller the OwnerSerial-index is queried, for the matching devices of its tenant only.
+func TestGetDevicesBySerialOwner(t *testing.T) {
+	owned := func(id string, serial string, ownerID string) map[string]*dynamodb.AttributeValue {
+		item := testItem(id, serial)
+		item["ownerId"] = &dynamodb.AttributeValue{S: aws.String(ownerID)}
+		return item
+	}
+	mock := &MockDynamoDB{Items: []map[string]*dynamodb.AttributeValue{
+		owned("id_test1", "SN-2024-001", "tenant-a"),
+		owned("id_test2", "SN-2024-002", "tenant-b"),
+		owned("id_test3", "SN-2024-003", "tenant-a"),
+	}}
+	mock.Items[2]["deleted"] = &dynamodb.AttributeValue{BOOL: aws.Bool(true)}
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	response, _ := GetDevicesBySerial(events.APIGatewayProxyRequest{
+		QueryStringParameters: map[string]string{"prefix": "SN-2024-"},
+		RequestContext:        events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"sub": "tenant-a"}},
+	})
+	expected := "[{\"id\":\"id_test1\",\"deviceModel\":\"/devicemodels/id1\",\"name\":\"name_id_test1\",\"note\":\"note_test\",\"serial\":\"SN-2024-001\",\"ownerId\":\"tenant-a\"}]"
+	if response.StatusCode != 200 || response.Body != expected {
+		t.Errorf("** Testing: Matching devices of the caller's tenant. ** \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", 200, response.StatusCode, expected, response.Body)
+	}
+	if mock.IndexName != defaultOwnerSerialIndex {
+		t.Errorf("** Testing: Queried index. ** \n \t<expected index: %s> <resulted index: %s>", defaultOwnerSerialIndex, mock.IndexName)
+	}
+} // End of TestGetDevicesBySerialOwner function
+
+// The index named by SERIAL_INDEX_NAME is queried instead of the default one.
+func TestGetDevicesBySerialIndexName(t *testing.T) {
+	mock := &MockDynamoDB{}
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	os.Setenv("SERIAL_INDEX_NAME", "OwnerSerial-gsi")
+	defer func() {
+		TestAws = realAws
+		os.Unsetenv("SERIAL_INDEX_NAME")
+	}()
+
+	GetDevicesBySerial(events.APIGatewayProxyRequest{
+		QueryStringParameters: map[string]string{"prefix": "SN-"},
+		RequestContext:        events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"sub": "tenant-a"}},
+	})
+	if mock.IndexName != "OwnerSerial-gsi" {
+		t.Errorf("** Testing: Configured index. ** \n \t<expected index: %s> <resulted index: %s>", "OwnerSerial-gsi", mock.IndexName)
+	}
+} // End of TestGetDevicesBySerialIndexName function
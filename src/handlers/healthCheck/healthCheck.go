@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"gateway"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"os"
+	"recovery"
+	"strconv"
+	"types"
+)
+
+type AmazonWebServices struct {
+	Config   *aws.Config
+	Session  *session.Session
+	DynamoDB dynamodbiface.DynamoDBAPI
+}
+
+// Prepare a new AWS & DynamoDB session, then configure it.
+var TestAws *AmazonWebServices
+
+func init() {
+	region := os.Getenv("AWS_REGION")
+	var Aws *AmazonWebServices = new(AmazonWebServices)
+	Aws.Config = &aws.Config{Region: aws.String(region)}
+	// Pointing the client to a local DynamoDB, i.e: DynamoDB Local for the integration tests. It's unset in production.
+	if endpoint := os.Getenv("DYNAMODB_ENDPOINT"); endpoint != "" {
+		Aws.Config.Endpoint = aws.String(endpoint)
+	}
+	var err error
+	Aws.Session, err = session.NewSession(Aws.Config)
+	if err != nil {
+		// Logs error on Amazon CloudWatch. It's sysadmin's duty to handle it.
+		fmt.Println(fmt.Sprintf("Failed to connect to AWS: %s", err.Error()))
+	} else {
+		var svc *dynamodb.DynamoDB = dynamodb.New(Aws.Session)
+		Aws.DynamoDB = dynamodbiface.DynamoDBAPI(svc)
+	}
+	// Instantiate a global session in TestAws
+	TestAws = Aws
+}
+
+// Preparing DynamoDB Session and Calling DB's DescribeTable function inside.
+// Returns an error unless the devices table exists and serves requests, which it does while being updated too.
+func (self *AmazonWebServices) CheckTable() error {
+	// Get desire table's name from OS's environmental varible.
+	tableName := aws.String(os.Getenv("DEVICES_TABLE_NAME"))
+
+	if self.DynamoDB == nil {
+		return fmt.Errorf("no DynamoDB session")
+	}
+	// Calling either DescribeTable function of interface, defined in healthCheck_test.go file, or api with the input we've provided.
+	// In real deployment environment, the DescribeTable function of aws (api.go) will be called.
+	result, err := self.DynamoDB.DescribeTable(&dynamodb.DescribeTableInput{TableName: tableName})
+	if err != nil {
+		return err
+	}
+	status := aws.StringValue(result.Table.TableStatus)
+	if status != dynamodb.TableStatusActive && status != dynamodb.TableStatusUpdating {
+		return fmt.Errorf("table is %s", status)
+	}
+	return nil
+}
+
+// The handler function which will be first started from main function.
+// The shallow check only tells the function is alive, "deep=true" also checks the devices table on DynamoDB.
+func HealthCheck(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !gateway.AllowsMethod(request, "GET") {
+		return gateway.MethodNotAllowed("GET"), nil
+	}
+
+	deep := false
+	if rawDeep, ok := request.QueryStringParameters["deep"]; ok {
+		var err error
+		deep, err = strconv.ParseBool(rawDeep)
+		if err != nil {
+			return events.APIGatewayProxyResponse{
+				Body:       "Invalid parameter: deep must be a boolean.",
+				StatusCode: 400,
+			}, nil
+		}
+	}
+
+	if deep {
+		if err := TestAws.CheckTable(); err != nil {
+			// Logs error on Amazon CloudWatch, the response doesn't tell more than the service is unavailable.
+			fmt.Println(fmt.Sprintf("Health check failed: %s", err.Error()))
+			statusJson, _ := json.Marshal(types.HealthStatus{Status: "unavailable"})
+			return events.APIGatewayProxyResponse{
+				Body:       string(statusJson),
+				StatusCode: 503,
+			}, nil
+		}
+	}
+
+	statusJson, _ := json.Marshal(types.HealthStatus{Status: "ok"})
+	return events.APIGatewayProxyResponse{
+		Body:       string(statusJson),
+		StatusCode: 200,
+	}, nil
+} // End of HealthCheck function
+
+func main() {
+	lambda.Start(gateway.Adapt(recovery.WithRecover(HealthCheck)))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"testing"
+)
+
+// Mocking DynamoDB through dynamodbiface.
+type MockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	// Status of the table and the error which the mocked DescribeTable returns.
+	TableStatus string
+	Error       error
+	// Number of DescribeTable calls.
+	Describes int
+}
+
+// Custom DescribeTable function for overriding the DescribeTable of healthCheck.go for using in test scenarios.
+func (self *MockDynamoDB) DescribeTable(input *dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error) {
+	self.Describes++
+	if self.Error != nil {
+		return nil, self.Error
+	}
+	return &dynamodb.DescribeTableOutput{Table: &dynamodb.TableDescription{TableStatus: aws.String(self.TableStatus)}}, nil
+}
+
+// HealthCheck function in healthCheck.go signature: input: (request events.APIGatewayProxyRequest), output: (events.APIGatewayProxyResponse, error)
+func TestHealthCheck(t *testing.T) {
+	testCases := []struct {
+		Name               string
+		Request            events.APIGatewayProxyRequest
+		MockDatabase       *MockDynamoDB
+		ExpectedBody       string
+		ExpectedStatusCode int
+		ExpectedDescribes  int
+	}{
+		{
+			Name:               "** Testing: Shallow check doesn't call AWS. **",
+			MockDatabase:       &MockDynamoDB{Error: errors.New("unexpected Error has occurred")},
+			ExpectedBody:       "{\"status\":\"ok\"}",
+			ExpectedStatusCode: 200,
+			ExpectedDescribes:  0,
+		},
+
+		{
+			Name:               "** Testing: Deep check with an active table. **",
+			Request:            events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"deep": "true"}},
+			MockDatabase:       &MockDynamoDB{TableStatus: dynamodb.TableStatusActive},
+			ExpectedBody:       "{\"status\":\"ok\"}",
+			ExpectedStatusCode: 200,
+			ExpectedDescribes:  1,
+		},
+
+		{
+			Name:               "** Testing: Deep check with an unreachable table. **",
+			Request:            events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"deep": "true"}},
+			MockDatabase:       &MockDynamoDB{Error: errors.New("unexpected Error has occurred")},
+			ExpectedBody:       "{\"status\":\"unavailable\"}",
+			ExpectedStatusCode: 503,
+			ExpectedDescribes:  1,
+		},
+
+		{
+			Name:               "** Testing: Deep check with a table being deleted. **",
+			Request:            events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"deep": "true"}},
+			MockDatabase:       &MockDynamoDB{TableStatus: dynamodb.TableStatusDeleting},
+			ExpectedBody:       "{\"status\":\"unavailable\"}",
+			ExpectedStatusCode: 503,
+			ExpectedDescribes:  1,
+		},
+
+		{
+			Name:               "** Testing: deep is not a boolean. **",
+			Request:            events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"deep": "very"}},
+			MockDatabase:       &MockDynamoDB{},
+			ExpectedBody:       "Invalid parameter: deep must be a boolean.",
+			ExpectedStatusCode: 400,
+			ExpectedDescribes:  0,
+		},
+	}
+
+	realAws := TestAws
+	defer func() { TestAws = realAws }()
+
+	for _, test := range testCases {
+		// Executing each test cases scenario against its own mocked database.
+		TestAws = &AmazonWebServices{DynamoDB: test.MockDatabase}
+		response, _ := HealthCheck(test.Request)
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody || test.MockDatabase.Describes != test.ExpectedDescribes {
+			t.Errorf("%s \n \t<expected error-code: %d, describes: %d> <resulted error-code: %d, describes: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, test.ExpectedDescribes, response.StatusCode, test.MockDatabase.Describes, test.ExpectedBody, response.Body)
+		}
+	}
+} // End of TestHealthCheck function
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"attributes"
+	"audit"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"gateway"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"io"
+	"mime"
+	"os"
+	"owner"
+	"placeholder"
+	"recovery"
+	"strings"
+	"time"
+	"types"
+	"validation"
+)
+
+type AmazonWebServices struct {
+	Config   *aws.Config
+	Session  *session.Session
+	DynamoDB dynamodbiface.DynamoDBAPI
+}
+
+// Prepare a new AWS & DynamoDB session, then configure it.
+var TestAws *AmazonWebServices
+
+// Number of devices written by a single TransactWriteItems call, along with the markers of their serials.
+const batchSize = 25
+
+// How many times a throttled chunk is sent again, and the delay before the first retry which doubles each time.
+const maxBatchRetries = 5
+
+var batchRetryDelay = 50 * time.Millisecond
+
+// Columns of the CSV written by ExportDevices, so an export can be imported as is. Matched regardless of their case and order.
+var csvColumns = []string{"ID", "Name", "DeviceModel", "Serial", "Note"}
+
+func init() {
+	region := os.Getenv("AWS_REGION")
+	var Aws *AmazonWebServices = new(AmazonWebServices)
+	Aws.Config = &aws.Config{Region: aws.String(region)}
+	// Pointing the client to a local DynamoDB, i.e: DynamoDB Local for the integration tests. It's unset in production.
+	if endpoint := os.Getenv("DYNAMODB_ENDPOINT"); endpoint != "" {
+		Aws.Config.Endpoint = aws.String(endpoint)
+	}
+	var err error
+	Aws.Session, err = session.NewSession(Aws.Config)
+	if err != nil {
+		// Logs error on Amazon CloudWatch. It's sysadmin's duty to handle it.
+		fmt.Println(fmt.Sprintf("Failed to connect to AWS: %s", err.Error()))
+	} else {
+		var svc *dynamodb.DynamoDB = dynamodb.New(Aws.Session)
+		Aws.DynamoDB = dynamodbiface.DynamoDBAPI(svc)
+	}
+	// Instantiate a global session in TestAws
+	TestAws = Aws
+}
+
+// Preparing DynamoDB Session and Calling DB's TransactWriteItems function inside, in chunks of 25 devices.
+// Unlike BatchWriteItem, a transaction can be conditional, so a device whose id already exists, of this tenant or of
+// another one, is never overwritten. With SERIALS_TABLE_NAME, each device is written along with the marker of its
+// serial, same as AddDevice, so no two devices can share a serial. A device failing its condition cancels its whole
+// chunk, so it's reported and the rest of the chunk is sent again. A chunk cancelled without a device to blame,
+// i.e: because of throttling, is retried as a whole with an exponential backoff.
+// Returns why each of the devices which have not been written has failed, by id.
+func (self *AmazonWebServices) BatchPut(items []map[string]*dynamodb.AttributeValue) map[string]string {
+	// Get table names from OS's environment
+	tableName := aws.String(os.Getenv("DEVICES_TABLE_NAME"))
+	serialsTableName := aws.String(os.Getenv("SERIALS_TABLE_NAME"))
+	failed := map[string]string{}
+
+	deviceNames, markerNames := placeholder.Names{}, placeholder.Names{}
+	deviceCondition := aws.String(fmt.Sprintf("attribute_not_exists(%s)", deviceNames.Of("id")))
+	markerCondition := aws.String(fmt.Sprintf("attribute_not_exists(%s)", markerNames.Of("serial")))
+
+	for start := 0; start < len(items); start += batchSize {
+		end := start + batchSize
+		if end > len(items) {
+			end = len(items)
+		}
+		chunk := items[start:end]
+
+		delay := batchRetryDelay
+		for retries := 0; len(chunk) > 0; {
+			// The position in the chunk of the device which each item of the transaction writes, in their order.
+			var transactItems []*dynamodb.TransactWriteItem
+			var devices []int
+			for i, item := range chunk {
+				transactItems = append(transactItems, &dynamodb.TransactWriteItem{Put: &dynamodb.Put{
+					TableName:                tableName,
+					Item:                     item,
+					ConditionExpression:      deviceCondition,
+					ExpressionAttributeNames: deviceNames,
+				}})
+				devices = append(devices, i)
+				if marksSerials() && item["serial"] != nil {
+					transactItems = append(transactItems, &dynamodb.TransactWriteItem{Put: &dynamodb.Put{
+						TableName:                serialsTableName,
+						Item:                     map[string]*dynamodb.AttributeValue{"serial": item["serial"], "id": item["id"]},
+						ConditionExpression:      markerCondition,
+						ExpressionAttributeNames: markerNames,
+					}})
+					devices = append(devices, i)
+				}
+			}
+			// Calling either TransactWriteItems function of interface, defined in importDevices_test.go file, or api with the input we've provided.
+			// In real deployment environment, the TransactWriteItems function of aws (api.go) will be called.
+			_, err := self.DynamoDB.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: transactItems})
+			if err == nil {
+				break
+			}
+
+			// The reasons are in the order of the items, "None" for an item which has not caused the cancellation.
+			rejected := map[int]bool{}
+			canceled, ok := err.(*dynamodb.TransactionCanceledException)
+			if ok && len(canceled.CancellationReasons) == len(transactItems) {
+				for j, reason := range canceled.CancellationReasons {
+					if aws.StringValue(reason.Code) != "ConditionalCheckFailed" {
+						continue
+					}
+					rejected[devices[j]] = true
+					id := aws.StringValue(chunk[devices[j]]["id"].S)
+					if transactItems[j].Put.TableName == tableName {
+						failed[id] = "Device with this ID already exists."
+					} else if failed[id] == "" {
+						failed[id] = "Serial already registered"
+					}
+				}
+			}
+			// Without a device to blame, i.e: a throttled or conflicting transaction, the whole chunk is sent again.
+			if len(rejected) == 0 && ok && retries < maxBatchRetries {
+				retries++
+				time.Sleep(delay)
+				delay *= 2
+				continue
+			}
+			if len(rejected) == 0 {
+				// Logs error on Amazon CloudWatch, the rest of the chunk is reported as failed.
+				fmt.Println(fmt.Sprintf("Failed to write a batch of devices: %s", err.Error()))
+				for _, item := range chunk {
+					failed[aws.StringValue(item["id"].S)] = "Internal Server Error: device could not be written."
+				}
+				break
+			}
+			var remaining []map[string]*dynamodb.AttributeValue
+			for i, item := range chunk {
+				if !rejected[i] {
+					remaining = append(remaining, item)
+				}
+			}
+			chunk = remaining
+		}
+	}
+	return failed
+}
+
+// Checking whether the devices are written along with the markers of their serials, see BatchPut.
+// As in AddDevice, SKIP_SERIAL_CHECK=true writes them without, i.e: while migrating data known to be unique.
+func marksSerials() bool {
+	return os.Getenv("SERIALS_TABLE_NAME") != "" && os.Getenv("SKIP_SERIAL_CHECK") != "true"
+}
+
+// The handler function which will be first started from main function.
+// The body is a CSV file with a header row, i.e: one of ExportDevices. Each row is validated like in AddDevice and only
+// the valid ones are written, the response reports every rejected row by its line number so it can be fixed and sent again.
+func ImportDevices(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !gateway.AllowsMethod(request, "POST") {
+		return gateway.MethodNotAllowed("POST"), nil
+	}
+
+	if len(request.Body) == 0 {
+		return events.APIGatewayProxyResponse{
+			Body:       "No inputs provided, please provide inputs in CSV format.",
+			StatusCode: 400,
+		}, nil
+	}
+
+	if err := validation.CheckBodySize(request); err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:       err.Error(),
+			StatusCode: 413,
+		}, nil
+	}
+
+	if !isCSV(headerValue(request.Headers, "Content-Type")) {
+		return events.APIGatewayProxyResponse{
+			Body:       "Unsupported Media Type: Content-Type must be text/csv.",
+			StatusCode: 415,
+		}, nil
+	}
+
+	rows, lines, err := readCSV(request.Body)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:       fmt.Sprintf("Wrong format: Inputs must be a valid CSV, %s.", err.Error()),
+			StatusCode: 400,
+		}, nil
+	}
+
+	result := types.ImportResult{Errors: []types.ImportRowError{}}
+	var items []map[string]*dynamodb.AttributeValue
+	// Line of each valid device in the body, by its id, and the serials of the valid devices.
+	importedLines := map[string]int{}
+	serials := map[string]bool{}
+	createdAt := time.Now().UTC().Format(time.RFC3339)
+	ownerID := owner.Caller(request)
+
+	for i, NewDevice := range rows {
+		NewDevice = validation.NormalizeDevice(validation.SanitizeDevice(NewDevice))
+		Failures := validation.ValidateDevice(NewDevice)
+		// DynamoDB rejects a whole batch which writes the same id twice.
+		if _, duplicate := importedLines[NewDevice.ID]; duplicate {
+			Failures = append(Failures, types.FieldError{Field: "id", Code: validation.CodeInvalidValue, Message: "Invalid field: ID is duplicated in the import"})
+		}
+		// Nor can a transaction write the marker of the same serial twice.
+		if marksSerials() && NewDevice.Serial != "" && serials[NewDevice.Serial] {
+			Failures = append(Failures, types.FieldError{Field: "serial", Code: validation.CodeInvalidValue, Message: "Invalid field: Serial is duplicated in the import"})
+		}
+		if len(Failures) > 0 {
+			result.Errors = append(result.Errors, types.ImportRowError{Line: lines[i], Message: Failures.Error()})
+			continue
+		}
+
+		// Timestamps, version and owner are set on the server side, same as AddDevice.
+		NewDevice.CreatedAt = createdAt
+		NewDevice.OwnerID = ownerID
+		NewDevice.Version = 1
+		NewDevice.Status = types.StatusActive
+		item, _ := attributes.Marshal(NewDevice)
+		items = append(items, item)
+		importedLines[NewDevice.ID] = lines[i]
+		serials[NewDevice.Serial] = true
+	}
+
+	// Reporting the rows which DynamoDB has not written, in the order of their lines, and recording who has created
+	// each written device for the audit trail like AddDevice does. They have been written anyway, so a failed record
+	// is only logged.
+	failed := TestAws.BatchPut(items)
+	for _, item := range items {
+		id := aws.StringValue(item["id"].S)
+		if failure, ok := failed[id]; ok {
+			result.Errors = append(result.Errors, types.ImportRowError{Line: importedLines[id], Message: failure})
+			continue
+		}
+		// Recording who has imported the device for the audit trail. It has been written anyway, so a failure is only logged.
+		if err := audit.Write(TestAws.DynamoDB, audit.NewRecord(id, audit.ActionCreate, ownerID, nil, item)); err != nil {
+			fmt.Println(fmt.Sprintf("Failed to write the audit record: %s", err.Error()))
+		}
+	}
+	result.Imported = len(items) - len(failed)
+
+	// Serialization/Encoding the result to JSON.
+	resultJson, _ := json.Marshal(result)
+	return events.APIGatewayProxyResponse{
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(resultJson),
+		StatusCode: 200,
+	}, nil
+} // End of ImportDevices function
+
+// Decoding the rows of a CSV body into devices, along with the line which each of them starts at.
+// The first row names the columns, every other row must have as many fields as it.
+func readCSV(body string) ([]types.Device, []int, error) {
+	reader := csv.NewReader(strings.NewReader(body))
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, fmt.Errorf("missing header row")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Position of each column of the header in csvColumns.
+	columns := make([]int, len(header))
+	for i, name := range header {
+		columns[i] = -1
+		for j, column := range csvColumns {
+			if strings.EqualFold(strings.TrimSpace(name), column) {
+				columns[i] = j
+			}
+		}
+		if columns[i] < 0 {
+			return nil, nil, fmt.Errorf("unknown column %q", name)
+		}
+	}
+
+	var devices []types.Device
+	var lines []int
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return devices, lines, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		values := make([]string, len(csvColumns))
+		for i, value := range record {
+			values[columns[i]] = value
+		}
+		devices = append(devices, types.Device{ID: values[0], Name: values[1], DeviceModel: values[2], Serial: values[3], Note: values[4]})
+		line, _ := reader.FieldPos(0)
+		lines = append(lines, line)
+	}
+}
+
+// Checking whether a Content-Type header is "text/csv", ignoring its parameters, i.e: "text/csv; charset=utf-8".
+func isCSV(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "text/csv"
+}
+
+// Finding a header of the request regardless of its case, as clients and proxies may change it.
+func headerValue(headers map[string]string, name string) string {
+	for header, value := range headers {
+		if strings.EqualFold(header, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+func main() {
+	lambda.Start(gateway.Adapt(recovery.WithRecover(ImportDevices)))
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"testing"
+	"types"
+)
+
+// Mocking DynamoDB through dynamodbiface.
+type MockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	// Ids which are already stored in the mocked table, and the ids which have been written.
+	ExistingIDs map[string]bool
+	Written     map[string]bool
+}
+
+// Custom TransactWriteItems function for overriding the TransactWriteItems of importDevices.go for using in test scenarios.
+// Cancels the transaction like DynamoDB when one of its devices already exists, with a reason for each item in their order.
+func (self *MockDynamoDB) TransactWriteItems(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+	reasons := make([]*dynamodb.CancellationReason, 0, len(input.TransactItems))
+	canceled := false
+	for _, item := range input.TransactItems {
+		reason := &dynamodb.CancellationReason{Code: aws.String("None")}
+		if self.ExistingIDs[aws.StringValue(item.Put.Item["id"].S)] {
+			reason.Code, canceled = aws.String("ConditionalCheckFailed"), true
+		}
+		reasons = append(reasons, reason)
+	}
+	if canceled {
+		return nil, &dynamodb.TransactionCanceledException{Message_: aws.String("Transaction cancelled"), CancellationReasons: reasons}
+	}
+	for _, item := range input.TransactItems {
+		self.Written[aws.StringValue(item.Put.Item["id"].S)] = true
+	}
+	return new(dynamodb.TransactWriteItemsOutput), nil
+}
+
+// A CSV request, as sent by a client uploading an export.
+func csvRequest(body string) events.APIGatewayProxyRequest {
+	return events.APIGatewayProxyRequest{Headers: map[string]string{"Content-Type": "text/csv"}, Body: body}
+}
+
+// ImportDevices function in importDevices.go signature: input: (request events.APIGatewayProxyRequest), output: (events.APIGatewayProxyResponse, error)
+func TestImportDevices(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	mock := &MockDynamoDB{Written: map[string]bool{}}
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	// Three rows, the one on line 3 without a Name.
+	body := "ID,Name,DeviceModel,Serial,Note\n" +
+		"7c9e6679-7425-40de-944b-000000000001,Sensor,/devicemodels/id1,A020000101,First\n" +
+		"7c9e6679-7425-40de-944b-000000000002,,/devicemodels/id1,A020000102,Second\n" +
+		"7c9e6679-7425-40de-944b-000000000003,Sensor,/devicemodels/id1,A020000103,Third\n"
+	response, _ := ImportDevices(csvRequest(body))
+	if response.StatusCode != 200 {
+		t.Fatalf("** Testing: Import with an invalid row. ** \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", 200, response.StatusCode, response.Body)
+	}
+
+	result := types.ImportResult{}
+	json.Unmarshal([]byte(response.Body), &result)
+	if result.Imported != 2 || len(result.Errors) != 1 || result.Errors[0].Line != 3 || result.Errors[0].Message != "Missing field: Name" {
+		t.Errorf("** Testing: Result of the import. ** \n \t<expected 2 imported and the error of line 3> <resulted body: %s>", response.Body)
+	}
+	if len(mock.Written) != 2 || mock.Written["7c9e6679-7425-40de-944b-000000000002"] {
+		t.Errorf("** Testing: Written devices. ** \n \t<expected 2 written devices without the invalid one> <resulted written devices: %v>", mock.Written)
+	}
+} // End of TestImportDevices function
+
+// A row whose id already exists is rejected instead of overwriting the stored device.
+func TestImportDevicesExisting(t *testing.T) {
+	realAws := TestAws
+	mock := &MockDynamoDB{ExistingIDs: map[string]bool{"7c9e6679-7425-40de-944b-000000000002": true}, Written: map[string]bool{}}
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	body := "ID,Name,DeviceModel,Serial,Note\n" +
+		"7c9e6679-7425-40de-944b-000000000001,Sensor,/devicemodels/id1,A020000101,First\n" +
+		"7c9e6679-7425-40de-944b-000000000002,Sensor,/devicemodels/id1,A020000102,Second\n"
+	response, _ := ImportDevices(csvRequest(body))
+
+	expected := "{\"imported\":1,\"errors\":[{\"line\":3,\"message\":\"Device with this ID already exists.\"}]}"
+	if response.StatusCode != 200 || response.Body != expected {
+		t.Errorf("** Testing: Import of an existing id. ** \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", 200, response.StatusCode, expected, response.Body)
+	}
+	if len(mock.Written) != 1 || mock.Written["7c9e6679-7425-40de-944b-000000000002"] {
+		t.Errorf("** Testing: Written devices. ** \n \t<expected only the new device written> <resulted written devices: %v>", mock.Written)
+	}
+} // End of TestImportDevicesExisting function
+
+// Request bodies which can't be imported at all.
+func TestImportDevicesWrongInputs(t *testing.T) {
+	testCases := []struct {
+		Name               string
+		Request            events.APIGatewayProxyRequest
+		ExpectedBody       string
+		ExpectedStatusCode int
+	}{
+		{
+			Name:               "** Testing: Empty body input. **",
+			Request:            csvRequest(""),
+			ExpectedBody:       "No inputs provided, please provide inputs in CSV format.",
+			ExpectedStatusCode: 400,
+		},
+
+		{
+			Name:               "** Testing: A row with more fields than the header. **",
+			Request:            csvRequest("ID,Name\n7c9e6679-7425-40de-944b-000000000001,Sensor,Extra\n"),
+			ExpectedBody:       "Wrong format: Inputs must be a valid CSV, record on line 2: wrong number of fields.",
+			ExpectedStatusCode: 400,
+		},
+
+		{
+			Name:               "** Testing: Unknown column. **",
+			Request:            csvRequest("ID,Color\n"),
+			ExpectedBody:       "Wrong format: Inputs must be a valid CSV, unknown column \"Color\".",
+			ExpectedStatusCode: 400,
+		},
+
+		{
+			Name:               "** Testing: JSON body. **",
+			Request:            events.APIGatewayProxyRequest{Headers: map[string]string{"Content-Type": "application/json"}, Body: "[]"},
+			ExpectedBody:       "Unsupported Media Type: Content-Type must be text/csv.",
+			ExpectedStatusCode: 415,
+		},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := ImportDevices(test.Request)
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+} // End of TestImportDevicesWrongInputs function
@@ -0,0 +1,508 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gateway"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"negotiation"
+	"os"
+	"owner"
+	"placeholder"
+	"projection"
+	"recovery"
+	"semver"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"types"
+	"validation"
+)
+
+type AmazonWebServices struct {
+	Config   *aws.Config
+	Session  *session.Session
+	DynamoDB dynamodbiface.DynamoDBAPI
+}
+
+// Prepare a new AWS & DynamoDB session, then configure it.
+var TestAws *AmazonWebServices
+
+func init() {
+	region := os.Getenv("AWS_REGION")
+	var Aws *AmazonWebServices = new(AmazonWebServices)
+	Aws.Config = &aws.Config{Region: aws.String(region)}
+	// Pointing the client to a local DynamoDB, i.e: DynamoDB Local for the integration tests. It's unset in production.
+	if endpoint := os.Getenv("DYNAMODB_ENDPOINT"); endpoint != "" {
+		Aws.Config.Endpoint = aws.String(endpoint)
+	}
+	var err error
+	Aws.Session, err = session.NewSession(Aws.Config)
+	if err != nil {
+		// Logs error on Amazon CloudWatch. It's sysadmin's duty to handle it.
+		fmt.Println(fmt.Sprintf("Failed to connect to AWS: %s", err.Error()))
+	} else {
+		var svc *dynamodb.DynamoDB = dynamodb.New(Aws.Session)
+		Aws.DynamoDB = dynamodbiface.DynamoDBAPI(svc)
+	}
+	// Instantiate a global session in TestAws
+	TestAws = Aws
+}
+
+// Conditions which DynamoDB applies to the scanned devices, all of them have to match.
+type ScanFilter struct {
+	NameContains   string // Part of the name, case sensitive.
+	DeviceModel    string
+	ModelCode      int // Numeric code of the model, when it's set.
+	IncludeDeleted bool
+	OwnerID        string // Tenant of the caller, only its devices are scanned when it's set.
+	Status         string // One of the types.Status constants, the devices without a status are active.
+	TagKey         string // Key of a tag which the devices have with TagValue, when it's set.
+	TagValue       string
+}
+
+// Preparing DynamoDB Session and Calling DB's Scan function inside.
+// A zero limit scans without a limit, a nil startKey scans from the first page and a nil projection fetches whole devices.
+// Expired and soft deleted devices are filtered out, the latter unless filter.IncludeDeleted, note that DynamoDB filters after the limit
+// so a page can be shorter, even empty while there are more pages.
+func (self *AmazonWebServices) Scan(limit int64, startKey map[string]*dynamodb.AttributeValue, fields *projection.Projection, filter ScanFilter) (*dynamodb.ScanOutput, error) {
+	// Get desire table's name from OS's environmental varible.
+	tableName := aws.String(os.Getenv("DEVICES_TABLE_NAME"))
+
+	var input = &dynamodb.ScanInput{
+		TableName:         tableName,
+		ExclusiveStartKey: startKey,
+	}
+	if limit > 0 {
+		input.Limit = aws.Int64(limit)
+	}
+	// The placeholders of the projection and of the filter are alike, so they share the names.
+	names := placeholder.Names{}
+	if fields != nil {
+		input.ProjectionExpression = fields.Expression
+		for attribute, name := range fields.Names {
+			names[attribute] = name
+		}
+	}
+
+	// Expired devices are only purged by DynamoDB within a few days, till then they are filtered out.
+	conditions := []string{fmt.Sprintf("(attribute_not_exists(%[1]s) OR %[1]s > :now)", names.Of("expiresAt"))}
+	values := map[string]*dynamodb.AttributeValue{
+		":now": {N: aws.String(strconv.FormatInt(time.Now().Unix(), 10))},
+	}
+	if !filter.IncludeDeleted {
+		conditions = append(conditions, fmt.Sprintf("(attribute_not_exists(%[1]s) OR %[1]s = :false)", names.Of("deleted")))
+		values[":false"] = &dynamodb.AttributeValue{BOOL: aws.Bool(false)}
+	}
+	if filter.NameContains != "" {
+		conditions = append(conditions, fmt.Sprintf("contains(%s, :term)", names.Of("name")))
+		values[":term"] = &dynamodb.AttributeValue{S: aws.String(filter.NameContains)}
+	}
+	if filter.DeviceModel != "" {
+		conditions = append(conditions, fmt.Sprintf("%s = :model", names.Field("deviceModel")))
+		values[":model"] = &dynamodb.AttributeValue{S: aws.String(filter.DeviceModel)}
+	}
+	if filter.ModelCode != 0 {
+		conditions = append(conditions, fmt.Sprintf("%s = :modelCode", names.Of("modelCode")))
+		values[":modelCode"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(filter.ModelCode))}
+	}
+	if filter.OwnerID != "" {
+		conditions = append(conditions, fmt.Sprintf("%s = :owner", names.Of("ownerId")))
+		values[":owner"] = &dynamodb.AttributeValue{S: aws.String(filter.OwnerID)}
+	}
+	if filter.Status == types.StatusActive {
+		conditions = append(conditions, fmt.Sprintf("(attribute_not_exists(%[1]s) OR %[1]s = :status)", names.Of("status")))
+		values[":status"] = &dynamodb.AttributeValue{S: aws.String(filter.Status)}
+	} else if filter.Status != "" {
+		conditions = append(conditions, fmt.Sprintf("%s = :status", names.Of("status")))
+		values[":status"] = &dynamodb.AttributeValue{S: aws.String(filter.Status)}
+	}
+	// A tag key is arbitrary text, so it gets a placeholder of its own rather than one made of it.
+	if filter.TagKey != "" {
+		names["#tagKey"] = aws.String(filter.TagKey)
+		conditions = append(conditions, fmt.Sprintf("%s.#tagKey = :tagValue", names.Of("tags")))
+		values[":tagValue"] = &dynamodb.AttributeValue{S: aws.String(filter.TagValue)}
+	}
+	input.FilterExpression = aws.String(strings.Join(conditions, " AND "))
+	input.ExpressionAttributeNames = names
+	input.ExpressionAttributeValues = values
+
+	// Calling either Scan function of interface, defined in listDevices_test.go file, or api with the input we've provided.
+	// In real deployment environment, the Scan function of aws (api.go) will be called.
+	result, err := self.DynamoDB.Scan(input)
+	return result, err
+}
+
+// The handler function which will be first started from main function.
+// Large pages are gzip compressed for the clients which accept it.
+func ListDevices(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !gateway.AllowsMethod(request, "GET") {
+		return gateway.MethodNotAllowed("GET"), nil
+	}
+
+	response, err := listDevices(request)
+	if response.StatusCode == 200 && acceptsGzip(request.Headers) {
+		response = compressResponse(response)
+	}
+	return response, err
+} // End of ListDevices function
+
+// Listing a page of the devices, i.e: the response of ListDevices before compression.
+func listDevices(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	// The page size and the position to continue from, which user has sent through the query string.
+	// A scan is never unbounded: the page size is DEFAULT_PAGE_SIZE when it's omitted, and at most MAX_PAGE_SIZE.
+	limit := pageSize("DEFAULT_PAGE_SIZE", 50)
+	if rawLimit, ok := request.QueryStringParameters["limit"]; ok {
+		var err error
+		limit, err = strconv.ParseInt(rawLimit, 10, 64)
+		// if limit is not a positive integer, return HTTP error code 400.
+		if err != nil || limit <= 0 {
+			return events.APIGatewayProxyResponse{
+				Body:       "Invalid parameter: limit must be a positive integer.",
+				StatusCode: 400,
+			}, nil
+		}
+	}
+	// A larger page is clamped rather than rejected, the client keeps paging with the nextToken anyway.
+	if maxLimit := pageSize("MAX_PAGE_SIZE", 1000); limit > maxLimit {
+		limit = maxLimit
+	}
+
+	startKey, err := DecodeNextToken(request.QueryStringParameters["nextToken"])
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:       "Invalid parameter: nextToken.",
+			StatusCode: 400,
+		}, nil
+	}
+
+	// The fields which user wants back, i.e: "fields=ID,Name", whole devices by default.
+	fields, err := projection.Parse(request.QueryStringParameters["fields"])
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:       err.Error(),
+			StatusCode: 400,
+		}, nil
+	}
+
+	// Only the devices whose name contains "name", of the model "model" and in the status "status", if they are given.
+	// Never the devices of another tenant.
+	filter := ScanFilter{
+		NameContains: request.QueryStringParameters["name"],
+		DeviceModel:  request.QueryStringParameters["model"],
+		OwnerID:      owner.Caller(request),
+		Status:       request.QueryStringParameters["status"],
+	}
+	if filter.Status != "" && !validation.ValidStatus(filter.Status) {
+		return events.APIGatewayProxyResponse{
+			Body:       validation.StatusFailure("Invalid parameter: status") + ".",
+			StatusCode: 400,
+		}, nil
+	}
+	// Only the devices of the numeric model code "modelCode=42".
+	if rawModelCode, ok := request.QueryStringParameters["modelCode"]; ok {
+		filter.ModelCode, err = strconv.Atoi(rawModelCode)
+		if err != nil || filter.ModelCode <= 0 {
+			return events.APIGatewayProxyResponse{
+				Body:       "Invalid parameter: modelCode must be a positive integer.",
+				StatusCode: 400,
+			}, nil
+		}
+	}
+	// Only the devices with the tag "tag=key:value", the value may contain colons itself.
+	if rawTag, ok := request.QueryStringParameters["tag"]; ok {
+		separator := strings.Index(rawTag, ":")
+		if separator <= 0 {
+			return events.APIGatewayProxyResponse{
+				Body:       "Invalid parameter: tag must be key:value.",
+				StatusCode: 400,
+			}, nil
+		}
+		filter.TagKey, filter.TagValue = rawTag[:separator], rawTag[separator+1:]
+	}
+
+	// Soft deleted devices are hidden, unless "includeDeleted=true" is asked for.
+	if rawIncludeDeleted, ok := request.QueryStringParameters["includeDeleted"]; ok {
+		filter.IncludeDeleted, err = strconv.ParseBool(rawIncludeDeleted)
+		if err != nil {
+			return events.APIGatewayProxyResponse{
+				Body:       "Invalid parameter: includeDeleted must be a boolean.",
+				StatusCode: 400,
+			}, nil
+		}
+	}
+
+	// The order of the devices of the page, scan order by default.
+	sortBy, descending, err := sortParameters(request.QueryStringParameters)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:       err.Error(),
+			StatusCode: 400,
+		}, nil
+	}
+
+	// Only the devices at or above the firmware "minFirmware=1.2.3", compared as semantic versions.
+	minFirmware, hasMinFirmware := request.QueryStringParameters["minFirmware"]
+	if hasMinFirmware && !semver.Valid(minFirmware) {
+		return events.APIGatewayProxyResponse{
+			Body:       "Invalid parameter: minFirmware must be a semantic version, i.e: 1.2.3.",
+			StatusCode: 400,
+		}, nil
+	}
+
+	// The sort and the firmware attributes have to be fetched to sort and filter by them, even if user has not asked for them.
+	fetchedFields := fields
+	if fetchedFields != nil && sortBy != "" {
+		fetchedFields = fetchedFields.With(sortBy)
+	}
+	if fetchedFields != nil && hasMinFirmware {
+		fetchedFields = fetchedFields.With("firmwareVersion")
+	}
+
+	result, err := TestAws.Scan(limit, startKey, fetchedFields, filter)
+
+	// If an internal error have occurred in the database, return HTTP error code 500.
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:       "Internal Server Error.",
+			StatusCode: 500,
+		}, nil
+	}
+
+	// Only the projected attributes have been fetched, return just them instead of devices with empty fields.
+	if fields != nil {
+		partials := []map[string]interface{}{}
+		for _, item := range result.Items {
+			partial, err := projection.Decode(item)
+			if err != nil {
+				return events.APIGatewayProxyResponse{
+					Body:       "Internal Server Error.",
+					StatusCode: 500,
+				}, nil
+			}
+			partials = append(partials, partial)
+		}
+		if hasMinFirmware {
+			atOrAbove := []map[string]interface{}{}
+			for _, partial := range partials {
+				if firmware, _ := partial["firmwareVersion"].(string); semver.Compare(firmware, minFirmware) >= 0 {
+					atOrAbove = append(atOrAbove, partial)
+				}
+			}
+			partials = atOrAbove
+			if !fields.Has("firmwareVersion") {
+				for _, partial := range partials {
+					delete(partial, "firmwareVersion")
+				}
+			}
+		}
+		if sortBy != "" {
+			sort.SliceStable(partials, func(i, j int) bool {
+				return lessOrdered(fmt.Sprint(partials[i][sortBy]), fmt.Sprint(partials[j][sortBy]), descending)
+			})
+			if !fields.Has(sortBy) {
+				for _, partial := range partials {
+					delete(partial, sortBy)
+				}
+			}
+		}
+		partialsJson, _ := negotiation.Marshal(negotiation.JSON, types.PartialDeviceList{Devices: partials, NextToken: EncodeNextToken(result.LastEvaluatedKey)})
+		return events.APIGatewayProxyResponse{
+			Headers:    pageHeaders(result),
+			Body:       string(partialsJson),
+			StatusCode: 200,
+		}, nil
+	}
+
+	// Deserialization/Decoding "result.Items" to Go structs.
+	// Starting from an empty slice, so an empty table is returned as "[]" instead of "null".
+	devices := []types.Device{}
+	err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &devices)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:       "Internal Server Error.",
+			StatusCode: 500,
+		}, nil
+	}
+
+	// Semantic versions are not ordered as text, i.e: "1.10.0" is above "1.9.0", so they are compared after the scan.
+	// The devices without a firmware version are never at or above one.
+	if hasMinFirmware {
+		atOrAbove := []types.Device{}
+		for _, device := range devices {
+			if semver.Compare(device.FirmwareVersion, minFirmware) >= 0 {
+				atOrAbove = append(atOrAbove, device)
+			}
+		}
+		devices = atOrAbove
+	}
+
+	if sortBy != "" {
+		key := sortKeys[sortBy]
+		sort.SliceStable(devices, func(i, j int) bool {
+			return lessOrdered(key(devices[i]), key(devices[j]), descending)
+		})
+	}
+
+	// Serialization/Encoding the page of devices to JSON.
+	// DynamoDB has more items for us only when it returns a LastEvaluatedKey.
+	page := types.DeviceList{Devices: devices, NextToken: EncodeNextToken(result.LastEvaluatedKey)}
+	devicesJson, _ := negotiation.Marshal(negotiation.JSON, page)
+
+	// Return the page of devices as JSON with 200 HTTP status code.
+	return events.APIGatewayProxyResponse{
+		Headers:    pageHeaders(result),
+		Body:       string(devicesJson),
+		StatusCode: 200,
+	}, nil
+} // End of listDevices function
+
+// The paging of a scan as response headers, for the clients which prefer them to the body: X-Next-Token is the same
+// token as the nextToken of the body and is omitted on the last page, X-Total-Scanned is the number of devices which
+// DynamoDB has read for the page, before filtering them.
+func pageHeaders(result *dynamodb.ScanOutput) map[string]string {
+	headers := map[string]string{"X-Total-Scanned": strconv.FormatInt(aws.Int64Value(result.ScannedCount), 10)}
+	if nextToken := EncodeNextToken(result.LastEvaluatedKey); nextToken != "" {
+		headers["X-Next-Token"] = nextToken
+	}
+	return headers
+}
+
+// Values which the devices can be sorted by, keyed by their attribute names.
+// CreatedAt is RFC3339 in UTC, so its textual order is its time order.
+var sortKeys = map[string]func(types.Device) string{
+	"id":          func(device types.Device) string { return device.ID },
+	"name":        func(device types.Device) string { return device.Name },
+	"deviceModel": func(device types.Device) string { return device.DeviceModel },
+	"createdAt":   func(device types.Device) string { return device.CreatedAt },
+}
+
+// Parsing the "sortBy" and "order" query parameters, i.e: "sortBy=Name&order=desc".
+// sortBy is matched against the sortKeys in any case and returned as its attribute name, empty when it's missing.
+func sortParameters(query map[string]string) (string, bool, error) {
+	sortBy := ""
+	if rawSortBy, ok := query["sortBy"]; ok {
+		for attribute := range sortKeys {
+			if strings.EqualFold(attribute, rawSortBy) {
+				sortBy = attribute
+			}
+		}
+		if sortBy == "" {
+			return "", false, errors.New("Invalid parameter: sortBy must be one of ID, Name, DeviceModel, CreatedAt.")
+		}
+	}
+	switch strings.ToLower(query["order"]) {
+	case "", "asc":
+		return sortBy, false, nil
+	case "desc":
+		return sortBy, true, nil
+	}
+	return "", false, errors.New("Invalid parameter: order must be asc or desc.")
+}
+
+// Comparing two sort values, reversed when descending.
+func lessOrdered(a, b string, descending bool) bool {
+	if descending {
+		return a > b
+	}
+	return a < b
+}
+
+// Checking whether the client accepts a gzip compressed body, through the Accept-Encoding header of any case.
+func acceptsGzip(headers map[string]string) bool {
+	for name, value := range headers {
+		if !strings.EqualFold(name, "Accept-Encoding") {
+			continue
+		}
+		for _, encoding := range strings.Split(value, ",") {
+			// Ignoring the quality value, i.e: "gzip;q=0.8".
+			encoding = strings.TrimSpace(strings.Split(encoding, ";")[0])
+			if strings.EqualFold(encoding, "gzip") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// A page size taken from OS's environment, falling back to the given one when it's missing or not a positive integer.
+func pageSize(name string, fallback int64) int64 {
+	size, err := strconv.ParseInt(os.Getenv(name), 10, 64)
+	if err != nil || size <= 0 {
+		return fallback
+	}
+	return size
+}
+
+// Minimum size of a body in bytes to be compressed, taken from OS's environment (GZIP_MIN_BYTES) and defaulting to 1 KB.
+// Compressing a smaller body isn't worth it.
+func gzipMinBytes() int {
+	minBytes, err := strconv.Atoi(os.Getenv("GZIP_MIN_BYTES"))
+	if err != nil || minBytes < 0 {
+		return 1024
+	}
+	return minBytes
+}
+
+// Compressing the body of a response with gzip. API Gateway only passes a binary body as base64,
+// so the compressed body is base64 encoded and flagged with IsBase64Encoded.
+func compressResponse(response events.APIGatewayProxyResponse) events.APIGatewayProxyResponse {
+	if len(response.Body) < gzipMinBytes() {
+		return response
+	}
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Write([]byte(response.Body))
+	if err := writer.Close(); err != nil {
+		return response
+	}
+	if response.Headers == nil {
+		response.Headers = map[string]string{}
+	}
+	response.Headers["Content-Encoding"] = "gzip"
+	response.Headers["Vary"] = "Accept-Encoding"
+	response.Body = base64.StdEncoding.EncodeToString(compressed.Bytes())
+	response.IsBase64Encoded = true
+	return response
+}
+
+// Encoding the LastEvaluatedKey of a scan as an opaque base64 token for the client.
+// An empty key means the last page has been reached, so an empty token is returned.
+func EncodeNextToken(key map[string]*dynamodb.AttributeValue) string {
+	if len(key) == 0 {
+		return ""
+	}
+	keyJson, _ := json.Marshal(key)
+	return base64.URLEncoding.EncodeToString(keyJson)
+}
+
+// Decoding a token made by EncodeNextToken back into an ExclusiveStartKey.
+func DecodeNextToken(token string) (map[string]*dynamodb.AttributeValue, error) {
+	if token == "" {
+		return nil, nil
+	}
+	keyJson, err := base64.URLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+	key := map[string]*dynamodb.AttributeValue{}
+	if err = json.Unmarshal(keyJson, &key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func main() {
+	lambda.Start(gateway.Adapt(recovery.WithRecover(ListDevices)))
+}
@@ -0,0 +1,770 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"io"
+	"semver"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"types"
+)
+
+type TestCase struct {
+	Name               string
+	Request            events.APIGatewayProxyRequest
+	MockDatabase       *MockDynamoDB
+	ExpectedBody       string
+	ExpectedStatusCode int
+}
+
+// Mocking DynamoDB through dynamodbiface.
+type MockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	// Items and error which the mocked Scan returns.
+	Items []map[string]*dynamodb.AttributeValue
+	Error error
+	// Filter expression and limit of the last Scan call.
+	FilterExpression string
+	Limit            int64
+}
+
+// Custom Scan function for overriding the Scan of listDevices.go for using in test scenarios.
+// Mocking the paging of DynamoDB: continues after ExclusiveStartKey and stops at Limit with a LastEvaluatedKey.
+// The ScannedCount is the number of the items of the page, before the filter.
+// Items are cut down to the attributes of the projection, if any, and soft deleted ones are dropped by the filter.
+func (self *MockDynamoDB) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	if self.Error != nil {
+		return nil, self.Error
+	}
+	start := 0
+	if input.ExclusiveStartKey != nil {
+		for i, item := range self.Items {
+			if aws.StringValue(item["id"].S) == aws.StringValue(input.ExclusiveStartKey["id"].S) {
+				start = i + 1
+			}
+		}
+	}
+	end := len(self.Items)
+	MockOutput := new(dynamodb.ScanOutput)
+	if input.Limit != nil && start+int(*input.Limit) < end {
+		end = start + int(*input.Limit)
+		MockOutput.SetLastEvaluatedKey(map[string]*dynamodb.AttributeValue{"id": self.Items[end-1]["id"]})
+	}
+	MockOutput.SetItems(self.Items[start:end])
+	MockOutput.SetScannedCount(int64(end - start))
+	self.FilterExpression = aws.StringValue(input.FilterExpression)
+	self.Limit = aws.Int64Value(input.Limit)
+	if input.FilterExpression != nil {
+		var filtered []map[string]*dynamodb.AttributeValue
+		for _, item := range MockOutput.Items {
+			if matchesFilter(item, aws.StringValue(input.FilterExpression), input.ExpressionAttributeNames, input.ExpressionAttributeValues) {
+				filtered = append(filtered, item)
+			}
+		}
+		MockOutput.Items = filtered
+	}
+	if input.ProjectionExpression != nil {
+		var projected []map[string]*dynamodb.AttributeValue
+		for _, item := range MockOutput.Items {
+			projectedItem := map[string]*dynamodb.AttributeValue{}
+			for _, placeholder := range strings.Split(*input.ProjectionExpression, ", ") {
+				attribute := input.ExpressionAttributeNames[placeholder]
+				if value, ok := item[*attribute]; ok {
+					projectedItem[*attribute] = value
+				}
+			}
+			projected = append(projected, projectedItem)
+		}
+		MockOutput.SetItems(projected)
+	}
+	return MockOutput, nil
+}
+
+// Mocking the conditions of the filter expressions of listDevices.go, which all have to match.
+func matchesFilter(item map[string]*dynamodb.AttributeValue, expression string, names map[string]*string, values map[string]*dynamodb.AttributeValue) bool {
+	if now, ok := values[":now"]; ok && item["expiresAt"] != nil {
+		expiresAt, _ := strconv.ParseInt(aws.StringValue(item["expiresAt"].N), 10, 64)
+		nowSeconds, _ := strconv.ParseInt(aws.StringValue(now.N), 10, 64)
+		if expiresAt <= nowSeconds {
+			return false
+		}
+	}
+	if strings.Contains(expression, ":false") && item["deleted"] != nil && aws.BoolValue(item["deleted"].BOOL) {
+		return false
+	}
+	if term, ok := values[":term"]; ok && (item["name"] == nil || !strings.Contains(aws.StringValue(item["name"].S), aws.StringValue(term.S))) {
+		return false
+	}
+	if ownerID, ok := values[":owner"]; ok && (item["ownerId"] == nil || aws.StringValue(item["ownerId"].S) != aws.StringValue(ownerID.S)) {
+		return false
+	}
+	if model, ok := values[":model"]; ok && (item["device_model"] == nil || aws.StringValue(item["device_model"].S) != aws.StringValue(model.S)) {
+		return false
+	}
+	if modelCode, ok := values[":modelCode"]; ok && (item["modelCode"] == nil || item["modelCode"].N == nil || aws.StringValue(item["modelCode"].N) != aws.StringValue(modelCode.N)) {
+		return false
+	}
+	if status, ok := values[":status"]; ok {
+		if item["status"] == nil {
+			return strings.Contains(expression, "attribute_not_exists(#status)")
+		}
+		return aws.StringValue(item["status"].S) == aws.StringValue(status.S)
+	}
+	if value, ok := values[":tagValue"]; ok {
+		if item["tags"] == nil || item["tags"].M[aws.StringValue(names["#tagKey"])] == nil {
+			return false
+		}
+		return aws.StringValue(item["tags"].M[aws.StringValue(names["#tagKey"])].S) == aws.StringValue(value.S)
+	}
+	return true
+}
+
+// ListDevices function in listDevices.go signature: input: (request events.APIGatewayProxyRequest), output: (events.APIGatewayProxyResponse, error)
+func TestListDevices(t *testing.T) {
+	TwoDevices := []map[string]*dynamodb.AttributeValue{
+		{
+			"id":           &dynamodb.AttributeValue{S: aws.String("id_test1")},
+			"device_model": &dynamodb.AttributeValue{S: aws.String("deviceModel_test")},
+			"name":         &dynamodb.AttributeValue{S: aws.String("name_test1")},
+			"note":         &dynamodb.AttributeValue{S: aws.String("note_test")},
+			"serial":       &dynamodb.AttributeValue{S: aws.String("serial_test1")},
+		},
+		{
+			"id":           &dynamodb.AttributeValue{S: aws.String("id_test2")},
+			"device_model": &dynamodb.AttributeValue{S: aws.String("deviceModel_test")},
+			"name":         &dynamodb.AttributeValue{S: aws.String("name_test2")},
+			"note":         &dynamodb.AttributeValue{S: aws.String("note_test")},
+			"serial":       &dynamodb.AttributeValue{S: aws.String("serial_test2")},
+		},
+	}
+
+	// A soft deleted device after the two others.
+	WithDeleted := append([]map[string]*dynamodb.AttributeValue{}, TwoDevices...)
+	WithDeleted = append(WithDeleted, map[string]*dynamodb.AttributeValue{
+		"id":           &dynamodb.AttributeValue{S: aws.String("id_test3")},
+		"device_model": &dynamodb.AttributeValue{S: aws.String("deviceModel_test")},
+		"name":         &dynamodb.AttributeValue{S: aws.String("name_test3")},
+		"note":         &dynamodb.AttributeValue{S: aws.String("note_test")},
+		"serial":       &dynamodb.AttributeValue{S: aws.String("serial_test3")},
+		"deleted":      &dynamodb.AttributeValue{BOOL: aws.Bool(true)},
+		"deletedAt":    &dynamodb.AttributeValue{S: aws.String("2018-11-02T10:04:05Z")},
+	})
+
+	// The token of the page following the first device.
+	FirstPageToken := EncodeNextToken(map[string]*dynamodb.AttributeValue{"id": {S: aws.String("id_test1")}})
+
+	testCases := []TestCase{
+		{
+			Name:               "** Database Returns two devices **",
+			MockDatabase:       &MockDynamoDB{Items: TwoDevices},
+			ExpectedBody:       "{\"devices\":[{\"id\":\"id_test1\",\"deviceModel\":\"deviceModel_test\",\"name\":\"name_test1\",\"note\":\"note_test\",\"serial\":\"serial_test1\"},{\"id\":\"id_test2\",\"deviceModel\":\"deviceModel_test\",\"name\":\"name_test2\",\"note\":\"note_test\",\"serial\":\"serial_test2\"}]}",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Database Returns Empty Result **",
+			MockDatabase:       &MockDynamoDB{},
+			ExpectedBody:       "{\"devices\":[]}",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Testing: First page with limit. **",
+			Request:            events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"limit": "1"}},
+			MockDatabase:       &MockDynamoDB{Items: TwoDevices},
+			ExpectedBody:       "{\"devices\":[{\"id\":\"id_test1\",\"deviceModel\":\"deviceModel_test\",\"name\":\"name_test1\",\"note\":\"note_test\",\"serial\":\"serial_test1\"}],\"nextToken\":\"" + FirstPageToken + "\"}",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Testing: Last page with limit and nextToken. **",
+			Request:            events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"limit": "1", "nextToken": FirstPageToken}},
+			MockDatabase:       &MockDynamoDB{Items: TwoDevices},
+			ExpectedBody:       "{\"devices\":[{\"id\":\"id_test2\",\"deviceModel\":\"deviceModel_test\",\"name\":\"name_test2\",\"note\":\"note_test\",\"serial\":\"serial_test2\"}]}",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Testing: Limit is not a number. **",
+			Request:            events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"limit": "ten"}},
+			MockDatabase:       &MockDynamoDB{Items: TwoDevices},
+			ExpectedBody:       "Invalid parameter: limit must be a positive integer.",
+			ExpectedStatusCode: 400,
+		},
+
+		{
+			Name:               "** Testing: Limit is not positive. **",
+			Request:            events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"limit": "0"}},
+			MockDatabase:       &MockDynamoDB{Items: TwoDevices},
+			ExpectedBody:       "Invalid parameter: limit must be a positive integer.",
+			ExpectedStatusCode: 400,
+		},
+
+		{
+			Name:               "** Testing: Malformed nextToken. **",
+			Request:            events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"nextToken": "%%%"}},
+			MockDatabase:       &MockDynamoDB{Items: TwoDevices},
+			ExpectedBody:       "Invalid parameter: nextToken.",
+			ExpectedStatusCode: 400,
+		},
+
+		{
+			Name:               "** Testing: Only ID and Name fields. **",
+			Request:            events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"fields": "ID,Name"}},
+			MockDatabase:       &MockDynamoDB{Items: TwoDevices},
+			ExpectedBody:       "{\"devices\":[{\"id\":\"id_test1\",\"name\":\"name_test1\"},{\"id\":\"id_test2\",\"name\":\"name_test2\"}]}",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Testing: Unknown field. **",
+			Request:            events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"fields": "ID,Color"}},
+			MockDatabase:       &MockDynamoDB{Items: TwoDevices},
+			ExpectedBody:       "Invalid parameter: fields, unknown field \"Color\".",
+			ExpectedStatusCode: 400,
+		},
+
+		{
+			Name:               "** Testing: Soft deleted device is hidden. **",
+			MockDatabase:       &MockDynamoDB{Items: WithDeleted},
+			ExpectedBody:       "{\"devices\":[{\"id\":\"id_test1\",\"deviceModel\":\"deviceModel_test\",\"name\":\"name_test1\",\"note\":\"note_test\",\"serial\":\"serial_test1\"},{\"id\":\"id_test2\",\"deviceModel\":\"deviceModel_test\",\"name\":\"name_test2\",\"note\":\"note_test\",\"serial\":\"serial_test2\"}]}",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Testing: Soft deleted device with includeDeleted. **",
+			Request:            events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"includeDeleted": "true", "fields": "id"}},
+			MockDatabase:       &MockDynamoDB{Items: WithDeleted},
+			ExpectedBody:       "{\"devices\":[{\"id\":\"id_test1\"},{\"id\":\"id_test2\"},{\"id\":\"id_test3\"}]}",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Testing: includeDeleted is not a boolean. **",
+			Request:            events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"includeDeleted": "yes please"}},
+			MockDatabase:       &MockDynamoDB{Items: WithDeleted},
+			ExpectedBody:       "Invalid parameter: includeDeleted must be a boolean.",
+			ExpectedStatusCode: 400,
+		},
+
+		{
+			Name:               "** Database Unexpected Error **",
+			MockDatabase:       &MockDynamoDB{Error: errors.New("unexpected Error has occurred")},
+			ExpectedBody:       "Internal Server Error.",
+			ExpectedStatusCode: 500,
+		},
+	}
+
+	realAws := TestAws
+	defer func() { TestAws = realAws }()
+
+	for _, test := range testCases {
+		// Executing each test cases scenario against its own mocked database.
+		TestAws = &AmazonWebServices{DynamoDB: test.MockDatabase}
+		response, _ := ListDevices(test.Request)
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+} // End of TestListDevices function
+
+// Filters by a part of the name and by the model are applied by DynamoDB, combined with AND.
+func TestListDevicesFilters(t *testing.T) {
+	device := func(id, model, name string) map[string]*dynamodb.AttributeValue {
+		return map[string]*dynamodb.AttributeValue{
+			"id":           {S: aws.String(id)},
+			"device_model": {S: aws.String(model)},
+			"name":         {S: aws.String(name)},
+		}
+	}
+	mock := &MockDynamoDB{Items: []map[string]*dynamodb.AttributeValue{
+		device("id_test1", "/devicemodels/id1", "Kitchen sensor"),
+		device("id_test2", "/devicemodels/id2", "Garage sensor"),
+		device("id_test3", "/devicemodels/id1", "Kitchen light"),
+	}}
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	testCases := []struct {
+		Name               string
+		Query              map[string]string
+		ExpectedIDs        string
+		ExpectedExpression string
+	}{
+		{
+			Name:               "** Testing: Name containing a term. **",
+			Query:              map[string]string{"name": "sensor", "fields": "id"},
+			ExpectedIDs:        "{\"devices\":[{\"id\":\"id_test1\"},{\"id\":\"id_test2\"}]}",
+			ExpectedExpression: "(attribute_not_exists(#expiresAt) OR #expiresAt > :now) AND (attribute_not_exists(#deleted) OR #deleted = :false) AND contains(#name, :term)",
+		},
+
+		{
+			Name:               "** Testing: Name and model combined. **",
+			Query:              map[string]string{"name": "Kitchen", "model": "/devicemodels/id1", "fields": "id"},
+			ExpectedIDs:        "{\"devices\":[{\"id\":\"id_test1\"},{\"id\":\"id_test3\"}]}",
+			ExpectedExpression: "(attribute_not_exists(#expiresAt) OR #expiresAt > :now) AND (attribute_not_exists(#deleted) OR #deleted = :false) AND contains(#name, :term) AND #deviceModel = :model",
+		},
+
+		{
+			Name:               "** Testing: Term matching no name. **",
+			Query:              map[string]string{"name": "kitchen", "includeDeleted": "true", "fields": "id"},
+			ExpectedIDs:        "{\"devices\":[]}",
+			ExpectedExpression: "(attribute_not_exists(#expiresAt) OR #expiresAt > :now) AND contains(#name, :term)",
+		},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := ListDevices(events.APIGatewayProxyRequest{QueryStringParameters: test.Query})
+		if response.StatusCode != 200 || response.Body != test.ExpectedIDs {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, 200, response.StatusCode, test.ExpectedIDs, response.Body)
+		}
+		if mock.FilterExpression != test.ExpectedExpression {
+			t.Errorf("%s \n \t<expected filter: %s> <resulted filter: %s>", test.Name, test.ExpectedExpression, mock.FilterExpression)
+		}
+	}
+} // End of TestListDevicesFilters function
+
+// The devices of a page are sorted by the asked attribute, ascending by default.
+func TestListDevicesSorting(t *testing.T) {
+	device := func(id, name string) map[string]*dynamodb.AttributeValue {
+		return map[string]*dynamodb.AttributeValue{
+			"id":   {S: aws.String(id)},
+			"name": {S: aws.String(name)},
+		}
+	}
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{Items: []map[string]*dynamodb.AttributeValue{
+		device("id_test1", "Beta"),
+		device("id_test2", "Gamma"),
+		device("id_test3", "Alpha"),
+	}}}
+	defer func() { TestAws = realAws }()
+
+	testCases := []struct {
+		Name               string
+		Query              map[string]string
+		ExpectedBody       string
+		ExpectedStatusCode int
+	}{
+		{
+			Name:               "** Testing: Ascending by Name. **",
+			Query:              map[string]string{"sortBy": "Name", "fields": "id"},
+			ExpectedBody:       "{\"devices\":[{\"id\":\"id_test3\"},{\"id\":\"id_test1\"},{\"id\":\"id_test2\"}]}",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Testing: Descending by Name. **",
+			Query:              map[string]string{"sortBy": "name", "order": "desc"},
+			ExpectedBody:       "{\"devices\":[{\"id\":\"id_test2\",\"deviceModel\":\"\",\"name\":\"Gamma\",\"note\":\"\",\"serial\":\"\"},{\"id\":\"id_test1\",\"deviceModel\":\"\",\"name\":\"Beta\",\"note\":\"\",\"serial\":\"\"},{\"id\":\"id_test3\",\"deviceModel\":\"\",\"name\":\"Alpha\",\"note\":\"\",\"serial\":\"\"}]}",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Testing: Unknown sortBy. **",
+			Query:              map[string]string{"sortBy": "Serial"},
+			ExpectedBody:       "Invalid parameter: sortBy must be one of ID, Name, DeviceModel, CreatedAt.",
+			ExpectedStatusCode: 400,
+		},
+
+		{
+			Name:               "** Testing: Unknown order. **",
+			Query:              map[string]string{"sortBy": "Name", "order": "up"},
+			ExpectedBody:       "Invalid parameter: order must be asc or desc.",
+			ExpectedStatusCode: 400,
+		},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := ListDevices(events.APIGatewayProxyRequest{QueryStringParameters: test.Query})
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+} // End of TestListDevicesSorting function
+
+// A page is gzip compressed when the client accepts it and it's large enough, and decompresses to the same JSON.
+func TestListDevicesGzip(t *testing.T) {
+	var items []map[string]*dynamodb.AttributeValue
+	for i := 0; i < 50; i++ {
+		items = append(items, map[string]*dynamodb.AttributeValue{
+			"id":           {S: aws.String(fmt.Sprintf("id_test%d", i))},
+			"device_model": {S: aws.String("deviceModel_test")},
+			"name":         {S: aws.String("name_test")},
+			"note":         {S: aws.String("note_test")},
+			"serial":       {S: aws.String("serial_test")},
+		})
+	}
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{Items: items}}
+	defer func() { TestAws = realAws }()
+
+	plain, _ := ListDevices(events.APIGatewayProxyRequest{})
+	if plain.IsBase64Encoded || plain.Headers["Content-Encoding"] != "" {
+		t.Errorf("** Testing: Client without gzip. ** \n \t<expected an uncompressed body> <resulted headers: %v>", plain.Headers)
+	}
+
+	response, _ := ListDevices(events.APIGatewayProxyRequest{Headers: map[string]string{"accept-encoding": "deflate, gzip;q=0.8"}})
+	if !response.IsBase64Encoded || response.Headers["Content-Encoding"] != "gzip" {
+		t.Fatalf("** Testing: Client with gzip. ** \n \t<expected a compressed body> <resulted headers: %v>", response.Headers)
+	}
+	compressed, _ := base64.StdEncoding.DecodeString(response.Body)
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatalf("** Testing: Client with gzip. ** \n \t<expected a gzip body> <resulted error: %v>", err)
+	}
+	decompressed, _ := io.ReadAll(reader)
+	if string(decompressed) != plain.Body {
+		t.Errorf("** Testing: Round-trip of the compressed body. ** \n \t<expected body: %s> <resulted body: %s>", plain.Body, decompressed)
+	}
+
+	// Small bodies are left uncompressed.
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{Items: items[:1]}}
+	small, _ := ListDevices(events.APIGatewayProxyRequest{Headers: map[string]string{"Accept-Encoding": "gzip"}})
+	if small.IsBase64Encoded || small.Headers["Content-Encoding"] != "" {
+		t.Errorf("** Testing: Small body with gzip. ** \n \t<expected an uncompressed body> <resulted headers: %v>", small.Headers)
+	}
+} // End of TestListDevicesGzip function
+
+// Devices whose TTL has passed are not listed, even before DynamoDB has purged them.
+func TestListDevicesExpired(t *testing.T) {
+	device := func(id string, expiresAt int64) map[string]*dynamodb.AttributeValue {
+		item := map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}}
+		if expiresAt != 0 {
+			item["expiresAt"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(expiresAt, 10))}
+		}
+		return item
+	}
+	mock := &MockDynamoDB{Items: []map[string]*dynamodb.AttributeValue{
+		device("id_test1", 0),
+		device("id_test2", time.Now().Add(-time.Hour).Unix()),
+		device("id_test3", time.Now().Add(time.Hour).Unix()),
+	}}
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	response, _ := ListDevices(events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"fields": "id"}})
+	ExpectedBody := "{\"devices\":[{\"id\":\"id_test1\"},{\"id\":\"id_test3\"}]}"
+	if response.StatusCode != 200 || response.Body != ExpectedBody {
+		t.Errorf("** Testing: Expired device is not listed. ** \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", 200, response.StatusCode, ExpectedBody, response.Body)
+	}
+} // End of TestListDevicesExpired function
+
+// Only the devices of the caller's tenant are listed.
+func TestListDevicesOwner(t *testing.T) {
+	device := func(id string, ownerID string) map[string]*dynamodb.AttributeValue {
+		return map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}, "ownerId": {S: aws.String(ownerID)}}
+	}
+	mock := &MockDynamoDB{Items: []map[string]*dynamodb.AttributeValue{
+		device("id_test1", "tenant-a"),
+		device("id_test2", "tenant-b"),
+		device("id_test3", "tenant-a"),
+	}}
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	testCases := []struct {
+		Name         string
+		Authorizer   map[string]interface{}
+		ExpectedBody string
+	}{
+		{Name: "** Testing: Devices of tenant-a. **", Authorizer: map[string]interface{}{"claims": map[string]interface{}{"sub": "tenant-a"}}, ExpectedBody: "{\"devices\":[{\"id\":\"id_test1\"},{\"id\":\"id_test3\"}]}"},
+		{Name: "** Testing: Devices of tenant-b. **", Authorizer: map[string]interface{}{"claims": map[string]interface{}{"sub": "tenant-b"}}, ExpectedBody: "{\"devices\":[{\"id\":\"id_test2\"}]}"},
+		{Name: "** Testing: Without an authorizer. **", Authorizer: nil, ExpectedBody: "{\"devices\":[{\"id\":\"id_test1\"},{\"id\":\"id_test2\"},{\"id\":\"id_test3\"}]}"},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := ListDevices(events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"fields": "id"}, RequestContext: events.APIGatewayProxyRequestContext{Authorizer: test.Authorizer}})
+		if response.StatusCode != 200 || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, 200, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+} // End of TestListDevicesOwner function
+
+// Only the devices in the asked status are listed, the devices without a status are active.
+func TestListDevicesStatus(t *testing.T) {
+	device := func(id string, status string) map[string]*dynamodb.AttributeValue {
+		item := map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}}
+		if status != "" {
+			item["status"] = &dynamodb.AttributeValue{S: aws.String(status)}
+		}
+		return item
+	}
+	mock := &MockDynamoDB{Items: []map[string]*dynamodb.AttributeValue{
+		device("id_test1", "active"),
+		device("id_test2", "retired"),
+		device("id_test3", ""),
+	}}
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	testCases := []struct {
+		Name               string
+		Status             string
+		ExpectedBody       string
+		ExpectedStatusCode int
+	}{
+		{Name: "** Testing: Active devices. **", Status: "active", ExpectedBody: "{\"devices\":[{\"id\":\"id_test1\"},{\"id\":\"id_test3\"}]}", ExpectedStatusCode: 200},
+		{Name: "** Testing: Retired devices. **", Status: "retired", ExpectedBody: "{\"devices\":[{\"id\":\"id_test2\"}]}", ExpectedStatusCode: 200},
+		{Name: "** Testing: Inactive devices. **", Status: "inactive", ExpectedBody: "{\"devices\":[]}", ExpectedStatusCode: 200},
+		{Name: "** Testing: Unknown status. **", Status: "broken", ExpectedBody: "Invalid parameter: status must be one of active, inactive, retired.", ExpectedStatusCode: 400},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := ListDevices(events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"fields": "id", "status": test.Status}})
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+} // End of TestListDevicesStatus function
+
+// Only the devices with the asked tag are listed, through the "#tags.#tagKey" placeholders.
+func TestListDevicesTag(t *testing.T) {
+	device := func(id string, tags map[string]string) map[string]*dynamodb.AttributeValue {
+		item := map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}}
+		if tags != nil {
+			item["tags"], _ = dynamodbattribute.Marshal(tags)
+		}
+		return item
+	}
+	mock := &MockDynamoDB{Items: []map[string]*dynamodb.AttributeValue{
+		device("id_test1", map[string]string{"floor": "2", "room": "kitchen"}),
+		device("id_test2", map[string]string{"floor": "3"}),
+		device("id_test3", nil),
+		device("id_test4", map[string]string{"url": "http://example.com"}),
+	}}
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	testCases := []struct {
+		Name               string
+		Tag                string
+		ExpectedBody       string
+		ExpectedStatusCode int
+	}{
+		{Name: "** Testing: Devices of a tag. **", Tag: "floor:2", ExpectedBody: "{\"devices\":[{\"id\":\"id_test1\"}]}", ExpectedStatusCode: 200},
+		{Name: "** Testing: Tag without devices. **", Tag: "floor:4", ExpectedBody: "{\"devices\":[]}", ExpectedStatusCode: 200},
+		{Name: "** Testing: Tag value with a colon. **", Tag: "url:http://example.com", ExpectedBody: "{\"devices\":[{\"id\":\"id_test4\"}]}", ExpectedStatusCode: 200},
+		{Name: "** Testing: Tag without a value. **", Tag: "floor", ExpectedBody: "Invalid parameter: tag must be key:value.", ExpectedStatusCode: 400},
+		{Name: "** Testing: Tag without a key. **", Tag: ":2", ExpectedBody: "Invalid parameter: tag must be key:value.", ExpectedStatusCode: 400},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := ListDevices(events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"fields": "id", "tag": test.Tag}})
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+	if expected := "#tags.#tagKey = :tagValue"; !strings.HasSuffix(mock.FilterExpression, expected) {
+		t.Errorf("** Testing: Filter of a tag. ** \n \t<expected filter ending with: %s> <resulted filter: %s>", expected, mock.FilterExpression)
+	}
+} // End of TestListDevicesTag function
+
+// Only the devices of the asked model code are listed, compared as numbers.
+func TestListDevicesModelCode(t *testing.T) {
+	device := func(id string, modelCode int) map[string]*dynamodb.AttributeValue {
+		item, _ := dynamodbattribute.MarshalMap(types.Device{ID: id, ModelCode: modelCode})
+		return item
+	}
+	mock := &MockDynamoDB{Items: []map[string]*dynamodb.AttributeValue{
+		device("id_test1", 42),
+		device("id_test2", 7),
+		device("id_test3", 0),
+		device("id_test4", 42),
+	}}
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	testCases := []struct {
+		Name               string
+		ModelCode          string
+		ExpectedBody       string
+		ExpectedStatusCode int
+	}{
+		{Name: "** Testing: Devices of a model code. **", ModelCode: "42", ExpectedBody: "{\"devices\":[{\"id\":\"id_test1\"},{\"id\":\"id_test4\"}]}", ExpectedStatusCode: 200},
+		{Name: "** Testing: Model code without devices. **", ModelCode: "8", ExpectedBody: "{\"devices\":[]}", ExpectedStatusCode: 200},
+		{Name: "** Testing: Zero model code. **", ModelCode: "0", ExpectedBody: "Invalid parameter: modelCode must be a positive integer.", ExpectedStatusCode: 400},
+		{Name: "** Testing: Non numeric model code. **", ModelCode: "abc", ExpectedBody: "Invalid parameter: modelCode must be a positive integer.", ExpectedStatusCode: 400},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := ListDevices(events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"fields": "id", "modelCode": test.ModelCode}})
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+	if expected := "#modelCode = :modelCode"; !strings.Contains(mock.FilterExpression, expected) {
+		t.Errorf("** Testing: Filter of a model code. ** \n \t<expected filter with: %s> <resulted filter: %s>", expected, mock.FilterExpression)
+	}
+} // End of TestListDevicesModelCode function
+
+// Only the devices at or above the asked firmware are listed, the versions being compared numerically, not as text.
+func TestListDevicesMinFirmware(t *testing.T) {
+	device := func(id string, firmware string) map[string]*dynamodb.AttributeValue {
+		item := map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}}
+		if firmware != "" {
+			item["firmwareVersion"] = &dynamodb.AttributeValue{S: aws.String(firmware)}
+		}
+		return item
+	}
+	mock := &MockDynamoDB{Items: []map[string]*dynamodb.AttributeValue{
+		device("id_test1", "1.9.0"),
+		device("id_test2", "1.10.0"),
+		device("id_test3", ""),
+		device("id_test4", "2.0.0-rc.1"),
+	}}
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	testCases := []struct {
+		Name               string
+		Query              map[string]string
+		ExpectedBody       string
+		ExpectedStatusCode int
+	}{
+		{Name: "** Testing: Devices above a minor version. **", Query: map[string]string{"fields": "id", "minFirmware": "1.10.0"}, ExpectedBody: "{\"devices\":[{\"id\":\"id_test2\"},{\"id\":\"id_test4\"}]}", ExpectedStatusCode: 200},
+		{Name: "** Testing: Pre-release below its release. **", Query: map[string]string{"fields": "id", "minFirmware": "2.0.0"}, ExpectedBody: "{\"devices\":[]}", ExpectedStatusCode: 200},
+		{Name: "** Testing: Firmware asked for. **", Query: map[string]string{"fields": "id,firmwareVersion", "minFirmware": "2.0.0-beta"}, ExpectedBody: "{\"devices\":[{\"firmwareVersion\":\"2.0.0-rc.1\",\"id\":\"id_test4\"}]}", ExpectedStatusCode: 200},
+		{Name: "** Testing: Whole devices. **", Query: map[string]string{"minFirmware": "1.9.0"}, ExpectedBody: "{\"devices\":[{\"id\":\"id_test1\",\"deviceModel\":\"\",\"name\":\"\",\"note\":\"\",\"serial\":\"\",\"firmwareVersion\":\"1.9.0\"},{\"id\":\"id_test2\",\"deviceModel\":\"\",\"name\":\"\",\"note\":\"\",\"serial\":\"\",\"firmwareVersion\":\"1.10.0\"},{\"id\":\"id_test4\",\"deviceModel\":\"\",\"name\":\"\",\"note\":\"\",\"serial\":\"\",\"firmwareVersion\":\"2.0.0-rc.1\"}]}", ExpectedStatusCode: 200},
+		{Name: "** Testing: Malformed firmware. **", Query: map[string]string{"minFirmware": "1.2"}, ExpectedBody: "Invalid parameter: minFirmware must be a semantic version, i.e: 1.2.3.", ExpectedStatusCode: 400},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := ListDevices(events.APIGatewayProxyRequest{QueryStringParameters: test.Query})
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+} // End of TestListDevicesMinFirmware function
+
+// The precedence of semantic versions which the minFirmware filter relies on.
+func TestSemverCompare(t *testing.T) {
+	testCases := []struct {
+		A, B     string
+		Expected int
+	}{
+		{A: "1.2.3", B: "1.2.3", Expected: 0},
+		{A: "1.10.0", B: "1.9.0", Expected: 1},
+		{A: "1.9.0", B: "1.10.0", Expected: -1},
+		{A: "2.0.0", B: "10.0.0", Expected: -1},
+		{A: "1.0.10", B: "1.0.2", Expected: 1},
+		{A: "2.0.0-rc.1", B: "2.0.0", Expected: -1},
+		{A: "1.0.0-alpha", B: "1.0.0-alpha.1", Expected: -1},
+		{A: "1.0.0-alpha.1", B: "1.0.0-alpha.beta", Expected: -1},
+		{A: "1.0.0-beta.11", B: "1.0.0-beta.2", Expected: 1},
+		{A: "1.0.0-rc.1", B: "1.0.0-beta.11", Expected: 1},
+		{A: "1.2.3+build.5", B: "1.2.3", Expected: 0},
+		{A: "1.2", B: "1.0.0", Expected: -1},
+	}
+
+	for _, test := range testCases {
+		if result := semver.Compare(test.A, test.B); result != test.Expected {
+			t.Errorf("** Testing: Compare %s to %s. ** \n \t<expected: %d> <resulted: %d>", test.A, test.B, test.Expected, result)
+		}
+	}
+	for _, version := range []string{"1.2", "v1.2.3", "01.2.3", "1.2.3-", "1.2.3-01"} {
+		if semver.Valid(version) {
+			t.Errorf("** Testing: Malformed version %s. ** \n \t<expected invalid> <resulted valid>", version)
+		}
+	}
+} // End of TestSemverCompare function
+
+// The paging is also returned through the X-Next-Token and X-Total-Scanned headers, X-Next-Token only while there are more pages.
+func TestListDevicesPageHeaders(t *testing.T) {
+	mock := &MockDynamoDB{Items: []map[string]*dynamodb.AttributeValue{
+		{"id": {S: aws.String("id_test1")}},
+		{"id": {S: aws.String("id_test2")}, "deleted": {BOOL: aws.Bool(true)}},
+		{"id": {S: aws.String("id_test3")}},
+	}}
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	FirstPageToken := EncodeNextToken(map[string]*dynamodb.AttributeValue{"id": {S: aws.String("id_test2")}})
+	testCases := []struct {
+		Name                 string
+		Query                map[string]string
+		ExpectedNextToken    string
+		ExpectedTotalScanned string
+	}{
+		{Name: "** Testing: Headers of a paged response. **", Query: map[string]string{"limit": "2"}, ExpectedNextToken: FirstPageToken, ExpectedTotalScanned: "2"},
+		{Name: "** Testing: Headers of the last page. **", Query: map[string]string{"limit": "2", "nextToken": FirstPageToken}, ExpectedNextToken: "", ExpectedTotalScanned: "1"},
+		{Name: "** Testing: Headers of a projected page. **", Query: map[string]string{"limit": "2", "fields": "id"}, ExpectedNextToken: FirstPageToken, ExpectedTotalScanned: "2"},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := ListDevices(events.APIGatewayProxyRequest{QueryStringParameters: test.Query})
+		nextToken, hasNextToken := response.Headers["X-Next-Token"]
+		if response.StatusCode != 200 || nextToken != test.ExpectedNextToken || hasNextToken != (test.ExpectedNextToken != "") {
+			t.Errorf("%s \n \t<expected X-Next-Token: %q> <resulted X-Next-Token: %q, present: %t> <resulted body: %s>", test.Name, test.ExpectedNextToken, nextToken, hasNextToken, response.Body)
+		}
+		if totalScanned := response.Headers["X-Total-Scanned"]; totalScanned != test.ExpectedTotalScanned {
+			t.Errorf("%s \n \t<expected X-Total-Scanned: %s> <resulted X-Total-Scanned: %s>", test.Name, test.ExpectedTotalScanned, totalScanned)
+		}
+	}
+} // End of TestListDevicesPageHeaders function
+
+// The page size is DEFAULT_PAGE_SIZE when it's omitted and is clamped to MAX_PAGE_SIZE, both with their own defaults.
+func TestListDevicesPageSize(t *testing.T) {
+	mock := &MockDynamoDB{Items: []map[string]*dynamodb.AttributeValue{{"id": {S: aws.String("id_test1")}}}}
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	testCases := []struct {
+		Name            string
+		Limit           string
+		DefaultPageSize string
+		MaxPageSize     string
+		ExpectedLimit   int64
+	}{
+		{Name: "** Testing: Default page size. **", ExpectedLimit: 50},
+		{Name: "** Testing: Configured default page size. **", DefaultPageSize: "20", ExpectedLimit: 20},
+		{Name: "** Testing: Malformed default page size. **", DefaultPageSize: "none", ExpectedLimit: 50},
+		{Name: "** Testing: Limit within the max. **", Limit: "200", ExpectedLimit: 200},
+		{Name: "** Testing: Limit clamped at the max. **", Limit: "5000", ExpectedLimit: 1000},
+		{Name: "** Testing: Limit clamped at the configured max. **", Limit: "200", MaxPageSize: "100", ExpectedLimit: 100},
+		{Name: "** Testing: Default page size clamped at the max. **", DefaultPageSize: "200", MaxPageSize: "100", ExpectedLimit: 100},
+	}
+
+	for _, test := range testCases {
+		t.Setenv("DEFAULT_PAGE_SIZE", test.DefaultPageSize)
+		t.Setenv("MAX_PAGE_SIZE", test.MaxPageSize)
+		query := map[string]string{}
+		if test.Limit != "" {
+			query["limit"] = test.Limit
+		}
+
+		// Executing each test cases scenario.
+		response, _ := ListDevices(events.APIGatewayProxyRequest{QueryStringParameters: query})
+		if response.StatusCode != 200 || mock.Limit != test.ExpectedLimit {
+			t.Errorf("%s \n \t<expected limit: %d> <resulted limit: %d> <resulted error-code: %d>", test.Name, test.ExpectedLimit, mock.Limit, response.StatusCode)
+		}
+	}
+} // End of TestListDevicesPageSize function
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"gateway"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"os"
+	"owner"
+	"placeholder"
+	"recovery"
+	"sort"
+	"strings"
+	"types"
+)
+
+type AmazonWebServices struct {
+	Config   *aws.Config
+	Session  *session.Session
+	DynamoDB dynamodbiface.DynamoDBAPI
+}
+
+// Prepare a new AWS & DynamoDB session, then configure it.
+var TestAws *AmazonWebServices
+
+func init() {
+	region := os.Getenv("AWS_REGION")
+	var Aws *AmazonWebServices = new(AmazonWebServices)
+	Aws.Config = &aws.Config{Region: aws.String(region)}
+	// Pointing the client to a local DynamoDB, i.e: DynamoDB Local for the integration tests. It's unset in production.
+	if endpoint := os.Getenv("DYNAMODB_ENDPOINT"); endpoint != "" {
+		Aws.Config.Endpoint = aws.String(endpoint)
+	}
+	var err error
+	Aws.Session, err = session.NewSession(Aws.Config)
+	if err != nil {
+		// Logs error on Amazon CloudWatch. It's sysadmin's duty to handle it.
+		fmt.Println(fmt.Sprintf("Failed to connect to AWS: %s", err.Error()))
+	} else {
+		var svc *dynamodb.DynamoDB = dynamodb.New(Aws.Session)
+		Aws.DynamoDB = dynamodbiface.DynamoDBAPI(svc)
+	}
+	// Instantiate a global session in TestAws
+	TestAws = Aws
+}
+
+// Preparing DynamoDB Session and Calling DB's Scan function inside, counting the devices of each model.
+// Only the model of the devices is projected, and the scan pages through the whole table as it reads at most 1 MB at a
+// time. Soft deleted devices and the devices without a model are never counted, a non empty ownerID counts only its own.
+func (self *AmazonWebServices) CountModels(ownerID string) (map[string]int64, error) {
+	// Get desire table's name from OS's environmental varible.
+	tableName := aws.String(os.Getenv("DEVICES_TABLE_NAME"))
+
+	names := placeholder.Names{}
+	conditions := []string{fmt.Sprintf("(attribute_not_exists(%[1]s) OR %[1]s = :false)", names.Of("deleted"))}
+	values := map[string]*dynamodb.AttributeValue{":false": {BOOL: aws.Bool(false)}}
+	if ownerID != "" {
+		conditions = append(conditions, fmt.Sprintf("%s = :owner", names.Of("ownerId")))
+		values[":owner"] = &dynamodb.AttributeValue{S: aws.String(ownerID)}
+	}
+
+	names["#legacyModel"] = aws.String(types.LegacyModelAttribute)
+	var input = &dynamodb.ScanInput{
+		TableName:                 tableName,
+		ProjectionExpression:      aws.String(names.Field("deviceModel") + ", #legacyModel"),
+		FilterExpression:          aws.String(strings.Join(conditions, " AND ")),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	}
+
+	counts := map[string]int64{}
+	for {
+		// Calling either Scan function of interface, defined in listModels_test.go file, or api with the input we've provided.
+		// In real deployment environment, the Scan function of aws (api.go) will be called.
+		result, err := self.DynamoDB.Scan(input)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range result.Items {
+			// A device stored without a model has no such attribute at all, and one written before the model was
+			// stored as "device_model" has it as "deviceModel" until it's migrated.
+			model := item[placeholder.Stored("deviceModel")]
+			if model == nil {
+				model = item[types.LegacyModelAttribute]
+			}
+			if model != nil && aws.StringValue(model.S) != "" {
+				counts[aws.StringValue(model.S)]++
+			}
+		}
+		// DynamoDB has more items for us only when it returns a LastEvaluatedKey.
+		if len(result.LastEvaluatedKey) == 0 {
+			return counts, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+// The handler function which will be first started from main function.
+// Returns the distinct models of the devices sorted by name, each along with its number of devices, i.e: to populate
+// a dropdown. Like ListDevices, only the devices of the caller's tenant are counted.
+func ListModels(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !gateway.AllowsMethod(request, "GET") {
+		return gateway.MethodNotAllowed("GET"), nil
+	}
+
+	counts, err := TestAws.CountModels(owner.Caller(request))
+
+	// If an internal error have occurred in the database, return HTTP error code 500.
+	if err != nil {
+		// Logs error on Amazon CloudWatch. It's sysadmin's duty to handle it.
+		fmt.Println(fmt.Sprintf("Failed to scan the models: %s", err.Error()))
+		return events.APIGatewayProxyResponse{
+			Body:       "Internal Server Error.",
+			StatusCode: 500,
+		}, nil
+	}
+
+	// Starting from an empty slice, so a table without devices is returned with "[]" instead of "null".
+	models := make([]types.ModelCount, 0, len(counts))
+	for model, count := range counts {
+		models = append(models, types.ModelCount{Model: model, Count: count})
+	}
+	sort.Slice(models, func(i, j int) bool { return models[i].Model < models[j].Model })
+
+	// Serialization/Encoding the models to JSON.
+	modelsJson, _ := json.Marshal(models)
+	return events.APIGatewayProxyResponse{
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(modelsJson),
+		StatusCode: 200,
+	}, nil
+} // End of ListModels function
+
+func main() {
+	lambda.Start(gateway.Adapt(recovery.WithRecover(ListModels)))
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Mocking DynamoDB through dynamodbiface.
+type MockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	// Models of the devices of the pages which the mocked Scan returns one after another, and the error instead of them.
+	Pages [][]string
+	Error error
+	// Inputs of every Scan call.
+	Inputs []*dynamodb.ScanInput
+}
+
+// Custom Scan function for overriding the Scan of listModels.go for using in test scenarios.
+// Pages are keyed by their position, every page but the last one returns a LastEvaluatedKey.
+// An empty model stands for a device stored without one, and a "legacy:" one for a model stored as "deviceModel".
+func (self *MockDynamoDB) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	self.Inputs = append(self.Inputs, input)
+	if self.Error != nil {
+		return nil, self.Error
+	}
+	page := 0
+	if input.ExclusiveStartKey != nil {
+		previous, _ := strconv.Atoi(aws.StringValue(input.ExclusiveStartKey["page"].N))
+		page = previous + 1
+	}
+	MockOutput := &dynamodb.ScanOutput{}
+	for _, model := range self.Pages[page] {
+		item := map[string]*dynamodb.AttributeValue{}
+		if strings.HasPrefix(model, "legacy:") {
+			item["deviceModel"] = &dynamodb.AttributeValue{S: aws.String(strings.TrimPrefix(model, "legacy:"))}
+		} else if model != "" {
+			item["device_model"] = &dynamodb.AttributeValue{S: aws.String(model)}
+		}
+		MockOutput.Items = append(MockOutput.Items, item)
+	}
+	if page < len(self.Pages)-1 {
+		MockOutput.LastEvaluatedKey = map[string]*dynamodb.AttributeValue{"page": {N: aws.String(strconv.Itoa(page))}}
+	}
+	return MockOutput, nil
+}
+
+// ListModels function in listModels.go signature: input: (request events.APIGatewayProxyRequest), output: (events.APIGatewayProxyResponse, error)
+func TestListModels(t *testing.T) {
+	testCases := []struct {
+		Name               string
+		MockDatabase       *MockDynamoDB
+		ExpectedBody       string
+		ExpectedStatusCode int
+		ExpectedScans      int
+	}{
+		{
+			Name:               "** Testing: Models across two pages. **",
+			MockDatabase:       &MockDynamoDB{Pages: [][]string{{"sensor", "camera", "sensor"}, {"", "thermostat", "sensor", "camera", "legacy:camera"}}},
+			ExpectedBody:       "[{\"model\":\"camera\",\"count\":3},{\"model\":\"sensor\",\"count\":3},{\"model\":\"thermostat\",\"count\":1}]",
+			ExpectedStatusCode: 200,
+			ExpectedScans:      2,
+		},
+
+		{
+			Name:               "** Testing: Empty table. **",
+			MockDatabase:       &MockDynamoDB{Pages: [][]string{{}}},
+			ExpectedBody:       "[]",
+			ExpectedStatusCode: 200,
+			ExpectedScans:      1,
+		},
+
+		{
+			Name:               "** Database Unexpected Error **",
+			MockDatabase:       &MockDynamoDB{Error: errors.New("unexpected Error has occurred")},
+			ExpectedBody:       "Internal Server Error.",
+			ExpectedStatusCode: 500,
+			ExpectedScans:      1,
+		},
+	}
+
+	realAws := TestAws
+	defer func() { TestAws = realAws }()
+
+	for _, test := range testCases {
+		// Executing each test cases scenario against its own mocked database.
+		TestAws = &AmazonWebServices{DynamoDB: test.MockDatabase}
+		response, _ := ListModels(events.APIGatewayProxyRequest{})
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+		if len(test.MockDatabase.Inputs) != test.ExpectedScans || aws.StringValue(test.MockDatabase.Inputs[0].ProjectionExpression) != "#deviceModel, #legacyModel" {
+			t.Errorf("%s \n \t<expected %d scans projecting the model only> <resulted scans: %d>", test.Name, test.ExpectedScans, len(test.MockDatabase.Inputs))
+		}
+	}
+
+	// The devices of the caller's tenant only are counted, after hiding the soft deleted devices.
+	scoped := &MockDynamoDB{Pages: [][]string{{"sensor"}}}
+	TestAws = &AmazonWebServices{DynamoDB: scoped}
+	ListModels(events.APIGatewayProxyRequest{RequestContext: events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"claims": map[string]interface{}{"sub": "tenant-a"}}}})
+	expected := "(attribute_not_exists(#deleted) OR #deleted = :false) AND #ownerId = :owner"
+	if expression := aws.StringValue(scoped.Inputs[0].FilterExpression); expression != expected || aws.StringValue(scoped.Inputs[0].ExpressionAttributeValues[":owner"].S) != "tenant-a" {
+		t.Errorf("** Testing: Filter of the models. ** \n \t<expected filter: %s> <resulted filter: %s>", expected, expression)
+	}
+} // End of TestListModels function
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"attributes"
+	"audit"
+	"encoding/json"
+	"etag"
+	"fmt"
+	"gateway"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"history"
+	"negotiation"
+	"os"
+	"owner"
+	"placeholder"
+	"recovery"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"types"
+	"validation"
+)
+
+type AmazonWebServices struct {
+	Config   *aws.Config
+	Session  *session.Session
+	DynamoDB dynamodbiface.DynamoDBAPI
+}
+
+// Prepare a new AWS & DynamoDB session, then configure it.
+var TestAws *AmazonWebServices
+
+// Positions of the writes in the transaction of MergeWithHistory, which its cancellation reasons follow.
+const (
+	deviceWrite = iota
+	historyWrite
+)
+
+// Fields which a merge patch may set or remove, the others are set on the server side.
+// Removing a required one fails its validation, like omitting it from AddDevice. The serial may only be set to the
+// one stored, since it's changed by RotateSerial along with its marker in SERIALS_TABLE_NAME.
+var mergeableFields = []string{"deviceModel", "name", "note", "serial", "status", "tags", "firmwareVersion", "expiresAt"}
+
+func init() {
+	region := os.Getenv("AWS_REGION")
+	var Aws *AmazonWebServices = new(AmazonWebServices)
+	Aws.Config = &aws.Config{Region: aws.String(region)}
+	// Pointing the client to a local DynamoDB, i.e: DynamoDB Local for the integration tests. It's unset in production.
+	if endpoint := os.Getenv("DYNAMODB_ENDPOINT"); endpoint != "" {
+		Aws.Config.Endpoint = aws.String(endpoint)
+	}
+	var err error
+	Aws.Session, err = session.NewSession(Aws.Config)
+	if err != nil {
+		// Logs error on Amazon CloudWatch. It's sysadmin's duty to handle it.
+		fmt.Println(fmt.Sprintf("Failed to connect to AWS: %s", err.Error()))
+	} else {
+		var svc *dynamodb.DynamoDB = dynamodb.New(Aws.Session)
+		Aws.DynamoDB = dynamodbiface.DynamoDBAPI(svc)
+	}
+	// Instantiate a global session in TestAws
+	TestAws = Aws
+}
+
+// Preparing DynamoDB Session and Calling DB's GetItem function inside, reading the device which the patch is merged into.
+func (self *AmazonWebServices) Get(id string) (*dynamodb.GetItemOutput, error) {
+	// Get desire table's name from OS's environmental varible.
+	tableName := aws.String(os.Getenv("DEVICES_TABLE_NAME"))
+
+	var input = &dynamodb.GetItemInput{
+		TableName: tableName,
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(id),
+			},
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+
+	// Calling either GetItem function of interface, defined in mergePatchDevice_test.go file, or api with the input we've provided.
+	// In real deployment environment, the GetItem function of aws (api.go) will be called.
+	result, err := self.DynamoDB.GetItem(input)
+	return result, err
+}
+
+// Preparing DynamoDB Session and Calling DB's UpdateItem function inside, setting the attributes of set and removing
+// the removed ones with a REMOVE clause, then incrementing the version. The device is only updated if it still has the
+// version which the patch has been merged into, so a concurrent change is never overwritten.
+// A non empty ownerID makes it fail for a device of another owner as well.
+func (self *AmazonWebServices) Merge(id string, set map[string]*dynamodb.AttributeValue, removed []string, version int, updatedAt string, ownerID string) (*dynamodb.UpdateItemOutput, error) {
+	// Get desire table's name from OS's environmental varible.
+	tableName := aws.String(os.Getenv("DEVICES_TABLE_NAME"))
+
+	expression, condition, names, values := mergeExpression(set, removed, version, updatedAt, ownerID)
+	var input = &dynamodb.UpdateItemInput{
+		TableName: tableName,
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(id),
+			},
+		},
+		UpdateExpression:          aws.String(expression),
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		ReturnValues:              aws.String(dynamodb.ReturnValueAllNew),
+	}
+
+	// Calling either UpdateItem function of interface, defined in mergePatchDevice_test.go file, or api with the input we've provided.
+	// In real deployment environment, the UpdateItem function of aws (api.go) will be called.
+	result, err := self.DynamoDB.UpdateItem(input)
+	return result, err
+}
+
+// Preparing DynamoDB Session and Calling DB's TransactWriteItems function inside. The device is updated like in Merge,
+// while the stored device which the patch has been merged into is appended to the history table (HISTORY_TABLE_NAME)
+// in the same transaction, as UpdateDevice keeps the devices it replaces. A failed condition, of the device or of a
+// version which is already in the history, is returned as a *dynamodb.ConditionalCheckFailedException like the one of
+// Merge. UpdateItem isn't called, so the updated device is built from the stored one.
+func (self *AmazonWebServices) MergeWithHistory(stored map[string]*dynamodb.AttributeValue, set map[string]*dynamodb.AttributeValue, removed []string, version int, updatedAt string, ownerID string) (map[string]*dynamodb.AttributeValue, error) {
+	// Get desire tables' names from OS's environmental varibles.
+	tableName := aws.String(os.Getenv("DEVICES_TABLE_NAME"))
+	historyTableName := os.Getenv("HISTORY_TABLE_NAME")
+
+	expression, condition, names, values := mergeExpression(set, removed, version, updatedAt, ownerID)
+	var input = &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			deviceWrite: {Update: &dynamodb.Update{
+				Key:                       map[string]*dynamodb.AttributeValue{"id": stored["id"]},
+				TableName:                 tableName,
+				UpdateExpression:          aws.String(expression),
+				ConditionExpression:       aws.String(condition),
+				ExpressionAttributeNames:  names,
+				ExpressionAttributeValues: values,
+			}},
+			historyWrite: {Put: history.Put(historyTableName, stored)},
+		},
+	}
+
+	// Calling either TransactWriteItems function of interface, defined in mergePatchDevice_test.go file, or api with the input we've provided.
+	// In real deployment environment, the TransactWriteItems function of aws (api.go) will be called.
+	_, err := self.DynamoDB.TransactWriteItems(input)
+	if canceled, ok := err.(*dynamodb.TransactionCanceledException); ok {
+		if reasonFailed(canceled, deviceWrite) || reasonFailed(canceled, historyWrite) {
+			return nil, &dynamodb.ConditionalCheckFailedException{Message_: aws.String("The conditional request failed")}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	updated := map[string]*dynamodb.AttributeValue{}
+	for attribute, value := range stored {
+		updated[attribute] = value
+	}
+	for attribute, value := range set {
+		updated[attribute] = value
+	}
+	for _, attribute := range removed {
+		delete(updated, attribute)
+	}
+	updated["updatedAt"] = &dynamodb.AttributeValue{S: aws.String(updatedAt)}
+	updated["version"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(version + 1))}
+	return updated, nil
+}
+
+// Building the update expression of a merge, setting the attributes of set, removing the removed ones and incrementing
+// the version, along with its condition, placeholders and values.
+func mergeExpression(set map[string]*dynamodb.AttributeValue, removed []string, version int, updatedAt string, ownerID string) (string, string, placeholder.Names, map[string]*dynamodb.AttributeValue) {
+	names := placeholder.Names{}
+	values := map[string]*dynamodb.AttributeValue{
+		":updatedAt": {S: aws.String(updatedAt)},
+		":one":       {N: aws.String("1")},
+	}
+	var attributes []string
+	for attribute := range set {
+		attributes = append(attributes, attribute)
+	}
+	// Sorted, so the same patch always gets the same expression.
+	sort.Strings(attributes)
+	var clauses []string
+	for _, attribute := range attributes {
+		values[":"+attribute] = set[attribute]
+		clauses = append(clauses, fmt.Sprintf("%s = :%s", names.Of(attribute), attribute))
+	}
+	clauses = append(clauses, fmt.Sprintf("%s = :updatedAt", names.Of("updatedAt")))
+	condition := fmt.Sprintf("attribute_exists(%s) AND attribute_not_exists(%s)", names.Of("id"), names.Of("deleted"))
+	// The devices stored before versioning have no version yet.
+	if version == 0 {
+		clauses = append(clauses, fmt.Sprintf("%s = :one", names.Of("version")))
+		condition += fmt.Sprintf(" AND attribute_not_exists(%s)", names.Of("version"))
+	} else {
+		clauses = append(clauses, fmt.Sprintf("%[1]s = %[1]s + :one", names.Of("version")))
+		condition += fmt.Sprintf(" AND %s = :version", names.Of("version"))
+		values[":version"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(version))}
+	}
+	expression := "SET " + strings.Join(clauses, ", ")
+	if len(removed) > 0 {
+		var removedNames []string
+		for _, attribute := range removed {
+			removedNames = append(removedNames, names.Of(attribute))
+		}
+		expression += " REMOVE " + strings.Join(removedNames, ", ")
+	}
+	if ownerID != "" {
+		condition += fmt.Sprintf(" AND %s = :owner", names.Of("ownerId"))
+		values[":owner"] = &dynamodb.AttributeValue{S: aws.String(ownerID)}
+	}
+	return expression, condition, names, values
+}
+
+// Checking whether a write of a cancelled transaction has failed its condition.
+func reasonFailed(canceled *dynamodb.TransactionCanceledException, write int) bool {
+	return write < len(canceled.CancellationReasons) && aws.StringValue(canceled.CancellationReasons[write].Code) == "ConditionalCheckFailed"
+}
+
+// The handler function which will be first started from main function.
+// The body is a JSON merge patch (RFC 7386) of the device: its fields are set, the ones which are null are removed
+// and the tags are merged the same way, key by key. The merged device gets the same checks as AddDevice and is
+// returned as a whole. With HISTORY_TABLE_NAME set, the device as it was before the patch is kept in the history table.
+func MergePatchDevice(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !gateway.AllowsMethod(request, "PATCH") {
+		return gateway.MethodNotAllowed("PATCH"), nil
+	}
+
+	// The id which user has sent through PATCH method.
+	id := request.PathParameters["id"]
+
+	// If no id have been provided, return HTTP error code 400.
+	if id == "" {
+		return events.APIGatewayProxyResponse{
+			Body:       "Missing field: id",
+			StatusCode: 400,
+		}, nil
+	}
+
+	if len(request.Body) == 0 {
+		return events.APIGatewayProxyResponse{
+			Body:       "No inputs provided, please provide the merge patch in JSON format.",
+			StatusCode: 400,
+		}, nil
+	}
+
+	if err := validation.CheckBodySize(request); err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:       err.Error(),
+			StatusCode: 413,
+		}, nil
+	}
+
+	// De-serialize "request.Body" as a JSON object, a null field being kept to tell it from a missing one.
+	var Patch map[string]interface{}
+	if err := json.Unmarshal([]byte(request.Body), &Patch); err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:       validation.JSONFailure("Wrong format: Inputs must be a valid JSON", err),
+			StatusCode: 400,
+		}, nil
+	}
+	if len(Patch) == 0 {
+		return events.APIGatewayProxyResponse{
+			Body:       "No fields to change provided.",
+			StatusCode: 400,
+		}, nil
+	}
+
+	// The id of a device can neither be removed nor changed, and server side fields can not be set by the user.
+	if patchedID, ok := Patch["id"]; ok {
+		if patchedID == nil {
+			return events.APIGatewayProxyResponse{
+				Body:       "Invalid field: ID can not be removed.",
+				StatusCode: 400,
+			}, nil
+		}
+		if patchedID != id {
+			return events.APIGatewayProxyResponse{
+				Body:       "Invalid field: ID can not be changed.",
+				StatusCode: 400,
+			}, nil
+		}
+		delete(Patch, "id")
+	}
+	for name := range Patch {
+		if !isMergeable(name) {
+			return events.APIGatewayProxyResponse{
+				Body:       fmt.Sprintf("Invalid field: %s can not be patched.", name),
+				StatusCode: 400,
+			}, nil
+		}
+	}
+
+	result, err := TestAws.Get(id)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Failed to get the device: %s", err.Error()))
+		return events.APIGatewayProxyResponse{
+			Body:       "Internal Server Error\nDatabase error.",
+			StatusCode: 500,
+		}, nil
+	}
+
+	// A missing, soft deleted or another tenant's device is reported as not found, like in GetDeviceById, unless it's
+	// shared with one of the groups of the caller.
+	Stored := types.Device{}
+	dynamodbattribute.UnmarshalMap(result.Item, &Stored)
+	caller := owner.Caller(request)
+	if len(result.Item) == 0 || Stored.Deleted || !owner.Allows(request, Stored.OwnerID, Stored.AllowedGroups) {
+		return events.APIGatewayProxyResponse{
+			Body:       "Desired device not found.",
+			StatusCode: 404,
+		}, nil
+	}
+
+	// Merging the patch into the stored device as JSON documents, then validating the result like a created device.
+	var Target map[string]interface{}
+	StoredJson, _ := json.Marshal(Stored)
+	json.Unmarshal(StoredJson, &Target)
+	MergedJson, _ := json.Marshal(mergePatch(Target, Patch))
+	Merged, err := validation.ValidateInputs(events.APIGatewayProxyRequest{Body: string(MergedJson)})
+	if err != nil {
+		body := err.Error()
+		// Field failures are collected, so return all of them as a JSON list.
+		if fieldErrors, ok := err.(validation.FieldErrors); ok {
+			errorsJson, _ := json.Marshal(types.ErrorList{Errors: fieldErrors.Messages()})
+			body = string(errorsJson)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       body,
+			StatusCode: 400,
+		}, nil
+	}
+	// The device is only written if it still has the version read, so it still has the serial compared here.
+	if Merged.Serial != Stored.Serial {
+		return events.APIGatewayProxyResponse{
+			Body:       "Invalid field: Serial can only be changed by rotating it.",
+			StatusCode: 400,
+		}, nil
+	}
+
+	// Only the patched attributes are written, the ones left empty by the merge, i.e: tags without any key, are removed.
+	MergedItem, _ := attributes.Marshal(Merged)
+	set := map[string]*dynamodb.AttributeValue{}
+	var removed []string
+	for _, field := range mergeableFields {
+		if _, ok := Patch[field]; !ok {
+			continue
+		}
+		// The item is keyed by the attributes which the fields are stored as.
+		attribute := placeholder.Stored(field)
+		if value, ok := MergedItem[attribute]; ok {
+			set[attribute] = value
+		} else {
+			removed = append(removed, attribute)
+		}
+	}
+
+	updatedAt := time.Now().UTC().Format(time.RFC3339)
+	// The device keeps the owner which it's been read with, the caller may be a member of a group it's shared with.
+	ownerID := ""
+	if caller != "" {
+		ownerID = Stored.OwnerID
+	}
+	updated, err := merge(result.Item, set, removed, Stored.Version, updatedAt, ownerID)
+	if err != nil {
+		// The condition has failed, so the device has changed since it's been read, the client may retry it.
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return events.APIGatewayProxyResponse{
+				Body:       "The device has been modified meanwhile, please retry.",
+				StatusCode: 409,
+			}, nil
+		}
+		fmt.Println(fmt.Sprintf("Failed to merge the patch: %s", err.Error()))
+		// If internal database errors occurred, return HTTP error code 500.
+		return events.APIGatewayProxyResponse{
+			Body:       "Internal Server Error\nDatabase error.",
+			StatusCode: 500,
+		}, nil
+	}
+
+	// Recording who has patched the device for the audit trail. It has been patched anyway, so a failure is only logged.
+	if err := audit.Write(TestAws.DynamoDB, audit.NewRecord(id, audit.ActionUpdate, caller, result.Item, updated)); err != nil {
+		fmt.Println(fmt.Sprintf("Failed to write the audit record: %s", err.Error()))
+	}
+
+	// Deserialization/Decoding the patched "updated" to Go struct, then serialization/encoding it to JSON.
+	PatchedDevice := types.Device{}
+	dynamodbattribute.UnmarshalMap(updated, &PatchedDevice)
+	PatchedDeviceJson, _ := negotiation.Marshal(negotiation.JSON, PatchedDevice)
+
+	// Everything looks fine, return HTTP 200 with the patched device.
+	return events.APIGatewayProxyResponse{
+		Headers:    map[string]string{"ETag": etag.Format(PatchedDevice.Version)},
+		Body:       string(PatchedDeviceJson),
+		StatusCode: 200,
+	}, nil
+} // End of MergePatchDevice function
+
+// Merging a patch into the stored device, keeping the stored device in the history table when HISTORY_TABLE_NAME is set.
+// Returns the updated device.
+func merge(stored map[string]*dynamodb.AttributeValue, set map[string]*dynamodb.AttributeValue, removed []string, version int, updatedAt string, ownerID string) (map[string]*dynamodb.AttributeValue, error) {
+	if os.Getenv("HISTORY_TABLE_NAME") != "" {
+		return TestAws.MergeWithHistory(stored, set, removed, version, updatedAt, ownerID)
+	}
+	result, err := TestAws.Merge(aws.StringValue(stored["id"].S), set, removed, version, updatedAt, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	return result.Attributes, nil
+}
+
+// Applying a JSON merge patch to a target as of RFC 7386: a null removes the member, an object is merged into the
+// member recursively and any other value replaces it.
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+			continue
+		}
+		targetObject[name] = mergePatch(targetObject[name], value)
+	}
+	return targetObject
+}
+
+// Checking whether a field of the body is one which a merge patch may change.
+func isMergeable(name string) bool {
+	for _, field := range mergeableFields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+func main() {
+	lambda.Start(gateway.Adapt(recovery.WithRecover(MergePatchDevice)))
+}
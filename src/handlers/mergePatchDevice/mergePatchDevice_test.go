@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"types"
+)
+
+// Mocking DynamoDB through dynamodbiface.
+type MockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	// Items of the mocked table by id.
+	Items map[string]map[string]*dynamodb.AttributeValue
+	// Version which a concurrent request sets between the read and the update, if any.
+	ConcurrentVersion string
+	// Update expression of the last UpdateItem call.
+	UpdateExpression string
+	// Versions of the devices kept in the mocked history table, by "id/version".
+	History map[string]map[string]*dynamodb.AttributeValue
+}
+
+// Custom GetItem function for overriding the GetItem of mergePatchDevice.go for using in test scenarios.
+func (self *MockDynamoDB) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	MockOutput := new(dynamodb.GetItemOutput)
+	if item, ok := self.Items[aws.StringValue(input.Key["id"].S)]; ok {
+		MockOutput.SetItem(item)
+	}
+	return MockOutput, nil
+}
+
+// Custom UpdateItem function for overriding the UpdateItem of mergePatchDevice.go for using in test scenarios.
+// Applying the SET and REMOVE actions to the Items of the mock and returning the updated item, like DynamoDB it
+// rejects placeholders and values which the expressions don't use, and a version other than the expected one.
+func (self *MockDynamoDB) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	expression := aws.StringValue(input.UpdateExpression)
+	self.UpdateExpression = expression
+	expressions := expression + " " + aws.StringValue(input.ConditionExpression)
+	for name := range input.ExpressionAttributeNames {
+		if !strings.Contains(expressions, name) {
+			return nil, awserr.New("ValidationException", "Value provided in ExpressionAttributeNames unused in expressions: keys: {"+name+"}", nil)
+		}
+	}
+	for value := range input.ExpressionAttributeValues {
+		if !strings.Contains(expressions, value) {
+			return nil, awserr.New("ValidationException", "Value provided in ExpressionAttributeValues unused in expressions: keys: {"+value+"}", nil)
+		}
+	}
+	id := aws.StringValue(input.Key["id"].S)
+	item, exists := self.Items[id]
+	if self.ConcurrentVersion != "" {
+		item["version"] = &dynamodb.AttributeValue{N: aws.String(self.ConcurrentVersion)}
+	}
+	if expected := input.ExpressionAttributeValues[":version"]; !exists || expected != nil && aws.StringValue(item["version"].N) != aws.StringValue(expected.N) {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
+
+	actions := strings.SplitN(strings.TrimPrefix(expression, "SET "), " REMOVE ", 2)
+	for _, clause := range strings.Split(actions[0], ", ") {
+		sides := strings.SplitN(clause, " = ", 2)
+		attribute := aws.StringValue(input.ExpressionAttributeNames[sides[0]])
+		if strings.HasSuffix(sides[1], "+ :one") {
+			version, _ := strconv.Atoi(aws.StringValue(item["version"].N))
+			item[attribute] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(version + 1))}
+			continue
+		}
+		item[attribute] = input.ExpressionAttributeValues[sides[1]]
+	}
+	if len(actions) > 1 {
+		for _, name := range strings.Split(actions[1], ", ") {
+			delete(item, aws.StringValue(input.ExpressionAttributeNames[name]))
+		}
+	}
+	return &dynamodb.UpdateItemOutput{Attributes: item}, nil
+}
+
+// Custom TransactWriteItems function for overriding the TransactWriteItems of mergePatchDevice.go for using in test scenarios.
+// The device is updated like by UpdateItem, along with its version before the update appended to the history unless
+// it's already there. Cancels the transaction like DynamoDB, with a reason for each item in their order.
+func (self *MockDynamoDB) TransactWriteItems(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+	update, history := input.TransactItems[0].Update, input.TransactItems[1].Put
+	key := aws.StringValue(history.Item["id"].S) + "/" + aws.StringValue(history.Item["version"].N)
+	if _, ok := self.History[key]; ok {
+		return nil, &dynamodb.TransactionCanceledException{CancellationReasons: []*dynamodb.CancellationReason{{Code: aws.String("None")}, {Code: aws.String("ConditionalCheckFailed")}}}
+	}
+	// The stored device is updated in place, so the one before is copied first.
+	kept := map[string]*dynamodb.AttributeValue{}
+	for attribute, value := range history.Item {
+		kept[attribute] = value
+	}
+	_, err := self.UpdateItem(&dynamodb.UpdateItemInput{
+		Key:                       update.Key,
+		UpdateExpression:          update.UpdateExpression,
+		ConditionExpression:       update.ConditionExpression,
+		ExpressionAttributeNames:  update.ExpressionAttributeNames,
+		ExpressionAttributeValues: update.ExpressionAttributeValues,
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return nil, &dynamodb.TransactionCanceledException{CancellationReasons: []*dynamodb.CancellationReason{{Code: aws.String("ConditionalCheckFailed")}, {Code: aws.String("None")}}}
+	}
+	if err != nil {
+		return nil, err
+	}
+	self.History[key] = kept
+	return new(dynamodb.TransactWriteItemsOutput), nil
+}
+
+// A mocked table with a single device, which has tags and a firmware version.
+func newMock(id string) *MockDynamoDB {
+	item, _ := dynamodbattribute.MarshalMap(types.Device{
+		ID: id, DeviceModel: "testDeviceModel", Name: "testName", Note: "testNote", Serial: "testSerial",
+		Tags: types.Tags{"floor": "2", "room": "kitchen"}, FirmwareVersion: "1.2.3", Version: 2, OwnerID: "tenant-a",
+	})
+	return &MockDynamoDB{Items: map[string]map[string]*dynamodb.AttributeValue{id: item}, History: map[string]map[string]*dynamodb.AttributeValue{}}
+}
+
+// MergePatchDevice function in mergePatchDevice.go signature: input: (request events.APIGatewayProxyRequest), output: (events.APIGatewayProxyResponse, error)
+func TestMergePatchDevice(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	defer func() { TestAws = realAws }()
+
+	id := "7c9e6679-7425-40de-944b-e07fc1f90ae7"
+	testCases := []struct {
+		Name             string
+		Body             string
+		ExpectedName     string
+		ExpectedTags     types.Tags
+		ExpectedFirmware string
+		ExpectedRemoved  string
+	}{
+		{Name: "** Testing: Setting a field. **", Body: "{\"name\":\"newName\"}", ExpectedName: "newName", ExpectedTags: types.Tags{"floor": "2", "room": "kitchen"}, ExpectedFirmware: "1.2.3"},
+		{Name: "** Testing: Removing a field via null. **", Body: "{\"firmwareVersion\":null}", ExpectedName: "testName", ExpectedTags: types.Tags{"floor": "2", "room": "kitchen"}, ExpectedRemoved: "REMOVE #firmwareVersion"},
+		{Name: "** Testing: Merging the tags. **", Body: "{\"tags\":{\"floor\":null,\"desk\":\"4\"}}", ExpectedName: "testName", ExpectedTags: types.Tags{"desk": "4", "room": "kitchen"}, ExpectedFirmware: "1.2.3"},
+		{Name: "** Testing: Removing every tag. **", Body: "{\"tags\":{\"floor\":null,\"room\":null}}", ExpectedName: "testName", ExpectedFirmware: "1.2.3", ExpectedRemoved: "REMOVE #tags"},
+		{Name: "** Testing: Setting and removing at once. **", Body: "{\"id\":\"" + id + "\",\"name\":\"newName\",\"tags\":null}", ExpectedName: "newName", ExpectedFirmware: "1.2.3", ExpectedRemoved: "REMOVE #tags"},
+	}
+
+	for _, test := range testCases {
+		mock := newMock(id)
+		TestAws = &AmazonWebServices{DynamoDB: mock}
+
+		// Executing each test cases scenario.
+		response, _ := MergePatchDevice(events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": id}, Body: test.Body})
+		if response.StatusCode != 200 {
+			t.Errorf("%s \n \t<expected error-code: 200> <resulted error-code: %d> <resulted body: %s>", test.Name, response.StatusCode, response.Body)
+			continue
+		}
+		Stored := types.Device{}
+		dynamodbattribute.UnmarshalMap(mock.Items[id], &Stored)
+		if Stored.Name != test.ExpectedName || !reflect.DeepEqual(Stored.Tags, test.ExpectedTags) || Stored.FirmwareVersion != test.ExpectedFirmware || Stored.Version != 3 {
+			t.Errorf("%s \n \t<expected name: %s, tags: %v, firmware: %q, version: 3> <resulted name: %s, tags: %v, firmware: %q, version: %d>", test.Name, test.ExpectedName, test.ExpectedTags, test.ExpectedFirmware, Stored.Name, Stored.Tags, Stored.FirmwareVersion, Stored.Version)
+		}
+		if removes := strings.Contains(mock.UpdateExpression, "REMOVE"); test.ExpectedRemoved != "" && !strings.HasSuffix(mock.UpdateExpression, test.ExpectedRemoved) || test.ExpectedRemoved == "" && removes {
+			t.Errorf("%s \n \t<expected update ending with: %q> <resulted update: %s>", test.Name, test.ExpectedRemoved, mock.UpdateExpression)
+		}
+		Device := types.Device{}
+		json.Unmarshal([]byte(response.Body), &Device)
+		if !reflect.DeepEqual(Device, Stored) || response.Headers["ETag"] != "\"3\"" {
+			t.Errorf("%s \n \t<expected the stored device with ETag \"3\": %+v> <resulted device with ETag %s: %+v>", test.Name, Stored, response.Headers["ETag"], Device)
+		}
+	}
+} // End of TestMergePatchDevice function
+
+// Invalid merge patches leave the device alone: the id can neither be removed nor changed, a required field can not
+// be removed and the merged device gets the same checks as a created one.
+func TestMergePatchDeviceFailures(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	defer func() { TestAws = realAws }()
+
+	id := "7c9e6679-7425-40de-944b-e07fc1f90ae7"
+	testCases := []struct {
+		Name               string
+		ID                 string
+		Body               string
+		Caller             string
+		ConcurrentVersion  string
+		ExpectedBody       string
+		ExpectedStatusCode int
+	}{
+		{Name: "** Testing: Removing the id. **", ID: id, Body: "{\"id\":null}", ExpectedBody: "Invalid field: ID can not be removed.", ExpectedStatusCode: 400},
+		{Name: "** Testing: Changing the id. **", ID: id, Body: "{\"id\":\"a3bb189e-8bf9-3888-9912-ace4e6543002\"}", ExpectedBody: "Invalid field: ID can not be changed.", ExpectedStatusCode: 400},
+		{Name: "** Testing: Removing a required field. **", ID: id, Body: "{\"name\":null}", ExpectedBody: "{\"errors\":[\"Missing field: Name\"]}", ExpectedStatusCode: 400},
+		{Name: "** Testing: Invalid merged field. **", ID: id, Body: "{\"firmwareVersion\":\"1.2\"}", ExpectedBody: "{\"errors\":[\"Invalid field: Firmware Version must be a semantic version, i.e: 1.2.3\"]}", ExpectedStatusCode: 400},
+		{Name: "** Testing: Changing the serial. **", ID: id, Body: "{\"serial\":\"otherSerial\"}", ExpectedBody: "Invalid field: Serial can only be changed by rotating it.", ExpectedStatusCode: 400},
+		{Name: "** Testing: Server side field. **", ID: id, Body: "{\"version\":7}", ExpectedBody: "Invalid field: version can not be patched.", ExpectedStatusCode: 400},
+		{Name: "** Testing: Empty merge patch. **", ID: id, Body: "{}", ExpectedBody: "No fields to change provided.", ExpectedStatusCode: 400},
+		{Name: "** Testing: Merge patch which is not an object. **", ID: id, Body: "[]", ExpectedBody: "Wrong format: Inputs must be a valid JSON, the body must be an object, not an array, at offset 1.", ExpectedStatusCode: 400},
+		{Name: "** Testing: Missing device. **", ID: "a3bb189e-8bf9-3888-9912-ace4e6543002", Body: "{\"name\":\"newName\"}", ExpectedBody: "Desired device not found.", ExpectedStatusCode: 404},
+		{Name: "** Testing: Device of another tenant. **", ID: id, Body: "{\"name\":\"newName\"}", Caller: "tenant-b", ExpectedBody: "Desired device not found.", ExpectedStatusCode: 404},
+		{Name: "** Testing: Device modified meanwhile. **", ID: id, Body: "{\"name\":\"newName\"}", ConcurrentVersion: "5", ExpectedBody: "The device has been modified meanwhile, please retry.", ExpectedStatusCode: 409},
+	}
+
+	for _, test := range testCases {
+		mock := newMock(id)
+		mock.ConcurrentVersion = test.ConcurrentVersion
+		TestAws = &AmazonWebServices{DynamoDB: mock}
+
+		// Executing each test cases scenario.
+		response, _ := MergePatchDevice(events.APIGatewayProxyRequest{
+			PathParameters: map[string]string{"id": test.ID},
+			Body:           test.Body,
+			RequestContext: events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"sub": test.Caller}},
+		})
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+		if aws.StringValue(mock.Items[id]["name"].S) != "testName" {
+			t.Errorf("%s \n \t<expected the device untouched> <resulted name: %s>", test.Name, aws.StringValue(mock.Items[id]["name"].S))
+		}
+	}
+} // End of TestMergePatchDeviceFailures function
+
+// With HISTORY_TABLE_NAME set, the device as it was before the patch is kept in the history table, in the same
+// transaction. A version which is already there has been replaced meanwhile, so the patch is rejected.
+func TestMergePatchDeviceHistory(t *testing.T) {
+	t.Setenv("HISTORY_TABLE_NAME", "history_test")
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	defer func() { TestAws = realAws }()
+
+	id := "7c9e6679-7425-40de-944b-e07fc1f90ae7"
+	mock := newMock(id)
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	response, _ := MergePatchDevice(events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": id}, Body: "{\"name\":\"newName\",\"tags\":null}"})
+	if response.StatusCode != 200 {
+		t.Fatalf("** Testing: Merge patch kept in the history. ** \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", 200, response.StatusCode, response.Body)
+	}
+	Stored, Device := types.Device{}, types.Device{}
+	dynamodbattribute.UnmarshalMap(mock.Items[id], &Stored)
+	json.Unmarshal([]byte(response.Body), &Device)
+	if !reflect.DeepEqual(Device, Stored) || Stored.Name != "newName" || Stored.Tags != nil || Stored.Version != 3 {
+		t.Errorf("** Testing: Patched device. ** \n \t<expected the stored device: %+v> <resulted device: %+v>", Stored, Device)
+	}
+	if kept := mock.History[id+"/2"]; len(mock.History) != 1 || kept == nil || aws.StringValue(kept["name"].S) != "testName" || kept["tags"] == nil {
+		t.Errorf("** Testing: Patched device in the history. ** \n \t<expected version 2 with testName and its tags> <resulted history: %v>", mock.History)
+	}
+
+	mock = newMock(id)
+	mock.History[id+"/2"] = map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}, "version": {N: aws.String("2")}}
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	response, _ = MergePatchDevice(events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": id}, Body: "{\"name\":\"newName\"}"})
+	expected := "The device has been modified meanwhile, please retry."
+	if response.StatusCode != 409 || response.Body != expected || aws.StringValue(mock.Items[id]["name"].S) != "testName" {
+		t.Errorf("** Testing: Version already in the history. ** \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", 409, response.StatusCode, expected, response.Body)
+	}
+} // End of TestMergePatchDeviceHistory function
@@ -0,0 +1,33 @@
+package main
+
+import (
+	_ "embed"
+	"gateway"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"recovery"
+)
+
+// The OpenAPI 3.0 document of the API, embedded at compile time so it's deployed along with the function.
+// Keep it in step with the handlers and types.Device whenever an endpoint changes.
+//
+//go:embed openapi.json
+var openApiJson string
+
+// The handler function which will be first started from main function.
+// Returns the static OpenAPI document, i.e: for Swagger UI or generating clients.
+func OpenApi(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !gateway.AllowsMethod(request, "GET") {
+		return gateway.MethodNotAllowed("GET"), nil
+	}
+
+	return events.APIGatewayProxyResponse{
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       openApiJson,
+		StatusCode: 200,
+	}, nil
+} // End of OpenApi function
+
+func main() {
+	lambda.Start(gateway.Adapt(recovery.WithRecover(OpenApi)))
+}
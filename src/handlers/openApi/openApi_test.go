@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/aws/aws-lambda-go/events"
+	"testing"
+)
+
+// OpenApi function in openApi.go signature: input: (request events.APIGatewayProxyRequest), output: (events.APIGatewayProxyResponse, error)
+func TestOpenApi(t *testing.T) {
+	response, _ := OpenApi(events.APIGatewayProxyRequest{})
+	if response.StatusCode != 200 {
+		t.Errorf("** Testing: OpenAPI document ** \n \t<expected error-code: %d> <resulted error-code: %d>", 200, response.StatusCode)
+	}
+	if response.Headers["Content-Type"] != "application/json" {
+		t.Errorf("** Testing: OpenAPI document ** \n \t<expected Content-Type: %s> <resulted Content-Type: %s>", "application/json", response.Headers["Content-Type"])
+	}
+
+	var document struct {
+		OpenApi string                     `json:"openapi"`
+		Paths   map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal([]byte(response.Body), &document); err != nil {
+		t.Fatalf("** Testing: OpenAPI document ** \n \t<expected: valid JSON> <resulted error: %s>", err.Error())
+	}
+	if document.OpenApi != "3.0.3" {
+		t.Errorf("** Testing: OpenAPI document ** \n \t<expected openapi: %s> <resulted openapi: %s>", "3.0.3", document.OpenApi)
+	}
+	for _, path := range []string{"/devices", "/devices/{id}", "/addDevice"} {
+		if _, ok := document.Paths[path]; !ok {
+			t.Errorf("** Testing: OpenAPI document ** \n \t<expected path: %s> <resulted paths: %d>", path, len(document.Paths))
+		}
+	}
+}
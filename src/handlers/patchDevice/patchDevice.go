@@ -0,0 +1,401 @@
+package main
+
+import (
+	"attributes"
+	"audit"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gateway"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"history"
+	"negotiation"
+	"os"
+	"owner"
+	"placeholder"
+	"recovery"
+	"strconv"
+	"strings"
+	"time"
+	"types"
+	"validation"
+)
+
+type AmazonWebServices struct {
+	Config   *aws.Config
+	Session  *session.Session
+	DynamoDB dynamodbiface.DynamoDBAPI
+}
+
+// Prepare a new AWS & DynamoDB session, then configure it.
+var TestAws *AmazonWebServices
+
+// Positions of the writes in the transaction of PatchWithHistory, which its cancellation reasons follow.
+const (
+	deviceWrite = iota
+	historyWrite
+)
+
+// Returned by PatchWithHistory when the device has changed since it's been read.
+var errModified = errors.New("the device has been modified meanwhile")
+
+// Fields of a device which a patch may change, in the order their SET clauses are built.
+// The serial is changed by RotateSerial only, along with its marker in SERIALS_TABLE_NAME.
+var patchableFields = []string{"deviceModel", "name", "note"}
+
+func init() {
+	region := os.Getenv("AWS_REGION")
+	var Aws *AmazonWebServices = new(AmazonWebServices)
+	Aws.Config = &aws.Config{Region: aws.String(region)}
+	// Pointing the client to a local DynamoDB, i.e: DynamoDB Local for the integration tests. It's unset in production.
+	if endpoint := os.Getenv("DYNAMODB_ENDPOINT"); endpoint != "" {
+		Aws.Config.Endpoint = aws.String(endpoint)
+	}
+	var err error
+	Aws.Session, err = session.NewSession(Aws.Config)
+	if err != nil {
+		// Logs error on Amazon CloudWatch. It's sysadmin's duty to handle it.
+		fmt.Println(fmt.Sprintf("Failed to connect to AWS: %s", err.Error()))
+	} else {
+		var svc *dynamodb.DynamoDB = dynamodb.New(Aws.Session)
+		Aws.DynamoDB = dynamodbiface.DynamoDBAPI(svc)
+	}
+	// Instantiate a global session in TestAws
+	TestAws = Aws
+}
+
+// Preparing DynamoDB Session and Calling DB's UpdateItem function inside.
+// Only the given fields are SET, besides updatedAt and the version which is incremented, so concurrent full
+// updates based on the old version fail. Every attribute is referred to by a placeholder, as "name" is a reserved word.
+// The condition makes the call fail for a missing or soft deleted device, instead of creating a partial one.
+// A non empty ownerID makes it fail for a device of another owner as well, unless it's shared with one of groups.
+func (self *AmazonWebServices) Patch(id string, fields map[string]string, updatedAt string, ownerID string, groups []string) (*dynamodb.UpdateItemOutput, error) {
+	// Get desire table's name from OS's environmental varible.
+	tableName := aws.String(os.Getenv("DEVICES_TABLE_NAME"))
+
+	update, condition, names, values := patchExpression(fields, updatedAt, ownerID, groups)
+	var input = &dynamodb.UpdateItemInput{
+		TableName: tableName,
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(id),
+			},
+		},
+		UpdateExpression:          aws.String(update),
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		ReturnValues:              aws.String(dynamodb.ReturnValueAllNew),
+	}
+
+	// Calling either UpdateItem function of interface, defined in patchDevice_test.go file, or api with the input we've provided.
+	// In real deployment environment, the UpdateItem function of aws (api.go) will be called.
+	result, err := self.DynamoDB.UpdateItem(input)
+	return result, err
+}
+
+// Preparing DynamoDB Session and Calling DB's GetItem, then TransactWriteItems function inside. The device is patched
+// like in Patch, while the stored device it replaces is appended to the history table (HISTORY_TABLE_NAME) in the same
+// transaction, as UpdateDevice keeps the devices it replaces. So the device is only patched while it's still the one
+// read, and errModified is returned once it has changed, or its version is already in the history. A device which
+// fails the condition of Patch is returned as a *dynamodb.ConditionalCheckFailedException like the one of Patch.
+// UpdateItem isn't called, so the patched device is built from the stored one.
+func (self *AmazonWebServices) PatchWithHistory(id string, fields map[string]string, updatedAt string, ownerID string, groups []string) (map[string]*dynamodb.AttributeValue, error) {
+	// Get desire tables' names from OS's environmental varibles.
+	tableName := aws.String(os.Getenv("DEVICES_TABLE_NAME"))
+	historyTableName := os.Getenv("HISTORY_TABLE_NAME")
+	key := map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}}
+
+	stored, err := self.DynamoDB.GetItem(&dynamodb.GetItemInput{TableName: tableName, Key: key, ConsistentRead: aws.Bool(true)})
+	if err != nil {
+		return nil, err
+	}
+	if len(stored.Item) == 0 {
+		return nil, &dynamodb.ConditionalCheckFailedException{Message_: aws.String("The conditional request failed")}
+	}
+
+	update, condition, names, values := patchExpression(fields, updatedAt, ownerID, groups)
+	// The devices stored before versioning have no version yet.
+	if version := stored.Item["version"]; version != nil {
+		condition += fmt.Sprintf(" AND %s = :storedVersion", names.Of("version"))
+		values[":storedVersion"] = version
+	} else {
+		condition += fmt.Sprintf(" AND attribute_not_exists(%s)", names.Of("version"))
+	}
+	var input = &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			deviceWrite: {Update: &dynamodb.Update{
+				Key:                                 key,
+				TableName:                           tableName,
+				UpdateExpression:                    aws.String(update),
+				ConditionExpression:                 aws.String(condition),
+				ExpressionAttributeNames:            names,
+				ExpressionAttributeValues:           values,
+				ReturnValuesOnConditionCheckFailure: aws.String(dynamodb.ReturnValuesOnConditionCheckFailureAllOld),
+			}},
+			historyWrite: {Put: history.Put(historyTableName, stored.Item)},
+		},
+	}
+
+	// Calling either TransactWriteItems function of interface, defined in patchDevice_test.go file, or api with the input we've provided.
+	// In real deployment environment, the TransactWriteItems function of aws (api.go) will be called.
+	_, err = self.DynamoDB.TransactWriteItems(input)
+	if canceled, ok := err.(*dynamodb.TransactionCanceledException); ok {
+		// A device which still has the version read has failed the condition of Patch, i.e: it's soft deleted.
+		if reasonFailed(canceled, deviceWrite) {
+			if current := canceled.CancellationReasons[deviceWrite].Item; len(current) > 0 && storedVersion(current) != storedVersion(stored.Item) {
+				return nil, errModified
+			}
+			return nil, &dynamodb.ConditionalCheckFailedException{Message_: aws.String("The conditional request failed")}
+		}
+		if reasonFailed(canceled, historyWrite) {
+			return nil, errModified
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	patched := map[string]*dynamodb.AttributeValue{}
+	for attribute, value := range stored.Item {
+		patched[attribute] = value
+	}
+	for _, field := range patchableFields {
+		value, ok := fields[field]
+		if !ok {
+			continue
+		}
+		if len(value) == 0 && attributes.OmitEmpty() {
+			delete(patched, placeholder.Stored(field))
+		} else {
+			patched[placeholder.Stored(field)] = &dynamodb.AttributeValue{S: aws.String(value)}
+		}
+	}
+	patched["updatedAt"] = &dynamodb.AttributeValue{S: aws.String(updatedAt)}
+	patched["version"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(storedVersion(stored.Item) + 1))}
+	return patched, nil
+}
+
+// Building the update expression of a patch of fields, along with its condition, placeholders and values.
+func patchExpression(fields map[string]string, updatedAt string, ownerID string, groups []string) (string, string, placeholder.Names, map[string]*dynamodb.AttributeValue) {
+	names := placeholder.Names{}
+	values := map[string]*dynamodb.AttributeValue{
+		":updatedAt": {S: aws.String(updatedAt)},
+		":zero":      {N: aws.String("0")},
+		":one":       {N: aws.String("1")},
+	}
+	var clauses, removed []string
+	for _, field := range patchableFields {
+		value, ok := fields[field]
+		if !ok {
+			continue
+		}
+		// An optional field patched to empty is removed from the device rather than stored empty, see attributes.Marshal.
+		if len(value) == 0 && attributes.OmitEmpty() {
+			removed = append(removed, names.Field(field))
+			continue
+		}
+		values[":"+field] = &dynamodb.AttributeValue{S: aws.String(value)}
+		clauses = append(clauses, fmt.Sprintf("%s = :%s", names.Field(field), field))
+	}
+	clauses = append(clauses, fmt.Sprintf("%s = :updatedAt", names.Of("updatedAt")), fmt.Sprintf("%[1]s = if_not_exists(%[1]s, :zero) + :one", names.Of("version")))
+	update := "SET " + strings.Join(clauses, ", ")
+	if len(removed) > 0 {
+		update += " REMOVE " + strings.Join(removed, ", ")
+	}
+	condition := fmt.Sprintf("attribute_exists(%s) AND attribute_not_exists(%s)", names.Of("id"), names.Of("deleted"))
+	if ownerID != "" {
+		condition += " AND " + owner.Condition(ownerID, groups, names, values)
+	}
+	return update, condition, names, values
+}
+
+// Checking whether a write of a cancelled transaction has failed its condition.
+func reasonFailed(canceled *dynamodb.TransactionCanceledException, write int) bool {
+	return write < len(canceled.CancellationReasons) && aws.StringValue(canceled.CancellationReasons[write].Code) == "ConditionalCheckFailed"
+}
+
+// The handler function which will be first started from main function.
+// The body only carries the fields to change, the patched device is returned as a whole.
+// With HISTORY_TABLE_NAME set, the device as it was before the patch is kept in the history table.
+func PatchDevice(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !gateway.AllowsMethod(request, "PATCH") {
+		return gateway.MethodNotAllowed("PATCH"), nil
+	}
+
+	// The id which user has sent through PATCH method.
+	id := request.PathParameters["id"]
+
+	// If no id have been provided, return HTTP error code 400.
+	if id == "" {
+		return events.APIGatewayProxyResponse{
+			Body:       "Missing field: id",
+			StatusCode: 400,
+		}, nil
+	}
+
+	if len(request.Body) == 0 {
+		return events.APIGatewayProxyResponse{
+			Body:       "No inputs provided, please provide the fields to change in JSON format.",
+			StatusCode: 400,
+		}, nil
+	}
+
+	if err := validation.CheckBodySize(request); err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:       err.Error(),
+			StatusCode: 413,
+		}, nil
+	}
+
+	// De-serialize "request.Body" field by field first, to see which fields the user has sent.
+	var rawFields map[string]json.RawMessage
+	var Patch types.DevicePatch
+	err := json.Unmarshal([]byte(request.Body), &rawFields)
+	if err == nil {
+		err = json.Unmarshal([]byte(request.Body), &Patch)
+	}
+	if err != nil {
+		// Telling the client where the body has failed, i.e: a field of the wrong type.
+		return events.APIGatewayProxyResponse{
+			Body:       validation.JSONFailure("Wrong format: Inputs must be a valid JSON", err),
+			StatusCode: 400,
+		}, nil
+	}
+	if len(rawFields) == 0 {
+		return events.APIGatewayProxyResponse{
+			Body:       "No fields to change provided.",
+			StatusCode: 400,
+		}, nil
+	}
+
+	// The id of a device can not be changed, and server side fields can not be set by the user.
+	if _, ok := rawFields["id"]; ok {
+		return events.APIGatewayProxyResponse{
+			Body:       "Invalid field: ID can not be changed.",
+			StatusCode: 400,
+		}, nil
+	}
+	if _, ok := rawFields["serial"]; ok {
+		return events.APIGatewayProxyResponse{
+			Body:       "Invalid field: Serial can only be changed by rotating it.",
+			StatusCode: 400,
+		}, nil
+	}
+	for name := range rawFields {
+		if !isPatchable(name) {
+			return events.APIGatewayProxyResponse{
+				Body:       fmt.Sprintf("Invalid field: %s can not be patched.", name),
+				StatusCode: 400,
+			}, nil
+		}
+	}
+
+	// Free text fields are sanitized like in a whole device, before their checks.
+	Patch = validation.SanitizePatch(Patch)
+
+	// Provided fields get the same checks as a whole device, so return all of their failures as a list.
+	if Failures := validation.ValidatePatch(Patch); len(Failures) > 0 {
+		ErrorsJson, _ := json.Marshal(types.ErrorList{Errors: Failures.Messages()})
+		return events.APIGatewayProxyResponse{
+			Body:       string(ErrorsJson),
+			StatusCode: 400,
+		}, nil
+	}
+
+	fields := map[string]string{}
+	if Patch.DeviceModel != nil {
+		fields["deviceModel"] = *Patch.DeviceModel
+	}
+	if Patch.Name != nil {
+		fields["name"] = *Patch.Name
+	}
+	if Patch.Note != nil {
+		fields["note"] = *Patch.Note
+	}
+
+	// Only the devices of the caller's tenant can be patched, the others are reported as not found.
+	patched, err := patch(id, fields, time.Now().UTC().Format(time.RFC3339), owner.Caller(request), owner.Groups(request))
+
+	if err == errModified {
+		// The device has changed since it's been read, the client may retry.
+		return events.APIGatewayProxyResponse{
+			Body:       "The device has been modified meanwhile, please retry.",
+			StatusCode: 409,
+		}, nil
+	}
+	if err != nil {
+		// The condition has failed, so there is no device with this id in the table, return HTTP error code 404.
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return events.APIGatewayProxyResponse{
+				Body:       "Desired device not found.",
+				StatusCode: 404,
+			}, nil
+		}
+		// If internal database errors occurred, return HTTP error code 500.
+		return events.APIGatewayProxyResponse{
+			Body:       "Internal Server Error\nDatabase error.",
+			StatusCode: 500,
+		}, nil
+	}
+
+	// Recording who has patched the device for the audit trail. UpdateItem only returns the patched device, so the
+	// record has no hash of the device before. It has been patched anyway, so a failure is only logged.
+	if err := audit.Write(TestAws.DynamoDB, audit.NewRecord(id, audit.ActionUpdate, owner.Caller(request), nil, patched)); err != nil {
+		fmt.Println(fmt.Sprintf("Failed to write the audit record: %s", err.Error()))
+	}
+
+	// Deserialization/Decoding the "patched" device to Go struct, then serialization/encoding it to JSON.
+	PatchedDevice := types.Device{}
+	dynamodbattribute.UnmarshalMap(patched, &PatchedDevice)
+	PatchedDeviceJson, _ := negotiation.Marshal(negotiation.JSON, PatchedDevice)
+
+	// Everything looks fine, return HTTP 200 with the patched device.
+	return events.APIGatewayProxyResponse{
+		Body:       string(PatchedDeviceJson),
+		StatusCode: 200,
+	}, nil
+} // End of PatchDevice function
+
+// Patching a device, keeping the replaced device in the history table when HISTORY_TABLE_NAME is set.
+// Returns the patched device.
+func patch(id string, fields map[string]string, updatedAt string, ownerID string, groups []string) (map[string]*dynamodb.AttributeValue, error) {
+	if os.Getenv("HISTORY_TABLE_NAME") != "" {
+		return TestAws.PatchWithHistory(id, fields, updatedAt, ownerID, groups)
+	}
+	result, err := TestAws.Patch(id, fields, updatedAt, ownerID, groups)
+	if err != nil {
+		return nil, err
+	}
+	return result.Attributes, nil
+}
+
+// Finding the version of a stored device, 0 for the devices stored before versioning.
+func storedVersion(item map[string]*dynamodb.AttributeValue) int {
+	version := 0
+	if stored := item["version"]; stored != nil {
+		version, _ = strconv.Atoi(aws.StringValue(stored.N))
+	}
+	return version
+}
+
+// Checking whether a field of the body is one which a patch may change.
+func isPatchable(name string) bool {
+	for _, field := range patchableFields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+func main() {
+	lambda.Start(gateway.Adapt(recovery.WithRecover(PatchDevice)))
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+type TestCase struct {
+	Name               string
+	Request            events.APIGatewayProxyRequest
+	ExpectedBody       string
+	ExpectedStatusCode int
+}
+
+// Mocking DynamoDB through dynamodbiface.
+type MockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	// Devices which are already stored in the mocked table, by id.
+	Items map[string]map[string]*dynamodb.AttributeValue
+	// Versions of the devices kept in the mocked history table, by "id/version".
+	History map[string]map[string]*dynamodb.AttributeValue
+	// Version which a concurrent request sets between the read and the transaction, if any.
+	ConcurrentVersion string
+}
+
+// Custom GetItem function for overriding the GetItem of patchDevice.go for using in test scenarios.
+func (self *MockDynamoDB) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	MockOutput := new(dynamodb.GetItemOutput)
+	if item, ok := self.Items[aws.StringValue(input.Key["id"].S)]; ok {
+		stored := map[string]*dynamodb.AttributeValue{}
+		for attribute, value := range item {
+			stored[attribute] = value
+		}
+		MockOutput.SetItem(stored)
+	}
+	return MockOutput, nil
+}
+
+// Custom TransactWriteItems function for overriding the TransactWriteItems of patchDevice.go for using in test scenarios.
+// The device is patched like by UpdateItem while it still has the version read, along with the device read appended
+// to the history unless its version is already there. Cancels the transaction like DynamoDB, with a reason for each
+// item in their order and the stored device for a failed condition of the device.
+func (self *MockDynamoDB) TransactWriteItems(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+	update, history := input.TransactItems[0].Update, input.TransactItems[1].Put
+	id := aws.StringValue(update.Key["id"].S)
+	if self.ConcurrentVersion != "" {
+		self.Items[id]["version"] = &dynamodb.AttributeValue{N: aws.String(self.ConcurrentVersion)}
+	}
+	key := id + "/" + aws.StringValue(history.Item["version"].N)
+	if _, ok := self.History[key]; ok {
+		return nil, &dynamodb.TransactionCanceledException{CancellationReasons: []*dynamodb.CancellationReason{{Code: aws.String("None")}, {Code: aws.String("ConditionalCheckFailed")}}}
+	}
+	deviceFailed := &dynamodb.TransactionCanceledException{CancellationReasons: []*dynamodb.CancellationReason{{Code: aws.String("ConditionalCheckFailed"), Item: self.Items[id]}, {Code: aws.String("None")}}}
+	if aws.StringValue(self.Items[id]["version"].N) != aws.StringValue(update.ExpressionAttributeValues[":storedVersion"].N) {
+		return nil, deviceFailed
+	}
+	_, err := self.UpdateItem(&dynamodb.UpdateItemInput{
+		Key:                       update.Key,
+		UpdateExpression:          update.UpdateExpression,
+		ConditionExpression:       update.ConditionExpression,
+		ExpressionAttributeNames:  update.ExpressionAttributeNames,
+		ExpressionAttributeValues: update.ExpressionAttributeValues,
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return nil, deviceFailed
+	}
+	if err != nil {
+		return nil, err
+	}
+	self.History[key] = history.Item
+	return new(dynamodb.TransactWriteItemsOutput), nil
+}
+
+// Custom UpdateItem function for overriding the UpdateItem of patchDevice.go for using in test scenarios.
+// Mocking the condition and the SET clauses: each placeholder gets its value, the version is incremented.
+// Expressions are rejected like DynamoDB does when they refer to a reserved word directly or to an unknown placeholder.
+func (self *MockDynamoDB) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	for _, expression := range []*string{input.UpdateExpression, input.ConditionExpression} {
+		if err := checkExpression(aws.StringValue(expression), input.ExpressionAttributeNames); err != nil {
+			return nil, err
+		}
+	}
+	item, exists := self.Items[aws.StringValue(input.Key["id"].S)]
+	if !exists || item["deleted"] != nil {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
+	for placeholder, attribute := range input.ExpressionAttributeNames {
+		if !strings.Contains(aws.StringValue(input.UpdateExpression), placeholder) {
+			continue
+		}
+		if *attribute == "version" {
+			version, _ := strconv.Atoi(aws.StringValue(item["version"].N))
+			item["version"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(version + 1))}
+			continue
+		}
+		item[*attribute] = input.ExpressionAttributeValues[":"+*attribute]
+	}
+	return &dynamodb.UpdateItemOutput{Attributes: item}, nil
+}
+
+// Attributes of a device which are DynamoDB reserved words, lowercase. An expression which refers to one of them
+// directly fails with a ValidationException, see https://docs.aws.amazon.com/amazondynamodb/latest/developerguide/ReservedWords.html
+var reservedWords = map[string]bool{
+	"name":   true,
+	"status": true,
+}
+
+// Mocking the ValidationException of DynamoDB for an expression which uses a reserved word as an attribute name,
+// or a "#placeholder" which is missing from its ExpressionAttributeNames.
+func checkExpression(expression string, names map[string]*string) error {
+	tokens := strings.FieldsFunc(expression, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '#' && r != ':' && r != '_'
+	})
+	for _, token := range tokens {
+		if strings.HasPrefix(token, "#") && names[token] == nil {
+			return awserr.New("ValidationException", "Value provided in ExpressionAttributeNames unused in expressions: "+token, nil)
+		}
+		if reservedWords[strings.ToLower(token)] {
+			return awserr.New("ValidationException", "Attribute name is a reserved keyword; reserved keyword: "+token, nil)
+		}
+	}
+	return nil
+}
+
+// PatchDevice function in patchDevice.go signature: input: (request events.APIGatewayProxyRequest), output: (events.APIGatewayProxyResponse, error)
+func TestPatchDevice(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	mock := &MockDynamoDB{Items: map[string]map[string]*dynamodb.AttributeValue{
+		"id_test": {
+			"id":           {S: aws.String("id_test")},
+			"device_model": {S: aws.String("deviceModel_test")},
+			"name":         {S: aws.String("name_test")},
+			"note":         {S: aws.String("note_test")},
+			"serial":       {S: aws.String("serial_test")},
+			"createdAt":    {S: aws.String("2018-11-02T10:04:05Z")},
+			"version":      {N: aws.String("1")},
+		},
+	}}
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	response, _ := PatchDevice(events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}, Body: "{\"name\":\"newName\",\"note\":\"newNote\"}"})
+	if response.StatusCode != 200 {
+		t.Fatalf("** Testing: Patching Name and Note. ** \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", 200, response.StatusCode, response.Body)
+	}
+	item := mock.Items["id_test"]
+	if aws.StringValue(item["name"].S) != "newName" || aws.StringValue(item["note"].S) != "newNote" {
+		t.Errorf("** Testing: Patched fields. ** \n \t<expected name: newName, note: newNote> <resulted name: %s, note: %s>", aws.StringValue(item["name"].S), aws.StringValue(item["note"].S))
+	}
+	if aws.StringValue(item["device_model"].S) != "deviceModel_test" || aws.StringValue(item["serial"].S) != "serial_test" || aws.StringValue(item["createdAt"].S) != "2018-11-02T10:04:05Z" {
+		t.Errorf("** Testing: Untouched fields. ** \n \t<expected the other fields unchanged> <resulted item: %v>", item)
+	}
+	if aws.StringValue(item["version"].N) != "2" || item["updatedAt"] == nil || !strings.Contains(response.Body, "\"name\":\"newName\"") {
+		t.Errorf("** Testing: Version and updatedAt of the patched device. ** \n \t<expected version: 2 and an updatedAt> <resulted body: %s>", response.Body)
+	}
+
+	testCases := []TestCase{
+		{
+			Name:               "** Testing: Empty id input. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": ""}, Body: "{\"name\":\"newName\"}"},
+			ExpectedBody:       "Missing field: id",
+			ExpectedStatusCode: 400,
+		},
+
+		{
+			Name:               "** Testing: Empty body input. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}},
+			ExpectedBody:       "No inputs provided, please provide the fields to change in JSON format.",
+			ExpectedStatusCode: 400,
+		},
+
+		{
+			Name:               "** Testing: Empty JSON object. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}, Body: "{}"},
+			ExpectedBody:       "No fields to change provided.",
+			ExpectedStatusCode: 400,
+		},
+
+		{
+			Name:               "** Testing: Patching the ID. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}, Body: "{\"id\":\"id_other\",\"name\":\"newName\"}"},
+			ExpectedBody:       "Invalid field: ID can not be changed.",
+			ExpectedStatusCode: 400,
+		},
+
+		{
+			Name:               "** Testing: Patching a server side field. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}, Body: "{\"version\":7}"},
+			ExpectedBody:       "Invalid field: version can not be patched.",
+			ExpectedStatusCode: 400,
+		},
+
+		{
+			Name:               "** Testing: Patching the serial. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}, Body: "{\"serial\":\"serial_other\"}"},
+			ExpectedBody:       "Invalid field: Serial can only be changed by rotating it.",
+			ExpectedStatusCode: 400,
+		},
+
+		{
+			Name:               "** Testing: Patching Name to empty. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}, Body: "{\"name\":\"\"}"},
+			ExpectedBody:       "{\"errors\":[\"Missing field: Name\"]}",
+			ExpectedStatusCode: 400,
+		},
+
+		{
+			Name:               "** Testing: Desire device does not exist. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "NotExistedTestID"}, Body: "{\"name\":\"newName\"}"},
+			ExpectedBody:       "Desired device not found.",
+			ExpectedStatusCode: 404,
+		},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := PatchDevice(test.Request)
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+} // End of TestPatchDevice function
+
+// Patch function in patchDevice.go signature: input: (id string, fields map[string]string, updatedAt string, ownerID string, groups []string), output: (*dynamodb.UpdateItemOutput, error)
+// "name" is a DynamoDB reserved word, so the update expression only works through the placeholders.
+func TestPatchReservedWord(t *testing.T) {
+	mock := &MockDynamoDB{Items: map[string]map[string]*dynamodb.AttributeValue{
+		"id_test": {"id": {S: aws.String("id_test")}, "name": {S: aws.String("name_test")}, "version": {N: aws.String("1")}},
+	}}
+	test_aws := &AmazonWebServices{DynamoDB: mock}
+
+	_, err := test_aws.Patch("id_test", map[string]string{"name": "newName"}, "2018-11-02T10:04:05Z", "owner_test", nil)
+	if err != nil || aws.StringValue(mock.Items["id_test"]["name"].S) != "newName" {
+		t.Errorf("** Testing: Patching the reserved name attribute. ** \n \t<expected error: %v, name: newName> <resulted error: %v, name: %s>", nil, err, aws.StringValue(mock.Items["id_test"]["name"].S))
+	}
+
+	// The same expression without a placeholder is rejected.
+	expression := "SET name = :name"
+	if err := checkExpression(expression, nil); err == nil {
+		t.Errorf("** Testing: Reserved name attribute without a placeholder. ** \n \t<expected: ValidationException> <resulted error: %v>", err)
+	}
+}
+
+// A body which can't be decoded is rejected with where it has failed, the byte offset and the field of the wrong type.
+func TestPatchDeviceMalformedJSON(t *testing.T) {
+	testCases := []TestCase{
+		{
+			Name:               "** Testing: Syntax error. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}, Body: "{\"name\":\"newName\",}"},
+			ExpectedBody:       "Wrong format: Inputs must be a valid JSON, invalid character '}' looking for beginning of object key string at offset 19.",
+			ExpectedStatusCode: 400,
+		},
+		{
+			Name:               "** Testing: Field of the wrong type. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}, Body: "{\"name\":5}"},
+			ExpectedBody:       "Wrong format: Inputs must be a valid JSON, field name must be a string, not a number, at offset 9.",
+			ExpectedStatusCode: 400,
+		},
+		{
+			Name:               "** Testing: Body of the wrong type. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}, Body: "[\"name\"]"},
+			ExpectedBody:       "Wrong format: Inputs must be a valid JSON, the body must be an object, not an array, at offset 1.",
+			ExpectedStatusCode: 400,
+		},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := PatchDevice(test.Request)
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+} // End of TestPatchDeviceMalformedJSON function
+
+// With HISTORY_TABLE_NAME set, the device as it was before the patch is kept in the history table, in the same
+// transaction. A device which has changed since it's been read is not patched.
+func TestPatchDeviceHistory(t *testing.T) {
+	t.Setenv("HISTORY_TABLE_NAME", "history_test")
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	defer func() { TestAws = realAws }()
+
+	newMock := func() *MockDynamoDB {
+		return &MockDynamoDB{History: map[string]map[string]*dynamodb.AttributeValue{}, Items: map[string]map[string]*dynamodb.AttributeValue{
+			"id_test": {
+				"id":           {S: aws.String("id_test")},
+				"device_model": {S: aws.String("deviceModel_test")},
+				"name":         {S: aws.String("name_test")},
+				"note":         {S: aws.String("note_test")},
+				"version":      {N: aws.String("1")},
+			},
+		}}
+	}
+
+	mock := newMock()
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	response, _ := PatchDevice(events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}, Body: "{\"name\":\"newName\"}"})
+	if response.StatusCode != 200 || !strings.Contains(response.Body, "\"name\":\"newName\"") || !strings.Contains(response.Body, "\"version\":2") {
+		t.Errorf("** Testing: Patch kept in the history. ** \n \t<expected error-code: %d, name: newName, version: 2> <resulted error-code: %d> <resulted body: %s>", 200, response.StatusCode, response.Body)
+	}
+	if aws.StringValue(mock.Items["id_test"]["name"].S) != "newName" || aws.StringValue(mock.Items["id_test"]["version"].N) != "2" {
+		t.Errorf("** Testing: Patched device. ** \n \t<expected name: newName, version: 2> <resulted item: %v>", mock.Items["id_test"])
+	}
+	if kept := mock.History["id_test/1"]; len(mock.History) != 1 || kept == nil || aws.StringValue(kept["name"].S) != "name_test" {
+		t.Errorf("** Testing: Patched device in the history. ** \n \t<expected version 1 with name_test> <resulted history: %v>", mock.History)
+	}
+
+	testCases := []struct {
+		Name               string
+		Mock               *MockDynamoDB
+		ExpectedBody       string
+		ExpectedStatusCode int
+	}{
+		{Name: "** Testing: Device modified meanwhile. **", Mock: newMock(), ExpectedBody: "The device has been modified meanwhile, please retry.", ExpectedStatusCode: 409},
+		{Name: "** Testing: Version already in the history. **", Mock: newMock(), ExpectedBody: "The device has been modified meanwhile, please retry.", ExpectedStatusCode: 409},
+		{Name: "** Testing: Soft deleted device. **", Mock: newMock(), ExpectedBody: "Desired device not found.", ExpectedStatusCode: 404},
+		{Name: "** Testing: Desire device does not exist. **", Mock: &MockDynamoDB{History: map[string]map[string]*dynamodb.AttributeValue{}}, ExpectedBody: "Desired device not found.", ExpectedStatusCode: 404},
+	}
+	testCases[0].Mock.ConcurrentVersion = "5"
+	testCases[1].Mock.History["id_test/1"] = map[string]*dynamodb.AttributeValue{"id": {S: aws.String("id_test")}, "version": {N: aws.String("1")}}
+	testCases[2].Mock.Items["id_test"]["deleted"] = &dynamodb.AttributeValue{BOOL: aws.Bool(true)}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		TestAws = &AmazonWebServices{DynamoDB: test.Mock}
+		response, _ := PatchDevice(events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}, Body: "{\"name\":\"newName\"}"})
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+		if item := test.Mock.Items["id_test"]; item != nil && aws.StringValue(item["name"].S) != "name_test" {
+			t.Errorf("%s \n \t<expected the device untouched> <resulted item: %v>", test.Name, item)
+		}
+	}
+} // End of TestPatchDeviceHistory function
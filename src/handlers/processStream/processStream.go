@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"log/slog"
+	"os"
+	"placeholder"
+	"sort"
+	"types"
+)
+
+// Structured JSON logs on Amazon CloudWatch, so they can be queried with CloudWatch Logs Insights.
+var logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+// The handler function which will be first started from main function, for every batch of the stream of the devices table.
+// Every record is summarized as a created, modified or removed device and logged. A record which can't be read
+// is logged and skipped, as retrying the batch would only fail on it again.
+func ProcessStream(ctx context.Context, event events.DynamoDBEvent) ([]types.DeviceChange, error) {
+	var changes []types.DeviceChange
+	for _, record := range event.Records {
+		change, err := summarize(record)
+		if err != nil {
+			logger.Error("Failed to read the stream record", "eventId", record.EventID, "error", err.Error())
+			continue
+		}
+		logger.Info("Device changed", "id", change.ID, "change", change.Change, "fields", change.Fields)
+		changes = append(changes, change)
+	}
+	return changes, nil
+} // End of ProcessStream function
+
+// Summarizing a record of the stream by its event name, the device is taken from the image it still has.
+// The images are only there when the stream view type is NEW_AND_OLD_IMAGES.
+func summarize(record events.DynamoDBEventRecord) (types.DeviceChange, error) {
+	newDevice, err := device(record.Change.NewImage)
+	if err != nil {
+		return types.DeviceChange{}, err
+	}
+	oldDevice, err := device(record.Change.OldImage)
+	if err != nil {
+		return types.DeviceChange{}, err
+	}
+	switch record.EventName {
+	case string(events.DynamoDBOperationTypeInsert):
+		return types.DeviceChange{ID: newDevice.ID, Change: types.ChangeCreated}, nil
+	case string(events.DynamoDBOperationTypeModify):
+		return types.DeviceChange{ID: newDevice.ID, Change: types.ChangeModified, Fields: changedFields(record.Change.OldImage, record.Change.NewImage)}, nil
+	case string(events.DynamoDBOperationTypeRemove):
+		return types.DeviceChange{ID: oldDevice.ID, Change: types.ChangeRemoved}, nil
+	}
+	return types.DeviceChange{}, fmt.Errorf("unknown event name %q", record.EventName)
+}
+
+// Deserialization/Decoding an image of the stream to Go struct. The attributes of an image have the same JSON
+// as the ones of DynamoDB, so they are converted through it. A missing image gives an empty device.
+func device(image map[string]events.DynamoDBAttributeValue) (types.Device, error) {
+	Device := types.Device{}
+	if len(image) == 0 {
+		return Device, nil
+	}
+	imageJson, err := json.Marshal(image)
+	if err != nil {
+		return Device, err
+	}
+	item := map[string]*dynamodb.AttributeValue{}
+	if err := json.Unmarshal(imageJson, &item); err != nil {
+		return Device, err
+	}
+	err = dynamodbattribute.UnmarshalMap(item, &Device)
+	return Device, err
+}
+
+// Finding the attributes which differ between the old and the new image, including the added and removed ones.
+// They are reported by the JSON names of their fields, see placeholder.Field.
+func changedFields(oldImage map[string]events.DynamoDBAttributeValue, newImage map[string]events.DynamoDBAttributeValue) []string {
+	var fields []string
+	for name, value := range newImage {
+		if old, ok := oldImage[name]; !ok || !sameValue(old, value) {
+			fields = append(fields, placeholder.Field(name))
+		}
+	}
+	for name := range oldImage {
+		if _, ok := newImage[name]; !ok {
+			fields = append(fields, placeholder.Field(name))
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// Comparing two attributes by their JSON, which holds both their type and their value.
+func sameValue(a events.DynamoDBAttributeValue, b events.DynamoDBAttributeValue) bool {
+	aJson, _ := json.Marshal(a)
+	bJson, _ := json.Marshal(b)
+	return string(aJson) == string(bJson)
+}
+
+func main() {
+	lambda.Start(ProcessStream)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"github.com/aws/aws-lambda-go/events"
+	"reflect"
+	"testing"
+	"types"
+)
+
+// Building a stream record of the devices table with its images, as DynamoDB Streams sends it.
+func streamRecord(eventName string, oldImage map[string]events.DynamoDBAttributeValue, newImage map[string]events.DynamoDBAttributeValue) events.DynamoDBEventRecord {
+	return events.DynamoDBEventRecord{
+		EventID:   "event_" + eventName,
+		EventName: eventName,
+		Change: events.DynamoDBStreamRecord{
+			Keys:           map[string]events.DynamoDBAttributeValue{"id": events.NewStringAttribute("id_test")},
+			OldImage:       oldImage,
+			NewImage:       newImage,
+			StreamViewType: "NEW_AND_OLD_IMAGES",
+		},
+	}
+}
+
+// ProcessStream function in processStream.go signature: input: (ctx context.Context, event events.DynamoDBEvent), output: ([]types.DeviceChange, error)
+func TestProcessStream(t *testing.T) {
+	stored := map[string]events.DynamoDBAttributeValue{
+		"id":           events.NewStringAttribute("id_test"),
+		"device_model": events.NewStringAttribute("testDeviceModel"),
+		"name":         events.NewStringAttribute("testName"),
+		"serial":       events.NewStringAttribute("testSerial"),
+		"version":      events.NewNumberAttribute("1"),
+	}
+	updated := map[string]events.DynamoDBAttributeValue{
+		"id":           events.NewStringAttribute("id_test"),
+		"device_model": events.NewStringAttribute("testDeviceModel"),
+		"name":         events.NewStringAttribute("renamed"),
+		"note":         events.NewStringAttribute("testNote"),
+		"serial":       events.NewStringAttribute("testSerial"),
+		"version":      events.NewNumberAttribute("2"),
+	}
+
+	testCases := []struct {
+		Name           string
+		Record         events.DynamoDBEventRecord
+		ExpectedChange types.DeviceChange
+	}{
+		{
+			Name:           "** Testing: Inserted device. **",
+			Record:         streamRecord("INSERT", nil, stored),
+			ExpectedChange: types.DeviceChange{ID: "id_test", Change: "created"},
+		},
+		{
+			Name:           "** Testing: Modified device. **",
+			Record:         streamRecord("MODIFY", stored, updated),
+			ExpectedChange: types.DeviceChange{ID: "id_test", Change: "modified", Fields: []string{"name", "note", "version"}},
+		},
+		{
+			Name:           "** Testing: Removed device. **",
+			Record:         streamRecord("REMOVE", updated, nil),
+			ExpectedChange: types.DeviceChange{ID: "id_test", Change: "removed"},
+		},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		changes, err := ProcessStream(context.Background(), events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{test.Record}})
+		if err != nil || len(changes) != 1 || !reflect.DeepEqual(changes[0], test.ExpectedChange) {
+			t.Errorf("%s \n \t<expected change: %+v> <resulted changes: %+v, error: %v>", test.Name, test.ExpectedChange, changes, err)
+		}
+	}
+
+	// A record of an unknown event is skipped, the rest of the batch is still processed.
+	changes, err := ProcessStream(context.Background(), events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{streamRecord("UNKNOWN", nil, stored), streamRecord("INSERT", nil, stored)}})
+	if err != nil || len(changes) != 1 || changes[0].Change != "created" {
+		t.Errorf("** Testing: Unknown event. ** \n \t<expected a single created change> <resulted changes: %+v, error: %v>", changes, err)
+	}
+} // End of TestProcessStream function
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"audit"
+	"encoding/json"
+	"etag"
+	"fmt"
+	"gateway"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"history"
+	"negotiation"
+	"os"
+	"owner"
+	"placeholder"
+	"recovery"
+	"strconv"
+	"strings"
+	"time"
+	"types"
+	"validation"
+)
+
+type AmazonWebServices struct {
+	Config   *aws.Config
+	Session  *session.Session
+	DynamoDB dynamodbiface.DynamoDBAPI
+}
+
+// Prepare a new AWS & DynamoDB session, then configure it.
+var TestAws *AmazonWebServices
+
+// Positions of the first writes of the rotation transaction, which its cancellation reasons are reported in.
+// The deletion of the old marker and the history write follow, when there are such.
+const (
+	newMarkerWrite = iota
+	deviceWrite
+)
+
+func init() {
+	region := os.Getenv("AWS_REGION")
+	var Aws *AmazonWebServices = new(AmazonWebServices)
+	Aws.Config = &aws.Config{Region: aws.String(region)}
+	// Pointing the client to a local DynamoDB, i.e: DynamoDB Local for the integration tests. It's unset in production.
+	if endpoint := os.Getenv("DYNAMODB_ENDPOINT"); endpoint != "" {
+		Aws.Config.Endpoint = aws.String(endpoint)
+	}
+	var err error
+	Aws.Session, err = session.NewSession(Aws.Config)
+	if err != nil {
+		// Logs error on Amazon CloudWatch. It's sysadmin's duty to handle it.
+		fmt.Println(fmt.Sprintf("Failed to connect to AWS: %s", err.Error()))
+	} else {
+		var svc *dynamodb.DynamoDB = dynamodb.New(Aws.Session)
+		Aws.DynamoDB = dynamodbiface.DynamoDBAPI(svc)
+	}
+	// Instantiate a global session in TestAws
+	TestAws = Aws
+}
+
+// Preparing DynamoDB Session and Calling DB's GetItem function inside, reading the device to rotate.
+// The read is strongly consistent, its serial is the one which the transaction expects to replace.
+func (self *AmazonWebServices) Get(id string) (*dynamodb.GetItemOutput, error) {
+	// Get desire table's name from OS's environmental varible.
+	tableName := aws.String(os.Getenv("DEVICES_TABLE_NAME"))
+
+	var input = &dynamodb.GetItemInput{
+		TableName: tableName,
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(id),
+			},
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+
+	// Calling either GetItem function of interface, defined in rotateSerial_test.go file, or api with the input we've provided.
+	// In real deployment environment, the GetItem function of aws (api.go) will be called.
+	result, err := self.DynamoDB.GetItem(input)
+	return result, err
+}
+
+// Preparing DynamoDB Session and Calling DB's TransactWriteItems function inside, replacing the serial of a device
+// in one transaction along with the serial markers of SERIALS_TABLE_NAME, the ones written by AddDevice:
+// the marker of the new serial is written unless another device has it, the device is updated only if it still has
+// the old serial, and the marker of the old serial is deleted only if it's the device's one.
+// The devices created before the serials table have no marker, so a missing old marker is fine. A device without a
+// serial has no marker to delete either, and it has to be still without one.
+// The device has to be the stored one read by Get, which is appended to the history table (HISTORY_TABLE_NAME) in the
+// same transaction when it's set, like UpdateDevice keeps the devices it replaces.
+func (self *AmazonWebServices) Rotate(stored map[string]*dynamodb.AttributeValue, newSerial string, updatedAt string) error {
+	// Get desire tables' names from OS's environmental varibles.
+	tableName := aws.String(os.Getenv("DEVICES_TABLE_NAME"))
+	serialsTableName := aws.String(os.Getenv("SERIALS_TABLE_NAME"))
+	historyTableName := os.Getenv("HISTORY_TABLE_NAME")
+	id, oldSerial := aws.StringValue(stored["id"].S), stored["serial"]
+
+	names, markerNames := placeholder.Names{}, placeholder.Names{}
+	values := map[string]*dynamodb.AttributeValue{
+		":newSerial": {S: aws.String(newSerial)},
+		":updatedAt": {S: aws.String(updatedAt)},
+		":zero":      {N: aws.String("0")},
+		":one":       {N: aws.String("1")},
+	}
+	condition := fmt.Sprintf("attribute_exists(%s) AND attribute_not_exists(%s)", names.Of("id"), names.Of("deleted"))
+	if oldSerial != nil {
+		condition += fmt.Sprintf(" AND %s = :oldSerial", names.Of("serial"))
+		values[":oldSerial"] = oldSerial
+	} else {
+		condition += fmt.Sprintf(" AND attribute_not_exists(%s)", names.Of("serial"))
+	}
+	// The devices stored before versioning have no version yet.
+	if version := stored["version"]; version != nil {
+		condition += fmt.Sprintf(" AND %s = :version", names.Of("version"))
+		values[":version"] = version
+	} else {
+		condition += fmt.Sprintf(" AND attribute_not_exists(%s)", names.Of("version"))
+	}
+	var input = &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			newMarkerWrite: {Put: &dynamodb.Put{
+				Item:                     map[string]*dynamodb.AttributeValue{"serial": {S: aws.String(newSerial)}, "id": {S: aws.String(id)}},
+				TableName:                serialsTableName,
+				ConditionExpression:      aws.String(fmt.Sprintf("attribute_not_exists(%s)", markerNames.Of("serial"))),
+				ExpressionAttributeNames: markerNames,
+			}},
+			deviceWrite: {Update: &dynamodb.Update{
+				Key:       map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}},
+				TableName: tableName,
+				UpdateExpression: aws.String(fmt.Sprintf("SET %s = :newSerial, %s = :updatedAt, %[3]s = if_not_exists(%[3]s, :zero) + :one",
+					names.Of("serial"), names.Of("updatedAt"), names.Of("version"))),
+				ConditionExpression:       aws.String(condition),
+				ExpressionAttributeNames:  names,
+				ExpressionAttributeValues: values,
+			}},
+		},
+	}
+	if oldSerial != nil {
+		oldMarkerNames := placeholder.Names{}
+		input.TransactItems = append(input.TransactItems, &dynamodb.TransactWriteItem{Delete: &dynamodb.Delete{
+			Key:                       map[string]*dynamodb.AttributeValue{"serial": oldSerial},
+			TableName:                 serialsTableName,
+			ConditionExpression:       aws.String(fmt.Sprintf("attribute_not_exists(%s) OR %s = :id", oldMarkerNames.Of("serial"), oldMarkerNames.Of("id"))),
+			ExpressionAttributeNames:  oldMarkerNames,
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":id": {S: aws.String(id)}},
+		}})
+	}
+	if historyTableName != "" {
+		input.TransactItems = append(input.TransactItems, &dynamodb.TransactWriteItem{Put: history.Put(historyTableName, stored)})
+	}
+
+	// Calling either TransactWriteItems function of interface, defined in rotateSerial_test.go file, or api with the input we've provided.
+	// In real deployment environment, the TransactWriteItems function of aws (api.go) will be called.
+	_, err := self.DynamoDB.TransactWriteItems(input)
+	return err
+}
+
+// The handler function which will be first started from main function.
+// Replaces the serial of a device, i.e: a compromised one, without ever letting two devices share a serial:
+// a serial of another device is rejected with HTTP 409, and the rotated device is returned with its new version.
+// With HISTORY_TABLE_NAME set, the device as it was before the rotation is kept in the history table.
+func RotateSerial(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !gateway.AllowsMethod(request, "POST") {
+		return gateway.MethodNotAllowed("POST"), nil
+	}
+
+	// The id of the device whose serial user wants to rotate, sent through POST method.
+	id := request.PathParameters["id"]
+
+	// If no id have been provided, return HTTP error code 400.
+	if id == "" {
+		return events.APIGatewayProxyResponse{
+			Body:       "Missing field: id",
+			StatusCode: 400,
+		}, nil
+	}
+
+	if err := validation.CheckBodySize(request); err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:       err.Error(),
+			StatusCode: 413,
+		}, nil
+	}
+
+	// De-serialize "request.Body" to the new serial, which gets the same checks as the serial of AddDevice.
+	var Rotation types.SerialRotation
+	if err := json.Unmarshal([]byte(request.Body), &Rotation); err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:       validation.JSONFailure("Wrong format: Inputs must be a valid JSON", err),
+			StatusCode: 400,
+		}, nil
+	}
+	newSerial := strings.TrimSpace(Rotation.Serial)
+	if Failures := validation.ValidateSerial(newSerial); len(Failures) > 0 {
+		ErrorsJson, _ := json.Marshal(types.ErrorList{Errors: Failures.Messages()})
+		return events.APIGatewayProxyResponse{
+			Body:       string(ErrorsJson),
+			StatusCode: 400,
+		}, nil
+	}
+
+	// Without the serials table a rotation can't be atomic, it's sysadmin's duty to configure it.
+	if os.Getenv("SERIALS_TABLE_NAME") == "" {
+		fmt.Println("Failed to rotate a serial: SERIALS_TABLE_NAME is not set")
+		return events.APIGatewayProxyResponse{
+			Body:       "Internal Server Error.",
+			StatusCode: 500,
+		}, nil
+	}
+
+	result, err := TestAws.Get(id)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Failed to get the device: %s", err.Error()))
+		return events.APIGatewayProxyResponse{
+			Body:       "Internal Server Error\nDatabase error.",
+			StatusCode: 500,
+		}, nil
+	}
+
+	// A missing, soft deleted or another tenant's device is reported as not found, like in GetDeviceById.
+	Device := types.Device{}
+	dynamodbattribute.UnmarshalMap(result.Item, &Device)
+	caller := owner.Caller(request)
+	if len(result.Item) == 0 || Device.Deleted || (caller != "" && Device.OwnerID != caller) {
+		return events.APIGatewayProxyResponse{
+			Body:       "Desired device not found.",
+			StatusCode: 404,
+		}, nil
+	}
+
+	// The current serial is taken, by the device itself.
+	if newSerial == Device.Serial {
+		return events.APIGatewayProxyResponse{
+			Body:       "Serial already registered.",
+			StatusCode: 409,
+		}, nil
+	}
+
+	updatedAt := time.Now().UTC().Format(time.RFC3339)
+	err = TestAws.Rotate(result.Item, newSerial, updatedAt)
+	if canceled, ok := err.(*dynamodb.TransactionCanceledException); ok {
+		// The new serial belongs to another device, return HTTP error code 409.
+		if reasonFailed(canceled, newMarkerWrite) {
+			return events.APIGatewayProxyResponse{
+				Body:       "Serial already registered.",
+				StatusCode: 409,
+			}, nil
+		}
+		// The device or its serial has changed since it's been read, or its version is already in the history because
+		// it has been replaced meanwhile. The client may retry with the current one.
+		for write := deviceWrite; write < len(canceled.CancellationReasons); write++ {
+			if reasonFailed(canceled, write) {
+				return events.APIGatewayProxyResponse{
+					Body:       "The device has been modified meanwhile, please retry.",
+					StatusCode: 409,
+				}, nil
+			}
+		}
+	}
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Failed to rotate the serial: %s", err.Error()))
+		// If internal database errors occurred, return HTTP error code 500.
+		return events.APIGatewayProxyResponse{
+			Body:       "Internal Server Error\nDatabase error.",
+			StatusCode: 500,
+		}, nil
+	}
+
+	// The device as it's now stored, with the new serial and version.
+	Device.Serial = newSerial
+	Device.UpdatedAt = updatedAt
+	Device.Version++
+	item := map[string]*dynamodb.AttributeValue{}
+	for attribute, value := range result.Item {
+		item[attribute] = value
+	}
+	item["serial"] = &dynamodb.AttributeValue{S: aws.String(newSerial)}
+	item["updatedAt"] = &dynamodb.AttributeValue{S: aws.String(updatedAt)}
+	item["version"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(Device.Version))}
+
+	// Recording who has rotated the serial for the audit trail. It has been rotated anyway, so a failure is only logged.
+	if err := audit.Write(TestAws.DynamoDB, audit.NewRecord(id, audit.ActionUpdate, caller, result.Item, item)); err != nil {
+		fmt.Println(fmt.Sprintf("Failed to write the audit record: %s", err.Error()))
+	}
+
+	// Everything looks fine, return HTTP 200 with the rotated device.
+	DeviceJson, _ := negotiation.Marshal(negotiation.JSON, Device)
+	return events.APIGatewayProxyResponse{
+		Headers:    map[string]string{"ETag": etag.Format(Device.Version)},
+		Body:       string(DeviceJson),
+		StatusCode: 200,
+	}, nil
+} // End of RotateSerial function
+
+// Checking whether a write of a cancelled transaction has failed its condition.
+func reasonFailed(canceled *dynamodb.TransactionCanceledException, write int) bool {
+	return write < len(canceled.CancellationReasons) && aws.StringValue(canceled.CancellationReasons[write].Code) == "ConditionalCheckFailed"
+}
+
+func main() {
+	lambda.Start(gateway.Adapt(recovery.WithRecover(RotateSerial)))
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"testing"
+	"types"
+)
+
+// Mocking DynamoDB through dynamodbiface.
+type MockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	// Devices which are already stored in the mocked table by their ids, and the serial markers with their devices' ids.
+	Devices map[string]map[string]*dynamodb.AttributeValue
+	Markers map[string]string
+	// Serial which a concurrent request replaces between the read and the transaction, if any.
+	ConcurrentSerial string
+	// Versions of the devices kept in the mocked history table, by "id/version".
+	History map[string]map[string]*dynamodb.AttributeValue
+}
+
+// Custom GetItem function for overriding the GetItem of rotateSerial.go for using in test scenarios.
+func (self *MockDynamoDB) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	MockOutput := new(dynamodb.GetItemOutput)
+	if device, ok := self.Devices[aws.StringValue(input.Key["id"].S)]; ok {
+		item := map[string]*dynamodb.AttributeValue{}
+		for attribute, value := range device {
+			item[attribute] = value
+		}
+		MockOutput.SetItem(item)
+	}
+	return MockOutput, nil
+}
+
+// Custom TransactWriteItems function for mocking the rotation of a serial along with its markers.
+// Cancels the transaction like DynamoDB, with a reason for each item in their order. The new marker comes first and
+// the device second, followed by the deletion of the old marker, for a device which has a serial, and the put of the
+// replaced device in the history, unless its version is already there.
+func (self *MockDynamoDB) TransactWriteItems(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+	newMarker, device := input.TransactItems[0].Put, input.TransactItems[1].Update
+	id := aws.StringValue(device.Key["id"].S)
+	if self.ConcurrentSerial != "" {
+		self.Devices[id]["serial"] = &dynamodb.AttributeValue{S: aws.String(self.ConcurrentSerial)}
+	}
+	newSerial := aws.StringValue(newMarker.Item["serial"].S)
+	reasons := []*dynamodb.CancellationReason{}
+	for range input.TransactItems {
+		reasons = append(reasons, &dynamodb.CancellationReason{Code: aws.String("None")})
+	}
+	canceled := false
+	if _, ok := self.Markers[newSerial]; ok {
+		reasons[0].Code, canceled = aws.String("ConditionalCheckFailed"), true
+	}
+	stored, ok := self.Devices[id]
+	if oldSerial := device.ExpressionAttributeValues[":oldSerial"]; !ok || (oldSerial == nil) != (stored["serial"] == nil) || oldSerial != nil && aws.StringValue(stored["serial"].S) != aws.StringValue(oldSerial.S) {
+		reasons[1].Code, canceled = aws.String("ConditionalCheckFailed"), true
+	}
+	var oldSerial, historyKey string
+	var history map[string]*dynamodb.AttributeValue
+	for i, write := range input.TransactItems[2:] {
+		switch {
+		case write.Delete != nil:
+			oldSerial = aws.StringValue(write.Delete.Key["serial"].S)
+			if markerID, ok := self.Markers[oldSerial]; ok && markerID != id {
+				reasons[2+i].Code, canceled = aws.String("ConditionalCheckFailed"), true
+			}
+		case write.Put != nil:
+			historyKey = id + "/" + aws.StringValue(write.Put.Item["version"].N)
+			if _, ok := self.History[historyKey]; ok {
+				reasons[2+i].Code, canceled = aws.String("ConditionalCheckFailed"), true
+			}
+			history = write.Put.Item
+		}
+	}
+	if canceled {
+		return nil, &dynamodb.TransactionCanceledException{Message_: aws.String("Transaction cancelled"), CancellationReasons: reasons}
+	}
+	self.Markers[newSerial] = id
+	if oldSerial != "" {
+		delete(self.Markers, oldSerial)
+	}
+	self.Devices[id]["serial"] = &dynamodb.AttributeValue{S: aws.String(newSerial)}
+	if history != nil {
+		self.History[historyKey] = history
+	}
+	return new(dynamodb.TransactWriteItemsOutput), nil
+}
+
+// Mocked devices and markers: "id_test" with the serial "serial_old", "id_other" with "serial_taken",
+// "id_legacy", created before the serials table, without a marker and "id_unserialized", without a serial at all.
+func newMock() *MockDynamoDB {
+	device := func(id string, serial string) map[string]*dynamodb.AttributeValue {
+		return map[string]*dynamodb.AttributeValue{
+			"id":      {S: aws.String(id)},
+			"serial":  {S: aws.String(serial)},
+			"ownerId": {S: aws.String("tenant-a")},
+			"version": {N: aws.String("3")},
+		}
+	}
+	unserialized := device("id_unserialized", "")
+	delete(unserialized, "serial")
+	return &MockDynamoDB{
+		Devices: map[string]map[string]*dynamodb.AttributeValue{
+			"id_test":         device("id_test", "serial_old"),
+			"id_other":        device("id_other", "serial_taken"),
+			"id_legacy":       device("id_legacy", "serial_legacy"),
+			"id_unserialized": unserialized,
+		},
+		Markers: map[string]string{"serial_old": "id_test", "serial_taken": "id_other"},
+		History: map[string]map[string]*dynamodb.AttributeValue{},
+	}
+}
+
+// RotateSerial function in rotateSerial.go signature: input: (request events.APIGatewayProxyRequest), output: (events.APIGatewayProxyResponse, error)
+func TestRotateSerial(t *testing.T) {
+	t.Setenv("SERIALS_TABLE_NAME", "serials_test")
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	defer func() { TestAws = realAws }()
+
+	testCases := []struct {
+		Name               string
+		ID                 string
+		Body               string
+		ExpectedStatusCode int
+		ExpectedSerial     string
+	}{
+		{Name: "** Testing: Rotation to a new serial. **", ID: "id_test", Body: "{\"serial\":\" serial_new \"}", ExpectedStatusCode: 200, ExpectedSerial: "serial_new"},
+		{Name: "** Testing: Rotation of a device without a marker. **", ID: "id_legacy", Body: "{\"serial\":\"serial_new\"}", ExpectedStatusCode: 200, ExpectedSerial: "serial_new"},
+		{Name: "** Testing: Rotation of a device without a serial. **", ID: "id_unserialized", Body: "{\"serial\":\"serial_new\"}", ExpectedStatusCode: 200, ExpectedSerial: "serial_new"},
+		{Name: "** Testing: Rotation to the current serial. **", ID: "id_test", Body: "{\"serial\":\"serial_old\"}", ExpectedStatusCode: 409, ExpectedSerial: "serial_old"},
+		{Name: "** Testing: Missing device. **", ID: "NotExistedTestID", Body: "{\"serial\":\"serial_new\"}", ExpectedStatusCode: 404},
+		{Name: "** Testing: Missing serial. **", ID: "id_test", Body: "{\"serial\":\"  \"}", ExpectedStatusCode: 400, ExpectedSerial: "serial_old"},
+		{Name: "** Testing: Malformed body. **", ID: "id_test", Body: "{\"serial\":1}", ExpectedStatusCode: 400, ExpectedSerial: "serial_old"},
+		{Name: "** Testing: Missing id. **", ID: "", Body: "{\"serial\":\"serial_new\"}", ExpectedStatusCode: 400},
+	}
+
+	for _, test := range testCases {
+		mock := newMock()
+		TestAws = &AmazonWebServices{DynamoDB: mock}
+		var oldSerial string
+		if serial := mock.Devices[test.ID]["serial"]; serial != nil {
+			oldSerial = aws.StringValue(serial.S)
+		}
+
+		// Executing each test cases scenario.
+		response, _ := RotateSerial(events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": test.ID}, Body: test.Body})
+		if response.StatusCode != test.ExpectedStatusCode {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, response.Body)
+			continue
+		}
+		if test.ExpectedSerial == "" {
+			continue
+		}
+		if stored := aws.StringValue(mock.Devices[test.ID]["serial"].S); stored != test.ExpectedSerial {
+			t.Errorf("%s \n \t<expected stored serial: %s> <resulted stored serial: %s>", test.Name, test.ExpectedSerial, stored)
+		}
+		if test.ExpectedStatusCode != 200 {
+			continue
+		}
+		// The new serial is registered to the device, and the old one is free again.
+		if _, ok := mock.Markers[oldSerial]; ok || mock.Markers[test.ExpectedSerial] != test.ID {
+			t.Errorf("%s \n \t<expected only the marker of %s to %s> <resulted markers: %v>", test.Name, test.ExpectedSerial, test.ID, mock.Markers)
+		}
+		Device := types.Device{}
+		json.Unmarshal([]byte(response.Body), &Device)
+		if Device.Serial != test.ExpectedSerial || Device.Version != 4 || response.Headers["ETag"] != "\"4\"" {
+			t.Errorf("%s \n \t<expected serial: %s, version: 4, ETag: \"4\"> <resulted serial: %s, version: %d, ETag: %s>", test.Name, test.ExpectedSerial, Device.Serial, Device.Version, response.Headers["ETag"])
+		}
+	}
+} // End of TestRotateSerial function
+
+// A cancelled transaction leaves the device and the markers alone: a serial of another device is rejected with
+// HTTP 409, and so is a rotation racing with another change of the device.
+func TestRotateSerialCancellation(t *testing.T) {
+	t.Setenv("SERIALS_TABLE_NAME", "serials_test")
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	defer func() { TestAws = realAws }()
+
+	testCases := []struct {
+		Name               string
+		Serial             string
+		ConcurrentSerial   string
+		ExpectedBody       string
+		ExpectedStatusCode int
+	}{
+		{Name: "** Testing: Serial of another device. **", Serial: "serial_taken", ExpectedBody: "Serial already registered.", ExpectedStatusCode: 409},
+		{Name: "** Testing: Serial changed meanwhile. **", Serial: "serial_new", ConcurrentSerial: "serial_concurrent", ExpectedBody: "The device has been modified meanwhile, please retry.", ExpectedStatusCode: 409},
+	}
+
+	for _, test := range testCases {
+		mock := newMock()
+		mock.ConcurrentSerial = test.ConcurrentSerial
+		TestAws = &AmazonWebServices{DynamoDB: mock}
+
+		// Executing each test cases scenario.
+		response, _ := RotateSerial(events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}, Body: "{\"serial\":\"" + test.Serial + "\"}"})
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+		if mock.Markers["serial_old"] != "id_test" || mock.Markers["serial_taken"] != "id_other" || len(mock.Markers) != 2 {
+			t.Errorf("%s \n \t<expected the markers untouched> <resulted markers: %v>", test.Name, mock.Markers)
+		}
+	}
+} // End of TestRotateSerialCancellation function
+
+// Only the owner of a device can rotate its serial, a device of another tenant is reported as missing.
+func TestRotateSerialOwner(t *testing.T) {
+	t.Setenv("SERIALS_TABLE_NAME", "serials_test")
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	defer func() { TestAws = realAws }()
+
+	testCases := []struct {
+		Name               string
+		Caller             string
+		ExpectedStatusCode int
+	}{
+		{Name: "** Testing: Rotation by another tenant. **", Caller: "tenant-b", ExpectedStatusCode: 404},
+		{Name: "** Testing: Rotation by the owner. **", Caller: "tenant-a", ExpectedStatusCode: 200},
+	}
+
+	for _, test := range testCases {
+		TestAws = &AmazonWebServices{DynamoDB: newMock()}
+
+		// Executing each test cases scenario.
+		response, _ := RotateSerial(events.APIGatewayProxyRequest{
+			PathParameters: map[string]string{"id": "id_test"},
+			Body:           "{\"serial\":\"serial_new\"}",
+			RequestContext: events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"sub": test.Caller}},
+		})
+		if response.StatusCode != test.ExpectedStatusCode {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, response.Body)
+		}
+	}
+} // End of TestRotateSerialOwner function
+
+// With HISTORY_TABLE_NAME set, the device as it was before the rotation is kept in the history table, in the same
+// transaction. A version which is already there has been replaced meanwhile, so the rotation is rejected.
+func TestRotateSerialHistory(t *testing.T) {
+	t.Setenv("SERIALS_TABLE_NAME", "serials_test")
+	t.Setenv("HISTORY_TABLE_NAME", "history_test")
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	defer func() { TestAws = realAws }()
+
+	mock := newMock()
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	response, _ := RotateSerial(events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}, Body: "{\"serial\":\"serial_new\"}"})
+	if response.StatusCode != 200 {
+		t.Fatalf("** Testing: Rotation kept in the history. ** \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", 200, response.StatusCode, response.Body)
+	}
+	if kept := mock.History["id_test/3"]; len(mock.History) != 1 || kept == nil || aws.StringValue(kept["serial"].S) != "serial_old" {
+		t.Errorf("** Testing: Rotated device in the history. ** \n \t<expected version 3 with serial_old> <resulted history: %v>", mock.History)
+	}
+
+	mock = newMock()
+	mock.History["id_test/3"] = mock.Devices["id_test"]
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	response, _ = RotateSerial(events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}, Body: "{\"serial\":\"serial_new\"}"})
+	expected := "The device has been modified meanwhile, please retry."
+	if response.StatusCode != 409 || response.Body != expected || aws.StringValue(mock.Devices["id_test"]["serial"].S) != "serial_old" {
+		t.Errorf("** Testing: Version already in the history. ** \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", 409, response.StatusCode, expected, response.Body)
+	}
+} // End of TestRotateSerialHistory function
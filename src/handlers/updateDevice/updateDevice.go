@@ -0,0 +1,419 @@
+package main
+
+import (
+	"attributes"
+	"audit"
+	"encoding/json"
+	"etag"
+	"fmt"
+	"gateway"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"history"
+	"negotiation"
+	"os"
+	"owner"
+	"placeholder"
+	"recovery"
+	"strconv"
+	"strings"
+	"time"
+	"types"
+	"validation"
+)
+
+type AmazonWebServices struct {
+	Config   *aws.Config
+	Session  *session.Session
+	DynamoDB dynamodbiface.DynamoDBAPI
+}
+
+// Prepare a new AWS & DynamoDB session, then configure it.
+var TestAws *AmazonWebServices
+
+// Positions of the writes in the transaction of UpdateWithHistory, which its cancellation reasons follow.
+const (
+	deviceWrite = iota
+	historyWrite
+)
+
+func init() {
+	region := os.Getenv("AWS_REGION")
+	var Aws *AmazonWebServices = new(AmazonWebServices)
+	Aws.Config = &aws.Config{Region: aws.String(region)}
+	// Pointing the client to a local DynamoDB, i.e: DynamoDB Local for the integration tests. It's unset in production.
+	if endpoint := os.Getenv("DYNAMODB_ENDPOINT"); endpoint != "" {
+		Aws.Config.Endpoint = aws.String(endpoint)
+	}
+	var err error
+	Aws.Session, err = session.NewSession(Aws.Config)
+	if err != nil {
+		// Logs error on Amazon CloudWatch. It's sysadmin's duty to handle it.
+		fmt.Println(fmt.Sprintf("Failed to connect to AWS: %s", err.Error()))
+	} else {
+		var svc *dynamodb.DynamoDB = dynamodb.New(Aws.Session)
+		Aws.DynamoDB = dynamodbiface.DynamoDBAPI(svc)
+	}
+	// Instantiate a global session in TestAws
+	TestAws = Aws
+}
+
+// Preparing DynamoDB Session and Calling DB's PutItem function inside.
+// Optimistic concurrency: the item is only replaced when the stored device exists and still has the version the
+// user has read, while the new item carries the incremented version. So two concurrent updates of the same
+// version can never both succeed. On a failed condition the stored item is returned within the
+// *dynamodb.ConditionalCheckFailedException, to tell a missing device (no item) from a stale version.
+// On success the replaced item is returned, for the audit trail. The stored device is read first, as item keeps its
+// createdAt.
+func (self *AmazonWebServices) Update(item map[string]*dynamodb.AttributeValue, version int) (*dynamodb.PutItemOutput, error) {
+	// Get table name from OS's environment
+	tableName := aws.String(os.Getenv("DEVICES_TABLE_NAME"))
+
+	stored, err := self.DynamoDB.GetItem(&dynamodb.GetItemInput{
+		TableName:      tableName,
+		Key:            map[string]*dynamodb.AttributeValue{"id": item["id"]},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(stored.Item) == 0 {
+		return nil, &dynamodb.ConditionalCheckFailedException{Message_: aws.String("The conditional request failed")}
+	}
+	keepCreatedAt(item, stored.Item)
+
+	condition, names, values := updateCondition(item, version)
+	var input = &dynamodb.PutItemInput{
+		Item:                                item,
+		TableName:                           tableName,
+		ConditionExpression:                 aws.String(condition),
+		ExpressionAttributeNames:            names,
+		ExpressionAttributeValues:           values,
+		ReturnValues:                        aws.String(dynamodb.ReturnValueAllOld),
+		ReturnValuesOnConditionCheckFailure: aws.String(dynamodb.ReturnValuesOnConditionCheckFailureAllOld),
+	}
+	// Calling either PutItem function of interface, defined in updateDevice_test.go file, or api with the input we've provided.
+	// In real deployment environment, the PutItem function of aws (api.go) will be called.
+	result, err := self.DynamoDB.PutItem(input)
+	return result, err
+}
+
+// Preparing DynamoDB Session and Calling DB's GetItem, then TransactWriteItems function inside. The device is replaced
+// with the same condition as Update, while the stored device it replaces is appended to the history table
+// (HISTORY_TABLE_NAME), keyed by its id and version, in the same transaction. So a version is in the history exactly
+// when it has been replaced. Failures are returned like the ones of Update, a failed condition as a
+// *dynamodb.ConditionalCheckFailedException with the stored item, and on success the replaced item is returned.
+func (self *AmazonWebServices) UpdateWithHistory(item map[string]*dynamodb.AttributeValue, version int) (map[string]*dynamodb.AttributeValue, error) {
+	// Get tables' names from OS's environment
+	tableName := aws.String(os.Getenv("DEVICES_TABLE_NAME"))
+	historyTableName := os.Getenv("HISTORY_TABLE_NAME")
+
+	// The condition of the transaction makes sure the device read here is still the stored one when it's replaced.
+	stored, err := self.DynamoDB.GetItem(&dynamodb.GetItemInput{
+		TableName:      tableName,
+		Key:            map[string]*dynamodb.AttributeValue{"id": item["id"]},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(stored.Item) == 0 {
+		return nil, &dynamodb.ConditionalCheckFailedException{Message_: aws.String("The conditional request failed")}
+	}
+	keepCreatedAt(item, stored.Item)
+
+	condition, names, values := updateCondition(item, version)
+	var input = &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			deviceWrite: {Put: &dynamodb.Put{
+				Item:                                item,
+				TableName:                           tableName,
+				ConditionExpression:                 aws.String(condition),
+				ExpressionAttributeNames:            names,
+				ExpressionAttributeValues:           values,
+				ReturnValuesOnConditionCheckFailure: aws.String(dynamodb.ReturnValuesOnConditionCheckFailureAllOld),
+			}},
+			historyWrite: {Put: history.Put(historyTableName, stored.Item)},
+		},
+	}
+
+	// Calling either TransactWriteItems function of interface, defined in updateDevice_test.go file, or api with the input we've provided.
+	// In real deployment environment, the TransactWriteItems function of aws (api.go) will be called.
+	_, err = self.DynamoDB.TransactWriteItems(input)
+	if canceled, ok := err.(*dynamodb.TransactionCanceledException); ok {
+		// The device has changed since it's been read, or its version is already in the history because it has
+		// been replaced meanwhile. Either way the stored device is returned, as by the failed condition of Update.
+		if reasonFailed(canceled, deviceWrite) {
+			return nil, &dynamodb.ConditionalCheckFailedException{Message_: aws.String("The conditional request failed"), Item: canceled.CancellationReasons[deviceWrite].Item}
+		}
+		if reasonFailed(canceled, historyWrite) {
+			return nil, &dynamodb.ConditionalCheckFailedException{Message_: aws.String("The conditional request failed"), Item: stored.Item}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stored.Item, nil
+}
+
+// Building the condition of replacing a device with item, which is based on version, and setting the incremented
+// version on item: the device has to exist, not be soft deleted, still have version and, for an item with an owner,
+// belong to the same owner. Its serial can't change either, it's changed by RotateSerial along with its marker.
+// Version 0 stands for a device stored before versioning, which has no version at all.
+func updateCondition(item map[string]*dynamodb.AttributeValue, version int) (string, placeholder.Names, map[string]*dynamodb.AttributeValue) {
+	item["version"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(version + 1))}
+	names := placeholder.Names{}
+	condition := fmt.Sprintf("attribute_exists(%s) AND attribute_not_exists(%s) AND attribute_not_exists(%s)", names.Of("id"), names.Of("deleted"), names.Of("version"))
+	values := map[string]*dynamodb.AttributeValue{}
+	if version > 0 {
+		condition = fmt.Sprintf("attribute_exists(%s) AND attribute_not_exists(%s) AND %s = :v", names.Of("id"), names.Of("deleted"), names.Of("version"))
+		values[":v"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(version))}
+	}
+	// An item with an owner may only replace a device of the same owner.
+	if ownerID := item["ownerId"]; ownerID != nil {
+		condition += fmt.Sprintf(" AND %s = :owner", names.Of("ownerId"))
+		values[":owner"] = ownerID
+	}
+	if serial := item["serial"]; serial != nil {
+		condition += fmt.Sprintf(" AND %s = :serial", names.Of("serial"))
+		values[":serial"] = serial
+	}
+	return condition, names, values
+}
+
+// Keeping the createdAt of the stored device on the item replacing it, whatever the user has sent for it.
+// A device is only created once, so its createdAt never changes.
+func keepCreatedAt(item map[string]*dynamodb.AttributeValue, stored map[string]*dynamodb.AttributeValue) {
+	if createdAt := stored["createdAt"]; createdAt != nil {
+		item["createdAt"] = createdAt
+	} else {
+		delete(item, "createdAt")
+	}
+}
+
+// Checking whether a write of a cancelled transaction has failed its condition.
+func reasonFailed(canceled *dynamodb.TransactionCanceledException, write int) bool {
+	return write < len(canceled.CancellationReasons) && aws.StringValue(canceled.CancellationReasons[write].Code) == "ConditionalCheckFailed"
+}
+
+// The handler function which will be first started from main function.
+// The body has to carry the current version of the device: a stale version is rejected with HTTP 409,
+// and the updated device is returned with the incremented version.
+// An If-Match header with the ETag of GetDeviceById stands for the version instead, a stale ETag is rejected
+// with HTTP 412 Precondition Failed. With HISTORY_TABLE_NAME set, the replaced device is kept in the history table.
+func UpdateDevice(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !gateway.AllowsMethod(request, "PUT") {
+		return gateway.MethodNotAllowed("PUT"), nil
+	}
+
+	// The id of the device which user wants to update, sent through PUT method.
+	id := request.PathParameters["id"]
+
+	// If no id have been provided, return HTTP error code 400.
+	if id == "" {
+		return events.APIGatewayProxyResponse{
+			Body:       "Missing field: id",
+			StatusCode: 400,
+		}, nil
+	}
+
+	// Validate user input with the same checks as AddDevice.
+	UpdatedDevice, err := validation.ValidateInputs(request)
+	// if inputs are not suitable, return HTTP error code 400.
+	if err == validation.ErrBodyTooLarge {
+		return events.APIGatewayProxyResponse{
+			Body:       err.Error(),
+			StatusCode: 413,
+		}, nil
+	}
+	if err != nil {
+		body := err.Error()
+		// Field failures are collected, so return all of them as a JSON list.
+		if fieldErrors, ok := err.(validation.FieldErrors); ok {
+			errorsJson, _ := json.Marshal(types.ErrorList{Errors: fieldErrors.Messages()})
+			body = string(errorsJson)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       body,
+			StatusCode: 400,
+		}, nil
+	}
+
+	// The id of a device can not be changed, so the body has to point to the same device as the path.
+	if UpdatedDevice.ID != id {
+		return events.APIGatewayProxyResponse{
+			Body:       "Invalid field: ID does not match the requested device.",
+			StatusCode: 400,
+		}, nil
+	}
+
+	// The ETag of If-Match takes precedence over the version of the body, "*" only asks for an existing device.
+	ifMatch := strings.TrimSpace(headerValue(request.Headers, "If-Match"))
+	conditional := ifMatch != "" && ifMatch != "*"
+	if conditional {
+		version, ok := etag.Parse(ifMatch)
+		if !ok {
+			// A malformed or a weak ETag can never match the one of the device.
+			return events.APIGatewayProxyResponse{
+				Body:       "Precondition Failed",
+				StatusCode: 412,
+			}, nil
+		}
+		UpdatedDevice.Version = version
+	}
+
+	// The user has to send the version of the device which the update is based on, a missing one or 0 is the one of a
+	// device stored before versioning.
+	if UpdatedDevice.Version < 0 {
+		return events.APIGatewayProxyResponse{
+			Body:       "Invalid field: Version must not be negative",
+			StatusCode: 400,
+		}, nil
+	}
+
+	// Only DeleteDevice can soft delete a device, whatever the user has sent for it is ignored.
+	UpdatedDevice.Deleted = false
+	UpdatedDevice.DeletedAt = ""
+	// The timestamps are set on the server side, like in PatchDevice: the device is updated now, and it keeps the
+	// createdAt which it's stored with.
+	UpdatedDevice.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	UpdatedDevice.CreatedAt = ""
+	// The device stays with the tenant of the caller, which has to be its owner.
+	caller := owner.Caller(request)
+	UpdatedDevice.OwnerID = caller
+
+	// Serialization/Encoding "UpdatedDevice" in "item" for using in DynamoDB functions.
+	item, _ := attributes.Marshal(UpdatedDevice)
+	replaced, err := replace(item, UpdatedDevice.Version)
+
+	// A member of one of the groups which the device is shared with replaces it on behalf of its owner: the device keeps
+	// its owner and the groups which it's shared with.
+	if cerr, ok := err.(*dynamodb.ConditionalCheckFailedException); ok && caller != "" && len(cerr.Item) > 0 && cerr.Item["deleted"] == nil && storedOwner(cerr.Item) != caller && owner.Allows(request, storedOwner(cerr.Item), storedGroups(cerr.Item)) {
+		UpdatedDevice.OwnerID = storedOwner(cerr.Item)
+		UpdatedDevice.AllowedGroups = storedGroups(cerr.Item)
+		item, _ = attributes.Marshal(UpdatedDevice)
+		replaced, err = replace(item, UpdatedDevice.Version)
+	}
+
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			// The device exists but has another version, someone else has changed it meanwhile, return HTTP error code 409.
+			// A soft deleted device, or one of another tenant, is reported as missing, same as in GetDeviceById.
+			if cerr, ok := err.(*dynamodb.ConditionalCheckFailedException); ok && len(cerr.Item) > 0 && cerr.Item["deleted"] == nil && owner.Allows(request, storedOwner(cerr.Item), storedGroups(cerr.Item)) {
+				// The device still has the version, so it's the serial which has failed the condition.
+				if storedVersion(cerr.Item) == UpdatedDevice.Version && storedSerial(cerr.Item) != UpdatedDevice.Serial {
+					return events.APIGatewayProxyResponse{
+						Body:       "Invalid field: Serial can only be changed by rotating it.",
+						StatusCode: 400,
+					}, nil
+				}
+				// The ETag of If-Match no longer matches the device, return HTTP error code 412.
+				if conditional {
+					return events.APIGatewayProxyResponse{
+						Body:       "Precondition Failed",
+						StatusCode: 412,
+					}, nil
+				}
+				return events.APIGatewayProxyResponse{
+					Body:       "Version conflict",
+					StatusCode: 409,
+				}, nil
+			}
+			// There is no device with this id in the table, return HTTP error code 404.
+			return events.APIGatewayProxyResponse{
+				Body:       "Desired device not found.",
+				StatusCode: 404,
+			}, nil
+		}
+		// If internal database errors occurred, return HTTP error code 500.
+		return events.APIGatewayProxyResponse{
+			Body:       "Internal Server Error\nDatabase error.",
+			StatusCode: 500,
+		}, nil
+	}
+
+	// Recording who has replaced the device for the audit trail. It has been updated anyway, so a failure is only logged.
+	if err := audit.Write(TestAws.DynamoDB, audit.NewRecord(id, audit.ActionUpdate, caller, replaced, item)); err != nil {
+		fmt.Println(fmt.Sprintf("Failed to write the audit record: %s", err.Error()))
+	}
+
+	// Serialization/Encoding "UpdatedDevice" with its new version and the createdAt it has kept to JSON.
+	UpdatedDevice.Version++
+	if createdAt := item["createdAt"]; createdAt != nil {
+		UpdatedDevice.CreatedAt = aws.StringValue(createdAt.S)
+	}
+	jsonResponse, _ := negotiation.Marshal(negotiation.JSON, UpdatedDevice)
+	return events.APIGatewayProxyResponse{
+		Headers: map[string]string{"ETag": etag.Format(UpdatedDevice.Version)},
+		Body:    string(jsonResponse),
+		// Everything looks fine, return HTTP 200
+		StatusCode: 200,
+	}, nil
+} // End of UpdateDevice function
+
+// Replacing a device with item, keeping the replaced device in the history table when HISTORY_TABLE_NAME is set.
+// Returns the replaced device.
+func replace(item map[string]*dynamodb.AttributeValue, version int) (map[string]*dynamodb.AttributeValue, error) {
+	if os.Getenv("HISTORY_TABLE_NAME") != "" {
+		return TestAws.UpdateWithHistory(item, version)
+	}
+	result, err := TestAws.Update(item, version)
+	if err != nil {
+		return nil, err
+	}
+	return result.Attributes, nil
+}
+
+// Finding the groups which a stored device is shared with, none for the devices which aren't shared.
+func storedGroups(item map[string]*dynamodb.AttributeValue) []string {
+	var groups []string
+	if allowedGroups := item["allowedGroups"]; allowedGroups != nil {
+		dynamodbattribute.Unmarshal(allowedGroups, &groups)
+	}
+	return groups
+}
+
+// Finding the owner of a stored device, empty for the devices created without a caller.
+func storedOwner(item map[string]*dynamodb.AttributeValue) string {
+	if ownerID := item["ownerId"]; ownerID != nil {
+		return aws.StringValue(ownerID.S)
+	}
+	return ""
+}
+
+// Finding the version of a stored device, 0 for the devices stored before versioning.
+func storedVersion(item map[string]*dynamodb.AttributeValue) int {
+	version := 0
+	if stored := item["version"]; stored != nil {
+		version, _ = strconv.Atoi(aws.StringValue(stored.N))
+	}
+	return version
+}
+
+// Finding the serial of a stored device.
+func storedSerial(item map[string]*dynamodb.AttributeValue) string {
+	if serial := item["serial"]; serial != nil {
+		return aws.StringValue(serial.S)
+	}
+	return ""
+}
+
+// Finding a header of the request regardless of its case, as clients and proxies may change it.
+func headerValue(headers map[string]string, name string) string {
+	for header, value := range headers {
+		if strings.EqualFold(header, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+func main() {
+	lambda.Start(gateway.Adapt(recovery.WithRecover(UpdateDevice)))
+}
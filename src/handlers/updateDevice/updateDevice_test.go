@@ -0,0 +1,423 @@
+package main
+
+import (
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type TestCase struct {
+	Name               string
+	Request            events.APIGatewayProxyRequest
+	ExpectedBody       string
+	ExpectedStatusCode int
+}
+
+// Mocking DynamoDB through dynamodbiface.
+type MockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	// Current versions of the devices which are already stored in the mocked table, by id, and the soft deleted ones.
+	Versions map[string]int
+	Deleted  map[string]bool
+	Owners   map[string]string
+	// Serials of the devices which are checked by the condition, by id, none when it's missing.
+	Serials map[string]string
+	// Revisions of the devices appended to the mocked history table, by id.
+	History map[string][]string
+	// Item of the last successful put.
+	Put map[string]*dynamodb.AttributeValue
+}
+
+// The updatedAt of a device is the time of its update, so it's left out of the compared bodies.
+var updatedAt = regexp.MustCompile(`"updatedAt":"[^"]*",`)
+
+// Every device of the mocked table has been created at the same time.
+const createdAt = "2018-11-02T10:04:05Z"
+
+// Custom PutItem function for overriding the PutItem of updateDevice.go for using in test scenarios.
+// Mocking the "attribute_exists(#id) AND attribute_not_exists(#deleted) AND #version = :v" condition against the mock,
+// and the "#ownerId = :owner" and "#serial = :serial" ones when they're given.
+func (self *MockDynamoDB) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	id := aws.StringValue(input.Item["id"].S)
+	storedVersion, exists := self.Versions[id]
+	if !exists {
+		return nil, &dynamodb.ConditionalCheckFailedException{Message_: aws.String("The conditional request failed")}
+	}
+	if self.Deleted[id] {
+		return nil, &dynamodb.ConditionalCheckFailedException{
+			Message_: aws.String("The conditional request failed"),
+			Item:     map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}, "version": {N: aws.String(strconv.Itoa(storedVersion))}, "deleted": {BOOL: aws.Bool(true)}},
+		}
+	}
+	if ownerID := input.ExpressionAttributeValues[":owner"]; ownerID != nil && self.Owners[id] != aws.StringValue(ownerID.S) {
+		stored := map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}, "version": {N: aws.String(strconv.Itoa(storedVersion))}}
+		if self.Owners[id] != "" {
+			stored["ownerId"] = &dynamodb.AttributeValue{S: aws.String(self.Owners[id])}
+		}
+		return nil, &dynamodb.ConditionalCheckFailedException{Message_: aws.String("The conditional request failed"), Item: stored}
+	}
+	// A condition without a version is the one of a device stored before versioning, which the mock stores as 0.
+	expectedVersion := "0"
+	if version := input.ExpressionAttributeValues[":v"]; version != nil {
+		expectedVersion = aws.StringValue(version.N)
+	}
+	if strconv.Itoa(storedVersion) != expectedVersion {
+		// Returning the stored item, as ReturnValuesOnConditionCheckFailure is ALL_OLD.
+		return nil, &dynamodb.ConditionalCheckFailedException{
+			Message_: aws.String("The conditional request failed"),
+			Item:     map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}, "version": {N: aws.String(strconv.Itoa(storedVersion))}},
+		}
+	}
+	if serial := input.ExpressionAttributeValues[":serial"]; serial != nil && self.Serials[id] != "" && self.Serials[id] != aws.StringValue(serial.S) {
+		return nil, &dynamodb.ConditionalCheckFailedException{
+			Message_: aws.String("The conditional request failed"),
+			Item:     map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}, "version": {N: aws.String(strconv.Itoa(storedVersion))}, "serial": {S: aws.String(self.Serials[id])}},
+		}
+	}
+	self.Versions[id], _ = strconv.Atoi(aws.StringValue(input.Item["version"].N))
+	self.Put = input.Item
+	return new(dynamodb.PutItemOutput), nil
+}
+
+// Custom GetItem function for overriding the GetItem of updateDevice.go for using in test scenarios.
+// Returning the stored device of the mock, with its version and createdAt, which UpdateWithHistory keeps in the history.
+// A device at version 0 is one stored before versioning, without a version.
+func (self *MockDynamoDB) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	id := aws.StringValue(input.Key["id"].S)
+	storedVersion, exists := self.Versions[id]
+	if !exists {
+		return new(dynamodb.GetItemOutput), nil
+	}
+	item := map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}, "createdAt": {S: aws.String(createdAt)}}
+	if storedVersion > 0 {
+		item["version"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(storedVersion))}
+	}
+	return &dynamodb.GetItemOutput{Item: item}, nil
+}
+
+// Custom TransactWriteItems function for overriding the TransactWriteItems of updateDevice.go for using in test scenarios.
+// The put of the device is checked like PutItem, and the put of the history only if its revision isn't there yet.
+func (self *MockDynamoDB) TransactWriteItems(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+	device, history := input.TransactItems[deviceWrite].Put, input.TransactItems[historyWrite].Put
+	id := aws.StringValue(history.Item["id"].S)
+	revision := aws.StringValue(history.Item["revision"].S)
+	for _, replaced := range self.History[id] {
+		if replaced == revision {
+			return nil, &dynamodb.TransactionCanceledException{CancellationReasons: []*dynamodb.CancellationReason{{Code: aws.String("None")}, {Code: aws.String("ConditionalCheckFailed")}}}
+		}
+	}
+	_, err := self.PutItem(&dynamodb.PutItemInput{Item: device.Item, ExpressionAttributeValues: device.ExpressionAttributeValues})
+	if cerr, ok := err.(*dynamodb.ConditionalCheckFailedException); ok {
+		return nil, &dynamodb.TransactionCanceledException{CancellationReasons: []*dynamodb.CancellationReason{{Code: aws.String("ConditionalCheckFailed"), Item: cerr.Item}, {Code: aws.String("None")}}}
+	}
+	self.History[id] = append(self.History[id], revision)
+	return new(dynamodb.TransactWriteItemsOutput), nil
+}
+
+// Update function in updateDevice.go signature: input: (item map[string] *dynamodb.AttributeValue, version int), output: (*dynamodb.PutItemOutput, error)
+func TestUpdate(t *testing.T) {
+	mock := &MockDynamoDB{Versions: map[string]int{"id1": 1}}
+	test_aws := new(AmazonWebServices)
+	test_aws.DynamoDB = mock
+
+	_, err := test_aws.Update(map[string]*dynamodb.AttributeValue{"id": {S: aws.String("id1")}}, 1)
+	if err != nil || mock.Versions["id1"] != 2 {
+		t.Errorf("** Updating an existing device ** \n \t<expected error: %v, version: 2> <resulted error: %v, version: %d>", nil, err, mock.Versions["id1"])
+	}
+
+	// The device is at version 2 now, so an update based on version 1 is stale.
+	_, err = test_aws.Update(map[string]*dynamodb.AttributeValue{"id": {S: aws.String("id1")}}, 1)
+	if cerr, ok := err.(*dynamodb.ConditionalCheckFailedException); !ok || len(cerr.Item) == 0 {
+		t.Errorf("** Updating a device with a stale version ** \n \t<expected a conditional check error with the stored item> <resulted error: %v>", err)
+	}
+
+	_, err = test_aws.Update(map[string]*dynamodb.AttributeValue{"id": {S: aws.String("NotExistedTestID")}}, 1)
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != dynamodb.ErrCodeConditionalCheckFailedException {
+		t.Errorf("** Updating a missing device ** \n \t<expected a conditional check error> <resulted error: %v>", err)
+	}
+} // End of TestUpdate function.
+
+// UpdateDevice function in updateDevice.go signature: input: (request events.APIGatewayProxyRequest), output: (events.APIGatewayProxyResponse, error)
+func TestUpdateDevice(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{
+		Versions: map[string]int{"7c9e6679-7425-40de-944b-e07fc1f90ae7": 3, "3f2504e0-4f89-41d3-9a0c-0305e82c3301": 1, "0f8fad5b-d9cb-469f-a165-70867728950e": 0},
+		Deleted:  map[string]bool{"3f2504e0-4f89-41d3-9a0c-0305e82c3301": true},
+		Serials:  map[string]string{"7c9e6679-7425-40de-944b-e07fc1f90ae7": "testSerial"},
+	}}
+	defer func() { TestAws = realAws }()
+
+	testCases := []TestCase{
+		{
+			Name:               "** Testing: Empty id input. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": ""}},
+			ExpectedBody:       "Missing field: id",
+			ExpectedStatusCode: 400,
+		},
+
+		{
+			Name:               "** Testing: JSON with missing field - Name **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "7c9e6679-7425-40de-944b-e07fc1f90ae7"}, Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\" , \"deviceModel\":\"testDeviceModel\" , \"name\":\"\" , \"note\":\"testNote\" , \"serial\":\"testSerial\" }"},
+			ExpectedBody:       "{\"errors\":[\"Missing field: Name\"]}",
+			ExpectedStatusCode: 400,
+		},
+
+		{
+			Name:               "** Testing: Body id differs from path id. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "7c9e6679-7425-40de-944b-e07fc1f90ae7"}, Body: "{\"id\":\"9b2e1d4a-3f5c-4e8a-b6d7-0c1f2a3b4c5d\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\",\"version\":3}"},
+			ExpectedBody:       "Invalid field: ID does not match the requested device.",
+			ExpectedStatusCode: 400,
+		},
+
+		{
+			Name:               "** Testing: Desire device does not exist. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "9b2e1d4a-3f5c-4e8a-b6d7-0c1f2a3b4c5d"}, Body: "{\"id\":\"9b2e1d4a-3f5c-4e8a-b6d7-0c1f2a3b4c5d\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\",\"version\":3}"},
+			ExpectedBody:       "Desired device not found.",
+			ExpectedStatusCode: 404,
+		},
+
+		{
+			Name:               "** Testing: Proper update of an existing device. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "7c9e6679-7425-40de-944b-e07fc1f90ae7"}, Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"newName\",\"note\":\"testNote\",\"serial\":\"testSerial\",\"version\":3}"},
+			ExpectedBody:       "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"newName\",\"note\":\"testNote\",\"serial\":\"testSerial\",\"createdAt\":\"2018-11-02T10:04:05Z\",\"version\":4}",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			// The device is at version 4 now, after the previous case.
+			Name:               "** Testing: Update based on a stale version. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "7c9e6679-7425-40de-944b-e07fc1f90ae7"}, Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"otherName\",\"note\":\"testNote\",\"serial\":\"testSerial\",\"version\":3}"},
+			ExpectedBody:       "Version conflict",
+			ExpectedStatusCode: 409,
+		},
+
+		{
+			// The serial is changed by RotateSerial only, along with its marker.
+			Name:               "** Testing: Update changing the serial. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "7c9e6679-7425-40de-944b-e07fc1f90ae7"}, Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"otherName\",\"note\":\"testNote\",\"serial\":\"otherSerial\",\"version\":4}"},
+			ExpectedBody:       "Invalid field: Serial can only be changed by rotating it.",
+			ExpectedStatusCode: 400,
+		},
+
+		{
+			Name:               "** Testing: Update of a soft deleted device. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "3f2504e0-4f89-41d3-9a0c-0305e82c3301"}, Body: "{\"id\":\"3f2504e0-4f89-41d3-9a0c-0305e82c3301\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\",\"version\":1}"},
+			ExpectedBody:       "Desired device not found.",
+			ExpectedStatusCode: 404,
+		},
+
+		{
+			Name:               "** Testing: Update without a version. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "7c9e6679-7425-40de-944b-e07fc1f90ae7"}, Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"otherName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}"},
+			ExpectedBody:       "Version conflict",
+			ExpectedStatusCode: 409,
+		},
+
+		{
+			Name:               "** Testing: Update without a version of a device stored before versioning. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "0f8fad5b-d9cb-469f-a165-70867728950e"}, Body: "{\"id\":\"0f8fad5b-d9cb-469f-a165-70867728950e\",\"deviceModel\":\"testDeviceModel\",\"name\":\"newName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}"},
+			ExpectedBody:       "{\"id\":\"0f8fad5b-d9cb-469f-a165-70867728950e\",\"deviceModel\":\"testDeviceModel\",\"name\":\"newName\",\"note\":\"testNote\",\"serial\":\"testSerial\",\"createdAt\":\"2018-11-02T10:04:05Z\",\"version\":1}",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Testing: Update with a negative version. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "7c9e6679-7425-40de-944b-e07fc1f90ae7"}, Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"otherName\",\"note\":\"testNote\",\"serial\":\"testSerial\",\"version\":-1}"},
+			ExpectedBody:       "Invalid field: Version must not be negative",
+			ExpectedStatusCode: 400,
+		},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := UpdateDevice(test.Request)
+		if response.StatusCode != test.ExpectedStatusCode || updatedAt.ReplaceAllString(response.Body, "") != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+} // End of TestUpdateDevice function
+
+// An If-Match header only lets the update through while it matches the ETag of the stored device.
+func TestUpdateDeviceIfMatch(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{Versions: map[string]int{"7c9e6679-7425-40de-944b-e07fc1f90ae7": 3}}}
+	defer func() { TestAws = realAws }()
+
+	body := "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"newName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}"
+	testCases := []struct {
+		Name               string
+		Headers            map[string]string
+		ExpectedStatusCode int
+		ExpectedETag       string
+	}{
+		{Name: "** Testing: Matching If-Match. **", Headers: map[string]string{"If-Match": "\"3\""}, ExpectedStatusCode: 200, ExpectedETag: "\"4\""},
+		// The device is at version 4 now, after the previous case.
+		{Name: "** Testing: Mismatching If-Match. **", Headers: map[string]string{"If-Match": "\"3\""}, ExpectedStatusCode: 412},
+		// A weak ETag never matches, as If-Match uses the strong comparison, so the device stays at version 4.
+		{Name: "** Testing: Weak matching if-match. **", Headers: map[string]string{"if-match": "W/\"4\""}, ExpectedStatusCode: 412},
+		{Name: "** Testing: Strong matching if-match. **", Headers: map[string]string{"if-match": "\"4\""}, ExpectedStatusCode: 200, ExpectedETag: "\"5\""},
+		{Name: "** Testing: Malformed If-Match. **", Headers: map[string]string{"If-Match": "five"}, ExpectedStatusCode: 412},
+		// "*" only asks for an existing device, so the update is still based on the version of the body, and a body without
+		// one is based on a device stored before versioning.
+		{Name: "** Testing: If-Match any without a version. **", Headers: map[string]string{"If-Match": "*"}, ExpectedStatusCode: 409},
+		// "0" is the ETag of a device stored before versioning, which this one isn't.
+		{Name: "** Testing: If-Match of an unversioned device. **", Headers: map[string]string{"If-Match": "\"0\""}, ExpectedStatusCode: 412},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := UpdateDevice(events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "7c9e6679-7425-40de-944b-e07fc1f90ae7"}, Headers: test.Headers, Body: body})
+		if response.StatusCode != test.ExpectedStatusCode || response.Headers["ETag"] != test.ExpectedETag {
+			t.Errorf("%s \n \t<expected error-code: %d, ETag: %s> <resulted error-code: %d, ETag: %s> \n \t<resulted body: %s>", test.Name, test.ExpectedStatusCode, test.ExpectedETag, response.StatusCode, response.Headers["ETag"], response.Body)
+		}
+	}
+
+	// A device stored before versioning matches "0", its ETag, and gets its first version.
+	TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{Versions: map[string]int{"0f8fad5b-d9cb-469f-a165-70867728950e": 0}}}
+	unversioned := strings.Replace(body, "7c9e6679-7425-40de-944b-e07fc1f90ae7", "0f8fad5b-d9cb-469f-a165-70867728950e", 1)
+	response, _ := UpdateDevice(events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "0f8fad5b-d9cb-469f-a165-70867728950e"}, Headers: map[string]string{"If-Match": "\"0\""}, Body: unversioned})
+	if response.StatusCode != 200 || response.Headers["ETag"] != "\"1\"" {
+		t.Errorf("** Testing: Matching If-Match of a device stored before versioning. ** \n \t<expected error-code: 200, ETag: \"1\"> <resulted error-code: %d, ETag: %s> \n \t<resulted body: %s>", response.StatusCode, response.Headers["ETag"], response.Body)
+	}
+} // End of TestUpdateDeviceIfMatch function
+
+// Only the owner of a device can update it, a device of another tenant is reported as missing.
+func TestUpdateDeviceOwner(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	mock := &MockDynamoDB{Versions: map[string]int{"7c9e6679-7425-40de-944b-e07fc1f90ae7": 1}, Owners: map[string]string{"7c9e6679-7425-40de-944b-e07fc1f90ae7": "tenant-a"}}
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	body := "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"newName\",\"note\":\"testNote\",\"serial\":\"testSerial\",\"version\":1,\"ownerId\":\"tenant-a\"}"
+	testCases := []struct {
+		Name               string
+		Caller             string
+		ExpectedStatusCode int
+	}{
+		// Claiming the owner in the body doesn't help, it's always the caller.
+		{Name: "** Testing: Update by another tenant. **", Caller: "tenant-b", ExpectedStatusCode: 404},
+		{Name: "** Testing: Update by the owner. **", Caller: "tenant-a", ExpectedStatusCode: 200},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := UpdateDevice(events.APIGatewayProxyRequest{
+			PathParameters: map[string]string{"id": "7c9e6679-7425-40de-944b-e07fc1f90ae7"},
+			RequestContext: events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"claims": map[string]interface{}{"sub": test.Caller}}},
+			Body:           body,
+		})
+		if response.StatusCode != test.ExpectedStatusCode {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, response.Body)
+		}
+	}
+	if mock.Versions["7c9e6679-7425-40de-944b-e07fc1f90ae7"] != 2 {
+		t.Errorf("** Testing: Only the owner's update is applied. ** \n \t<expected version: 2> <resulted version: %d>", mock.Versions["7c9e6679-7425-40de-944b-e07fc1f90ae7"])
+	}
+} // End of TestUpdateDeviceOwner function
+
+// With HISTORY_TABLE_NAME set, every replaced version is appended to the history along with the update.
+func TestUpdateDeviceHistory(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	// The device has been created again, so the history already has the version 1 of the former one.
+	mock := &MockDynamoDB{
+		Versions: map[string]int{"7c9e6679-7425-40de-944b-e07fc1f90ae7": 1, "0f8fad5b-d9cb-469f-a165-70867728950e": 0},
+		History:  map[string][]string{"7c9e6679-7425-40de-944b-e07fc1f90ae7": {"2000-01-01T00:00:00Z#0000000001"}},
+	}
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	os.Setenv("HISTORY_TABLE_NAME", "history_test")
+	defer func() {
+		TestAws = realAws
+		os.Unsetenv("HISTORY_TABLE_NAME")
+	}()
+
+	testCases := []TestCase{
+		{
+			Name:               "** Testing: Update of version 1. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "7c9e6679-7425-40de-944b-e07fc1f90ae7"}, Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"newName\",\"note\":\"testNote\",\"serial\":\"testSerial\",\"version\":1}"},
+			ExpectedBody:       "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"newName\",\"note\":\"testNote\",\"serial\":\"testSerial\",\"createdAt\":\"2018-11-02T10:04:05Z\",\"version\":2}",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			Name:               "** Testing: Update of version 2. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "7c9e6679-7425-40de-944b-e07fc1f90ae7"}, Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"otherName\",\"note\":\"testNote\",\"serial\":\"testSerial\",\"version\":2}"},
+			ExpectedBody:       "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"otherName\",\"note\":\"testNote\",\"serial\":\"testSerial\",\"createdAt\":\"2018-11-02T10:04:05Z\",\"version\":3}",
+			ExpectedStatusCode: 200,
+		},
+
+		{
+			// Nothing is appended to the history for a rejected update.
+			Name:               "** Testing: Update based on a stale version. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "7c9e6679-7425-40de-944b-e07fc1f90ae7"}, Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"staleName\",\"note\":\"testNote\",\"serial\":\"testSerial\",\"version\":2}"},
+			ExpectedBody:       "Version conflict",
+			ExpectedStatusCode: 409,
+		},
+
+		{
+			Name:               "** Testing: Desire device does not exist. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "9b2e1d4a-3f5c-4e8a-b6d7-0c1f2a3b4c5d"}, Body: "{\"id\":\"9b2e1d4a-3f5c-4e8a-b6d7-0c1f2a3b4c5d\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\",\"version\":1}"},
+			ExpectedBody:       "Desired device not found.",
+			ExpectedStatusCode: 404,
+		},
+
+		{
+			Name:               "** Testing: Update of a device stored before versioning. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "0f8fad5b-d9cb-469f-a165-70867728950e"}, Body: "{\"id\":\"0f8fad5b-d9cb-469f-a165-70867728950e\",\"deviceModel\":\"testDeviceModel\",\"name\":\"newName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}"},
+			ExpectedBody:       "{\"id\":\"0f8fad5b-d9cb-469f-a165-70867728950e\",\"deviceModel\":\"testDeviceModel\",\"name\":\"newName\",\"note\":\"testNote\",\"serial\":\"testSerial\",\"createdAt\":\"2018-11-02T10:04:05Z\",\"version\":1}",
+			ExpectedStatusCode: 200,
+		},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := UpdateDevice(test.Request)
+		if response.StatusCode != test.ExpectedStatusCode || updatedAt.ReplaceAllString(response.Body, "") != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+	// The versions of the device are kept after the one of the former device, told apart by their createdAt.
+	expected := []string{"2000-01-01T00:00:00Z#0000000001", "2018-11-02T10:04:05Z#0000000001", "2018-11-02T10:04:05Z#0000000002"}
+	if history := mock.History["7c9e6679-7425-40de-944b-e07fc1f90ae7"]; !reflect.DeepEqual(history, expected) {
+		t.Errorf("** Testing: History of the device. ** \n \t<expected revisions: %v> <resulted revisions: %v>", expected, history)
+	}
+	// A device stored before versioning is kept as version 0.
+	if history := mock.History["0f8fad5b-d9cb-469f-a165-70867728950e"]; !reflect.DeepEqual(history, []string{"2018-11-02T10:04:05Z#0000000000"}) {
+		t.Errorf("** Testing: History of a device stored before versioning. ** \n \t<expected revisions: [2018-11-02T10:04:05Z#0000000000]> <resulted revisions: %v>", history)
+	}
+} // End of TestUpdateDeviceHistory function
+
+// The timestamps of the body are ignored: the device keeps the createdAt which it's stored with, and its updatedAt is
+// the time of the update.
+func TestUpdateDeviceTimestamps(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	mock := &MockDynamoDB{Versions: map[string]int{"7c9e6679-7425-40de-944b-e07fc1f90ae7": 1}}
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	before := time.Now().UTC().Add(-time.Second)
+	body := "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\",\"deviceModel\":\"testDeviceModel\",\"name\":\"newName\",\"note\":\"testNote\",\"serial\":\"testSerial\",\"version\":1,\"createdAt\":\"2000-01-01T00:00:00Z\",\"updatedAt\":\"2000-01-01T00:00:00Z\"}"
+	response, _ := UpdateDevice(events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "7c9e6679-7425-40de-944b-e07fc1f90ae7"}, Body: body})
+	if response.StatusCode != 200 || mock.Put == nil {
+		t.Fatalf("** Testing: Update with timestamps. ** \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", 200, response.StatusCode, response.Body)
+	}
+	if aws.StringValue(mock.Put["createdAt"].S) != createdAt || !strings.Contains(response.Body, "\"createdAt\":\""+createdAt+"\"") {
+		t.Errorf("** Testing: createdAt of the updated device. ** \n \t<expected createdAt: %s> <resulted stored: %s, body: %s>", createdAt, aws.StringValue(mock.Put["createdAt"].S), response.Body)
+	}
+	if stored, err := time.Parse(time.RFC3339, aws.StringValue(mock.Put["updatedAt"].S)); err != nil || stored.Before(before) {
+		t.Errorf("** Testing: updatedAt of the updated device. ** \n \t<expected updatedAt after: %s> <resulted stored: %s>", before.Format(time.RFC3339), aws.StringValue(mock.Put["updatedAt"].S))
+	}
+} // End of TestUpdateDeviceTimestamps function
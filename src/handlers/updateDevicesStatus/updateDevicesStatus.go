@@ -0,0 +1,356 @@
+package main
+
+import (
+	"audit"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gateway"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"history"
+	"os"
+	"owner"
+	"placeholder"
+	"recovery"
+	"strconv"
+	"time"
+	"types"
+	"validation"
+)
+
+type AmazonWebServices struct {
+	Config   *aws.Config
+	Session  *session.Session
+	DynamoDB dynamodbiface.DynamoDBAPI
+}
+
+// Prepare a new AWS & DynamoDB session, then configure it.
+var TestAws *AmazonWebServices
+
+// Number of devices updated by a single TransactWriteItems call, the same chunks as the other bulk writes.
+// Along with their history, a chunk takes 50 writes of the 100 which a transaction accepts.
+const chunkSize = 25
+
+// How many times unprocessed keys are read again, and the delay before the first retry which doubles each time.
+const maxBatchRetries = 5
+
+var batchRetryDelay = 50 * time.Millisecond
+
+func init() {
+	region := os.Getenv("AWS_REGION")
+	var Aws *AmazonWebServices = new(AmazonWebServices)
+	Aws.Config = &aws.Config{Region: aws.String(region)}
+	// Pointing the client to a local DynamoDB, i.e: DynamoDB Local for the integration tests. It's unset in production.
+	if endpoint := os.Getenv("DYNAMODB_ENDPOINT"); endpoint != "" {
+		Aws.Config.Endpoint = aws.String(endpoint)
+	}
+	var err error
+	Aws.Session, err = session.NewSession(Aws.Config)
+	if err != nil {
+		// Logs error on Amazon CloudWatch. It's sysadmin's duty to handle it.
+		fmt.Println(fmt.Sprintf("Failed to connect to AWS: %s", err.Error()))
+	} else {
+		var svc *dynamodb.DynamoDB = dynamodb.New(Aws.Session)
+		Aws.DynamoDB = dynamodbiface.DynamoDBAPI(svc)
+	}
+	// Instantiate a global session in TestAws
+	TestAws = Aws
+}
+
+// Preparing DynamoDB Session and Calling DB's BatchGetItem function inside, reading the devices of a chunk before
+// they are updated. Keys left unprocessed by DynamoDB are retried with an exponential backoff.
+// Returns the found devices by id, ids which don't exist are simply not returned.
+func (self *AmazonWebServices) BatchGet(ids []string) (map[string]map[string]*dynamodb.AttributeValue, error) {
+	// Get table name from OS's environment
+	tableName := os.Getenv("DEVICES_TABLE_NAME")
+	items := map[string]map[string]*dynamodb.AttributeValue{}
+
+	keys := &dynamodb.KeysAndAttributes{ConsistentRead: aws.Bool(true)}
+	for _, id := range ids {
+		keys.Keys = append(keys.Keys, map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}})
+	}
+	delay := batchRetryDelay
+	for attempt := 0; keys != nil && len(keys.Keys) > 0; attempt++ {
+		if attempt > maxBatchRetries {
+			return nil, errors.New("keys left unprocessed after retries")
+		}
+		if attempt > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+		var input = &dynamodb.BatchGetItemInput{
+			RequestItems: map[string]*dynamodb.KeysAndAttributes{tableName: keys},
+		}
+		// Calling either BatchGetItem function of interface, defined in updateDevicesStatus_test.go file, or api with the input we've provided.
+		// In real deployment environment, the BatchGetItem function of aws (api.go) will be called.
+		result, err := self.DynamoDB.BatchGetItem(input)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range result.Responses[tableName] {
+			items[aws.StringValue(item["id"].S)] = item
+		}
+		keys = result.UnprocessedKeys[tableName]
+	}
+	return items, nil
+}
+
+// Preparing DynamoDB Session and Calling DB's BatchGetItem, then TransactWriteItems function inside, in chunks of 25 ids.
+// Each device gets the status, updatedAt and an incremented version. Unlike BatchWriteItem, a transaction can be
+// conditional, so a missing or soft deleted device, or one of another owner when ownerID is set, isn't created.
+// A device failing its condition cancels its whole chunk, so it's reported and the rest of the chunk is sent again.
+// The devices of a chunk are read first, for the audit trail, and a device is only updated while it's still the one
+// read, one which has changed meanwhile is reported as such. With HISTORY_TABLE_NAME set, each one is appended to the
+// history table in the same transaction as its update, as UpdateDevice keeps the devices it replaces.
+// Returns why each of the ids which have not been updated has failed, and the devices which have been, by id, as they
+// were before the update.
+func (self *AmazonWebServices) UpdateStatuses(ids []string, status string, updatedAt string, ownerID string) (map[string]string, map[string]map[string]*dynamodb.AttributeValue) {
+	// Get desire tables' names from OS's environmental varibles.
+	tableName := aws.String(os.Getenv("DEVICES_TABLE_NAME"))
+	historyTableName := os.Getenv("HISTORY_TABLE_NAME")
+	failed := map[string]string{}
+	updated := map[string]map[string]*dynamodb.AttributeValue{}
+
+	names := placeholder.Names{}
+	values := map[string]*dynamodb.AttributeValue{
+		":status":    {S: aws.String(status)},
+		":updatedAt": {S: aws.String(updatedAt)},
+		":zero":      {N: aws.String("0")},
+		":one":       {N: aws.String("1")},
+	}
+	update := fmt.Sprintf("SET %s = :status, %s = :updatedAt, %[3]s = if_not_exists(%[3]s, :zero) + :one", names.Of("status"), names.Of("updatedAt"), names.Of("version"))
+	condition := fmt.Sprintf("attribute_exists(%s) AND attribute_not_exists(%s)", names.Of("id"), names.Of("deleted"))
+	if ownerID != "" {
+		condition += fmt.Sprintf(" AND %s = :owner", names.Of("ownerId"))
+		values[":owner"] = &dynamodb.AttributeValue{S: aws.String(ownerID)}
+	}
+
+	for start := 0; start < len(ids); start += chunkSize {
+		end := start + chunkSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		chunk := ids[start:end]
+
+		for len(chunk) > 0 {
+			// The devices of the chunk as they are before the update, by id.
+			stored, err := self.BatchGet(chunk)
+			if err != nil {
+				// Logs error on Amazon CloudWatch, the whole chunk is reported as failed.
+				fmt.Println(fmt.Sprintf("Failed to read a chunk of devices: %s", err.Error()))
+				for _, id := range chunk {
+					failed[id] = "Internal Server Error: device could not be updated."
+				}
+				break
+			}
+			var found []string
+			for _, id := range chunk {
+				if stored[id] == nil {
+					failed[id] = "Desired device not found."
+				} else {
+					found = append(found, id)
+				}
+			}
+			if chunk = found; len(chunk) == 0 {
+				break
+			}
+
+			// Every device takes one write, or two along with its history.
+			writes := 1
+			if historyTableName != "" {
+				writes = 2
+			}
+			items := make([]*dynamodb.TransactWriteItem, 0, writes*len(chunk))
+			for _, id := range chunk {
+				// The device is only updated while it still has the version read, which the devices stored before
+				// versioning don't have yet.
+				deviceCondition, deviceNames, deviceValues := condition, placeholder.Names{}, map[string]*dynamodb.AttributeValue{}
+				for placeholder, attribute := range names {
+					deviceNames[placeholder] = attribute
+				}
+				for placeholder, value := range values {
+					deviceValues[placeholder] = value
+				}
+				if version := stored[id]["version"]; version != nil {
+					deviceCondition += fmt.Sprintf(" AND %s = :storedVersion", deviceNames.Of("version"))
+					deviceValues[":storedVersion"] = version
+				} else {
+					deviceCondition += fmt.Sprintf(" AND attribute_not_exists(%s)", deviceNames.Of("version"))
+				}
+				items = append(items, &dynamodb.TransactWriteItem{Update: &dynamodb.Update{
+					TableName:                           tableName,
+					Key:                                 map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}},
+					UpdateExpression:                    aws.String(update),
+					ConditionExpression:                 aws.String(deviceCondition),
+					ExpressionAttributeNames:            deviceNames,
+					ExpressionAttributeValues:           deviceValues,
+					ReturnValuesOnConditionCheckFailure: aws.String(dynamodb.ReturnValuesOnConditionCheckFailureAllOld),
+				}})
+				if historyTableName != "" {
+					items = append(items, &dynamodb.TransactWriteItem{Put: history.Put(historyTableName, stored[id])})
+				}
+			}
+			// Calling either TransactWriteItems function of interface, defined in updateDevicesStatus_test.go file, or api with the input we've provided.
+			// In real deployment environment, the TransactWriteItems function of aws (api.go) will be called.
+			_, err = self.DynamoDB.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: items})
+			if err == nil {
+				for _, id := range chunk {
+					updated[id] = stored[id]
+				}
+				break
+			}
+
+			// The reasons are in the order of the items, "None" for an item which has not caused the cancellation.
+			remaining := chunk
+			if canceled, ok := err.(*dynamodb.TransactionCanceledException); ok && len(canceled.CancellationReasons) == len(items) {
+				remaining = nil
+				for i, id := range chunk {
+					device := canceled.CancellationReasons[i*writes]
+					switch {
+					case aws.StringValue(device.Code) == "ConditionalCheckFailed" && len(device.Item) > 0 && storedVersion(device.Item) != storedVersion(stored[id]):
+						failed[id] = "The device has been modified meanwhile, please retry."
+					case aws.StringValue(device.Code) == "ConditionalCheckFailed":
+						failed[id] = "Desired device not found."
+					// The version read is already in the history, so the device has been replaced meanwhile.
+					case writes == 2 && aws.StringValue(canceled.CancellationReasons[i*writes+1].Code) == "ConditionalCheckFailed":
+						failed[id] = "The device has been modified meanwhile, please retry."
+					default:
+						remaining = append(remaining, id)
+					}
+				}
+			}
+			// Without a device to blame, i.e: a throttled or conflicting transaction, the whole chunk has failed.
+			if len(remaining) == len(chunk) {
+				// Logs error on Amazon CloudWatch, the whole chunk is reported as failed.
+				fmt.Println(fmt.Sprintf("Failed to update the status of a chunk of devices: %s", err.Error()))
+				for _, id := range chunk {
+					failed[id] = "Internal Server Error: device could not be updated."
+				}
+				break
+			}
+			chunk = remaining
+		}
+	}
+	return failed, updated
+}
+
+// The handler function which will be first started from main function.
+// The body names the devices and the status to set on all of them, i.e: {"ids": [...], "status": "retired"}.
+// The response has the outcome of each id, in the order of the request, as a batch does.
+// With HISTORY_TABLE_NAME set, each device as it was before the update is kept in the history table.
+func UpdateDevicesStatus(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !gateway.AllowsMethod(request, "POST") {
+		return gateway.MethodNotAllowed("POST"), nil
+	}
+
+	if len(request.Body) == 0 {
+		return events.APIGatewayProxyResponse{
+			Body:       "No inputs provided, please provide inputs in JSON format.",
+			StatusCode: 400,
+		}, nil
+	}
+
+	if err := validation.CheckBodySize(request); err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:       err.Error(),
+			StatusCode: 413,
+		}, nil
+	}
+
+	// De-serialize "request.Body" which is a JSON object into "Update" in Go objects.
+	var Update types.StatusUpdate
+	if err := json.Unmarshal([]byte(request.Body), &Update); err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:       "Wrong format: Inputs must be a valid JSON object with ids and a status.",
+			StatusCode: 400,
+		}, nil
+	}
+	if len(Update.IDs) == 0 {
+		return events.APIGatewayProxyResponse{
+			Body:       "No ids provided, please provide at least one id.",
+			StatusCode: 400,
+		}, nil
+	}
+	if !validation.ValidStatus(Update.Status) {
+		return events.APIGatewayProxyResponse{
+			Body:       validation.StatusFailure("Invalid field: Status") + ".",
+			StatusCode: 400,
+		}, nil
+	}
+
+	// A transaction can't update the same device twice, and an empty id is no device at all.
+	Result := types.BatchResult{Results: make([]types.BatchItemResult, 0, len(Update.IDs))}
+	var unique []string
+	seen := map[string]bool{}
+	for _, id := range Update.IDs {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+
+	// Never the devices of another tenant.
+	caller := owner.Caller(request)
+	updatedAt := time.Now().UTC().Format(time.RFC3339)
+	failed, updated := TestAws.UpdateStatuses(unique, Update.Status, updatedAt, caller)
+
+	reported := map[string]bool{}
+	for i, id := range Update.IDs {
+		item := types.BatchItemResult{Index: i, ID: id, Success: true}
+		switch {
+		case id == "":
+			item.Success, item.Errors = false, []string{"Missing field: ID"}
+		case reported[id]:
+			item.Success, item.Errors = false, []string{"Invalid field: ID is duplicated in the request"}
+		case failed[id] != "":
+			item.Success, item.Errors = false, []string{failed[id]}
+		default:
+			// Recording who has updated the device for the audit trail, along with the device it has replaced.
+			// It has been updated anyway, so a failure is only logged.
+			record := audit.NewRecord(id, audit.ActionUpdate, caller, updated[id], withStatus(updated[id], Update.Status, updatedAt))
+			if err := audit.Write(TestAws.DynamoDB, record); err != nil {
+				fmt.Println(fmt.Sprintf("Failed to write the audit record: %s", err.Error()))
+			}
+		}
+		reported[id] = true
+		Result.Results = append(Result.Results, item)
+	}
+
+	// Serialization/Encoding the result to JSON.
+	resultJson, _ := json.Marshal(Result)
+	return events.APIGatewayProxyResponse{
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(resultJson),
+		StatusCode: 200,
+	}, nil
+} // End of UpdateDevicesStatus function
+
+// Building a device as UpdateStatuses has updated it from the stored one, since the transaction returns no item.
+func withStatus(stored map[string]*dynamodb.AttributeValue, status string, updatedAt string) map[string]*dynamodb.AttributeValue {
+	item := map[string]*dynamodb.AttributeValue{}
+	for attribute, value := range stored {
+		item[attribute] = value
+	}
+	item["status"] = &dynamodb.AttributeValue{S: aws.String(status)}
+	item["updatedAt"] = &dynamodb.AttributeValue{S: aws.String(updatedAt)}
+	item["version"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(storedVersion(stored) + 1))}
+	return item
+}
+
+// Finding the version of a stored device, 0 for the devices stored before versioning.
+func storedVersion(item map[string]*dynamodb.AttributeValue) int {
+	version := 0
+	if stored := item["version"]; stored != nil {
+		version, _ = strconv.Atoi(aws.StringValue(stored.N))
+	}
+	return version
+}
+
+func main() {
+	lambda.Start(gateway.Adapt(recovery.WithRecover(UpdateDevicesStatus)))
+}
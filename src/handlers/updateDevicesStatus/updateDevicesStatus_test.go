@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"testing"
+	"types"
+)
+
+// Mocking DynamoDB through dynamodbiface.
+type MockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	// Statuses of the devices which are stored in the mocked table by id, and the size of every TransactWriteItems call.
+	Statuses         map[string]string
+	TransactionSizes []int
+	// Device ids of the records appended to the mocked audit table, in their order, and the records themselves.
+	Audited []string
+	Records []map[string]*dynamodb.AttributeValue
+	// Versions of the devices kept in the mocked history table, by "id/version".
+	History map[string]map[string]*dynamodb.AttributeValue
+}
+
+// Custom PutItem function for overriding the PutItem of updateDevicesStatus.go for using in test scenarios.
+// Only the audit records are put on their own.
+func (self *MockDynamoDB) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	self.Audited = append(self.Audited, aws.StringValue(input.Item["deviceId"].S))
+	self.Records = append(self.Records, input.Item)
+	return new(dynamodb.PutItemOutput), nil
+}
+
+// Custom BatchGetItem function for overriding the BatchGetItem of updateDevicesStatus.go for using in test scenarios.
+// Every stored device is read with its status at version 1.
+func (self *MockDynamoDB) BatchGetItem(input *dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error) {
+	MockOutput := &dynamodb.BatchGetItemOutput{Responses: map[string][]map[string]*dynamodb.AttributeValue{}}
+	for table, keys := range input.RequestItems {
+		for _, key := range keys.Keys {
+			if status, ok := self.Statuses[aws.StringValue(key["id"].S)]; ok {
+				MockOutput.Responses[table] = append(MockOutput.Responses[table], map[string]*dynamodb.AttributeValue{"id": key["id"], "status": {S: aws.String(status)}, "version": {N: aws.String("1")}})
+			}
+		}
+	}
+	return MockOutput, nil
+}
+
+// Custom TransactWriteItems function for overriding the TransactWriteItems of updateDevicesStatus.go for using in test scenarios.
+// Cancels the transaction like DynamoDB when one of its devices is missing, or one of its versions is already in the
+// history, with a reason for each item in their order.
+func (self *MockDynamoDB) TransactWriteItems(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+	self.TransactionSizes = append(self.TransactionSizes, len(input.TransactItems))
+	reasons := make([]*dynamodb.CancellationReason, 0, len(input.TransactItems))
+	canceled := false
+	for _, item := range input.TransactItems {
+		reason := &dynamodb.CancellationReason{Code: aws.String("None")}
+		if item.Put != nil {
+			if _, ok := self.History[historyKey(item.Put.Item)]; ok {
+				reason.Code, canceled = aws.String("ConditionalCheckFailed"), true
+			}
+		} else if _, ok := self.Statuses[aws.StringValue(item.Update.Key["id"].S)]; !ok {
+			reason.Code, canceled = aws.String("ConditionalCheckFailed"), true
+		}
+		reasons = append(reasons, reason)
+	}
+	if canceled {
+		return nil, &dynamodb.TransactionCanceledException{Message_: aws.String("Transaction cancelled"), CancellationReasons: reasons}
+	}
+	for _, item := range input.TransactItems {
+		if item.Put != nil {
+			self.History[historyKey(item.Put.Item)] = item.Put.Item
+			continue
+		}
+		self.Statuses[aws.StringValue(item.Update.Key["id"].S)] = aws.StringValue(item.Update.ExpressionAttributeValues[":status"].S)
+	}
+	return new(dynamodb.TransactWriteItemsOutput), nil
+}
+
+// Key of a device in the mocked history table, "id/version".
+func historyKey(item map[string]*dynamodb.AttributeValue) string {
+	return aws.StringValue(item["id"].S) + "/" + aws.StringValue(item["version"].N)
+}
+
+// UpdateDevicesStatus function in updateDevicesStatus.go signature: input: (request events.APIGatewayProxyRequest), output: (events.APIGatewayProxyResponse, error)
+func TestUpdateDevicesStatus(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	mock := &MockDynamoDB{Statuses: map[string]string{}}
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+	t.Setenv("AUDIT_TABLE_NAME", "audit_test")
+
+	// 30 ids spanning two chunks, all of them stored but id_test27.
+	var ids []string
+	for i := 0; i < 30; i++ {
+		id := fmt.Sprintf("id_test%d", i)
+		ids = append(ids, id)
+		if i != 27 {
+			mock.Statuses[id] = types.StatusActive
+		}
+	}
+	body, _ := json.Marshal(types.StatusUpdate{IDs: ids, Status: types.StatusRetired})
+
+	response, _ := UpdateDevicesStatus(events.APIGatewayProxyRequest{HTTPMethod: "POST", Body: string(body)})
+	if response.StatusCode != 200 {
+		t.Fatalf("** Testing: Bulk status update spanning two chunks. ** \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", 200, response.StatusCode, response.Body)
+	}
+
+	// The missing id is left out of its chunk once it's been read.
+	if len(mock.TransactionSizes) != 2 || mock.TransactionSizes[0] != 25 || mock.TransactionSizes[1] != 4 {
+		t.Errorf("** Testing: Chunks of the bulk status update. ** \n \t<expected transaction sizes: [25 4]> <resulted transaction sizes: %v>", mock.TransactionSizes)
+	}
+	Result := types.BatchResult{}
+	json.Unmarshal([]byte(response.Body), &Result)
+	if len(Result.Results) != 30 {
+		t.Fatalf("** Testing: Result of the bulk status update. ** \n \t<expected 30 results> <resulted body: %s>", response.Body)
+	}
+	for i, item := range Result.Results {
+		if i == 27 {
+			if item.Success || item.ID != "id_test27" || len(item.Errors) != 1 || item.Errors[0] != "Desired device not found." {
+				t.Errorf("** Testing: Missing device. ** \n \t<expected failure: Desired device not found.> <resulted result: %+v>", item)
+			}
+			continue
+		}
+		if !item.Success || item.Index != i || mock.Statuses[item.ID] != types.StatusRetired {
+			t.Errorf("** Testing: Updated device. ** \n \t<expected success and status: retired> <resulted result: %+v, status: %s>", item, mock.Statuses[item.ID])
+		}
+	}
+	if _, created := mock.Statuses["id_test27"]; created {
+		t.Errorf("** Testing: Missing device. ** \n \t<expected no device created> <resulted status: %s>", mock.Statuses["id_test27"])
+	}
+	// Every updated device, but no missing one, is recorded in the audit trail.
+	if len(mock.Audited) != 29 || mock.Audited[27] != "id_test28" {
+		t.Errorf("** Testing: Audit records. ** \n \t<expected records of the 29 updated devices> <resulted records of: %v>", mock.Audited)
+	}
+	// Each record has the hashes of the device before and after its update, which differ.
+	for _, record := range mock.Records {
+		if record["beforeHash"] == nil || record["afterHash"] == nil || aws.StringValue(record["beforeHash"].S) == aws.StringValue(record["afterHash"].S) {
+			t.Errorf("** Testing: Hashes of the audit record. ** \n \t<expected two different hashes> <resulted record: %v>", record)
+		}
+	}
+} // End of TestUpdateDevicesStatus function
+
+// Request bodies which can't be a bulk status update at all, and the ids which are rejected on their own.
+func TestUpdateDevicesStatusWrongInputs(t *testing.T) {
+	realAws := TestAws
+	defer func() { TestAws = realAws }()
+
+	testCases := []struct {
+		Name               string
+		Body               string
+		ExpectedBody       string
+		ExpectedStatusCode int
+	}{
+		{Name: "** Testing: Empty body. **", Body: "", ExpectedBody: "No inputs provided, please provide inputs in JSON format.", ExpectedStatusCode: 400},
+		{Name: "** Testing: Not a JSON object. **", Body: "[\"id_test1\"]", ExpectedBody: "Wrong format: Inputs must be a valid JSON object with ids and a status.", ExpectedStatusCode: 400},
+		{Name: "** Testing: No ids. **", Body: "{\"ids\":[],\"status\":\"retired\"}", ExpectedBody: "No ids provided, please provide at least one id.", ExpectedStatusCode: 400},
+		{Name: "** Testing: Unknown status. **", Body: "{\"ids\":[\"id_test1\"],\"status\":\"broken\"}", ExpectedBody: "Invalid field: Status must be one of active, inactive, retired.", ExpectedStatusCode: 400},
+		{Name: "** Testing: Missing status. **", Body: "{\"ids\":[\"id_test1\"]}", ExpectedBody: "Invalid field: Status must be one of active, inactive, retired.", ExpectedStatusCode: 400},
+		{
+			Name:               "** Testing: Empty and duplicated ids. **",
+			Body:               "{\"ids\":[\"id_test1\",\"\",\"id_test1\"],\"status\":\"inactive\"}",
+			ExpectedBody:       "{\"results\":[{\"index\":0,\"id\":\"id_test1\",\"success\":true},{\"index\":1,\"id\":\"\",\"success\":false,\"errors\":[\"Missing field: ID\"]},{\"index\":2,\"id\":\"id_test1\",\"success\":false,\"errors\":[\"Invalid field: ID is duplicated in the request\"]}]}",
+			ExpectedStatusCode: 200,
+		},
+	}
+
+	for _, test := range testCases {
+		TestAws = &AmazonWebServices{DynamoDB: &MockDynamoDB{Statuses: map[string]string{"id_test1": types.StatusActive}}}
+		// Executing each test cases scenario.
+		response, _ := UpdateDevicesStatus(events.APIGatewayProxyRequest{Body: test.Body})
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+} // End of TestUpdateDevicesStatusWrongInputs function
+
+// With HISTORY_TABLE_NAME set, each device as it was before the update is kept in the history table, in the same
+// transaction. A missing device is never written and one whose version is already there has been replaced meanwhile.
+func TestUpdateDevicesStatusHistory(t *testing.T) {
+	t.Setenv("HISTORY_TABLE_NAME", "history_test")
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	mock := &MockDynamoDB{
+		Statuses: map[string]string{"id_test1": types.StatusActive, "id_test2": types.StatusActive},
+		History:  map[string]map[string]*dynamodb.AttributeValue{"id_test2/1": {"id": {S: aws.String("id_test2")}, "version": {N: aws.String("1")}}},
+	}
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	body, _ := json.Marshal(types.StatusUpdate{IDs: []string{"id_test1", "id_test2", "id_missing"}, Status: types.StatusRetired})
+	response, _ := UpdateDevicesStatus(events.APIGatewayProxyRequest{HTTPMethod: "POST", Body: string(body)})
+	expected := "{\"results\":[{\"index\":0,\"id\":\"id_test1\",\"success\":true},{\"index\":1,\"id\":\"id_test2\",\"success\":false,\"errors\":[\"The device has been modified meanwhile, please retry.\"]},{\"index\":2,\"id\":\"id_missing\",\"success\":false,\"errors\":[\"Desired device not found.\"]}]}"
+	if response.StatusCode != 200 || response.Body != expected {
+		t.Errorf("** Testing: Bulk status update kept in the history. ** \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", 200, response.StatusCode, expected, response.Body)
+	}
+	// The missing device is left out before the first transaction, which id_test2 cancels.
+	if len(mock.TransactionSizes) != 2 || mock.TransactionSizes[0] != 4 || mock.TransactionSizes[1] != 2 {
+		t.Errorf("** Testing: Transactions along with the history. ** \n \t<expected transaction sizes: [4 2]> <resulted transaction sizes: %v>", mock.TransactionSizes)
+	}
+	if kept := mock.History["id_test1/1"]; kept == nil || aws.StringValue(kept["status"].S) != types.StatusActive || mock.Statuses["id_test2"] != types.StatusActive {
+		t.Errorf("** Testing: Updated device in the history. ** \n \t<expected id_test1 at version 1, id_test2 untouched> <resulted history: %v, statuses: %v>", mock.History, mock.Statuses)
+	}
+} // End of TestUpdateDevicesStatusHistory function
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"attributes"
+	"audit"
+	"encoding/json"
+	"errors"
+	"etag"
+	"fmt"
+	"gateway"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"history"
+	"negotiation"
+	"os"
+	"owner"
+	"placeholder"
+	"recovery"
+	"sort"
+	"strings"
+	"time"
+	"types"
+	"validation"
+)
+
+type AmazonWebServices struct {
+	Config   *aws.Config
+	Session  *session.Session
+	DynamoDB dynamodbiface.DynamoDBAPI
+}
+
+// Prepare a new AWS & DynamoDB session, then configure it.
+var TestAws *AmazonWebServices
+
+// Position of the device in the transaction of UpsertInTransaction, its marker and history follow when they're written.
+const deviceWrite = 0
+
+// Returned by UpsertInTransaction when the serial is registered to another device.
+var errSerialExists = errors.New("serial already registered")
+
+// Attributes which a device may lack, removed from the stored device when the upserted one doesn't have them.
+// A soft deleted device is brought back by an upsert, so its deleted flag goes as well.
+// They're named as they're stored, i.e: "device_model" for the deviceModel of the API.
+var optionalAttributes = []string{placeholder.Stored("deviceModel"), "tags", "firmwareVersion", "modelCode", "allowedGroups", "expiresAt", "deleted", "deletedAt"}
+
+func init() {
+	region := os.Getenv("AWS_REGION")
+	var Aws *AmazonWebServices = new(AmazonWebServices)
+	Aws.Config = &aws.Config{Region: aws.String(region)}
+	// Pointing the client to a local DynamoDB, i.e: DynamoDB Local for the integration tests. It's unset in production.
+	if endpoint := os.Getenv("DYNAMODB_ENDPOINT"); endpoint != "" {
+		Aws.Config.Endpoint = aws.String(endpoint)
+	}
+	var err error
+	Aws.Session, err = session.NewSession(Aws.Config)
+	if err != nil {
+		// Logs error on Amazon CloudWatch. It's sysadmin's duty to handle it.
+		fmt.Println(fmt.Sprintf("Failed to connect to AWS: %s", err.Error()))
+	} else {
+		var svc *dynamodb.DynamoDB = dynamodb.New(Aws.Session)
+		Aws.DynamoDB = dynamodbiface.DynamoDBAPI(svc)
+	}
+	// Instantiate a global session in TestAws
+	TestAws = Aws
+}
+
+// Preparing DynamoDB Session and Calling DB's UpdateItem function inside, creating the device or replacing every
+// attribute of the stored one. Unlike a plain PutItem, a replaced device keeps its createdAt and its version is
+// incremented, so the ETags of GetDeviceById and the versions of UpdateDevice stay meaningful.
+// The stored item, if any, is returned as it was before, telling a created device from a replaced one.
+// A replaced device has to keep its serial, which RotateSerial changes along with its marker, and a non empty ownerID
+// makes it fail for a device of another owner. On a failed condition the stored item is returned within the
+// *dynamodb.ConditionalCheckFailedException.
+func (self *AmazonWebServices) Upsert(item map[string]*dynamodb.AttributeValue, now string, ownerID string) (*dynamodb.UpdateItemOutput, error) {
+	// Get desire table's name from OS's environmental varible.
+	tableName := aws.String(os.Getenv("DEVICES_TABLE_NAME"))
+
+	expression, names, values := upsertExpression(item, now)
+	var input = &dynamodb.UpdateItemInput{
+		TableName: tableName,
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": item["id"],
+		},
+		UpdateExpression:                    aws.String(expression),
+		ConditionExpression:                 aws.String(fmt.Sprintf("attribute_not_exists(%s) OR (%s)", names.Of("id"), replaceCondition(names, values, ownerID))),
+		ExpressionAttributeNames:            names,
+		ExpressionAttributeValues:           values,
+		ReturnValues:                        aws.String(dynamodb.ReturnValueAllOld),
+		ReturnValuesOnConditionCheckFailure: aws.String(dynamodb.ReturnValuesOnConditionCheckFailureAllOld),
+	}
+
+	// Calling either UpdateItem function of interface, defined in upsertDevice_test.go file, or api with the input we've provided.
+	// In real deployment environment, the UpdateItem function of aws (api.go) will be called.
+	result, err := self.DynamoDB.UpdateItem(input)
+	return result, err
+}
+
+// Preparing DynamoDB Session and Calling DB's GetItem, then TransactWriteItems function inside. The device is upserted
+// like in Upsert, while in the same transaction the marker of its serial is written to SERIALS_TABLE_NAME when the
+// serials are marked, as AddDevice registers it, unless another device has it, and the device it replaces, if any, is
+// appended to HISTORY_TABLE_NAME when it's set, as UpdateDevice keeps it. The device is only written while it's still
+// the one read, so the returned item is the one it has replaced, if any. A failed condition of the device, or a version
+// which is already in the history, is returned like the failed condition of Upsert, and errSerialExists for the serial
+// of another device.
+func (self *AmazonWebServices) UpsertInTransaction(item map[string]*dynamodb.AttributeValue, now string, ownerID string) (map[string]*dynamodb.AttributeValue, error) {
+	// Get desire tables' names from OS's environmental varibles.
+	tableName := aws.String(os.Getenv("DEVICES_TABLE_NAME"))
+	serialsTableName := aws.String(os.Getenv("SERIALS_TABLE_NAME"))
+	historyTableName := os.Getenv("HISTORY_TABLE_NAME")
+
+	// The condition of the transaction makes sure the device read here is still the stored one when it's replaced.
+	stored, err := self.DynamoDB.GetItem(&dynamodb.GetItemInput{
+		TableName:      tableName,
+		Key:            map[string]*dynamodb.AttributeValue{"id": item["id"]},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	expression, names, values := upsertExpression(item, now)
+	var condition string
+	if len(stored.Item) == 0 {
+		condition = fmt.Sprintf("attribute_not_exists(%s)", names.Of("id"))
+	} else {
+		condition = replaceCondition(names, values, ownerID)
+		// The devices stored before versioning have no version yet.
+		if version := stored.Item["version"]; version != nil {
+			condition += fmt.Sprintf(" AND %s = :storedVersion", names.Of("version"))
+			values[":storedVersion"] = version
+		} else {
+			condition += fmt.Sprintf(" AND attribute_not_exists(%s)", names.Of("version"))
+		}
+	}
+	var input = &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			deviceWrite: {Update: &dynamodb.Update{
+				Key:                                 map[string]*dynamodb.AttributeValue{"id": item["id"]},
+				TableName:                           tableName,
+				UpdateExpression:                    aws.String(expression),
+				ConditionExpression:                 aws.String(condition),
+				ExpressionAttributeNames:            names,
+				ExpressionAttributeValues:           values,
+				ReturnValuesOnConditionCheckFailure: aws.String(dynamodb.ReturnValuesOnConditionCheckFailureAllOld),
+			}},
+		},
+	}
+	// Positions of the marker and the history in the transaction, -1 for the ones which aren't written.
+	markerWrite, historyWrite := -1, -1
+	// A device without a serial has no marker to write.
+	if marksSerials() && item["serial"] != nil {
+		markerNames := placeholder.Names{}
+		markerWrite = len(input.TransactItems)
+		input.TransactItems = append(input.TransactItems, &dynamodb.TransactWriteItem{Put: &dynamodb.Put{
+			Item:                      map[string]*dynamodb.AttributeValue{"serial": item["serial"], "id": item["id"]},
+			TableName:                 serialsTableName,
+			ConditionExpression:       aws.String(fmt.Sprintf("attribute_not_exists(%s) OR %s = :id", markerNames.Of("serial"), markerNames.Of("id"))),
+			ExpressionAttributeNames:  markerNames,
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":id": item["id"]},
+		}})
+	}
+	if historyTableName != "" && len(stored.Item) > 0 {
+		historyWrite = len(input.TransactItems)
+		input.TransactItems = append(input.TransactItems, &dynamodb.TransactWriteItem{Put: history.Put(historyTableName, stored.Item)})
+	}
+
+	// Calling either TransactWriteItems function of interface, defined in upsertDevice_test.go file, or api with the input we've provided.
+	// In real deployment environment, the TransactWriteItems function of aws (api.go) will be called.
+	_, err = self.DynamoDB.TransactWriteItems(input)
+	if canceled, ok := err.(*dynamodb.TransactionCanceledException); ok {
+		if reasonFailed(canceled, deviceWrite) {
+			return nil, &dynamodb.ConditionalCheckFailedException{Message_: aws.String("The conditional request failed"), Item: canceled.CancellationReasons[deviceWrite].Item}
+		}
+		if reasonFailed(canceled, markerWrite) {
+			return nil, errSerialExists
+		}
+		// The version read is already in the history, so the device has been replaced meanwhile.
+		if reasonFailed(canceled, historyWrite) {
+			return nil, &dynamodb.ConditionalCheckFailedException{Message_: aws.String("The conditional request failed"), Item: stored.Item}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stored.Item, nil
+}
+
+// Building the update expression of an upsert of item, along with its placeholders and values: every attribute of
+// item is set, the optional ones which it lacks are removed, and the server side ones are set on their own.
+func upsertExpression(item map[string]*dynamodb.AttributeValue, now string) (string, placeholder.Names, map[string]*dynamodb.AttributeValue) {
+	names := placeholder.Names{}
+	values := map[string]*dynamodb.AttributeValue{
+		":now":  {S: aws.String(now)},
+		":zero": {N: aws.String("0")},
+		":one":  {N: aws.String("1")},
+	}
+	var attributes []string
+	for attribute := range item {
+		if attribute != "id" {
+			attributes = append(attributes, attribute)
+		}
+	}
+	// Sorted, so the same device always gets the same expression.
+	sort.Strings(attributes)
+	var clauses []string
+	for _, attribute := range attributes {
+		values[":"+attribute] = item[attribute]
+		clauses = append(clauses, fmt.Sprintf("%s = :%s", names.Of(attribute), attribute))
+	}
+	clauses = append(clauses,
+		fmt.Sprintf("%s = :now", names.Of("updatedAt")),
+		fmt.Sprintf("%[1]s = if_not_exists(%[1]s, :now)", names.Of("createdAt")),
+		fmt.Sprintf("%[1]s = if_not_exists(%[1]s, :zero) + :one", names.Of("version")))
+	var removed []string
+	for _, attribute := range optionalAttributes {
+		if _, ok := item[attribute]; !ok {
+			removed = append(removed, names.Of(attribute))
+		}
+	}
+	expression := "SET " + strings.Join(clauses, ", ")
+	if len(removed) > 0 {
+		expression += " REMOVE " + strings.Join(removed, ", ")
+	}
+	return expression, names, values
+}
+
+// Building the condition of replacing a stored device: it keeps its serial, the ":serial" value of the expression,
+// or stays without one for a device without a serial, and a non empty ownerID has to be its owner.
+func replaceCondition(names placeholder.Names, values map[string]*dynamodb.AttributeValue, ownerID string) string {
+	condition := fmt.Sprintf("attribute_not_exists(%s)", names.Of("serial"))
+	if values[":serial"] != nil {
+		condition = fmt.Sprintf("%s = :serial", names.Of("serial"))
+	}
+	if ownerID != "" {
+		condition += fmt.Sprintf(" AND %s = :owner", names.Of("ownerId"))
+		values[":owner"] = &dynamodb.AttributeValue{S: aws.String(ownerID)}
+	}
+	return condition
+}
+
+// Checking whether a write of a cancelled transaction has failed its condition.
+// A write which isn't in the transaction, at -1, never has.
+func reasonFailed(canceled *dynamodb.TransactionCanceledException, write int) bool {
+	return write >= 0 && write < len(canceled.CancellationReasons) && aws.StringValue(canceled.CancellationReasons[write].Code) == "ConditionalCheckFailed"
+}
+
+// Checking whether the device is written along with the marker of its serial, see UpsertInTransaction.
+// As in AddDevice, SKIP_SERIAL_CHECK=true writes it without, i.e: while migrating data known to be unique.
+func marksSerials() bool {
+	return os.Getenv("SERIALS_TABLE_NAME") != "" && os.Getenv("SKIP_SERIAL_CHECK") != "true"
+}
+
+// The handler function which will be first started from main function.
+// Makes sure the device exists with the given attributes: it's created with HTTP 201 or replaced with HTTP 200,
+// in both cases the stored device is returned. The body gets the same checks as AddDevice.
+// A soft deleted device is created again, keeping only its createdAt. Its serial, like the one of any replaced
+// device, can't change, and with SERIALS_TABLE_NAME set the serial of a created device is registered like in AddDevice.
+// With HISTORY_TABLE_NAME set, the replaced device is kept in the history table.
+func UpsertDevice(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !gateway.AllowsMethod(request, "PUT") {
+		return gateway.MethodNotAllowed("PUT"), nil
+	}
+
+	// The id of the device which user wants to create or replace, sent through PUT method.
+	id := request.PathParameters["id"]
+
+	// If no id have been provided, return HTTP error code 400.
+	if id == "" {
+		return events.APIGatewayProxyResponse{
+			Body:       "Missing field: id",
+			StatusCode: 400,
+		}, nil
+	}
+
+	// Validate user input with the same checks as AddDevice.
+	Device, err := validation.ValidateInputs(request)
+	// if inputs are not suitable, return HTTP error code 400.
+	if err == validation.ErrBodyTooLarge {
+		return events.APIGatewayProxyResponse{
+			Body:       err.Error(),
+			StatusCode: 413,
+		}, nil
+	}
+	if err != nil {
+		body := err.Error()
+		// Field failures are collected, so return all of them as a JSON list.
+		if fieldErrors, ok := err.(validation.FieldErrors); ok {
+			errorsJson, _ := json.Marshal(types.ErrorList{Errors: fieldErrors.Messages()})
+			body = string(errorsJson)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       body,
+			StatusCode: 400,
+		}, nil
+	}
+
+	// The body has to point to the same device as the path.
+	if Device.ID != id {
+		return events.APIGatewayProxyResponse{
+			Body:       "Invalid field: ID does not match the requested device.",
+			StatusCode: 400,
+		}, nil
+	}
+
+	// Server side fields are set by Upsert, whatever the user has sent for them is ignored.
+	Device.CreatedAt = ""
+	Device.UpdatedAt = ""
+	Device.Version = 0
+	Device.Deleted = false
+	Device.DeletedAt = ""
+	// The device belongs to the tenant of the caller, which has to be its owner when it already exists.
+	caller := owner.Caller(request)
+	Device.OwnerID = caller
+	// A device is in service unless the user has told otherwise.
+	if Device.Status == "" {
+		Device.Status = types.StatusActive
+	}
+
+	// Serialization/Encoding "Device" in "item" for using in DynamoDB functions.
+	item, _ := attributes.Marshal(Device)
+	now := time.Now().UTC().Format(time.RFC3339)
+	replaced, err := upsert(item, now, caller)
+
+	if err == errSerialExists {
+		return events.APIGatewayProxyResponse{
+			Body:       "Serial already registered.",
+			StatusCode: 409,
+		}, nil
+	}
+	if cerr, ok := err.(*dynamodb.ConditionalCheckFailedException); ok {
+		// The device belongs to another tenant, reported as missing like in GetDeviceById.
+		if caller != "" && storedOwner(cerr.Item) != caller {
+			return events.APIGatewayProxyResponse{
+				Body:       "Desired device not found.",
+				StatusCode: 404,
+			}, nil
+		}
+		// The serial of a device is changed by RotateSerial only, along with its marker.
+		if storedSerial(cerr.Item) != Device.Serial {
+			return events.APIGatewayProxyResponse{
+				Body:       "Invalid field: Serial can only be changed by rotating it.",
+				StatusCode: 400,
+			}, nil
+		}
+		// The device has changed since it's been read, the client may retry.
+		return events.APIGatewayProxyResponse{
+			Body:       "The device has been modified meanwhile, please retry.",
+			StatusCode: 409,
+		}, nil
+	}
+	if err != nil {
+		// If internal database errors occurred, return HTTP error code 500.
+		return events.APIGatewayProxyResponse{
+			Body:       "Internal Server Error\nDatabase error.",
+			StatusCode: 500,
+		}, nil
+	}
+
+	// Rebuilding the stored device from the one it has replaced, if any.
+	Stored := types.Device{}
+	dynamodbattribute.UnmarshalMap(replaced, &Stored)
+	Device.UpdatedAt = now
+	Device.CreatedAt = Stored.CreatedAt
+	if Device.CreatedAt == "" {
+		Device.CreatedAt = now
+	}
+	Device.Version = Stored.Version + 1
+	if Device.OwnerID == "" {
+		Device.OwnerID = Stored.OwnerID
+	}
+
+	// Everything looks fine, return HTTP 200 for a replaced device, or HTTP 201 for a created one.
+	statusCode := 201
+	if len(replaced) > 0 && !Stored.Deleted {
+		statusCode = 200
+	}
+
+	// Recording who has created or replaced the device for the audit trail, along with the device it has replaced.
+	// It has been written anyway, so a failure is only logged.
+	action := audit.ActionUpdate
+	if statusCode == 201 {
+		action = audit.ActionCreate
+	}
+	written, _ := attributes.Marshal(Device)
+	if err := audit.Write(TestAws.DynamoDB, audit.NewRecord(id, action, caller, replaced, written)); err != nil {
+		fmt.Println(fmt.Sprintf("Failed to write the audit record: %s", err.Error()))
+	}
+
+	DeviceJson, _ := negotiation.Marshal(negotiation.JSON, Device)
+	return events.APIGatewayProxyResponse{
+		Headers:    map[string]string{"ETag": etag.Format(Device.Version)},
+		Body:       string(DeviceJson),
+		StatusCode: statusCode,
+	}, nil
+} // End of UpsertDevice function
+
+// Creating or replacing a device with item, registering its serial along with it when the serials are marked and
+// keeping the replaced device in the history table when HISTORY_TABLE_NAME is set. Returns the replaced device, if any.
+func upsert(item map[string]*dynamodb.AttributeValue, now string, ownerID string) (map[string]*dynamodb.AttributeValue, error) {
+	if marksSerials() || os.Getenv("HISTORY_TABLE_NAME") != "" {
+		return TestAws.UpsertInTransaction(item, now, ownerID)
+	}
+	result, err := TestAws.Upsert(item, now, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	return result.Attributes, nil
+}
+
+// Finding the owner of a stored device, empty for the devices created without a caller.
+func storedOwner(item map[string]*dynamodb.AttributeValue) string {
+	if ownerID := item["ownerId"]; ownerID != nil {
+		return aws.StringValue(ownerID.S)
+	}
+	return ""
+}
+
+// Finding the serial of a stored device.
+func storedSerial(item map[string]*dynamodb.AttributeValue) string {
+	if serial := item["serial"]; serial != nil {
+		return aws.StringValue(serial.S)
+	}
+	return ""
+}
+
+func main() {
+	lambda.Start(gateway.Adapt(recovery.WithRecover(UpsertDevice)))
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"types"
+)
+
+// Mocking DynamoDB through dynamodbiface.
+type MockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	// Items of the mocked table by id.
+	Items map[string]map[string]*dynamodb.AttributeValue
+	// Ids of the devices which the serials are registered to in the mocked serials table, by serial.
+	Markers map[string]string
+	// Actions of the records appended to the mocked audit table, in their order.
+	Audited []string
+	// Versions of the devices kept in the mocked history table, by "id/version".
+	History map[string]map[string]*dynamodb.AttributeValue
+}
+
+// Custom PutItem function for overriding the PutItem of upsertDevice.go for using in test scenarios.
+// Only the audit records are put on their own.
+func (self *MockDynamoDB) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	self.Audited = append(self.Audited, aws.StringValue(input.Item["action"].S))
+	return new(dynamodb.PutItemOutput), nil
+}
+
+// The clauses of a SET action, split where the next one starts as commas are also within if_not_exists.
+var setClauses = regexp.MustCompile(`, (?:#)`)
+
+// Custom UpdateItem function for overriding the UpdateItem of upsertDevice.go for using in test scenarios.
+// Applying the SET and REMOVE actions of the upsert to the Items of the mock and returning the item as it was before,
+// like DynamoDB it rejects placeholders and values which the expressions don't use.
+func (self *MockDynamoDB) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	expression := aws.StringValue(input.UpdateExpression)
+	expressions := expression + " " + aws.StringValue(input.ConditionExpression)
+	for name := range input.ExpressionAttributeNames {
+		if !strings.Contains(expressions, name) {
+			return nil, awserr.New("ValidationException", "Value provided in ExpressionAttributeNames unused in expressions: keys: {"+name+"}", nil)
+		}
+	}
+	for value := range input.ExpressionAttributeValues {
+		if !strings.Contains(expressions, value) {
+			return nil, awserr.New("ValidationException", "Value provided in ExpressionAttributeValues unused in expressions: keys: {"+value+"}", nil)
+		}
+	}
+	id := aws.StringValue(input.Key["id"].S)
+	old, exists := self.Items[id]
+	// Returning the stored item on a failed condition, as ReturnValuesOnConditionCheckFailure is ALL_OLD.
+	if ownerID := input.ExpressionAttributeValues[":owner"]; ownerID != nil && exists && storedOwner(old) != aws.StringValue(ownerID.S) {
+		return nil, &dynamodb.ConditionalCheckFailedException{Message_: aws.String("The conditional request failed"), Item: old}
+	}
+	// A device without a serial may only replace a device without one.
+	serial := ""
+	if value := input.ExpressionAttributeValues[":serial"]; value != nil {
+		serial = aws.StringValue(value.S)
+	}
+	if exists && storedSerial(old) != serial {
+		return nil, &dynamodb.ConditionalCheckFailedException{Message_: aws.String("The conditional request failed"), Item: old}
+	}
+
+	item := map[string]*dynamodb.AttributeValue{"id": input.Key["id"]}
+	for attribute, value := range old {
+		item[attribute] = value
+	}
+	actions := strings.SplitN(strings.TrimPrefix(expression, "SET "), " REMOVE ", 2)
+	for _, clause := range setClauses.Split(actions[0], -1) {
+		sides := strings.SplitN(strings.TrimPrefix(clause, "#"), " = ", 2)
+		attribute := aws.StringValue(input.ExpressionAttributeNames["#"+sides[0]])
+		switch {
+		case strings.HasSuffix(sides[1], "+ :one"):
+			version := 0
+			if old["version"] != nil {
+				version, _ = strconv.Atoi(aws.StringValue(old["version"].N))
+			}
+			item[attribute] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(version + 1))}
+		case strings.HasPrefix(sides[1], "if_not_exists"):
+			if old[attribute] == nil {
+				item[attribute] = input.ExpressionAttributeValues[":now"]
+			}
+		default:
+			item[attribute] = input.ExpressionAttributeValues[sides[1]]
+		}
+	}
+	if len(actions) > 1 {
+		for _, name := range strings.Split(actions[1], ", ") {
+			delete(item, aws.StringValue(input.ExpressionAttributeNames[name]))
+		}
+	}
+	self.Items[id] = item
+	if !exists {
+		old = nil
+	}
+	return &dynamodb.UpdateItemOutput{Attributes: old}, nil
+}
+
+// Custom GetItem function for overriding the GetItem of upsertDevice.go for using in test scenarios.
+func (self *MockDynamoDB) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return &dynamodb.GetItemOutput{Item: self.Items[aws.StringValue(input.Key["id"].S)]}, nil
+}
+
+// Custom TransactWriteItems function for overriding the TransactWriteItems of upsertDevice.go for using in test scenarios.
+// The marker is only written while its serial is free or registered to the same device, the replaced device is only
+// appended to the history while its version isn't there yet, and the device is updated like by UpdateItem while it's
+// still the one which has been read. Cancels the transaction like DynamoDB, with a reason for each item in their order.
+func (self *MockDynamoDB) TransactWriteItems(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+	update := input.TransactItems[deviceWrite].Update
+	id := aws.StringValue(update.Key["id"].S)
+	reasons := make([]*dynamodb.CancellationReason, 0, len(input.TransactItems))
+	canceled := false
+	var marker, history map[string]*dynamodb.AttributeValue
+	for _, write := range input.TransactItems {
+		reason := &dynamodb.CancellationReason{Code: aws.String("None")}
+		if write.Put != nil && aws.StringValue(write.Put.TableName) == os.Getenv("SERIALS_TABLE_NAME") {
+			marker = write.Put.Item
+			if markedID, ok := self.Markers[aws.StringValue(marker["serial"].S)]; ok && markedID != id {
+				reason.Code, canceled = aws.String("ConditionalCheckFailed"), true
+			}
+		}
+		if write.Put != nil && aws.StringValue(write.Put.TableName) == os.Getenv("HISTORY_TABLE_NAME") {
+			history = write.Put.Item
+			if _, ok := self.History[id+"/"+aws.StringValue(history["version"].N)]; ok {
+				reason.Code, canceled = aws.String("ConditionalCheckFailed"), true
+			}
+		}
+		reasons = append(reasons, reason)
+	}
+	old, exists := self.Items[id]
+	stale := exists && aws.StringValue(update.ConditionExpression) == "attribute_not_exists(#id)"
+	if expected := update.ExpressionAttributeValues[":storedVersion"]; expected != nil && (!exists || aws.StringValue(old["version"].N) != aws.StringValue(expected.N)) {
+		stale = true
+	}
+	if stale {
+		reasons[deviceWrite].Code, reasons[deviceWrite].Item, canceled = aws.String("ConditionalCheckFailed"), old, true
+	}
+	if canceled {
+		return nil, &dynamodb.TransactionCanceledException{CancellationReasons: reasons}
+	}
+	_, err := self.UpdateItem(&dynamodb.UpdateItemInput{
+		Key:                       update.Key,
+		UpdateExpression:          update.UpdateExpression,
+		ConditionExpression:       update.ConditionExpression,
+		ExpressionAttributeNames:  update.ExpressionAttributeNames,
+		ExpressionAttributeValues: update.ExpressionAttributeValues,
+	})
+	if cerr, ok := err.(*dynamodb.ConditionalCheckFailedException); ok {
+		reasons[deviceWrite].Code, reasons[deviceWrite].Item = aws.String("ConditionalCheckFailed"), cerr.Item
+		return nil, &dynamodb.TransactionCanceledException{CancellationReasons: reasons}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if marker != nil {
+		self.Markers[aws.StringValue(marker["serial"].S)] = id
+	}
+	if history != nil {
+		self.History[id+"/"+aws.StringValue(history["version"].N)] = history
+	}
+	return new(dynamodb.TransactWriteItemsOutput), nil
+}
+
+// UpsertDevice function in upsertDevice.go signature: input: (request events.APIGatewayProxyRequest), output: (events.APIGatewayProxyResponse, error)
+func TestUpsertDevice(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	mock := &MockDynamoDB{Items: map[string]map[string]*dynamodb.AttributeValue{}}
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+	t.Setenv("AUDIT_TABLE_NAME", "audit_test")
+
+	id := "7c9e6679-7425-40de-944b-e07fc1f90ae7"
+	testCases := []struct {
+		Name               string
+		Body               string
+		ExpectedStatusCode int
+		ExpectedVersion    int
+		ExpectedName       string
+	}{
+		{
+			Name:               "** Testing: Upsert of a new device. **",
+			Body:               "{\"id\":\"" + id + "\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\",\"tags\":{\"floor\":\"2\"}}",
+			ExpectedStatusCode: 201,
+			ExpectedVersion:    1,
+			ExpectedName:       "testName",
+		},
+		{
+			// The device has been created by the previous case.
+			Name:               "** Testing: Upsert of an existing device. **",
+			Body:               "{\"id\":\"" + id + "\",\"deviceModel\":\"testDeviceModel\",\"name\":\"newName\",\"note\":\"testNote\",\"serial\":\"testSerial\",\"version\":7}",
+			ExpectedStatusCode: 200,
+			ExpectedVersion:    2,
+			ExpectedName:       "newName",
+		},
+	}
+
+	createdAt := ""
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := UpsertDevice(events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": id}, Body: test.Body})
+		Device := types.Device{}
+		json.Unmarshal([]byte(response.Body), &Device)
+		if response.StatusCode != test.ExpectedStatusCode || Device.Version != test.ExpectedVersion || Device.Name != test.ExpectedName {
+			t.Errorf("%s \n \t<expected error-code: %d, version: %d, name: %s> <resulted error-code: %d> <resulted body: %s>", test.Name, test.ExpectedStatusCode, test.ExpectedVersion, test.ExpectedName, response.StatusCode, response.Body)
+			continue
+		}
+		stored := mock.Items[id]
+		if aws.StringValue(stored["name"].S) != test.ExpectedName || aws.StringValue(stored["version"].N) != strconv.Itoa(test.ExpectedVersion) || response.Headers["ETag"] != strconv.Quote(strconv.Itoa(test.ExpectedVersion)) {
+			t.Errorf("%s \n \t<expected stored name: %s, version: %d> <resulted stored item: %v, ETag: %s>", test.Name, test.ExpectedName, test.ExpectedVersion, stored, response.Headers["ETag"])
+		}
+		// A replaced device keeps the createdAt of the created one.
+		if createdAt == "" {
+			createdAt = Device.CreatedAt
+		}
+		if Device.CreatedAt == "" || Device.CreatedAt != createdAt || aws.StringValue(stored["createdAt"].S) != createdAt {
+			t.Errorf("%s \n \t<expected createdAt: %s> <resulted createdAt: %s, stored: %s>", test.Name, createdAt, Device.CreatedAt, aws.StringValue(stored["createdAt"].S))
+		}
+	}
+	// The replaced device has no tags, so they have been removed along with it.
+	if mock.Items[id]["tags"] != nil {
+		t.Errorf("** Testing: Attributes of the replaced device. ** \n \t<expected no tags> <resulted tags: %v>", mock.Items[id]["tags"])
+	}
+	// The created device, then the replaced one are recorded in the audit trail.
+	if strings.Join(mock.Audited, ",") != "create,update" {
+		t.Errorf("** Testing: Audit records. ** \n \t<expected actions: create,update> <resulted actions: %v>", mock.Audited)
+	}
+} // End of TestUpsertDevice function
+
+// The body gets the same checks as AddDevice and has to point to the device of the path, a device of another tenant
+// is reported as missing and kept as is.
+func TestUpsertDeviceFailures(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	id := "7c9e6679-7425-40de-944b-e07fc1f90ae7"
+	mock := &MockDynamoDB{Items: map[string]map[string]*dynamodb.AttributeValue{
+		id: {"id": {S: aws.String(id)}, "name": {S: aws.String("name_test")}, "serial": {S: aws.String("testSerial")}, "ownerId": {S: aws.String("tenant-a")}, "version": {N: aws.String("3")}},
+	}}
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	body := "{\"id\":\"" + id + "\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}"
+	testCases := []struct {
+		Name               string
+		Request            events.APIGatewayProxyRequest
+		ExpectedBody       string
+		ExpectedStatusCode int
+	}{
+		{
+			Name:               "** Testing: Empty id input. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": ""}, Body: body},
+			ExpectedBody:       "Missing field: id",
+			ExpectedStatusCode: 400,
+		},
+		{
+			Name:               "** Testing: Missing fields. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": id}, Body: "{\"id\":\"" + id + "\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\"}"},
+			ExpectedBody:       "{\"errors\":[\"Missing field: Serial\"]}",
+			ExpectedStatusCode: 400,
+		},
+		{
+			Name:               "** Testing: Id of the body does not match the path. **",
+			Request:            events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "9b2d6c1e-3a4f-4e5b-8c7d-1f2e3a4b5c6d"}, Body: body},
+			ExpectedBody:       "Invalid field: ID does not match the requested device.",
+			ExpectedStatusCode: 400,
+		},
+		{
+			Name: "** Testing: Device of another tenant. **",
+			Request: events.APIGatewayProxyRequest{
+				PathParameters: map[string]string{"id": id},
+				Body:           body,
+				RequestContext: events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"sub": "tenant-b"}},
+			},
+			ExpectedBody:       "Desired device not found.",
+			ExpectedStatusCode: 404,
+		},
+		{
+			// The serial is changed by RotateSerial only, along with its marker.
+			Name: "** Testing: Serial of the device changed. **",
+			Request: events.APIGatewayProxyRequest{
+				PathParameters: map[string]string{"id": id},
+				Body:           strings.Replace(body, "testSerial", "otherSerial", 1),
+				RequestContext: events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"sub": "tenant-a"}},
+			},
+			ExpectedBody:       "Invalid field: Serial can only be changed by rotating it.",
+			ExpectedStatusCode: 400,
+		},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := UpsertDevice(test.Request)
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+	if aws.StringValue(mock.Items[id]["name"].S) != "name_test" {
+		t.Errorf("** Testing: Device of another tenant is kept. ** \n \t<expected name: name_test> <resulted item: %v>", mock.Items[id])
+	}
+} // End of TestUpsertDeviceFailures function
+
+// With SERIALS_TABLE_NAME set, the serial of a created device is registered along with it, so it can't be taken by
+// another device, while a replaced device keeps its own marker.
+func TestUpsertDeviceMarker(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	mock := &MockDynamoDB{Items: map[string]map[string]*dynamodb.AttributeValue{}, Markers: map[string]string{}}
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	os.Setenv("SERIALS_TABLE_NAME", "serials_test")
+	defer func() {
+		TestAws = realAws
+		os.Unsetenv("SERIALS_TABLE_NAME")
+	}()
+
+	id := "7c9e6679-7425-40de-944b-e07fc1f90ae7"
+	otherID := "9b2d6c1e-3a4f-4e5b-8c7d-1f2e3a4b5c6d"
+	testCases := []struct {
+		Name               string
+		ID                 string
+		ExpectedBody       string
+		ExpectedStatusCode int
+	}{
+		{Name: "** Testing: Upsert of a new device. **", ID: id, ExpectedStatusCode: 201},
+		{Name: "** Testing: Upsert of the same device. **", ID: id, ExpectedStatusCode: 200},
+		{Name: "** Testing: Upsert of another device with the same serial. **", ID: otherID, ExpectedBody: "Serial already registered.", ExpectedStatusCode: 409},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		body := "{\"id\":\"" + test.ID + "\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}"
+		response, _ := UpsertDevice(events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": test.ID}, Body: body})
+		if response.StatusCode != test.ExpectedStatusCode || test.ExpectedBody != "" && response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+	if mock.Markers["testSerial"] != id || mock.Items[otherID] != nil || aws.StringValue(mock.Items[id]["version"].N) != "2" {
+		t.Errorf("** Testing: Registered serial. ** \n \t<expected the serial registered to %s, version: 2> <resulted markers: %v, items: %v>", id, mock.Markers, mock.Items)
+	}
+} // End of TestUpsertDeviceMarker function
+
+// With HISTORY_TABLE_NAME set, the device which an upsert replaces is kept in the history table, in the same
+// transaction, while a created device has nothing to keep. A version which is already there has been replaced
+// meanwhile, so the upsert is rejected.
+func TestUpsertDeviceHistory(t *testing.T) {
+	t.Setenv("HISTORY_TABLE_NAME", "history_test")
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	mock := &MockDynamoDB{Items: map[string]map[string]*dynamodb.AttributeValue{}, History: map[string]map[string]*dynamodb.AttributeValue{}}
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	id := "7c9e6679-7425-40de-944b-e07fc1f90ae7"
+	request := func(name string) events.APIGatewayProxyRequest {
+		body := "{\"id\":\"" + id + "\",\"deviceModel\":\"testDeviceModel\",\"name\":\"" + name + "\",\"note\":\"testNote\",\"serial\":\"testSerial\"}"
+		return events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": id}, Body: body}
+	}
+
+	response, _ := UpsertDevice(request("testName"))
+	if response.StatusCode != 201 || len(mock.History) != 0 {
+		t.Errorf("** Testing: Upsert of a new device. ** \n \t<expected error-code: %d and no history> <resulted error-code: %d, history: %v>", 201, response.StatusCode, mock.History)
+	}
+	response, _ = UpsertDevice(request("newName"))
+	if kept := mock.History[id+"/1"]; response.StatusCode != 200 || len(mock.History) != 1 || kept == nil || aws.StringValue(kept["name"].S) != "testName" {
+		t.Errorf("** Testing: Replaced device in the history. ** \n \t<expected error-code: %d, version 1 with testName> <resulted error-code: %d, history: %v>", 200, response.StatusCode, mock.History)
+	}
+
+	mock.History[id+"/2"] = map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}, "version": {N: aws.String("2")}}
+	response, _ = UpsertDevice(request("otherName"))
+	expected := "The device has been modified meanwhile, please retry."
+	if response.StatusCode != 409 || response.Body != expected || aws.StringValue(mock.Items[id]["name"].S) != "newName" {
+		t.Errorf("** Testing: Version already in the history. ** \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", 409, response.StatusCode, expected, response.Body)
+	}
+} // End of TestUpsertDeviceHistory function
+
+// An upsert without allowedGroups stops sharing the device, the groups which it's stored with are removed like the
+// other optional attributes which the upserted device lacks.
+func TestUpsertDeviceGroups(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	id := "7c9e6679-7425-40de-944b-e07fc1f90ae7"
+	mock := &MockDynamoDB{Items: map[string]map[string]*dynamodb.AttributeValue{id: {
+		"id":            {S: aws.String(id)},
+		"serial":        {S: aws.String("testSerial")},
+		"allowedGroups": {L: []*dynamodb.AttributeValue{{S: aws.String("operators")}}},
+		"version":       {N: aws.String("1")},
+	}}}
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+
+	item := map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}, "name": {S: aws.String("testName")}}
+	expression, names, _ := upsertExpression(item, "2018-11-03T08:00:00Z")
+	removed := ""
+	if actions := strings.SplitN(expression, " REMOVE ", 2); len(actions) > 1 {
+		removed = actions[1]
+	}
+	if !strings.Contains(removed, "#allowedGroups") || aws.StringValue(names["#allowedGroups"]) != "allowedGroups" {
+		t.Errorf("** Testing: Expression of an upsert without groups. ** \n \t<expected REMOVE #allowedGroups> <resulted expression: %s>", expression)
+	}
+
+	body := "{\"id\":\"" + id + "\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\",\"serial\":\"testSerial\"}"
+	response, _ := UpsertDevice(events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": id}, Body: body})
+	if response.StatusCode != 200 || mock.Items[id]["allowedGroups"] != nil {
+		t.Errorf("** Testing: Upsert without groups. ** \n \t<expected error-code: %d, no allowedGroups> <resulted error-code: %d, item: %v> \n \t<resulted body: %s>", 200, response.StatusCode, mock.Items[id], response.Body)
+	}
+} // End of TestUpsertDeviceGroups function
+
+// A device without a serial, when REQUIRED_FIELDS leaves it out, is upserted without a marker, and it may only replace
+// a device which has no serial either.
+func TestUpsertDeviceWithoutSerial(t *testing.T) {
+	// Swap the global session with a mocked one for the duration of the test.
+	realAws := TestAws
+	mock := &MockDynamoDB{Items: map[string]map[string]*dynamodb.AttributeValue{}, Markers: map[string]string{}}
+	TestAws = &AmazonWebServices{DynamoDB: mock}
+	defer func() { TestAws = realAws }()
+	t.Setenv("REQUIRED_FIELDS", "deviceModel,name,note")
+
+	id := "7c9e6679-7425-40de-944b-e07fc1f90ae7"
+	testCases := []struct {
+		Name               string
+		SerialsTableName   string
+		Serial             string
+		ExpectedStatusCode int
+	}{
+		{Name: "** Testing: Upsert of a new device without a serial. **", SerialsTableName: "serials_test", ExpectedStatusCode: 201},
+		{Name: "** Testing: Upsert of the same device without a serial. **", SerialsTableName: "serials_test", ExpectedStatusCode: 200},
+		{Name: "** Testing: Upsert of the same device without a serial nor a serials table. **", ExpectedStatusCode: 200},
+		{Name: "** Testing: Upsert adding a serial. **", Serial: ",\"serial\":\"testSerial\"", ExpectedStatusCode: 400},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		t.Setenv("SERIALS_TABLE_NAME", test.SerialsTableName)
+		body := "{\"id\":\"" + id + "\",\"deviceModel\":\"testDeviceModel\",\"name\":\"testName\",\"note\":\"testNote\"" + test.Serial + "}"
+		response, _ := UpsertDevice(events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": id}, Body: body})
+		if response.StatusCode != test.ExpectedStatusCode {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, response.Body)
+		}
+	}
+	if len(mock.Markers) != 0 || mock.Items[id]["serial"] != nil {
+		t.Errorf("** Testing: Device without a serial. ** \n \t<expected no marker nor serial> <resulted markers: %v, item: %v>", mock.Markers, mock.Items[id])
+	}
+} // End of TestUpsertDeviceWithoutSerial function
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"gateway"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"recovery"
+	"types"
+	"validation"
+)
+
+// The handler function which will be first started from main function.
+// Each device of the JSON array in the body is validated like in BatchAddDevices, but nothing is written, so a batch
+// can be checked and fixed before it's sent to BatchAddDevices. DynamoDB is never called.
+func ValidateBatch(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !gateway.AllowsMethod(request, "POST") {
+		return gateway.MethodNotAllowed("POST"), nil
+	}
+
+	if len(request.Body) == 0 {
+		return events.APIGatewayProxyResponse{
+			Body:       "No inputs provided, please provide inputs in JSON format.",
+			StatusCode: 400,
+		}, nil
+	}
+
+	if err := validation.CheckBodySize(request); err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:       err.Error(),
+			StatusCode: 413,
+		}, nil
+	}
+
+	// De-serialize "request.Body" which is a JSON array into "NewDevices" in Go objects.
+	var NewDevices []types.Device
+	err := json.Unmarshal([]byte(request.Body), &NewDevices)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:       validation.JSONFailure("Wrong format: Inputs must be a valid JSON array of devices", err),
+			StatusCode: 400,
+		}, nil
+	}
+	if len(NewDevices) == 0 {
+		return events.APIGatewayProxyResponse{
+			Body:       "No devices provided, please provide at least one device.",
+			StatusCode: 400,
+		}, nil
+	}
+
+	report := types.BatchValidationReport{Results: make([]types.BatchValidationItem, len(NewDevices))}
+	// Ids of the valid devices, BatchAddDevices rejects an id which is repeated in the batch.
+	ids := map[string]bool{}
+
+	for i, NewDevice := range NewDevices {
+		NewDevice = validation.NormalizeDevice(validation.SanitizeDevice(NewDevice))
+		Failures := validation.ValidateDevice(NewDevice)
+		if ids[NewDevice.ID] {
+			Failures = append(Failures, types.FieldError{Field: "id", Code: validation.CodeInvalidValue, Message: "Invalid field: ID is duplicated in the batch"})
+		}
+		report.Results[i] = types.BatchValidationItem{Index: i, ID: NewDevice.ID, Valid: len(Failures) == 0, Errors: Failures.Messages()}
+		if len(Failures) > 0 {
+			report.Invalid++
+			continue
+		}
+		ids[NewDevice.ID] = true
+		report.Valid++
+	}
+
+	// Serialization/Encoding the report to JSON.
+	reportJson, _ := json.Marshal(report)
+	return events.APIGatewayProxyResponse{
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(reportJson),
+		StatusCode: 200,
+	}, nil
+} // End of ValidateBatch function
+
+func main() {
+	lambda.Start(gateway.Adapt(recovery.WithRecover(ValidateBatch)))
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/aws/aws-lambda-go/events"
+	"testing"
+	"types"
+)
+
+// ValidateBatch function in validateBatch.go signature: input: (request events.APIGatewayProxyRequest), output: (events.APIGatewayProxyResponse, error)
+func TestValidateBatch(t *testing.T) {
+	// A valid device, one without a Name and one repeating the id of the first.
+	devices := []types.Device{
+		{ID: "7c9e6679-7425-40de-944b-000000000001", DeviceModel: "testDeviceModel", Name: "testName", Note: "testNote", Serial: "testSerial"},
+		{ID: "7c9e6679-7425-40de-944b-000000000002", DeviceModel: "testDeviceModel", Note: "testNote", Serial: "testSerial"},
+		{ID: "7c9e6679-7425-40de-944b-000000000001", DeviceModel: "testDeviceModel", Name: "testName", Note: "testNote", Serial: "testSerial"},
+	}
+	body, _ := json.Marshal(devices)
+
+	response, _ := ValidateBatch(events.APIGatewayProxyRequest{Body: string(body)})
+	if response.StatusCode != 200 {
+		t.Fatalf("** Testing: Batch of mixed validity. ** \n \t<expected error-code: %d> <resulted error-code: %d> <resulted body: %s>", 200, response.StatusCode, response.Body)
+	}
+
+	expectedBody := "{\"valid\":1,\"invalid\":2,\"results\":[" +
+		"{\"index\":0,\"id\":\"7c9e6679-7425-40de-944b-000000000001\",\"valid\":true}," +
+		"{\"index\":1,\"id\":\"7c9e6679-7425-40de-944b-000000000002\",\"valid\":false,\"errors\":[\"Missing field: Name\"]}," +
+		"{\"index\":2,\"id\":\"7c9e6679-7425-40de-944b-000000000001\",\"valid\":false,\"errors\":[\"Invalid field: ID is duplicated in the batch\"]}]}"
+	if response.Body != expectedBody {
+		t.Errorf("** Testing: Report of the batch. ** \n \t<expected body: %s> <resulted body: %s>", expectedBody, response.Body)
+	}
+} // End of TestValidateBatch function
+
+// Request bodies which can't be a batch at all.
+func TestValidateBatchWrongInputs(t *testing.T) {
+	testCases := []struct {
+		Name               string
+		Request            events.APIGatewayProxyRequest
+		ExpectedBody       string
+		ExpectedStatusCode int
+	}{
+		{
+			Name:               "** Testing: Empty body input. **",
+			Request:            events.APIGatewayProxyRequest{Body: ""},
+			ExpectedBody:       "No inputs provided, please provide inputs in JSON format.",
+			ExpectedStatusCode: 400,
+		},
+
+		{
+			Name:               "** Testing: A single device instead of an array. **",
+			Request:            events.APIGatewayProxyRequest{Body: "{\"id\":\"7c9e6679-7425-40de-944b-e07fc1f90ae7\"}"},
+			ExpectedBody:       "Wrong format: Inputs must be a valid JSON array of devices, the body must be an array, not an object, at offset 1.",
+			ExpectedStatusCode: 400,
+		},
+
+		{
+			Name:               "** Testing: Empty array. **",
+			Request:            events.APIGatewayProxyRequest{Body: "[]"},
+			ExpectedBody:       "No devices provided, please provide at least one device.",
+			ExpectedStatusCode: 400,
+		},
+	}
+
+	for _, test := range testCases {
+		// Executing each test cases scenario.
+		response, _ := ValidateBatch(test.Request)
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+} // End of TestValidateBatchWrongInputs function